@@ -0,0 +1,60 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Validate application bundles offline
+
+package commands
+
+import (
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Application bundle operations",
+	Long:  `Operations on YAML or JSON application bundles`,
+	Run: func(cmd *cobra.Command, args []string) {
+		SetupLogging()
+		cmd.Help()
+	},
+}
+
+var bundleValidateCmd = &cobra.Command{
+	Use:   "validate [files]",
+	Short: "Validate application bundles",
+	Long: `Validate application bundles without connecting to the platform with the checks of the validate command.
+All the problems found are printed and the command exits with an error if there is any`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		SetupLogging()
+		if validateOutput != "human" && validateOutput != "json" {
+			log.Fatal().Str("output", validateOutput).Msg("output must be human or json")
+		}
+		problems := validateBundles(args)
+		printProblems(problems, validateOutput)
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	bundleValidateCmd.Flags().StringVar(&validateOutput, "output", "human", "Output format: human or json")
+	bundleCmd.AddCommand(bundleValidateCmd)
+	rootCmd.AddCommand(bundleCmd)
+}
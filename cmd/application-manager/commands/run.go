@@ -20,8 +20,10 @@ package commands
 
 import (
 	"github.com/nalej/application-manager/internal/pkg/server"
+	"github.com/nalej/application-manager/internal/pkg/server/application"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"time"
 )
 
 var config = server.Config{}
@@ -40,6 +42,7 @@ var runCmd = &cobra.Command{
 
 func init() {
 	runCmd.Flags().IntVar(&config.Port, "port", 8910, "Port to launch the Public API")
+	runCmd.Flags().IntVar(&config.MetricsPort, "metricsPort", 8911, "Port where the Prometheus metrics are served")
 	runCmd.PersistentFlags().StringVar(&config.SystemModelAddress, "systemModelAddress", "localhost:8800",
 		"System Model address (host:port)")
 	runCmd.PersistentFlags().StringVar(&config.ConductorAddress, "conductorAddress", "localhost:5000",
@@ -50,5 +53,21 @@ func init() {
 		"Unified Logging Coordinator address (host:port)")
 	runCmd.PersistentFlags().StringVar(&config.OrgManagerAddress, "organizationManagerAddress", "localhost:8950",
 		"Organization Manager address (host:port)")
+	runCmd.PersistentFlags().StringVar(&config.SecretsBackend, "secretsBackend", server.KubernetesSecretsBackend,
+		"Backend where the password parameters are stored: kubernetes or file")
+	runCmd.PersistentFlags().StringVar(&config.SecretsNamespace, "secretsNamespace", "nalej",
+		"Namespace where the kubernetes backend stores the password parameters")
+	runCmd.PersistentFlags().StringVar(&config.SecretsPath, "secretsPath", "/var/lib/application-manager/secrets.json",
+		"File where the file backend stores the password parameters, it must be in a persistent volume")
+	runCmd.PersistentFlags().StringVar(&config.SecretsKeyPath, "secretsKeyPath", "",
+		"File with the passphrase used to encrypt the password parameters, read from the SECRETS_KEY environment variable if empty")
+	runCmd.PersistentFlags().StringVar(&config.PolicyDirectory, "policyDirectory", "",
+		"Directory with the policies evaluated on the descriptors and the deployments")
+	runCmd.PersistentFlags().IntVar(&config.WatchBufferSize, "watchBufferSize", application.DefaultWatchBufferSize,
+		"Number of updates buffered for each watcher of an application instance")
+	runCmd.PersistentFlags().StringVar(&config.WatchDropPolicy, "watchDropPolicy", application.DropOldest.String(),
+		"Policy applied when the buffer of a watcher is full: drop_oldest, drop_newest or close_on_overflow")
+	runCmd.PersistentFlags().DurationVar(&config.ShutdownTimeout, "shutdownTimeout", 10*time.Second,
+		"Time given to the in-flight requests and bus updates to finish when the service stops")
 	rootCmd.AddCommand(runCmd)
 }
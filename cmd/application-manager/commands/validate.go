@@ -0,0 +1,204 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Validate descriptors and deploy requests offline
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/nalej/application-manager/internal/pkg/bundle"
+	"github.com/nalej/application-manager/internal/pkg/entities"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"os"
+)
+
+// validationOrganization and validationDescriptor are used as identifiers as files do not belong to any organization.
+const validationOrganization = "validation-organization"
+const validationDescriptor = "validation-descriptor"
+
+var deployRequestFile string
+var validateOutput string
+
+var validateCmd = &cobra.Command{
+	Use:   "validate [descriptor file]",
+	Short: "Validate a descriptor and a deploy request",
+	Long: `Validate an application descriptor and optionally a deploy request without connecting to the platform.
+All the problems found are printed and the command exits with an error if there is any`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		SetupLogging()
+		if validateOutput != "human" && validateOutput != "json" {
+			log.Fatal().Str("output", validateOutput).Msg("output must be human or json")
+		}
+		problems := validateFiles(args[0], deployRequestFile)
+		printProblems(problems, validateOutput)
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// Problem found while validating a descriptor or a deploy request.
+type Problem struct {
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Check   string `json:"check"`
+	Message string `json:"message"`
+}
+
+// validationCheck with a named validation of the chain.
+type validationCheck struct {
+	name  string
+	check func() derrors.Error
+}
+
+// runChecks executes all the checks and returns the problems found.
+func runChecks(fileName string, checks []validationCheck) []Problem {
+	problems := make([]Problem, 0)
+	for _, c := range checks {
+		err := c.check()
+		if err != nil {
+			problems = append(problems, Problem{File: fileName, Check: c.name, Message: err.Error()})
+		}
+	}
+	return problems
+}
+
+// bundleProblems converts the errors found in a descriptor or a bundle into problems. The descriptor violations are
+// reported with their check and path, the rest of the errors as decoding problems.
+func bundleProblems(errs []*bundle.Error) []Problem {
+	problems := make([]Problem, 0, len(errs))
+	for _, bErr := range errs {
+		if bErr.Violation != nil {
+			problems = append(problems, Problem{File: bErr.Position.File, Line: bErr.Position.Line,
+				Path: bErr.Violation.Path, Check: bErr.Violation.Code, Message: bErr.Violation.Message})
+			continue
+		}
+		problems = append(problems, Problem{File: bErr.Position.File, Line: bErr.Position.Line, Check: "decode", Message: bErr.Err.Error()})
+	}
+	return problems
+}
+
+// readFile reads and decodes a deploy request.
+func readFile(fileName string, check string, message proto.Message) *Problem {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return &Problem{File: fileName, Check: check, Message: err.Error()}
+	}
+	bErr := bundle.Decode(fileName, content, message)
+	if bErr != nil {
+		return &Problem{File: fileName, Line: bErr.Position.Line, Check: check, Message: bErr.Err.Error()}
+	}
+	return nil
+}
+
+// validateBundles validates a set of bundle files with the same checks applied to a single descriptor.
+func validateBundles(fileNames []string) []Problem {
+	problems := make([]Problem, 0)
+	for _, fileName := range fileNames {
+		content, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			problems = append(problems, Problem{File: fileName, Check: "decode", Message: err.Error()})
+			continue
+		}
+		_, errs := bundle.Parse(fileName, content, validationOrganization)
+		problems = append(problems, bundleProblems(errs)...)
+	}
+	return problems
+}
+
+// validateFiles runs the validation chain on a descriptor file and an optional deploy request file.
+func validateFiles(descriptorFile string, deployFile string) []Problem {
+	content, err := ioutil.ReadFile(descriptorFile)
+	if err != nil {
+		return []Problem{{File: descriptorFile, Check: "decode", Message: err.Error()}}
+	}
+	// the descriptor is validated as a descriptor of a bundle so both report the same problems at their lines
+	toAdd, errs := bundle.ParseDescriptor(descriptorFile, content, validationOrganization)
+	problems := bundleProblems(errs)
+	if toAdd == nil {
+		return problems
+	}
+
+	if deployFile == "" {
+		return problems
+	}
+
+	deployRequest := &grpc_application_manager_go.DeployRequest{}
+	if problem := readFile(deployFile, "decode", deployRequest); problem != nil {
+		return append(problems, *problem)
+	}
+	desc := entities.ToAppDescriptor(toAdd)
+	desc.AppDescriptorId = validationDescriptor
+	deployRequest.OrganizationId = desc.OrganizationId
+	deployRequest.AppDescriptorId = desc.AppDescriptorId
+
+	return append(problems, runChecks(deployFile, []validationCheck{
+		{"deploy_request", func() derrors.Error { return entities.ValidDeployRequest(deployRequest) }},
+		{"required_parameters", func() derrors.Error { return entities.ValidRequiredParameters(desc, deployRequest.Parameters) }},
+		{"required_outbounds", func() derrors.Error {
+			return entities.ValidRequiredOutbounds(deployRequest.OutboundConnections, desc.OutboundNetInterfaces)
+		}},
+		{"parametrized_descriptor", func() derrors.Error {
+			_, err := entities.CreateParametrizedDescriptor(desc, deployRequest.Parameters, nil)
+			return err
+		}},
+	})...)
+}
+
+// printProblems prints the problems found in human readable or JSON format.
+func printProblems(problems []Problem, output string) {
+	if output == "json" {
+		result, err := json.MarshalIndent(map[string]interface{}{
+			"valid":    len(problems) == 0,
+			"problems": problems,
+		}, "", "  ")
+		if err != nil {
+			log.Fatal().Err(err).Msg("cannot encode the result")
+		}
+		fmt.Println(string(result))
+		return
+	}
+	if len(problems) == 0 {
+		fmt.Println("OK")
+		return
+	}
+	for _, problem := range problems {
+		position := problem.File
+		if problem.Line > 0 {
+			position = fmt.Sprintf("%s:%d", problem.File, problem.Line)
+		}
+		if problem.Path != "" {
+			position = fmt.Sprintf("%s: %s", position, problem.Path)
+		}
+		fmt.Printf("%s: [%s] %s\n", position, problem.Check, problem.Message)
+	}
+	fmt.Printf("%d problems found\n", len(problems))
+}
+
+func init() {
+	validateCmd.Flags().StringVar(&deployRequestFile, "deployRequest", "", "Deploy request file (YAML or JSON)")
+	validateCmd.Flags().StringVar(&validateOutput, "output", "human", "Output format: human or json")
+	rootCmd.AddCommand(validateCmd)
+}
@@ -0,0 +1,429 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package bundle converts application descriptors from and to the declarative bundle format. A bundle is a YAML or
+// JSON document with a format version and a list of descriptors using the field names of the protocol buffers:
+//
+//	version: v1
+//	descriptors:
+//	- name: my-app
+//	  groups:
+//	  - name: g1
+//	    services:
+//	    - name: s1
+//	      image: nginx
+//
+// The identifiers assigned by the system (organization, descriptor, group, service and rule identifiers) are not
+// part of a bundle, so a bundle can be imported in any organization.
+package bundle
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/uuid"
+	"github.com/nalej/application-manager/internal/pkg/entities"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-go"
+	"gopkg.in/yaml.v2"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CurrentVersion with the version of the bundle format.
+const CurrentVersion = "v1"
+
+// Format of a bundle document.
+type Format int
+
+const (
+	YAML Format = iota + 1
+	JSON
+)
+
+// FormatToString maps the formats to their names.
+var FormatToString = map[Format]string{
+	YAML: "yaml",
+	JSON: "json",
+}
+
+// FormatFromString maps the names of the formats to the formats.
+var FormatFromString = map[string]Format{
+	"yaml": YAML,
+	"yml":  YAML,
+	"json": JSON,
+}
+
+// FormatFromFileName returns the format of a bundle file based on its extension. YAML is assumed by default.
+func FormatFromFileName(fileName string) Format {
+	format, exists := FormatFromString[strings.TrimPrefix(strings.ToLower(filepath.Ext(fileName)), ".")]
+	if !exists {
+		return YAML
+	}
+	return format
+}
+
+// systemFields contains the fields filled by the system that are removed when a descriptor is exported.
+var systemFields = map[string]bool{
+	"request_id":        true,
+	"organization_id":   true,
+	"app_descriptor_id": true,
+	"service_group_id":  true,
+	"service_id":        true,
+	"rule_id":           true,
+}
+
+// requestFields contains the fields of a request to add a descriptor. The fields of a stored descriptor that are
+// not part of the request, e.g. its creation timestamp, are removed when it is exported so it can be imported again.
+var requestFields = messageFields(reflect.TypeOf(grpc_application_go.AddAppDescriptorRequest{}))
+
+// messageFields returns the names of the fields of a protocol buffer message type.
+func messageFields(messageType reflect.Type) map[string]bool {
+	fields := make(map[string]bool, 0)
+	for _, prop := range proto.GetProperties(messageType).Prop {
+		fields[prop.OrigName] = true
+	}
+	return fields
+}
+
+// userMaps contains the fields whose keys are defined by the user and must be exported as they are.
+var userMaps = map[string]bool{
+	"labels":                true,
+	"environment_variables": true,
+	"configuration_options": true,
+}
+
+// yamlLineRegex extracts the line of a YAML syntax error.
+var yamlLineRegex = regexp.MustCompile(`line (\d+)`)
+
+// Position of an element in a bundle file. Line is 0 if the line is not known.
+type Position struct {
+	File string
+	Line int
+}
+
+func (p Position) String() string {
+	if p.Line == 0 {
+		return p.File
+	}
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
+// Error found while importing a bundle.
+type Error struct {
+	Position Position
+	// Descriptor with the name of the descriptor that contains the error, if any.
+	Descriptor string
+	// Violation with the descriptor violation that causes the error, nil for the errors of the document.
+	Violation *entities.Violation
+	Err       derrors.Error
+}
+
+func (e *Error) Error() string {
+	if e.Descriptor == "" {
+		return fmt.Sprintf("%s: %s", e.Position, e.Err.Error())
+	}
+	return fmt.Sprintf("%s: descriptor %s: %s", e.Position, e.Descriptor, e.Err.Error())
+}
+
+// Bundle with the descriptors read from a bundle document.
+type Bundle struct {
+	Version     string
+	Descriptors []*grpc_application_go.AddAppDescriptorRequest
+}
+
+// document with the structure of a bundle in YAML or JSON.
+type document struct {
+	Version     string                   `json:"version" yaml:"version"`
+	Descriptors []map[string]interface{} `json:"descriptors" yaml:"descriptors"`
+}
+
+// normalize converts the maps decoded by the YAML parser into maps that can be encoded as JSON.
+func normalize(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(typed))
+		for key, child := range typed {
+			result[fmt.Sprint(key)] = normalize(child)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(typed))
+		for index, child := range typed {
+			result[index] = normalize(child)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+// Parse reads a bundle document and returns the descriptors it contains ready to be added to the given organization.
+// All the errors found are returned with the position of the field that causes them, or of the descriptor if the
+// field is not defined in the document. YAML parsing also accepts JSON documents.
+func Parse(fileName string, raw []byte, organizationID string) (*Bundle, []*Error) {
+	decoded, pErr := parseDocument(fileName, raw)
+	if pErr != nil {
+		return nil, []*Error{pErr}
+	}
+
+	root, ok := decoded.(map[string]interface{})
+	if !ok {
+		return nil, []*Error{{
+			Position: Position{File: fileName, Line: 1},
+			Err:      derrors.NewInvalidArgumentError("bundle must be a document with version and descriptors"),
+		}}
+	}
+
+	errors := make([]*Error, 0)
+	keys := make([]string, 0, len(root))
+	for key := range root {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := fieldLines(raw)
+	for _, key := range keys {
+		if key != "version" && key != "descriptors" {
+			errors = append(errors, &Error{
+				Position: Position{File: fileName, Line: fieldLine(lines, key)},
+				Err:      derrors.NewInvalidArgumentError("unknown bundle field").WithParams(key),
+			})
+		}
+	}
+	version, _ := root["version"].(string)
+	if version != CurrentVersion {
+		errors = append(errors, &Error{
+			Position: Position{File: fileName, Line: fieldLine(lines, "version")},
+			Err:      derrors.NewInvalidArgumentError("unsupported bundle version").WithParams(version, CurrentVersion),
+		})
+	}
+	items, ok := root["descriptors"].([]interface{})
+	if !ok {
+		errors = append(errors, &Error{
+			Position: Position{File: fileName, Line: fieldLine(lines, "descriptors")},
+			Err:      derrors.NewInvalidArgumentError("bundle must contain a list of descriptors"),
+		})
+		return nil, errors
+	}
+
+	result := &Bundle{
+		Version:     version,
+		Descriptors: make([]*grpc_application_go.AddAppDescriptorRequest, 0, len(items)),
+	}
+	for index, item := range items {
+		descriptorPath := fmt.Sprintf("descriptors[%d]", index)
+		descriptor, dErr := decodeDescriptor(item)
+		if dErr != nil {
+			errors = append(errors, &Error{
+				Position: Position{File: fileName, Line: fieldLine(lines, descriptorPath)},
+				Err:      dErr,
+			})
+			continue
+		}
+		errors = append(errors, prepareDescriptor(fileName, lines, descriptorPath, descriptor, organizationID)...)
+		result.Descriptors = append(result.Descriptors, descriptor)
+	}
+
+	if len(errors) > 0 {
+		return nil, errors
+	}
+	return result, nil
+}
+
+// ParseDescriptor reads a document with a single descriptor and returns it ready to be added to the given
+// organization along with all the errors found, reported at the position of the field that causes them. The
+// descriptor is nil if the document cannot be decoded.
+func ParseDescriptor(fileName string, raw []byte, organizationID string) (*grpc_application_go.AddAppDescriptorRequest, []*Error) {
+	decoded, pErr := parseDocument(fileName, raw)
+	if pErr != nil {
+		return nil, []*Error{pErr}
+	}
+	descriptor, dErr := decodeDescriptor(decoded)
+	if dErr != nil {
+		return nil, []*Error{{Position: Position{File: fileName}, Err: dErr}}
+	}
+	return descriptor, prepareDescriptor(fileName, fieldLines(raw), "", descriptor, organizationID)
+}
+
+// prepareDescriptor assigns a decoded descriptor to an organization and validates it. Each violation is reported on
+// the line of the field that causes it, the fields of the descriptor are found under the given path of the document.
+func prepareDescriptor(fileName string, lines map[string]int, descriptorPath string, descriptor *grpc_application_go.AddAppDescriptorRequest, organizationID string) []*Error {
+	descriptor.OrganizationId = organizationID
+	if descriptor.RequestId == "" {
+		descriptor.RequestId = uuid.New().String()
+	}
+	errors := make([]*Error, 0)
+	for _, violation := range validateDescriptor(descriptor) {
+		fieldPath := violation.Path
+		if descriptorPath != "" {
+			fieldPath = fmt.Sprintf("%s.%s", descriptorPath, violation.Path)
+		}
+		errors = append(errors, &Error{
+			Position:   Position{File: fileName, Line: fieldLine(lines, fieldPath)},
+			Descriptor: descriptor.Name,
+			Violation:  violation,
+			Err:        derrors.NewInvalidArgumentError(violation.String()),
+		})
+	}
+	return errors
+}
+
+// decodeDescriptor converts a decoded descriptor into an AddAppDescriptorRequest. Unknown fields are rejected.
+func decodeDescriptor(item interface{}) (*grpc_application_go.AddAppDescriptorRequest, derrors.Error) {
+	descriptor := &grpc_application_go.AddAppDescriptorRequest{}
+	err := decodeMessage(item, descriptor)
+	if err != nil {
+		return nil, err
+	}
+	return descriptor, nil
+}
+
+// decodeMessage fills a message with a decoded YAML or JSON object. Unknown fields are rejected.
+func decodeMessage(item interface{}, message proto.Message) derrors.Error {
+	if _, ok := item.(map[string]interface{}); !ok {
+		return derrors.NewInvalidArgumentError("expecting an object")
+	}
+	raw, err := json.Marshal(item)
+	if err != nil {
+		return derrors.NewInvalidArgumentError("cannot decode object", err)
+	}
+	err = jsonpb.Unmarshal(bytes.NewReader(raw), message)
+	if err != nil {
+		return derrors.NewInvalidArgumentError("cannot decode object", err)
+	}
+	return nil
+}
+
+// parseDocument parses a YAML or JSON document.
+func parseDocument(fileName string, raw []byte) (interface{}, *Error) {
+	var decoded interface{}
+	err := yaml.Unmarshal(raw, &decoded)
+	if err != nil {
+		line := 0
+		if match := yamlLineRegex.FindStringSubmatch(err.Error()); match != nil {
+			line, _ = strconv.Atoi(match[1])
+		}
+		return nil, &Error{
+			Position: Position{File: fileName, Line: line},
+			Err:      derrors.NewInvalidArgumentError("cannot parse document", err),
+		}
+	}
+	return normalize(decoded), nil
+}
+
+// Decode fills a message with a YAML or JSON document that uses the field names of the protocol buffers, for
+// example a single descriptor or a deploy request.
+func Decode(fileName string, raw []byte, message proto.Message) *Error {
+	decoded, pErr := parseDocument(fileName, raw)
+	if pErr != nil {
+		return pErr
+	}
+	err := decodeMessage(decoded, message)
+	if err != nil {
+		return &Error{Position: Position{File: fileName}, Err: err}
+	}
+	return nil
+}
+
+// validateDescriptor applies the validations done when a descriptor is added and returns all the violations found.
+func validateDescriptor(descriptor *grpc_application_go.AddAppDescriptorRequest) []*entities.Violation {
+	return entities.CollectDescriptorViolations(descriptor).List
+}
+
+// ExportDescriptors converts stored descriptors into a bundle document.
+func ExportDescriptors(descriptors []*grpc_application_go.AppDescriptor, format Format) ([]byte, derrors.Error) {
+	messages := make([]proto.Message, 0, len(descriptors))
+	for _, descriptor := range descriptors {
+		messages = append(messages, descriptor)
+	}
+	return export(messages, format)
+}
+
+// ExportRequests converts a set of requests to add descriptors into a bundle document.
+func ExportRequests(descriptors []*grpc_application_go.AddAppDescriptorRequest, format Format) ([]byte, derrors.Error) {
+	messages := make([]proto.Message, 0, len(descriptors))
+	for _, descriptor := range descriptors {
+		messages = append(messages, descriptor)
+	}
+	return export(messages, format)
+}
+
+func export(messages []proto.Message, format Format) ([]byte, derrors.Error) {
+	marshaler := jsonpb.Marshaler{OrigName: true}
+	doc := document{
+		Version:     CurrentVersion,
+		Descriptors: make([]map[string]interface{}, 0, len(messages)),
+	}
+	for _, message := range messages {
+		raw, err := marshaler.MarshalToString(message)
+		if err != nil {
+			return nil, derrors.NewInternalError("cannot export descriptor", err)
+		}
+		var decoded map[string]interface{}
+		err = json.Unmarshal([]byte(raw), &decoded)
+		if err != nil {
+			return nil, derrors.NewInternalError("cannot export descriptor", err)
+		}
+		for key := range decoded {
+			if !requestFields[key] {
+				delete(decoded, key)
+			}
+		}
+		removeSystemFields(decoded)
+		doc.Descriptors = append(doc.Descriptors, decoded)
+	}
+
+	var result []byte
+	var err error
+	switch format {
+	case JSON:
+		result, err = json.MarshalIndent(doc, "", "  ")
+	case YAML:
+		result, err = yaml.Marshal(doc)
+	default:
+		return nil, derrors.NewInvalidArgumentError("unsupported bundle format").WithParams(format)
+	}
+	if err != nil {
+		return nil, derrors.NewInternalError("cannot encode bundle", err)
+	}
+	return result, nil
+}
+
+// removeSystemFields removes recursively the fields assigned by the system.
+func removeSystemFields(value interface{}) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, child := range typed {
+			if systemFields[key] {
+				delete(typed, key)
+				continue
+			}
+			if !userMaps[key] {
+				removeSystemFields(child)
+			}
+		}
+	case []interface{}:
+		for _, child := range typed {
+			removeSystemFields(child)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bundle
+
+import (
+	"github.com/nalej/application-manager/internal/pkg/utils"
+	"github.com/nalej/grpc-application-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+const validBundle = `version: v1
+descriptors:
+- name: first
+  groups:
+  - name: g1
+    services:
+    - name: s1
+      image: nginx
+- name: second
+  labels:
+    service_id: kept
+  groups:
+  - name: g1
+    services:
+    - name: s1
+      image: nginx
+`
+
+const invalidBundle = `version: v1
+descriptors:
+- name: first
+  groups:
+  - name: g1
+    services:
+    - name: s1
+      image: nginx
+- name: second
+  groups: []
+- name: third
+  unknown_field: value
+`
+
+const unknownReferenceBundle = `version: v1
+descriptors:
+- name: first
+  groups:
+  - name: g1
+    services:
+    - name: s1
+      image: nginx
+      deploy_after:
+      - missing
+`
+
+const unknownReferenceDescriptor = `name: first
+groups:
+- name: g1
+  services:
+  - name: s1
+    image: nginx
+    deploy_after:
+    - missing
+`
+
+const invalidJSONBundle = `{
+  "version": "v1",
+  "descriptors": [
+    {
+      "name": "first",
+      "groups": [{"name": "g1", "services": [{"name": "s1", "image": "nginx"}]}]
+    },
+    {
+      "name": "second"
+    }
+  ]
+}`
+
+var _ = ginkgo.Describe("Bundles", func() {
+
+	ginkgo.It("should parse a valid bundle", func() {
+		result, errs := Parse("bundle.yaml", []byte(validBundle), "org")
+		gomega.Expect(errs).To(gomega.BeNil())
+		gomega.Expect(result.Version).Should(gomega.Equal(CurrentVersion))
+		gomega.Expect(len(result.Descriptors)).Should(gomega.Equal(2))
+		for _, descriptor := range result.Descriptors {
+			gomega.Expect(descriptor.OrganizationId).Should(gomega.Equal("org"))
+			gomega.Expect(descriptor.RequestId).ShouldNot(gomega.BeEmpty())
+		}
+		gomega.Expect(result.Descriptors[1].Labels["service_id"]).Should(gomega.Equal("kept"))
+	})
+
+	ginkgo.It("should report all the errors with their positions", func() {
+		_, errs := Parse("bundle.yaml", []byte(invalidBundle), "org")
+		gomega.Expect(len(errs)).Should(gomega.Equal(2))
+		// the missing services are reported on the groups field
+		gomega.Expect(errs[0].Position.Line).Should(gomega.Equal(10))
+		gomega.Expect(errs[0].Descriptor).Should(gomega.Equal("second"))
+		gomega.Expect(errs[1].Position.Line).Should(gomega.Equal(11))
+		gomega.Expect(errs[1].Error()).Should(gomega.HavePrefix("bundle.yaml:11: "))
+	})
+
+	ginkgo.It("should report the position of the field that causes a violation", func() {
+		_, errs := Parse("bundle.yaml", []byte(unknownReferenceBundle), "org")
+		gomega.Expect(len(errs)).Should(gomega.Equal(1))
+		gomega.Expect(errs[0].Position.Line).Should(gomega.Equal(10))
+		gomega.Expect(errs[0].Error()).Should(gomega.ContainSubstring("groups[0].services[0].deploy_after[0]"))
+	})
+
+	ginkgo.It("should report the position of the violations of a single descriptor", func() {
+		descriptor, errs := ParseDescriptor("descriptor.yaml", []byte(unknownReferenceDescriptor), "org")
+		gomega.Expect(descriptor).NotTo(gomega.BeNil())
+		gomega.Expect(descriptor.OrganizationId).Should(gomega.Equal("org"))
+		gomega.Expect(len(errs)).Should(gomega.Equal(1))
+		gomega.Expect(errs[0].Position.Line).Should(gomega.Equal(8))
+		gomega.Expect(errs[0].Violation).NotTo(gomega.BeNil())
+		gomega.Expect(errs[0].Violation.Path).Should(gomega.Equal("groups[0].services[0].deploy_after[0]"))
+	})
+
+	ginkgo.It("should report the positions of JSON bundles", func() {
+		_, errs := Parse("bundle.json", []byte(invalidJSONBundle), "org")
+		gomega.Expect(len(errs)).Should(gomega.Equal(1))
+		gomega.Expect(errs[0].Position.Line).Should(gomega.Equal(8))
+	})
+
+	ginkgo.It("should report syntax errors", func() {
+		_, errs := Parse("bundle.yaml", []byte("version: v1\ndescriptors:\n- name: [\n"), "org")
+		gomega.Expect(len(errs)).Should(gomega.Equal(1))
+		gomega.Expect(errs[0].Position.Line).ShouldNot(gomega.BeZero())
+	})
+
+	ginkgo.It("should reject unsupported versions", func() {
+		_, errs := Parse("bundle.yaml", []byte("version: v0\ndescriptors: []\n"), "org")
+		gomega.Expect(len(errs)).Should(gomega.Equal(1))
+		gomega.Expect(errs[0].Position.Line).Should(gomega.Equal(1))
+	})
+
+	ginkgo.It("should return the same bundle after a round trip", func() {
+		for _, format := range []Format{YAML, JSON} {
+			exported, err := ExportRequests([]*grpc_application_go.AddAppDescriptorRequest{utils.CreateTestAddDescriptorWithParameters()}, format)
+			gomega.Expect(err).To(gomega.BeNil())
+			imported, errs := Parse("bundle", exported, "org")
+			gomega.Expect(errs).To(gomega.BeNil())
+			reexported, err := ExportRequests(imported.Descriptors, format)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(string(reexported)).Should(gomega.Equal(string(exported)))
+		}
+	})
+
+	ginkgo.It("should not export the identifiers assigned by the system", func() {
+		descriptor := utils.CreateTestDescriptor()
+		exported, err := ExportDescriptors([]*grpc_application_go.AppDescriptor{descriptor}, YAML)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(string(exported)).ShouldNot(gomega.ContainSubstring("app_descriptor_id"))
+		gomega.Expect(string(exported)).ShouldNot(gomega.ContainSubstring("organization_id"))
+	})
+
+	ginkgo.It("should import an exported descriptor", func() {
+		descriptor := &grpc_application_go.AppDescriptor{
+			OrganizationId:  "org",
+			AppDescriptorId: "desc",
+			Name:            "first",
+			Created:         1234,
+			Groups: []*grpc_application_go.ServiceGroup{{
+				OrganizationId:  "org",
+				AppDescriptorId: "desc",
+				ServiceGroupId:  "g1",
+				Name:            "g1",
+				Services: []*grpc_application_go.Service{{
+					OrganizationId:  "org",
+					AppDescriptorId: "desc",
+					ServiceGroupId:  "g1",
+					ServiceId:       "s1",
+					Name:            "s1",
+					Image:           "nginx",
+				}},
+			}},
+		}
+		for _, format := range []Format{YAML, JSON} {
+			exported, err := ExportDescriptors([]*grpc_application_go.AppDescriptor{descriptor}, format)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(string(exported)).ShouldNot(gomega.ContainSubstring("created"))
+			imported, errs := Parse("bundle", exported, "org")
+			gomega.Expect(errs).To(gomega.BeNil())
+			gomega.Expect(imported.Descriptors).Should(gomega.HaveLen(1))
+			gomega.Expect(imported.Descriptors[0].Name).Should(gomega.Equal(descriptor.Name))
+		}
+	})
+})
@@ -0,0 +1,83 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bundle
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"strings"
+)
+
+// fieldLines returns the line where each field of a document is defined indexed by its path, using the notation of
+// the descriptor violations, e.g. descriptors[0].groups[1].services[0].image. JSON documents are indexed too as they
+// are valid YAML. The document is parsed with yaml.v3 as it keeps the position of the nodes. The index is empty if the
+// document cannot be parsed.
+func fieldLines(raw []byte) map[string]int {
+	lines := make(map[string]int, 0)
+	var root yaml.Node
+	if err := yaml.Unmarshal(raw, &root); err != nil {
+		return lines
+	}
+	indexNode("", &root, lines)
+	return lines
+}
+
+// indexNode adds the lines of the fields of a node to the index.
+func indexNode(path string, node *yaml.Node, lines map[string]int) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			indexNode(path, child, lines)
+		}
+	case yaml.MappingNode:
+		for index := 0; index+1 < len(node.Content); index += 2 {
+			key := node.Content[index]
+			childPath := key.Value
+			if path != "" {
+				childPath = fmt.Sprintf("%s.%s", path, key.Value)
+			}
+			lines[childPath] = key.Line
+			indexNode(childPath, node.Content[index+1], lines)
+		}
+	case yaml.SequenceNode:
+		for index, child := range node.Content {
+			childPath := fmt.Sprintf("%s[%d]", path, index)
+			lines[childPath] = child.Line
+			indexNode(childPath, child, lines)
+		}
+	case yaml.AliasNode:
+		if node.Alias != nil {
+			indexNode(path, node.Alias, lines)
+		}
+	}
+}
+
+// fieldLine returns the line of a field of the document. If the field is not defined, for example because it is a
+// required field that is missing, the line of the closest parent defined is returned. It returns 0 if none is found.
+func fieldLine(lines map[string]int, path string) int {
+	for path != "" {
+		if line, exists := lines[path]; exists {
+			return line
+		}
+		index := strings.LastIndexAny(path, ".[")
+		if index < 0 {
+			return 0
+		}
+		path = path[:index]
+	}
+	return 0
+}
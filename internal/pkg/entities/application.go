@@ -71,3 +71,19 @@ type ServiceInstanceLogSummary struct {
 	ServiceInstanceId string
 	Name              string
 }
+
+// ToAppDescriptor converts a request to add a descriptor into the descriptor that would be stored.
+func ToAppDescriptor(source *grpc_application_go.AddAppDescriptorRequest) *grpc_application_go.AppDescriptor {
+	return &grpc_application_go.AppDescriptor{
+		OrganizationId:        source.OrganizationId,
+		Name:                  source.Name,
+		ConfigurationOptions:  source.ConfigurationOptions,
+		EnvironmentVariables:  source.EnvironmentVariables,
+		Labels:                source.Labels,
+		Rules:                 source.Rules,
+		Groups:                source.Groups,
+		Parameters:            source.Parameters,
+		InboundNetInterfaces:  source.InboundNetInterfaces,
+		OutboundNetInterfaces: source.OutboundNetInterfaces,
+	}
+}
@@ -0,0 +1,304 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Constraints of the descriptor parameters, declared in the constraints field of each parameter definition:
+//
+//   parameters:
+//   - name: replicas
+//     type: INTEGER
+//     constraints: {min: "1", max: "5"}
+//   - name: password
+//     type: PASSWORD
+//     constraints: {min_length: 8, required_if: [{parameter: auth, value: "true"}]}
+//
+// Min and max are parsed with the type of the parameter, so INTEGER bounds are compared as integers. A length of zero
+// means no limit. LIST and MAP parameters receive JSON-encoded values that replace a whole list of strings or map of
+// strings of the descriptor, e.g. ["dg1", "dg2"] or {"env": "prod"}. Their pattern and format apply to each element
+// and their length to the number of elements.
+
+package entities
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-go"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const invalidParamConstraints = "invalid param constraints"
+const paramConstraintNotMet = "param value does not meet the constraints"
+
+// Formats supported for string parameters.
+const (
+	HostnameFormat = "hostname"
+	CIDRFormat     = "cidr"
+	URLFormat      = "url"
+	MemoryFormat   = "memory"
+	CPUFormat      = "cpu"
+)
+
+// memoryQuantityRegex and cpuQuantityRegex validate the quantities in the format used by the service specs.
+const memoryQuantityRegex = "^[0-9]+(\\.[0-9]+)?(Ki|Mi|Gi|Ti|Pi|Ei|k|M|G|T|P|E)?$"
+const cpuQuantityRegex = "^[0-9]+(\\.[0-9]+)?m?$"
+
+func isNumericParam(param *grpc_application_go.AppParameter) bool {
+	return param.Type == grpc_application_go.ParamDataType_INTEGER || param.Type == grpc_application_go.ParamDataType_FLOAT
+}
+
+func isStringParam(param *grpc_application_go.AppParameter) bool {
+	return param.Type == grpc_application_go.ParamDataType_STRING || param.Type == grpc_application_go.ParamDataType_PASSWORD ||
+		param.Type == grpc_application_go.ParamDataType_ENUM
+}
+
+func isCollectionParam(param *grpc_application_go.AppParameter) bool {
+	return param.Type == grpc_application_go.ParamDataType_LIST || param.Type == grpc_application_go.ParamDataType_MAP
+}
+
+// compareNumeric compares two values of a numeric parameter, returning a negative number if a is lower than b, zero
+// if they are equal and a positive number otherwise. INTEGER values are parsed as integers so they are not rounded.
+func compareNumeric(paramType grpc_application_go.ParamDataType, a string, b string) (int, error) {
+	if paramType == grpc_application_go.ParamDataType_INTEGER {
+		x, err := strconv.ParseInt(a, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		y, err := strconv.ParseInt(b, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+		return 0, nil
+	}
+	x, err := strconv.ParseFloat(a, 64)
+	if err != nil {
+		return 0, err
+	}
+	y, err := strconv.ParseFloat(b, 64)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case x < y:
+		return -1, nil
+	case x > y:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// ValidateParamConstraints checks the constraints of a parameter are consistent with its type and the conditions
+// refer to other parameters of the descriptor.
+func ValidateParamConstraints(param *grpc_application_go.AppParameter, params map[string]*grpc_application_go.AppParameter) derrors.Error {
+	c := param.Constraints
+	if c == nil {
+		return nil
+	}
+	if (c.Min != "" || c.Max != "") && !isNumericParam(param) {
+		return derrors.NewInvalidArgumentError("min and max are only allowed in numeric params").WithParams(param.Name)
+	}
+	for _, bound := range []string{c.Min, c.Max} {
+		if bound == "" {
+			continue
+		}
+		if _, err := compareNumeric(param.Type, bound, bound); err != nil {
+			return derrors.NewInvalidArgumentError("invalid range bound", err).WithParams(param.Name, bound)
+		}
+	}
+	if c.Min != "" && c.Max != "" {
+		if cmp, _ := compareNumeric(param.Type, c.Min, c.Max); cmp > 0 {
+			return derrors.NewInvalidArgumentError("min cannot be greater than max").WithParams(param.Name)
+		}
+	}
+	if (c.Pattern != "" || c.MinLength != 0 || c.MaxLength != 0 || c.Format != "") && !isStringParam(param) && !isCollectionParam(param) {
+		return derrors.NewInvalidArgumentError("pattern, length and format are only allowed in string, list and map params").WithParams(param.Name)
+	}
+	if c.MinLength < 0 || c.MaxLength < 0 {
+		return derrors.NewInvalidArgumentError("length cannot be negative").WithParams(param.Name)
+	}
+	if c.MaxLength != 0 && c.MinLength > c.MaxLength {
+		return derrors.NewInvalidArgumentError("min_length cannot be greater than max_length").WithParams(param.Name)
+	}
+	if c.Pattern != "" {
+		if _, err := regexp.Compile(c.Pattern); err != nil {
+			return derrors.NewInvalidArgumentError("invalid pattern", err).WithParams(param.Name, c.Pattern)
+		}
+	}
+	switch c.Format {
+	case "", HostnameFormat, CIDRFormat, URLFormat, MemoryFormat, CPUFormat:
+	default:
+		return derrors.NewInvalidArgumentError("unknown format").WithParams(param.Name, c.Format)
+	}
+	for _, condition := range c.RequiredIf {
+		if condition.Parameter == param.Name {
+			return derrors.NewInvalidArgumentError("a param cannot be required on its own value").WithParams(param.Name)
+		}
+		if _, exists := params[condition.Parameter]; !exists {
+			return derrors.NewInvalidArgumentError("condition refers to an undefined param").WithParams(param.Name, condition.Parameter)
+		}
+	}
+	return nil
+}
+
+// CheckParamConstraints validates a value of a parameter meets its constraints.
+func CheckParamConstraints(param *grpc_application_go.AppParameter, value string) derrors.Error {
+	c := param.Constraints
+	if c == nil {
+		return nil
+	}
+	if isNumericParam(param) {
+		if _, err := compareNumeric(param.Type, value, value); err != nil {
+			return derrors.NewInvalidArgumentError(invalidParamType, err).WithParams(param.Name, value)
+		}
+		if c.Min != "" {
+			if cmp, err := compareNumeric(param.Type, value, c.Min); err != nil || cmp < 0 {
+				return derrors.NewInvalidArgumentError(paramConstraintNotMet).WithParams(param.Name, fmt.Sprintf("%s is lower than %s", value, c.Min))
+			}
+		}
+		if c.Max != "" {
+			if cmp, err := compareNumeric(param.Type, value, c.Max); err != nil || cmp > 0 {
+				return derrors.NewInvalidArgumentError(paramConstraintNotMet).WithParams(param.Name, fmt.Sprintf("%s is greater than %s", value, c.Max))
+			}
+		}
+		return nil
+	}
+	if isCollectionParam(param) {
+		return checkCollection(param, value)
+	}
+	if !isStringParam(param) {
+		return nil
+	}
+	if c.MinLength != 0 && len(value) < int(c.MinLength) {
+		return derrors.NewInvalidArgumentError(paramConstraintNotMet).WithParams(param.Name, fmt.Sprintf("length lower than %d", c.MinLength))
+	}
+	if c.MaxLength != 0 && len(value) > int(c.MaxLength) {
+		return derrors.NewInvalidArgumentError(paramConstraintNotMet).WithParams(param.Name, fmt.Sprintf("length greater than %d", c.MaxLength))
+	}
+	return checkElement(param, value)
+}
+
+// checkElement validates the pattern and the format of a string value or an element of a collection.
+func checkElement(param *grpc_application_go.AppParameter, value string) derrors.Error {
+	c := param.Constraints
+	if c.Pattern != "" {
+		matched, err := regexp.MatchString(fmt.Sprintf("^(?:%s)$", c.Pattern), value)
+		if err != nil || !matched {
+			return derrors.NewInvalidArgumentError(paramConstraintNotMet).WithParams(param.Name, fmt.Sprintf("%s does not match %s", value, c.Pattern))
+		}
+	}
+	if c.Format != "" && !validFormat(c.Format, value) {
+		return derrors.NewInvalidArgumentError(paramConstraintNotMet).WithParams(param.Name, fmt.Sprintf("%s is not a valid %s", value, c.Format))
+	}
+	return nil
+}
+
+// checkCollection validates the number of elements of a collection and each one of them.
+func checkCollection(param *grpc_application_go.AppParameter, value string) derrors.Error {
+	c := param.Constraints
+	decoded, err := CollectionValue(param, value)
+	if err != nil {
+		return err
+	}
+	elements := make([]string, 0)
+	switch collection := decoded.(type) {
+	case []string:
+		elements = collection
+	case map[string]string:
+		for _, element := range collection {
+			elements = append(elements, element)
+		}
+	}
+	if c.MinLength != 0 && len(elements) < int(c.MinLength) {
+		return derrors.NewInvalidArgumentError(paramConstraintNotMet).WithParams(param.Name, fmt.Sprintf("less than %d elements", c.MinLength))
+	}
+	if c.MaxLength != 0 && len(elements) > int(c.MaxLength) {
+		return derrors.NewInvalidArgumentError(paramConstraintNotMet).WithParams(param.Name, fmt.Sprintf("more than %d elements", c.MaxLength))
+	}
+	for _, element := range elements {
+		if err := checkElement(param, element); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CollectionValue decodes the JSON value of a LIST or MAP parameter into a list or a map of strings. The value of any
+// other parameter is returned as it is.
+func CollectionValue(param *grpc_application_go.AppParameter, value string) (interface{}, derrors.Error) {
+	var decoded interface{}
+	switch param.Type {
+	case grpc_application_go.ParamDataType_LIST:
+		list := make([]string, 0)
+		decoded = &list
+	case grpc_application_go.ParamDataType_MAP:
+		values := make(map[string]string, 0)
+		decoded = &values
+	default:
+		return value, nil
+	}
+	err := json.Unmarshal([]byte(value), decoded)
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError(invalidParamType, err).WithParams(param.Name, param.Type.String())
+	}
+	switch result := decoded.(type) {
+	case *[]string:
+		return *result, nil
+	case *map[string]string:
+		return *result, nil
+	}
+	return value, nil
+}
+
+// validFormat checks if a value has one of the supported formats.
+func validFormat(format string, value string) bool {
+	switch format {
+	case HostnameFormat:
+		return len(validation.IsDNS1123Subdomain(value)) == 0
+	case CIDRFormat:
+		_, _, err := net.ParseCIDR(value)
+		return err == nil
+	case URLFormat:
+		parsed, err := url.ParseRequestURI(value)
+		return err == nil && parsed.Scheme != "" && parsed.Host != ""
+	case MemoryFormat:
+		return regexp.MustCompile(memoryQuantityRegex).MatchString(value)
+	case CPUFormat:
+		return regexp.MustCompile(cpuQuantityRegex).MatchString(value)
+	}
+	return true
+}
+
+// IsParamRequired checks if any of the conditions that make a parameter required is true. Values contains the value
+// of every parameter of the deployment, either supplied or by default.
+func IsParamRequired(param *grpc_application_go.AppParameter, values map[string]string) bool {
+	for _, condition := range param.GetConstraints().GetRequiredIf() {
+		value, exists := values[condition.Parameter]
+		if exists && strings.EqualFold(value, condition.Value) {
+			return true
+		}
+	}
+	return false
+}
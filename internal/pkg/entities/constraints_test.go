@@ -0,0 +1,123 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"github.com/nalej/application-manager/internal/pkg/utils"
+	"github.com/nalej/grpc-application-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Parameter constraints", func() {
+
+	ginkgo.Context("Values", func() {
+		integer := func(constraints *grpc_application_go.ParamConstraints) *grpc_application_go.AppParameter {
+			return &grpc_application_go.AppParameter{Name: "replicas", Type: grpc_application_go.ParamDataType_INTEGER, Constraints: constraints}
+		}
+		str := func(constraints *grpc_application_go.ParamConstraints) *grpc_application_go.AppParameter {
+			return &grpc_application_go.AppParameter{Name: "value", Type: grpc_application_go.ParamDataType_STRING, Constraints: constraints}
+		}
+
+		ginkgo.It("should check numeric ranges", func() {
+			param := integer(&grpc_application_go.ParamConstraints{Min: "1", Max: "5"})
+			gomega.Expect(CheckParamConstraints(param, "3")).To(gomega.Succeed())
+			gomega.Expect(CheckParamConstraints(param, "0")).NotTo(gomega.Succeed())
+			gomega.Expect(CheckParamConstraints(param, "6")).NotTo(gomega.Succeed())
+			gomega.Expect(CheckParamConstraints(param, "3.5")).NotTo(gomega.Succeed())
+		})
+		ginkgo.It("should compare integer ranges without rounding", func() {
+			param := integer(&grpc_application_go.ParamConstraints{Max: "9007199254740992"})
+			gomega.Expect(CheckParamConstraints(param, "9007199254740992")).To(gomega.Succeed())
+			gomega.Expect(CheckParamConstraints(param, "9007199254740993")).NotTo(gomega.Succeed())
+		})
+		ginkgo.It("should check float ranges", func() {
+			param := &grpc_application_go.AppParameter{Name: "ratio", Type: grpc_application_go.ParamDataType_FLOAT,
+				Constraints: &grpc_application_go.ParamConstraints{Min: "0.5", Max: "1"}}
+			gomega.Expect(CheckParamConstraints(param, "0.75")).To(gomega.Succeed())
+			gomega.Expect(CheckParamConstraints(param, "0.25")).NotTo(gomega.Succeed())
+		})
+		ginkgo.It("should check patterns and lengths", func() {
+			param := str(&grpc_application_go.ParamConstraints{Pattern: "[a-z]+", MinLength: 2, MaxLength: 4})
+			gomega.Expect(CheckParamConstraints(param, "abc")).To(gomega.Succeed())
+			gomega.Expect(CheckParamConstraints(param, "abc1")).NotTo(gomega.Succeed())
+			gomega.Expect(CheckParamConstraints(param, "a")).NotTo(gomega.Succeed())
+			gomega.Expect(CheckParamConstraints(param, "abcde")).NotTo(gomega.Succeed())
+		})
+		ginkgo.It("should check formats", func() {
+			valid := map[string]string{HostnameFormat: "db.nalej.com", CIDRFormat: "10.0.0.0/8",
+				URLFormat: "https://nalej.com/path", MemoryFormat: "512Mi", CPUFormat: "250m"}
+			invalid := map[string]string{HostnameFormat: "db_nalej", CIDRFormat: "10.0.0.0",
+				URLFormat: "nalej.com", MemoryFormat: "512MB", CPUFormat: "1 core"}
+			for format, value := range valid {
+				gomega.Expect(CheckParamConstraints(str(&grpc_application_go.ParamConstraints{Format: format}), value)).To(gomega.Succeed())
+			}
+			for format, value := range invalid {
+				gomega.Expect(CheckParamConstraints(str(&grpc_application_go.ParamConstraints{Format: format}), value)).NotTo(gomega.Succeed())
+			}
+		})
+		ginkgo.It("should reject constraints not matching the param type", func() {
+			params := map[string]*grpc_application_go.AppParameter{"replicas": integer(nil), "value": str(nil)}
+			gomega.Expect(ValidateParamConstraints(str(&grpc_application_go.ParamConstraints{Min: "1"}), params)).NotTo(gomega.Succeed())
+			gomega.Expect(ValidateParamConstraints(integer(&grpc_application_go.ParamConstraints{Min: "1.5"}), params)).NotTo(gomega.Succeed())
+			gomega.Expect(ValidateParamConstraints(integer(&grpc_application_go.ParamConstraints{Min: "5", Max: "1"}), params)).NotTo(gomega.Succeed())
+			gomega.Expect(ValidateParamConstraints(integer(&grpc_application_go.ParamConstraints{Format: HostnameFormat}), params)).NotTo(gomega.Succeed())
+			gomega.Expect(ValidateParamConstraints(str(&grpc_application_go.ParamConstraints{Pattern: "[a-"}), params)).NotTo(gomega.Succeed())
+			gomega.Expect(ValidateParamConstraints(str(&grpc_application_go.ParamConstraints{
+				RequiredIf: []*grpc_application_go.ParamCondition{{Parameter: "unknown", Value: "true"}}}), params)).NotTo(gomega.Succeed())
+		})
+	})
+
+	ginkgo.Context("Descriptors", func() {
+		ginkgo.It("should check the default values", func() {
+			descriptor := utils.CreateTestAddDescriptorWithParameters()
+			descriptor.Parameters[0].Constraints = &grpc_application_go.ParamConstraints{Min: "1", Max: "5"}
+			gomega.Expect(ValidateDescriptorParameters(descriptor)).To(gomega.Succeed())
+
+			descriptor.Parameters[0].Constraints = &grpc_application_go.ParamConstraints{Max: "2"}
+			gomega.Expect(ValidateDescriptorParameters(descriptor)).NotTo(gomega.Succeed())
+		})
+		ginkgo.It("should report invalid constraints at the parameter", func() {
+			descriptor := utils.CreateTestAddDescriptorWithParameters()
+			descriptor.Parameters[0].Constraints = &grpc_application_go.ParamConstraints{Pattern: "[a-z]+"}
+			violations := NewViolations()
+			collectDescriptorParametersViolations(descriptor, violations)
+			gomega.Expect(violations.List).Should(gomega.HaveLen(1))
+			gomega.Expect(violations.List[0].Path).Should(gomega.Equal("parameters[0].constraints"))
+		})
+		ginkgo.It("should check the supplied values and the conditions", func() {
+			descriptor := utils.CreateTestDescriptorWithParameters()
+			descriptor.Parameters[0].Constraints = &grpc_application_go.ParamConstraints{Min: "1", Max: "5"}
+			descriptor.Parameters[1].Constraints = &grpc_application_go.ParamConstraints{
+				RequiredIf: []*grpc_application_go.ParamCondition{{Parameter: "replicas", Value: "4"}}}
+
+			_, err := CreateParametrizedDescriptor(descriptor, &grpc_application_go.InstanceParameterList{
+				Parameters: []*grpc_application_go.InstanceParameter{{ParameterName: "replicas", Value: "10"}}}, nil)
+			gomega.Expect(err).NotTo(gomega.Succeed())
+
+			_, err = CreateParametrizedDescriptor(descriptor, &grpc_application_go.InstanceParameterList{
+				Parameters: []*grpc_application_go.InstanceParameter{{ParameterName: "replicas", Value: "4"}}}, nil)
+			gomega.Expect(err).NotTo(gomega.Succeed())
+
+			parametrized, err := CreateParametrizedDescriptor(descriptor, &grpc_application_go.InstanceParameterList{
+				Parameters: []*grpc_application_go.InstanceParameter{{ParameterName: "replicas", Value: "4"},
+					{ParameterName: "env1", Value: "modified"}}}, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(parametrized.Groups[0].Specs.Replicas).Should(gomega.Equal(int32(4)))
+		})
+	})
+})
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Dependency graph of the services of a group built from the DeployAfter fields.
+
+package entities
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-go"
+	"strings"
+)
+
+// DependencyGraph with the services of a service group and the services each one must be deployed after.
+type DependencyGraph struct {
+	// Services with the names of the services in the order they are declared in the group.
+	Services []string
+	// After with the names of the services each service depends on. Only services of the group are included.
+	After map[string][]string
+}
+
+// NewDependencyGraph builds the dependency graph of a service group. DeployAfter entries are scoped to the group and
+// may use the service or the group.service syntax; references to services not included in the group are ignored.
+func NewDependencyGraph(group *grpc_application_go.ServiceGroup) *DependencyGraph {
+	graph := &DependencyGraph{
+		Services: make([]string, 0, len(group.Services)),
+		After:    make(map[string][]string, len(group.Services)),
+	}
+	for _, service := range group.Services {
+		if _, exists := graph.After[service.Name]; exists {
+			continue
+		}
+		graph.Services = append(graph.Services, service.Name)
+		graph.After[service.Name] = make([]string, 0)
+	}
+	resolver := NewServiceResolver([]*grpc_application_go.ServiceGroup{group})
+	for _, service := range group.Services {
+		for _, after := range service.DeployAfter {
+			if ref, err := resolver.Resolve(after, group.Name); err == nil {
+				graph.After[service.Name] = append(graph.After[service.Name], ref.Service)
+			}
+		}
+	}
+	return graph
+}
+
+// FindCycle returns the path of a dependency cycle starting and ending in the same service, or nil if the graph is
+// acyclic.
+func (g *DependencyGraph) FindCycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(g.Services))
+	stack := make([]string, 0, len(g.Services))
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, after := range g.After[name] {
+			switch state[after] {
+			case visiting:
+				// the cycle starts where the service was first pushed
+				for i, s := range stack {
+					if s == after {
+						cycle := append([]string{}, stack[i:]...)
+						return append(cycle, after)
+					}
+				}
+			case unvisited:
+				if cycle := visit(after); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+		return nil
+	}
+
+	for _, name := range g.Services {
+		if state[name] == unvisited {
+			if cycle := visit(name); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// Order returns the services in topological order, so each service appears after the services it depends on. Services
+// without dependencies between them keep the order in which they are declared in the group.
+func (g *DependencyGraph) Order() ([]string, derrors.Error) {
+	if cycle := g.FindCycle(); cycle != nil {
+		return nil, derrors.NewFailedPreconditionError("deploy after dependency cycle found").WithParams(strings.Join(cycle, " -> "))
+	}
+	pending := make(map[string]int, len(g.Services))
+	for _, name := range g.Services {
+		pending[name] = len(g.After[name])
+	}
+	order := make([]string, 0, len(g.Services))
+	deployed := make(map[string]bool, len(g.Services))
+	for len(order) < len(g.Services) {
+		for _, name := range g.Services {
+			if deployed[name] || pending[name] > 0 {
+				continue
+			}
+			order = append(order, name)
+			deployed[name] = true
+			// release the services waiting for this one
+			for _, other := range g.Services {
+				for _, after := range g.After[other] {
+					if after == name {
+						pending[other]--
+					}
+				}
+			}
+			break
+		}
+	}
+	return order, nil
+}
+
+// serviceIndex returns the position of a service in a group, or -1 if it is not found.
+func serviceIndex(group *grpc_application_go.ServiceGroup, name string) int {
+	for i, service := range group.Services {
+		if service.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// DeploymentOrder returns the topological deployment order of the services of each group, indexed by group name.
+func DeploymentOrder(groups []*grpc_application_go.ServiceGroup) (map[string][]string, derrors.Error) {
+	result := make(map[string][]string, len(groups))
+	for _, group := range groups {
+		graph := NewDependencyGraph(group)
+		if cycle := graph.FindCycle(); cycle != nil {
+			return nil, derrors.NewFailedPreconditionError("deploy after dependency cycle found").WithParams(group.Name, strings.Join(cycle, " -> "))
+		}
+		order, err := graph.Order()
+		if err != nil {
+			return nil, err
+		}
+		result[group.Name] = order
+	}
+	return result, nil
+}
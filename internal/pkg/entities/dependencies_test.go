@@ -0,0 +1,83 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"github.com/nalej/application-manager/internal/pkg/utils"
+	"github.com/nalej/grpc-application-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+func createDependencyGroup(name string, deployAfter map[string][]string, services ...string) *grpc_application_go.ServiceGroup {
+	group := &grpc_application_go.ServiceGroup{Name: name, Services: make([]*grpc_application_go.Service, 0)}
+	for _, s := range services {
+		group.Services = append(group.Services, &grpc_application_go.Service{Name: s, DeployAfter: deployAfter[s]})
+	}
+	return group
+}
+
+var _ = ginkgo.Describe("Deploy after dependencies", func() {
+
+	ginkgo.It("should compute the deployment order of a group", func() {
+		group := createDependencyGroup("g1", map[string][]string{
+			"web": {"api"}, "api": {"db", "cache"}}, "web", "api", "db", "cache")
+		graph := NewDependencyGraph(group)
+		gomega.Expect(graph.FindCycle()).To(gomega.BeNil())
+		order, err := graph.Order()
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(order).To(gomega.Equal([]string{"db", "cache", "api", "web"}))
+	})
+
+	ginkgo.It("should report the path of a cycle", func() {
+		group := createDependencyGroup("g1", map[string][]string{
+			"a": {"b"}, "b": {"c"}, "c": {"a"}}, "a", "b", "c", "d")
+		graph := NewDependencyGraph(group)
+		gomega.Expect(graph.FindCycle()).To(gomega.Equal([]string{"a", "b", "c", "a"}))
+		_, err := graph.Order()
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		_, err = DeploymentOrder([]*grpc_application_go.ServiceGroup{group})
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should reject cycles and deploy after references to other groups", func() {
+		descriptor := utils.CreateTestAddDescriptorWithParameters()
+		descriptor.Groups[0].Services[0].DeployAfter = []string{"service2"}
+		descriptor.Groups[0].Services[1].DeployAfter = []string{"service1", "service3"}
+
+		violations := NewViolations()
+		collectGroupSpecsViolations(descriptor, NewServiceResolver(descriptor.Groups), violations)
+		codes := make(map[string]string, 0)
+		for _, v := range violations.List {
+			codes[v.Path] = v.Code
+		}
+		gomega.Expect(codes).To(gomega.HaveLen(2))
+		gomega.Expect(codes).To(gomega.HaveKeyWithValue("groups[0].services[1].deploy_after[1]", UnknownReferenceViolation))
+		gomega.Expect(codes).To(gomega.HaveKeyWithValue("groups[0].services[0].deploy_after", DependencyCycleViolation))
+	})
+
+	ginkgo.It("should accept deploy after references inside the group", func() {
+		descriptor := utils.CreateTestAddDescriptorWithParameters()
+		descriptor.Groups[0].Services[0].DeployAfter = []string{"service2"}
+		gomega.Expect(ValidAppDescriptorGroupSpecs(descriptor)).To(gomega.Succeed())
+
+		order, err := DeploymentOrder(descriptor.Groups)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(order["g1"]).To(gomega.Equal([]string{"service2", "service1"}))
+		gomega.Expect(order["g2"]).To(gomega.Equal([]string{"service3"}))
+	})
+})
@@ -0,0 +1,130 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Interpolation of parameters inside the string fields of a descriptor, e.g. image: "registry/app:${VERSION}".
+// Only the fields that can be parametrized and the images of the services are interpolated, references in any other
+// field are rejected. $${ is used to write a literal ${.
+
+package entities
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// paramReferenceRegex matches the escape sequence and the references to parameters.
+const paramReferenceRegex = "\\$\\$\\{|\\$\\{([^}]*)\\}"
+
+// interpolableRegex matches the paths of the fields that can contain references to parameters.
+const interpolableRegex = paramRegex + "|(^groups\\.[0-9]+\\.services\\.[0-9]+\\.image$)"
+
+var paramReferenceMatcher = regexp.MustCompile(paramReferenceRegex)
+var interpolableMatcher = regexp.MustCompile(interpolableRegex)
+
+// ParamReferences returns the names of the parameters referenced in a string.
+func ParamReferences(value string) []string {
+	references := make([]string, 0)
+	for _, match := range paramReferenceMatcher.FindAllStringSubmatch(value, -1) {
+		if match[0] != "$${" {
+			references = append(references, strings.TrimSpace(match[1]))
+		}
+	}
+	return references
+}
+
+// Interpolate replaces the references to parameters with their values. References to unknown parameters are kept.
+func Interpolate(value string, values map[string]string) string {
+	return paramReferenceMatcher.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+		name := strings.TrimSpace(match[2 : len(match)-1])
+		if paramValue, exists := values[name]; exists {
+			return paramValue
+		}
+		return match
+	})
+}
+
+// escapePathKey escapes the characters of a key with a special meaning in gjson and sjson paths.
+func escapePathKey(key string) string {
+	replacer := strings.NewReplacer(".", "\\.", "*", "\\*", "?", "\\?")
+	return replacer.Replace(key)
+}
+
+// walkStrings calls fn with the path and the value of every string of a JSON document.
+func walkStrings(value gjson.Result, path string, fn func(path string, value string)) {
+	if value.Type == gjson.String {
+		fn(path, value.Str)
+		return
+	}
+	if !value.IsObject() && !value.IsArray() {
+		return
+	}
+	index := 0
+	value.ForEach(func(key, element gjson.Result) bool {
+		elementPath := strconv.Itoa(index)
+		if value.IsObject() {
+			elementPath = escapePathKey(key.String())
+		}
+		if path != "" {
+			elementPath = path + "." + elementPath
+		}
+		walkStrings(element, elementPath, fn)
+		index++
+		return true
+	})
+}
+
+// referencingStrings returns the strings of a JSON descriptor that contain references to parameters, indexed by path.
+func referencingStrings(jsonDescriptor string) map[string]string {
+	result := make(map[string]string, 0)
+	walkStrings(gjson.Parse(jsonDescriptor), "", func(path string, value string) {
+		if len(ParamReferences(value)) > 0 {
+			result[path] = value
+		}
+	})
+	return result
+}
+
+// interpolableStrings returns the strings of a JSON descriptor that can be interpolated and contain references or
+// escape sequences, indexed by path.
+func interpolableStrings(jsonDescriptor string) map[string]string {
+	result := make(map[string]string, 0)
+	walkStrings(gjson.Parse(jsonDescriptor), "", func(path string, value string) {
+		if strings.Contains(value, "${") && interpolableMatcher.MatchString(path) {
+			result[path] = value
+		}
+	})
+	return result
+}
+
+// interpolateDescriptor replaces the references to parameters in a JSON descriptor.
+func interpolateDescriptor(jsonDescriptor string, values map[string]string) (string, derrors.Error) {
+	for path, value := range interpolableStrings(jsonDescriptor) {
+		interpolated, err := sjson.Set(jsonDescriptor, path, Interpolate(value, values))
+		if err != nil {
+			return "", conversions.ToDerror(err)
+		}
+		jsonDescriptor = interpolated
+	}
+	return jsonDescriptor, nil
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"github.com/nalej/application-manager/internal/pkg/utils"
+	"github.com/nalej/grpc-application-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Parameter interpolation", func() {
+
+	ginkgo.It("should interpolate references and escape sequences", func() {
+		values := map[string]string{"VERSION": "1.2", "HOST": "db"}
+		gomega.Expect(Interpolate("registry/app:${VERSION}", values)).To(gomega.Equal("registry/app:1.2"))
+		gomega.Expect(Interpolate("${HOST}:${ VERSION }", values)).To(gomega.Equal("db:1.2"))
+		gomega.Expect(Interpolate("$${VERSION} ${UNKNOWN}", values)).To(gomega.Equal("${VERSION} ${UNKNOWN}"))
+		gomega.Expect(ParamReferences("$${VERSION} ${HOST}-${PORT}")).To(gomega.Equal([]string{"HOST", "PORT"}))
+	})
+
+	ginkgo.It("should reject references to unknown parameters", func() {
+		descriptor := utils.CreateTestAddDescriptorWithParameters()
+		descriptor.Groups[0].Services[0].EnvironmentVariables["url"] = "http://${env1}:${replicas}"
+		gomega.Expect(ValidateDescriptorParameters(descriptor)).To(gomega.Succeed())
+
+		descriptor.Groups[0].Services[0].EnvironmentVariables["url"] = "http://${host}"
+		gomega.Expect(ValidateDescriptorParameters(descriptor)).NotTo(gomega.Succeed())
+
+		descriptor.Groups[0].Services[0].EnvironmentVariables["url"] = "http://$${host}"
+		gomega.Expect(ValidateDescriptorParameters(descriptor)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should reject references in the fields that cannot be interpolated", func() {
+		descriptor := utils.CreateTestAddDescriptorWithParameters()
+		descriptor.Groups[0].Services[0].Name = "service-${env1}"
+		violations := NewViolations()
+		collectDescriptorParametersViolations(descriptor, violations)
+		gomega.Expect(violations.List).Should(gomega.HaveLen(1))
+		gomega.Expect(violations.List[0].Path).Should(gomega.Equal("groups.0.services.0.name"))
+
+		descriptor.Groups[0].Services[0].Name = "service-$${env1}"
+		gomega.Expect(ValidateDescriptorParameters(descriptor)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should interpolate the images of the services", func() {
+		descriptor := utils.CreateTestDescriptorWithParameters()
+		descriptor.Groups[0].Services[0].Image = "registry/app:${env1}"
+
+		parametrized, err := CreateParametrizedDescriptor(descriptor, &grpc_application_go.InstanceParameterList{
+			Parameters: []*grpc_application_go.InstanceParameter{{ParameterName: "env1", Value: "1.2"}}}, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(parametrized.Groups[0].Services[0].Image).To(gomega.Equal("registry/app:1.2"))
+	})
+
+	ginkgo.It("should interpolate the parametrized fields", func() {
+		descriptor := utils.CreateTestDescriptorWithParameters()
+		descriptor.Parameters[1].DefaultValue = "val1"
+		descriptor.Groups[0].Services[0].EnvironmentVariables["url"] = "http://${env1}:$${port}"
+		descriptor.Groups[0].Services[1].EnvironmentVariables = map[string]string{"replicas": "${replicas}"}
+
+		parametrized, err := CreateParametrizedDescriptor(descriptor, nil, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(parametrized.Groups[0].Services[0].EnvironmentVariables["url"]).To(gomega.Equal("http://val1:${port}"))
+		gomega.Expect(parametrized.Groups[0].Services[1].EnvironmentVariables["replicas"]).To(gomega.Equal(""))
+
+		parametrized, err = CreateParametrizedDescriptor(descriptor, &grpc_application_go.InstanceParameterList{
+			Parameters: []*grpc_application_go.InstanceParameter{{ParameterName: "env1", Value: "host"},
+				{ParameterName: "replicas", Value: "2"}}}, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(parametrized.Groups[0].Services[0].EnvironmentVariables["url"]).To(gomega.Equal("http://host:${port}"))
+		gomega.Expect(parametrized.Groups[0].Services[1].EnvironmentVariables["replicas"]).To(gomega.Equal("2"))
+		gomega.Expect(parametrized.Groups[0].Services[0].EnvironmentVariables["env1"]).To(gomega.Equal("host"))
+		gomega.Expect(descriptor.Groups[0].Services[0].EnvironmentVariables["url"]).To(gomega.Equal("http://${env1}:$${port}"))
+	})
+})
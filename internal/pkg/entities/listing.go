@@ -0,0 +1,134 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-manager-go"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// Page sizes of the listings.
+const (
+	// DefaultPageSize used when a listing request does not set the page size.
+	DefaultPageSize = 50
+	// MaxPageSize with the largest page a listing request can ask for.
+	MaxPageSize = 500
+)
+
+// Directions of the connections of an instance.
+const (
+	InboundDirection  = "inbound"
+	OutboundDirection = "outbound"
+)
+
+// PageKey with the position of an element in a listing. The elements are sorted by creation timestamp and identifier
+// so the pages are stable even if elements are added or removed between pages.
+type PageKey struct {
+	Created int64  `json:"c"`
+	Id      string `json:"i"`
+}
+
+// Before checks if an element goes before another one in a listing.
+func (k PageKey) Before(other PageKey) bool {
+	if k.Created != other.Created {
+		return k.Created < other.Created
+	}
+	return k.Id < other.Id
+}
+
+// pageToken with the key of the last element of a page. The fingerprint of the request prevents a token from being
+// used with a different filter.
+type pageToken struct {
+	Last        PageKey `json:"l"`
+	Fingerprint string  `json:"f"`
+}
+
+// fingerprint of a listing request, the page size and the token are not included as they can change between pages.
+func fingerprint(values ...interface{}) string {
+	hash := fnv.New64a()
+	for _, value := range values {
+		_, _ = fmt.Fprintf(hash, "%v|", value)
+	}
+	return strconv.FormatUint(hash.Sum64(), 36)
+}
+
+// filterFingerprint returns the values of a filter that identify a listing.
+func filterFingerprint(filter *grpc_application_manager_go.ListFilter) []interface{} {
+	return []interface{}{filter.GetNamePrefix(), filter.GetLabelSelector(), filter.GetCreatedAfter(), filter.GetCreatedBefore()}
+}
+
+// ListAppDescriptorsFingerprint returns the fingerprint of the filter of a descriptor listing.
+func ListAppDescriptorsFingerprint(request *grpc_application_manager_go.ListAppDescriptorsRequest) string {
+	return fingerprint(append([]interface{}{request.OrganizationId}, filterFingerprint(request.Filter)...)...)
+}
+
+// ListAppInstancesFingerprint returns the fingerprint of the filter of an instance listing.
+func ListAppInstancesFingerprint(request *grpc_application_manager_go.ListAppInstancesRequest) string {
+	return fingerprint(append([]interface{}{request.OrganizationId, request.AppDescriptorId, request.Status},
+		filterFingerprint(request.Filter)...)...)
+}
+
+// EncodePageToken returns the token of the page following the element with the given key.
+func EncodePageToken(last PageKey, fingerprint string) string {
+	raw, _ := json.Marshal(&pageToken{Last: last, Fingerprint: fingerprint})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodePageToken returns the key of the last element of the previous page, nil for an empty token.
+func DecodePageToken(token string, fingerprint string) (*PageKey, derrors.Error) {
+	if token == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError(invalidPageToken, err)
+	}
+	decoded := &pageToken{}
+	if err := json.Unmarshal(raw, decoded); err != nil {
+		return nil, derrors.NewInvalidArgumentError(invalidPageToken, err)
+	}
+	if decoded.Fingerprint != fingerprint {
+		return nil, derrors.NewInvalidArgumentError("page token does not belong to this filter")
+	}
+	return &decoded.Last, nil
+}
+
+// PageBounds returns the range [start, end) of the elements of a page and the token of the next page. The keys must
+// be sorted and the page starts at the first element after the last one of the previous page.
+func PageBounds(keys []PageKey, pageSize int32, last *PageKey, fingerprint string) (int, int, string) {
+	size := int(pageSize)
+	if size == 0 {
+		size = DefaultPageSize
+	}
+	start := 0
+	if last != nil {
+		start = sort.Search(len(keys), func(i int) bool {
+			return last.Before(keys[i])
+		})
+	}
+	end := start + size
+	if end >= len(keys) {
+		return start, len(keys), ""
+	}
+	return start, end, EncodePageToken(keys[end-1], fingerprint)
+}
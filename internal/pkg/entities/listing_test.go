@@ -0,0 +1,85 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Listing pages", func() {
+
+	keys := []PageKey{{100, "a"}, {100, "b"}, {200, "a"}, {300, "c"}, {400, "d"}}
+
+	ginkgo.It("should iterate the pages with the tokens", func() {
+		request := &grpc_application_manager_go.ListAppInstancesRequest{OrganizationId: "org1", Status: "RUNNING", PageSize: 2}
+		fingerprint := ListAppInstancesFingerprint(request)
+
+		start, end, next := PageBounds(keys, request.PageSize, nil, fingerprint)
+		gomega.Expect([]int{start, end}).To(gomega.Equal([]int{0, 2}))
+		gomega.Expect(next).NotTo(gomega.BeEmpty())
+
+		last, err := DecodePageToken(next, fingerprint)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(*last).To(gomega.Equal(PageKey{100, "b"}))
+		start, end, next = PageBounds(keys, request.PageSize, last, fingerprint)
+		gomega.Expect([]int{start, end}).To(gomega.Equal([]int{2, 4}))
+
+		last, err = DecodePageToken(next, fingerprint)
+		gomega.Expect(err).To(gomega.Succeed())
+		start, end, next = PageBounds(keys, request.PageSize, last, fingerprint)
+		gomega.Expect([]int{start, end}).To(gomega.Equal([]int{4, 5}))
+		gomega.Expect(next).To(gomega.BeEmpty())
+
+		start, end, _ = PageBounds(keys, 0, nil, fingerprint)
+		gomega.Expect([]int{start, end}).To(gomega.Equal([]int{0, 5}))
+	})
+
+	ginkgo.It("should not skip elements if the previous ones are removed", func() {
+		fingerprint := ListAppDescriptorsFingerprint(&grpc_application_manager_go.ListAppDescriptorsRequest{OrganizationId: "org1"})
+		_, _, next := PageBounds(keys, 2, nil, fingerprint)
+		last, err := DecodePageToken(next, fingerprint)
+		gomega.Expect(err).To(gomega.Succeed())
+		// the elements of the first page are removed before the second one is requested
+		start, end, _ := PageBounds(keys[2:], 2, last, fingerprint)
+		gomega.Expect(keys[2:][start:end]).To(gomega.Equal([]PageKey{{200, "a"}, {300, "c"}}))
+	})
+
+	ginkgo.It("should reject tokens of a different filter", func() {
+		request := &grpc_application_manager_go.ListAppInstancesRequest{OrganizationId: "org1", Status: "RUNNING"}
+		token := EncodePageToken(PageKey{100, "a"}, ListAppInstancesFingerprint(request))
+		request.Status = "ERROR"
+		_, err := DecodePageToken(token, ListAppInstancesFingerprint(request))
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		_, err = DecodePageToken("not-a-token", ListAppInstancesFingerprint(request))
+		gomega.Expect(err).NotTo(gomega.Succeed())
+
+		request.Status = "RUNNING"
+		request.Filter = &grpc_application_manager_go.ListFilter{NamePrefix: "web"}
+		_, err = DecodePageToken(token, ListAppInstancesFingerprint(request))
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should validate the listing requests", func() {
+		gomega.Expect(ValidListAppInstancesRequest(&grpc_application_manager_go.ListAppInstancesRequest{OrganizationId: "org1"})).To(gomega.Succeed())
+		gomega.Expect(ValidListAppInstancesRequest(&grpc_application_manager_go.ListAppInstancesRequest{OrganizationId: "org1", Status: "UNKNOWN"})).NotTo(gomega.Succeed())
+		gomega.Expect(ValidListAppInstancesRequest(&grpc_application_manager_go.ListAppInstancesRequest{OrganizationId: "org1", PageSize: MaxPageSize + 1})).NotTo(gomega.Succeed())
+		gomega.Expect(ValidListAppDescriptorsRequest(&grpc_application_manager_go.ListAppDescriptorsRequest{OrganizationId: "org1",
+			Filter: &grpc_application_manager_go.ListFilter{CreatedAfter: 10, CreatedBefore: 5}})).NotTo(gomega.Succeed())
+	})
+})
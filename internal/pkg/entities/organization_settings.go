@@ -18,45 +18,430 @@
 package entities
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/golang-lru"
 	"github.com/nalej/application-manager/internal/pkg/server/common"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-go"
 	"github.com/nalej/grpc-organization-go"
 	orgMng "github.com/nalej/grpc-organization-manager-go"
 	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-// OrganizationSettings contains all settings that can be applied in descriptors and instances
-// For now -> DEFAULT_STORAGE_SIZE
+// Keys of the organization settings applied to the descriptors.
+const (
+	// DefaultStorageSizeSetting with the size of the storage that does not define it.
+	DefaultStorageSizeSetting = "DEFAULT_STORAGE_SIZE"
+	// DefaultReplicasSetting with the replicas of the groups that do not define them.
+	DefaultReplicasSetting = "DEFAULT_REPLICAS"
+	// DefaultLabelsSetting with a JSON map of labels added to the instances.
+	DefaultLabelsSetting = "DEFAULT_LABELS"
+	// RegistryCredentialsSetting with the JSON credentials of the services without credentials.
+	RegistryCredentialsSetting = "REGISTRY_CREDENTIALS"
+	// ReservedPortsSetting with a comma separated list of ports that cannot be exposed.
+	ReservedPortsSetting = "RESERVED_PORTS"
+	// MaxInstancesSetting with the maximum number of instances of the organization.
+	MaxInstancesSetting = "MAX_INSTANCES"
+	// MaxReplicasSetting with the maximum number of service replicas of the organization.
+	MaxReplicasSetting = "MAX_REPLICAS"
+	// MaxStorageSetting with the maximum bytes of storage of the organization.
+	MaxStorageSetting = "MAX_STORAGE"
+	// MaxExposedPortsSetting with the maximum number of exposed ports of the organization.
+	MaxExposedPortsSetting = "MAX_EXPOSED_PORTS"
+)
+
+// quotaSettings with the settings that define the quotas of an organization.
+var quotaSettings = map[string]bool{MaxInstancesSetting: true, MaxReplicasSetting: true, MaxStorageSetting: true,
+	MaxExposedPortsSetting: true}
+
+// dockerHubRegistry with the registry of the images without an explicit registry.
+const dockerHubRegistry = "docker.io"
+
+// DefaultSettingsEntries with the number of organizations whose settings are cached.
+const DefaultSettingsEntries = 256
+
+// DefaultSettingsTTL with the time the settings of an organization are cached.
+const DefaultSettingsTTL = 5 * time.Minute
+
+// OrganizationSettings contains all settings that can be applied in descriptors and instances.
 type OrganizationSettings struct {
+	// StoreSize applied to the storage without size.
 	StoreSize int64
+	// Replicas applied to the groups without replicas.
+	Replicas int32
+	// Labels added to the instances if the descriptor does not define them.
+	Labels map[string]string
+	// Credentials applied to the services without credentials whose image is pulled from the registry of the
+	// repository.
+	Credentials *grpc_application_go.ImageCredentials
+	// ReservedPorts that cannot be exposed by the services.
+	ReservedPorts map[int32]bool
+	// Quotas with the resources the organization can use.
+	Quotas ResourceQuotas
+	// QuotasUnavailable is true if any quota could not be retrieved, the deployments must be rejected as the
+	// resources the organization can use are unknown.
+	QuotasUnavailable bool
+}
+
+// registryCredentials with the JSON format of the RegistryCredentialsSetting.
+type registryCredentials struct {
+	Username         string `json:"username"`
+	Password         string `json:"password"`
+	Email            string `json:"email,omitempty"`
+	DockerRepository string `json:"docker_repository,omitempty"`
 }
 
 // NewOrganizationSettings generates a OrganizationSetting for a received organization
-func NewOrganizationSettings(organizationID string, client orgMng.OrganizationsClient) *OrganizationSettings {
+func NewOrganizationSettings(ctx context.Context, organizationID string, client orgMng.OrganizationsClient) *OrganizationSettings {
+	settings, _ := loadOrganizationSettings(ctx, organizationID, client)
+	return settings
+}
+
+// loadOrganizationSettings retrieves the settings of an organization. The second value is false if any of them
+// could not be retrieved, in which case the settings should not be cached. The remaining settings are not requested
+// once the context is cancelled.
+func loadOrganizationSettings(ctx context.Context, organizationID string, client orgMng.OrganizationsClient) (*OrganizationSettings, bool) {
 	log.Debug().Str("organizationId", organizationID).Msg("creating a new OrganizationSettings")
 
 	if client == nil {
-		return nil
+		return nil, false
 	}
 
-	defaultStore := int64(0)
+	settings := &OrganizationSettings{
+		Labels:        make(map[string]string, 0),
+		ReservedPorts: make(map[int32]bool, 0),
+	}
+	complete := true
+	retrieved := make(map[string]bool, 0)
+	for _, key := range []string{DefaultStorageSizeSetting, DefaultReplicasSetting, DefaultLabelsSetting,
+		RegistryCredentialsSetting, ReservedPortsSetting, MaxInstancesSetting, MaxReplicasSetting, MaxStorageSetting,
+		MaxExposedPortsSetting} {
+		if ctx.Err() != nil {
+			log.Warn().Str("error", ctx.Err().Error()).Str("organizationId", organizationID).
+				Msg("organization settings not retrieved")
+			complete = false
+			break
+		}
+		value, found, err := getSetting(ctx, organizationID, key, client)
+		if err != nil {
+			log.Warn().Str("error", err.Error()).Str("setting", key).Msg("error getting setting")
+			complete = false
+			continue
+		}
+		retrieved[key] = true
+		if !found {
+			continue
+		}
+		if err := settings.set(key, value); err != nil {
+			log.Warn().Str("error", err.Error()).Str("setting", key).Str("value", maskSetting(key, value)).
+				Msg("error converting the setting value")
+			if quotaSettings[key] {
+				settings.QuotasUnavailable = true
+			}
+		}
+	}
+	for key := range quotaSettings {
+		if !retrieved[key] {
+			settings.QuotasUnavailable = true
+		}
+	}
+	return settings, complete
+}
 
-	ctx, cancel := common.GetContext()
+// getSetting retrieves a setting of an organization. A missing setting is not an error.
+func getSetting(ctx context.Context, organizationID string, key string, client orgMng.OrganizationsClient) (string, bool, error) {
+	ctx, cancel := common.GetContextFrom(ctx)
 	defer cancel()
 
 	setting, err := client.GetSetting(ctx, &grpc_organization_go.SettingKey{
 		OrganizationId: organizationID,
-		Key:            grpc_organization_go.AllowedSettingKey_DEFAULT_STORAGE_SIZE.String(),
+		Key:            key,
 	})
 	if err != nil {
-		log.Warn().Str("error", err.Error()).Str("setting", grpc_organization_go.AllowedSettingKey_DEFAULT_STORAGE_SIZE.String()).
-			Msg("error getting setting")
-	}else{
-		defaultStore, err = strconv.ParseInt(setting.Value, 10, 64)
+		if status.Code(err) == codes.NotFound {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return setting.Value, true, nil
+}
+
+// maskSetting hides the value of the settings that contain secrets.
+func maskSetting(key string, value string) string {
+	if key == RegistryCredentialsSetting {
+		return MaskedValue
+	}
+	return value
+}
+
+// set parses the value of a setting.
+func (s *OrganizationSettings) set(key string, value string) error {
+	switch key {
+	case DefaultStorageSizeSetting:
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		s.StoreSize = size
+	case DefaultReplicasSetting:
+		replicas, err := strconv.ParseInt(value, 10, 32)
 		if err != nil {
-			log.Warn().Str("error", err.Error()).Str("value", setting.Value).Msg("error converting the value to int")
+			return err
+		}
+		s.Replicas = int32(replicas)
+	case DefaultLabelsSetting:
+		return json.Unmarshal([]byte(value), &s.Labels)
+	case RegistryCredentialsSetting:
+		credentials := &registryCredentials{}
+		if err := json.Unmarshal([]byte(value), credentials); err != nil {
+			return err
+		}
+		s.Credentials = &grpc_application_go.ImageCredentials{
+			Username:         credentials.Username,
+			Password:         credentials.Password,
+			Email:            credentials.Email,
+			DockerRepository: credentials.DockerRepository,
+		}
+	case ReservedPortsSetting:
+		for _, port := range strings.Split(value, ",") {
+			if strings.TrimSpace(port) == "" {
+				continue
+			}
+			number, err := strconv.ParseInt(strings.TrimSpace(port), 10, 32)
+			if err != nil {
+				return err
+			}
+			s.ReservedPorts[int32(number)] = true
+		}
+	case MaxInstancesSetting:
+		return parseQuota(value, &s.Quotas.Instances)
+	case MaxReplicasSetting:
+		return parseQuota(value, &s.Quotas.Replicas)
+	case MaxStorageSetting:
+		return parseQuota(value, &s.Quotas.Storage)
+	case MaxExposedPortsSetting:
+		return parseQuota(value, &s.Quotas.ExposedPorts)
+	}
+	return nil
+}
+
+// parseQuota parses the value of a quota setting.
+func parseQuota(value string, quota *int64) error {
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return err
+	}
+	if limit < 0 {
+		return fmt.Errorf("negative quota %d", limit)
+	}
+	*quota = limit
+	return nil
+}
+
+// apply fills the fields of a parametrized descriptor not defined by the user with the organization defaults and
+// returns the defaults applied.
+func (s *OrganizationSettings) apply(parametrized *grpc_application_go.ParametrizedDescriptor) []*grpc_application_go.AppliedDefault {
+	applied := make([]*grpc_application_go.AppliedDefault, 0)
+	if s == nil {
+		return applied
+	}
+
+	if len(s.Labels) > 0 {
+		// the labels are shared with the descriptor
+		labels := make(map[string]string, len(parametrized.Labels)+len(s.Labels))
+		for key, value := range parametrized.Labels {
+			labels[key] = value
+		}
+		keys := make([]string, 0, len(s.Labels))
+		for key := range s.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if _, exists := labels[key]; !exists {
+				labels[key] = s.Labels[key]
+				applied = append(applied, &grpc_application_go.AppliedDefault{Setting: DefaultLabelsSetting,
+					Path: fmt.Sprintf("labels.%s", escapePathKey(key)), Value: s.Labels[key]})
+			}
 		}
+		parametrized.Labels = labels
 	}
 
-	return &OrganizationSettings{defaultStore}
+	for i, group := range parametrized.Groups {
+		if s.Replicas > 0 && (group.Specs == nil || (group.Specs.Replicas == 0 && !group.Specs.MultiClusterReplica)) {
+			if group.Specs == nil {
+				group.Specs = &grpc_application_go.ServiceGroupDeploymentSpecs{}
+			}
+			group.Specs.Replicas = s.Replicas
+			applied = append(applied, &grpc_application_go.AppliedDefault{Setting: DefaultReplicasSetting,
+				Path: fmt.Sprintf("groups.%d.specs.replicas", i), Value: strconv.Itoa(int(s.Replicas))})
+		}
+		for j, service := range group.Services {
+			servicePath := fmt.Sprintf("groups.%d.services.%d", i, j)
+			if s.StoreSize > 0 {
+				for k, storage := range service.Storage {
+					if storage.Size == 0 {
+						storage.Size = s.StoreSize
+						applied = append(applied, &grpc_application_go.AppliedDefault{Setting: DefaultStorageSizeSetting,
+							Path: fmt.Sprintf("%s.storage.%d.size", servicePath, k), Value: strconv.FormatInt(s.StoreSize, 10)})
+					}
+				}
+			}
+			if s.Credentials != nil && s.Credentials.DockerRepository != "" && service.Credentials == nil &&
+				imageRegistry(service.Image) == repositoryRegistry(s.Credentials.DockerRepository) {
+				service.Credentials = copyImageCredential(s.Credentials)
+				// the password is not recorded
+				applied = append(applied, &grpc_application_go.AppliedDefault{Setting: RegistryCredentialsSetting,
+					Path: servicePath + ".credentials", Value: s.Credentials.Username})
+			}
+		}
+	}
+	return applied
+}
+
+// checkReservedPorts checks the services do not expose a port reserved by the organization.
+func (s *OrganizationSettings) checkReservedPorts(parametrized *grpc_application_go.ParametrizedDescriptor) derrors.Error {
+	if s == nil || len(s.ReservedPorts) == 0 {
+		return nil
+	}
+	for _, group := range parametrized.Groups {
+		for _, service := range group.Services {
+			for _, port := range service.ExposedPorts {
+				if s.ReservedPorts[port.ExposedPort] {
+					return derrors.NewFailedPreconditionError("port reserved by the organization").
+						WithParams(group.Name, service.Name, port.ExposedPort)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// keptDefaults returns the applied defaults that have not been overridden by a parameter.
+func keptDefaults(applied []*grpc_application_go.AppliedDefault, parametrizedPaths map[string]bool) []*grpc_application_go.AppliedDefault {
+	kept := make([]*grpc_application_go.AppliedDefault, 0, len(applied))
+	for _, def := range applied {
+		overridden := false
+		for path := range parametrizedPaths {
+			if def.Path == path || strings.HasPrefix(def.Path, path+".") {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			kept = append(kept, def)
+		}
+	}
+	return kept
+}
+
+// imageRegistry returns the registry host an image is pulled from. The images without an explicit registry are
+// pulled from Docker Hub.
+func imageRegistry(image string) string {
+	index := strings.Index(image, "/")
+	if index < 0 {
+		return dockerHubRegistry
+	}
+	host := strings.ToLower(image[:index])
+	if host != "localhost" && !strings.ContainsAny(host, ".:") {
+		return dockerHubRegistry
+	}
+	return normalizeRegistry(host)
+}
+
+// repositoryRegistry returns the registry host of a docker repository, e.g. registry.nalej.com for
+// https://registry.nalej.com/v2/.
+func repositoryRegistry(repository string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(repository), "https://"), "http://")
+	if index := strings.Index(host, "/"); index >= 0 {
+		host = host[:index]
+	}
+	return normalizeRegistry(host)
+}
+
+// normalizeRegistry returns the canonical name of the registry hosts with several names.
+func normalizeRegistry(host string) string {
+	if host == "index.docker.io" || host == "registry-1.docker.io" {
+		return dockerHubRegistry
+	}
+	return host
+}
+
+// settingsEntry with the settings of an organization and the time they were loaded.
+type settingsEntry struct {
+	settings *OrganizationSettings
+	loaded   time.Time
+}
+
+// OrganizationSettingsCache keeps the settings of the organizations during a TTL to avoid retrieving them on every
+// deployment.
+type OrganizationSettingsCache struct {
+	sync.Mutex
+	cache  *lru.Cache
+	ttl    time.Duration
+	loader func(ctx context.Context, organizationID string) (*OrganizationSettings, bool)
+}
+
+// NewOrganizationSettingsCache creates a cache that keeps the settings of up to numEntries organizations during ttl.
+func NewOrganizationSettingsCache(client orgMng.OrganizationsClient, numEntries int, ttl time.Duration) (*OrganizationSettingsCache, derrors.Error) {
+	return newOrganizationSettingsCache(func(ctx context.Context, organizationID string) (*OrganizationSettings, bool) {
+		return loadOrganizationSettings(ctx, organizationID, client)
+	}, numEntries, ttl)
+}
+
+func newOrganizationSettingsCache(loader func(ctx context.Context, organizationID string) (*OrganizationSettings, bool), numEntries int, ttl time.Duration) (*OrganizationSettingsCache, derrors.Error) {
+	cache, err := lru.New(numEntries)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot create organization settings cache")
+	}
+	return &OrganizationSettingsCache{cache: cache, ttl: ttl, loader: loader}, nil
+}
+
+// Get returns the settings of an organization, loading them if they are not cached or have expired. The settings
+// are only cached if all of them could be retrieved before the context is done. If the quotas cannot be retrieved,
+// the last ones cached are kept, even if they have expired.
+func (c *OrganizationSettingsCache) Get(ctx context.Context, organizationID string) *OrganizationSettings {
+	if c == nil {
+		return nil
+	}
+	c.Lock()
+	value, found := c.cache.Get(organizationID)
+	c.Unlock()
+	if found {
+		entry := value.(*settingsEntry)
+		if time.Since(entry.loaded) <= c.ttl {
+			return entry.settings
+		}
+	}
+	settings, complete := c.loader(ctx, organizationID)
+	if settings != nil && settings.QuotasUnavailable && found {
+		previous := value.(*settingsEntry).settings
+		if previous != nil && !previous.QuotasUnavailable {
+			log.Warn().Str("organizationId", organizationID).Msg("quotas not retrieved, using the last ones cached")
+			settings.Quotas = previous.Quotas
+			settings.QuotasUnavailable = false
+		}
+	}
+	if complete {
+		c.Lock()
+		c.cache.Add(organizationID, &settingsEntry{settings: settings, loaded: time.Now()})
+		c.Unlock()
+	}
+	return settings
+}
+
+// Invalidate removes the cached settings of an organization.
+func (c *OrganizationSettingsCache) Invalidate(organizationID string) {
+	c.Lock()
+	defer c.Unlock()
+	c.cache.Remove(organizationID)
 }
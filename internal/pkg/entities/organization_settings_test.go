@@ -0,0 +1,222 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"context"
+	"github.com/nalej/application-manager/internal/pkg/utils"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-organization-go"
+	orgMng "github.com/nalej/grpc-organization-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+)
+
+// unreachableOrganizationsClient fails the test if any setting is requested.
+type unreachableOrganizationsClient struct {
+	orgMng.OrganizationsClient
+}
+
+// failingQuotasOrganizationsClient returns the settings but fails on the MAX_* ones.
+type failingQuotasOrganizationsClient struct {
+	orgMng.OrganizationsClient
+	settings map[string]string
+}
+
+func (c *failingQuotasOrganizationsClient) GetSetting(ctx context.Context, in *grpc_organization_go.SettingKey, opts ...grpc.CallOption) (*grpc_organization_go.Setting, error) {
+	if strings.HasPrefix(in.Key, "MAX_") {
+		return nil, status.Error(codes.Unavailable, "organization manager unavailable")
+	}
+	value, exists := c.settings[in.Key]
+	if !exists {
+		return nil, status.Error(codes.NotFound, "setting not found")
+	}
+	return &grpc_organization_go.Setting{OrganizationId: in.OrganizationId, Key: in.Key, Value: value}, nil
+}
+
+var _ = ginkgo.Describe("Organization settings", func() {
+
+	var settings *OrganizationSettings
+	var descriptor *grpc_application_go.AppDescriptor
+
+	ginkgo.BeforeEach(func() {
+		settings = &OrganizationSettings{Labels: make(map[string]string, 0), ReservedPorts: make(map[int32]bool, 0)}
+		gomega.Expect(settings.set(DefaultStorageSizeSetting, "1024")).To(gomega.Succeed())
+		gomega.Expect(settings.set(DefaultReplicasSetting, "2")).To(gomega.Succeed())
+		gomega.Expect(settings.set(DefaultLabelsSetting, `{"team": "nalej"}`)).To(gomega.Succeed())
+		gomega.Expect(settings.set(RegistryCredentialsSetting,
+			`{"username": "user", "password": "pass", "docker_repository": "registry.nalej.com/"}`)).To(gomega.Succeed())
+		gomega.Expect(settings.set(ReservedPortsSetting, "22, 8443")).To(gomega.Succeed())
+
+		descriptor = utils.CreateTestDescriptorWithParameters()
+		descriptor.Labels = map[string]string{"app": "test"}
+		descriptor.Groups[1].Services[0].Image = "registry.nalej.com/service3:1.0"
+		descriptor.Groups[1].Services[0].Storage = []*grpc_application_go.Storage{{MountPath: "/data"}}
+	})
+
+	ginkgo.It("should parse the settings", func() {
+		gomega.Expect(settings.StoreSize).To(gomega.Equal(int64(1024)))
+		gomega.Expect(settings.Replicas).To(gomega.Equal(int32(2)))
+		gomega.Expect(settings.Credentials.Password).To(gomega.Equal("pass"))
+		gomega.Expect(settings.ReservedPorts).To(gomega.HaveKey(int32(8443)))
+		gomega.Expect(settings.set(DefaultReplicasSetting, "two")).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should apply and record the defaults", func() {
+		parametrized, applied, err := CreateReferencedDescriptor(descriptor, nil, settings)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(parametrized.Labels).To(gomega.Equal(map[string]string{"app": "test", "team": "nalej"}))
+		gomega.Expect(descriptor.Labels).To(gomega.HaveLen(1))
+		gomega.Expect(parametrized.Groups[0].Specs.Replicas).To(gomega.Equal(int32(3)))
+		gomega.Expect(parametrized.Groups[1].Specs.Replicas).To(gomega.Equal(int32(2)))
+		gomega.Expect(parametrized.Groups[1].Services[0].Storage[0].Size).To(gomega.Equal(int64(1024)))
+		gomega.Expect(parametrized.Groups[1].Services[0].Credentials.Username).To(gomega.Equal("user"))
+		gomega.Expect(parametrized.Groups[0].Services[0].Credentials).To(gomega.BeNil())
+		gomega.Expect(applied).To(gomega.ConsistOf(
+			&grpc_application_go.AppliedDefault{Setting: DefaultLabelsSetting, Path: "labels.team", Value: "nalej"},
+			&grpc_application_go.AppliedDefault{Setting: DefaultReplicasSetting, Path: "groups.1.specs.replicas", Value: "2"},
+			&grpc_application_go.AppliedDefault{Setting: DefaultStorageSizeSetting, Path: "groups.1.services.0.storage.0.size", Value: "1024"},
+			&grpc_application_go.AppliedDefault{Setting: RegistryCredentialsSetting, Path: "groups.1.services.0.credentials", Value: "user"},
+		))
+		// the defaults are recorded in the instance, not in the options sent to the workload
+		gomega.Expect(parametrized.ConfigurationOptions).To(gomega.HaveLen(len(descriptor.ConfigurationOptions)))
+	})
+
+	ginkgo.It("should not record the defaults overridden by a parameter", func() {
+		descriptor.Groups[0].Specs.Replicas = 0
+		parametrized, applied, err := CreateReferencedDescriptor(descriptor, &grpc_application_go.InstanceParameterList{
+			Parameters: []*grpc_application_go.InstanceParameter{{ParameterName: "replicas", Value: "5"}}}, settings)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(parametrized.Groups[0].Specs.Replicas).To(gomega.Equal(int32(5)))
+		for _, def := range applied {
+			gomega.Expect(def.Path).NotTo(gomega.Equal("groups.0.specs.replicas"))
+		}
+	})
+
+	ginkgo.It("should apply the registry credentials only to the images of the registry", func() {
+		descriptor.Groups[0].Services[0].Image = "registry.nalej.com.example.io/service1:1.0"
+		parametrized, err := CreateParametrizedDescriptor(descriptor, nil, settings)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(parametrized.Groups[0].Services[0].Credentials).To(gomega.BeNil())
+		gomega.Expect(parametrized.Groups[1].Services[0].Credentials).NotTo(gomega.BeNil())
+
+		gomega.Expect(imageRegistry("nginx:1.17")).To(gomega.Equal("docker.io"))
+		gomega.Expect(imageRegistry("localhost:5000/service:1.0")).To(gomega.Equal("localhost:5000"))
+		gomega.Expect(repositoryRegistry("https://index.docker.io/v1/")).To(gomega.Equal("docker.io"))
+	})
+
+	ginkgo.It("should not apply the registry credentials without repository", func() {
+		gomega.Expect(settings.set(RegistryCredentialsSetting, `{"username": "user", "password": "pass"}`)).To(gomega.Succeed())
+		parametrized, err := CreateParametrizedDescriptor(descriptor, nil, settings)
+		gomega.Expect(err).To(gomega.Succeed())
+		for _, group := range parametrized.Groups {
+			for _, service := range group.Services {
+				gomega.Expect(service.Credentials).To(gomega.BeNil())
+			}
+		}
+	})
+
+	ginkgo.It("should reject the reserved ports", func() {
+		descriptor.Groups[1].Services[0].ExposedPorts = []*grpc_application_go.Port{{Name: "https", InternalPort: 443, ExposedPort: 8443}}
+		_, err := CreateParametrizedDescriptor(descriptor, nil, settings)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should cache the settings during the TTL", func() {
+		loaded := 0
+		cache, err := newOrganizationSettingsCache(func(ctx context.Context, organizationID string) (*OrganizationSettings, bool) {
+			loaded++
+			return settings, true
+		}, 10, 50*time.Millisecond)
+		gomega.Expect(err).To(gomega.BeNil())
+
+		ctx := context.Background()
+		gomega.Expect(cache.Get(ctx, "org")).To(gomega.Equal(settings))
+		cache.Get(ctx, "org")
+		gomega.Expect(loaded).To(gomega.Equal(1))
+		time.Sleep(60 * time.Millisecond)
+		cache.Get(ctx, "org")
+		gomega.Expect(loaded).To(gomega.Equal(2))
+		cache.Invalidate("org")
+		cache.Get(ctx, "org")
+		gomega.Expect(loaded).To(gomega.Equal(3))
+	})
+
+	ginkgo.It("should not request the settings once the context is cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		loaded, complete := loadOrganizationSettings(ctx, "org", &unreachableOrganizationsClient{})
+		gomega.Expect(complete).To(gomega.BeFalse())
+		gomega.Expect(loaded.Quotas).To(gomega.Equal(ResourceQuotas{}))
+	})
+
+	ginkgo.It("should flag the quotas that cannot be retrieved", func() {
+		client := &failingQuotasOrganizationsClient{settings: map[string]string{DefaultReplicasSetting: "2"}}
+		loaded, complete := loadOrganizationSettings(context.Background(), "org", client)
+		gomega.Expect(complete).To(gomega.BeFalse())
+		gomega.Expect(loaded.Replicas).To(gomega.Equal(int32(2)))
+		gomega.Expect(loaded.QuotasUnavailable).To(gomega.BeTrue())
+
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+		loaded, _ = loadOrganizationSettings(cancelled, "org", &unreachableOrganizationsClient{})
+		gomega.Expect(loaded.QuotasUnavailable).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should keep the last quotas cached if they cannot be retrieved", func() {
+		settings.Quotas = ResourceQuotas{Instances: 3}
+		failing := false
+		cache, err := newOrganizationSettingsCache(func(ctx context.Context, organizationID string) (*OrganizationSettings, bool) {
+			if failing {
+				return loadOrganizationSettings(ctx, organizationID, &failingQuotasOrganizationsClient{})
+			}
+			return settings, true
+		}, 10, 10*time.Millisecond)
+		gomega.Expect(err).To(gomega.BeNil())
+
+		ctx := context.Background()
+		gomega.Expect(cache.Get(ctx, "org").Quotas.Instances).To(gomega.Equal(int64(3)))
+		time.Sleep(20 * time.Millisecond)
+		failing = true
+		fallback := cache.Get(ctx, "org")
+		gomega.Expect(fallback.QuotasUnavailable).To(gomega.BeFalse())
+		gomega.Expect(fallback.Quotas.Instances).To(gomega.Equal(int64(3)))
+
+		cache.Invalidate("org")
+		gomega.Expect(cache.Get(ctx, "org").QuotasUnavailable).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should not cache the settings loaded with a cancelled context", func() {
+		loaded := 0
+		cache, err := newOrganizationSettingsCache(func(ctx context.Context, organizationID string) (*OrganizationSettings, bool) {
+			loaded++
+			return loadOrganizationSettings(ctx, organizationID, &unreachableOrganizationsClient{})
+		}, 10, time.Minute)
+		gomega.Expect(err).To(gomega.BeNil())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		cache.Get(ctx, "org")
+		cache.Get(ctx, "org")
+		gomega.Expect(loaded).To(gomega.Equal(2))
+	})
+})
@@ -18,12 +18,14 @@ package entities
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/nalej/derrors"
 	"github.com/nalej/grpc-application-go"
 	"github.com/nalej/grpc-utils/pkg/conversions"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -36,11 +38,12 @@ const paramDefinedTwice = "param name defined twice"
 const paramNotAllowed = "param not allowed"
 
 // regular expression that allows to validate the path of the allowed parameters
-const paramRootRegex = "(^configuration_options\\.)|(^environment_variables\\.)|(^labels\\.)"
-const paramRulesRegex = "(^rules\\.[0-9]+\\.device_group_names\\.[0-9]+)"
+// lists and maps of strings can be replaced as a whole: device_group_names, run_arguments, environment_variables and labels
+const paramRootRegex = "(^configuration_options\\.)|(^environment_variables(\\.|$))|(^labels(\\.|$))"
+const paramRulesRegex = "(^rules\\.[0-9]+\\.device_group_names(\\.[0-9]+|$))"
 const paramGroupsRegex = "(^groups\\.[0-9]+\\.services\\.[0-9]+\\.specs\\.)" +
-	"|(^groups\\.[0-9]+\\.services\\.[0-9]+\\.)(environment_variables\\.|run_arguments\\.|storage\\.[0-9]+\\.(size|type))|" +
-	"(^groups\\.[0-9]+\\.)(specs|labels|policy)"
+	"|(^groups\\.[0-9]+\\.services\\.[0-9]+\\.)(environment_variables(\\.|$)|run_arguments(\\.|$)|storage\\.[0-9]+\\.(size|type))|" +
+	"(^groups\\.[0-9]+\\.)(specs|labels|policy)(\\.|$)"
 
 const paramRegex = paramRootRegex + "|" + paramRulesRegex + "|" + paramGroupsRegex
 
@@ -79,7 +82,7 @@ func copyServiceGroupSpecs(spec *grpc_application_go.ServiceGroupDeploymentSpecs
 }
 
 // copyServiceGroup returns a copy of a given ServiceGroup
-func copyServiceGroup(group *grpc_application_go.ServiceGroup, settings *OrganizationSettings) *grpc_application_go.ServiceGroup {
+func copyServiceGroup(group *grpc_application_go.ServiceGroup) *grpc_application_go.ServiceGroup {
 
 	if group == nil {
 		return nil
@@ -87,7 +90,7 @@ func copyServiceGroup(group *grpc_application_go.ServiceGroup, settings *Organiz
 
 	services := make([]*grpc_application_go.Service, 0)
 	for _, service := range group.Services {
-		services = append(services, copyService(service, settings))
+		services = append(services, copyService(service))
 	}
 
 	return &grpc_application_go.ServiceGroup{
@@ -103,7 +106,7 @@ func copyServiceGroup(group *grpc_application_go.ServiceGroup, settings *Organiz
 }
 
 // copyService returns a copy of a given Service
-func copyService(service *grpc_application_go.Service, settings *OrganizationSettings) *grpc_application_go.Service {
+func copyService(service *grpc_application_go.Service) *grpc_application_go.Service {
 
 	if service == nil {
 		return nil
@@ -111,7 +114,7 @@ func copyService(service *grpc_application_go.Service, settings *OrganizationSet
 
 	storage := make([]*grpc_application_go.Storage, 0)
 	for _, sto := range service.Storage {
-		storage = append(storage, copyStorage(sto, settings))
+		storage = append(storage, copyStorage(sto))
 	}
 
 	ports := make([]*grpc_application_go.Port, 0)
@@ -146,21 +149,12 @@ func copyService(service *grpc_application_go.Service, settings *OrganizationSet
 }
 
 // copyStorage returns a copy of a given Storage
-func copyStorage(storage *grpc_application_go.Storage, settings *OrganizationSettings) *grpc_application_go.Storage {
+func copyStorage(storage *grpc_application_go.Storage) *grpc_application_go.Storage {
 	if storage == nil {
 		return nil
 	}
-
-	size := storage.Size
-	if size == 0 {
-		// apply the setting
-		if settings != nil {
-			size = settings.StoreSize
-		}
-
-	}
 	return &grpc_application_go.Storage{
-		Size:      size,
+		Size:      storage.Size,
 		MountPath: storage.MountPath,
 		Type:      storage.Type,
 	}
@@ -237,10 +231,11 @@ func copyConfigFile(configFile *grpc_application_go.ConfigFile) *grpc_applicatio
 	}
 }
 
-// newParametrizedDescriptorFromDescriptor returns a parameterized descriptor as a copy of a given descriptor
-func newParametrizedDescriptorFromDescriptor(descriptor *grpc_application_go.AppDescriptor, settings *OrganizationSettings) *grpc_application_go.ParametrizedDescriptor {
+// newParametrizedDescriptorFromDescriptor returns a parameterized descriptor as a copy of a given descriptor with the
+// organization defaults applied, and the list of defaults applied
+func newParametrizedDescriptorFromDescriptor(descriptor *grpc_application_go.AppDescriptor, settings *OrganizationSettings) (*grpc_application_go.ParametrizedDescriptor, []*grpc_application_go.AppliedDefault) {
 	if descriptor == nil {
-		return nil
+		return nil, nil
 	}
 	rules := make([]*grpc_application_go.SecurityRule, 0)
 	for _, rule := range descriptor.Rules {
@@ -248,10 +243,10 @@ func newParametrizedDescriptorFromDescriptor(descriptor *grpc_application_go.App
 	}
 	groups := make([]*grpc_application_go.ServiceGroup, 0)
 	for _, group := range descriptor.Groups {
-		groups = append(groups, copyServiceGroup(group, settings))
+		groups = append(groups, copyServiceGroup(group))
 	}
 
-	return &grpc_application_go.ParametrizedDescriptor{
+	parametrized := &grpc_application_go.ParametrizedDescriptor{
 		OrganizationId:        descriptor.OrganizationId,
 		AppDescriptorId:       descriptor.AppDescriptorId,
 		Name:                  descriptor.Name,
@@ -263,6 +258,7 @@ func newParametrizedDescriptorFromDescriptor(descriptor *grpc_application_go.App
 		InboundNetInterfaces:  descriptor.InboundNetInterfaces,
 		OutboundNetInterfaces: descriptor.OutboundNetInterfaces,
 	}
+	return parametrized, settings.apply(parametrized)
 }
 
 // findParameterInDescriptor looks for the definition of a given instance parameter in the description of the descriptor
@@ -283,11 +279,7 @@ func findParameterInDescriptor(descriptor *grpc_application_go.AppDescriptor,
 }
 
 // applyParameter substitutes the entry of the descriptor for the indicated value
-func applyParameter(jsonParamDescriptor *string,
-	paramDefinition grpc_application_go.AppParameter,
-	value interface{}) derrors.Error {
-
-	path := paramDefinition.Path
+func applyParameter(jsonParamDescriptor *string, path string, value interface{}) derrors.Error {
 
 	json, err := sjson.Set(*jsonParamDescriptor, path, value)
 	if err != nil {
@@ -337,59 +329,155 @@ func validateInstanceParameter(paramDefinition grpc_application_go.AppParameter,
 		value = parameter.Value
 	case grpc_application_go.ParamDataType_PASSWORD:
 		value = parameter.Value
-
+	case grpc_application_go.ParamDataType_LIST, grpc_application_go.ParamDataType_MAP:
+		// lists and maps replace the whole sub-tree
+		return CollectionValue(&paramDefinition, parameter.Value)
 	}
 
 	return value, nil
 }
 
+// parameterValues returns the value of every parameter of a deployment, either supplied or by default.
+func parameterValues(descriptor *grpc_application_go.AppDescriptor, parameters *grpc_application_go.InstanceParameterList) map[string]string {
+	values := make(map[string]string, len(descriptor.Parameters))
+	for _, param := range descriptor.Parameters {
+		values[param.Name] = param.DefaultValue
+	}
+	if parameters != nil {
+		for _, param := range parameters.Parameters {
+			values[param.ParameterName] = param.Value
+		}
+	}
+	return values
+}
+
+// checkConditionalParameters checks the parameters required by a condition are supplied. The conditions are evaluated
+// with the supplied values and the default values of the rest of the parameters.
+func checkConditionalParameters(descriptor *grpc_application_go.AppDescriptor,
+	parameters *grpc_application_go.InstanceParameterList) derrors.Error {
+
+	values := parameterValues(descriptor, parameters)
+	supplied := make(map[string]bool, 0)
+	if parameters != nil {
+		for _, param := range parameters.Parameters {
+			supplied[param.ParameterName] = true
+		}
+	}
+	for _, param := range descriptor.Parameters {
+		if !supplied[param.Name] && IsParamRequired(param, values) {
+			return derrors.NewFailedPreconditionError(RequiredParamNotFilled).WithParams(param.Name)
+		}
+	}
+	return nil
+}
+
 // CreateParametrizedDescriptor returns a parameterized descriptor once the parameters of the instance
 // have been validated and applied to the given descriptor
 func CreateParametrizedDescriptor(descriptor *grpc_application_go.AppDescriptor,
 	parameters *grpc_application_go.InstanceParameterList,
 	settings *OrganizationSettings) (*grpc_application_go.ParametrizedDescriptor, derrors.Error) {
+	parametrized, _, err := createParametrizedDescriptor(descriptor, parameters, settings, true)
+	return parametrized, err
+}
+
+// CreateReferencedDescriptor returns the parametrized descriptor that is persisted or returned to the user, where the
+// PASSWORD parameters hold the references to the stored secrets or masked values. The values of the PASSWORD
+// parameters are not validated, the descriptor must have been created from the actual values first. The organization
+// defaults applied and not overridden by a parameter are returned to be recorded in the instance.
+func CreateReferencedDescriptor(descriptor *grpc_application_go.AppDescriptor,
+	parameters *grpc_application_go.InstanceParameterList,
+	settings *OrganizationSettings) (*grpc_application_go.ParametrizedDescriptor, []*grpc_application_go.AppliedDefault, derrors.Error) {
+	return createParametrizedDescriptor(descriptor, parameters, settings, false)
+}
+
+// createParametrizedDescriptor applies the parameters to a descriptor, checkPasswords indicates if the values of the
+// PASSWORD parameters are checked against their constraints.
+func createParametrizedDescriptor(descriptor *grpc_application_go.AppDescriptor,
+	parameters *grpc_application_go.InstanceParameterList,
+	settings *OrganizationSettings, checkPasswords bool) (*grpc_application_go.ParametrizedDescriptor, []*grpc_application_go.AppliedDefault, derrors.Error) {
 
-	parametrized := newParametrizedDescriptorFromDescriptor(descriptor, settings)
+	parametrized, applied := newParametrizedDescriptorFromDescriptor(descriptor, settings)
 
-	if parameters == nil || parameters.Parameters == nil || len(parameters.Parameters) == 0 {
-		return parametrized, nil
+	dErr := checkConditionalParameters(descriptor, parameters)
+	if dErr != nil {
+		return nil, nil, dErr
+	}
+
+	if len(descriptor.Parameters) == 0 {
+		return completeParametrizedDescriptor(parametrized, settings, applied, nil)
 	}
 
 	// we need to convert the parametrized descriptor to json to apply changes
 	newDescriptor, err := json.Marshal(parametrized)
 	if err != nil {
-		return nil, conversions.ToDerror(err)
+		return nil, nil, conversions.ToDerror(err)
 	}
 
-	jsonDescriptor := string(newDescriptor)
+	// the references to parameters are interpolated before applying the parameters, so the values replacing a whole
+	// field are not interpolated
+	jsonDescriptor, dErr := interpolateDescriptor(string(newDescriptor), parameterValues(descriptor, parameters))
+	if dErr != nil {
+		return nil, nil, dErr
+	}
+	if parameters == nil {
+		parameters = &grpc_application_go.InstanceParameterList{}
+	}
+	resolver := NewServiceResolver(descriptor.Groups)
+	parametrizedPaths := make(map[string]bool, 0)
 
 	for _, param := range parameters.Parameters {
 
 		// find parameter definition, if the parameter does no exists an error is returned
 		paramDefinition, err := findParameterInDescriptor(descriptor, *param)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		// validate parameter
 		value, err := validateInstanceParameter(*paramDefinition, *param)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		if checkPasswords || paramDefinition.Type != grpc_application_go.ParamDataType_PASSWORD {
+			err = CheckParamConstraints(paramDefinition, param.Value)
+			if err != nil {
+				return nil, nil, err
+			}
 		}
 		// apply
-		err = applyParameter(&jsonDescriptor, *paramDefinition, value)
+		path, err := resolver.IndexPath(paramDefinition.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = applyParameter(&jsonDescriptor, path, value)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+		parametrizedPaths[path] = true
 	}
 
-	// convert json to parametrizedDescriptor
-	err = json.Unmarshal([]byte(jsonDescriptor), parametrized)
+	// convert json to parametrizedDescriptor, a new one is used as the maps are shared with the descriptor and
+	// unmarshalling on them would merge the keys instead of replacing them
+	result := &grpc_application_go.ParametrizedDescriptor{}
+	err = json.Unmarshal([]byte(jsonDescriptor), result)
 	if err != nil {
-		return nil, conversions.ToDerror(err)
+		return nil, nil, conversions.ToDerror(err)
 	}
 
-	return parametrized, nil
+	return completeParametrizedDescriptor(result, settings, applied, parametrizedPaths)
+}
+
+// completeParametrizedDescriptor checks the organization restrictions once the parameters are applied and returns the
+// organization defaults that have not been overridden by a parameter
+func completeParametrizedDescriptor(parametrized *grpc_application_go.ParametrizedDescriptor,
+	settings *OrganizationSettings, applied []*grpc_application_go.AppliedDefault,
+	parametrizedPaths map[string]bool) (*grpc_application_go.ParametrizedDescriptor, []*grpc_application_go.AppliedDefault, derrors.Error) {
+
+	err := settings.checkReservedPorts(parametrized)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parametrized, keptDefaults(applied, parametrizedPaths), nil
 }
 
 // validateParamName checks the param name does not start whit "NALEJ_"
@@ -411,12 +499,36 @@ func validateParamPath(jsonDescriptor string, param *grpc_application_go.AppPara
 		if param.Type == grpc_application_go.ParamDataType_INTEGER || param.Type == grpc_application_go.ParamDataType_FLOAT {
 			return gjson.Result{Type: gjson.Number, Raw: "0", Num: 0}, nil
 		}
+		// the same happens with empty lists and maps
+		switch param.Type {
+		case grpc_application_go.ParamDataType_LIST:
+			return gjson.Result{Type: gjson.JSON, Raw: "[]"}, nil
+		case grpc_application_go.ParamDataType_MAP:
+			return gjson.Result{Type: gjson.JSON, Raw: "{}"}, nil
+		}
 		return field, derrors.NewInvalidArgumentError(invalidParamPath).WithParams(param.Name)
 	}
 
 	return field, nil
 }
 
+// validateCollectionType validates that the value by default is a list or a map of strings
+func validateCollectionType(field gjson.Result, param *grpc_application_go.AppParameter) derrors.Error {
+	if (param.Type == grpc_application_go.ParamDataType_LIST && !field.IsArray()) ||
+		(param.Type == grpc_application_go.ParamDataType_MAP && !field.IsObject()) {
+		return derrors.NewInvalidArgumentError(invalidParamType).WithParams(param.Name)
+	}
+	valid := true
+	field.ForEach(func(key, value gjson.Result) bool {
+		valid = value.Type == gjson.String
+		return valid
+	})
+	if !valid {
+		return derrors.NewInvalidArgumentError(invalidParamType).WithParams(param.Name)
+	}
+	return nil
+}
+
 // validateParamType validates that the defined type corresponds to the value by default
 func validateParamType(field gjson.Result, param *grpc_application_go.AppParameter) derrors.Error {
 
@@ -447,6 +559,8 @@ func validateParamType(field gjson.Result, param *grpc_application_go.AppParamet
 		if fieldType != gjson.String {
 			return derrors.NewInvalidArgumentError(invalidParamPath).WithParams(param.Name)
 		}
+	case grpc_application_go.ParamDataType_LIST, grpc_application_go.ParamDataType_MAP:
+		return validateCollectionType(field, param)
 	}
 
 	return nil
@@ -467,53 +581,108 @@ func validateAllowedParameter(param *grpc_application_go.AppParameter) derrors.E
 
 // ValidateDescriptorParameters validates the parameter has correct format, the path is allowed (field can be updated) and the name is correct (no starts with NALEJ_)
 func ValidateDescriptorParameters(descriptor *grpc_application_go.AddAppDescriptorRequest) derrors.Error {
+	violations := NewViolations()
+	collectDescriptorParametersViolations(descriptor, violations)
+	return violations.AsError()
+}
+
+// collectDescriptorParametersViolations validates all the parameters of a descriptor filling their default values
+func collectDescriptorParametersViolations(descriptor *grpc_application_go.AddAppDescriptorRequest, violations *Violations) {
 
 	// we need to convert the parametrized descriptor to json get values
 	newDescriptor, err := json.Marshal(descriptor)
 	if err != nil {
-		return conversions.ToDerror(err)
+		violations.Add("parameters", InvalidParameterViolation, err.Error())
+		return
 	}
 
 	jsonDescriptor := string(newDescriptor)
 
 	paramNames := make(map[string]bool, 0)
+	resolver := NewServiceResolver(descriptor.Groups)
+
+	params := make(map[string]*grpc_application_go.AppParameter, len(descriptor.Parameters))
+	for _, param := range descriptor.Parameters {
+		params[param.Name] = param
+	}
 
 	for i := 0; i < len(descriptor.Parameters); i++ {
 		param := descriptor.Parameters[i]
+		paramPath := fmt.Sprintf("parameters[%d]", i)
 
 		// validate name
 		name := strings.ToUpper(strings.TrimLeft(param.Name, ""))
 		valErr := validateParamName(name)
 		if valErr != nil {
-			return valErr
+			violations.Add(paramPath+".name", InvalidNameViolation, invalidParamName, param.Name)
 		}
 		// check the name is not defined twice
 		exists, _ := paramNames[name]
 		if exists {
-			return derrors.NewInvalidArgumentError(paramDefinedTwice).WithParams(param.Name)
+			violations.Add(paramPath+".name", DuplicatedNameViolation, paramDefinedTwice, param.Name)
 		}
 		paramNames[name] = true
 
+		// validate constraints
+		constraintsErr := ValidateParamConstraints(param, params)
+		if constraintsErr != nil {
+			violations.Add(paramPath+".constraints", InvalidConstraintViolation, invalidParamConstraints, constraintsErr.Error())
+		}
+
+		// groups and services can be referred by name, the path is stored with their positions
+		path, valErr := resolver.IndexPath(param.Path)
+		if valErr != nil {
+			violations.Add(paramPath+".path", UnknownReferenceViolation, invalidParamPath, param.Path)
+			continue
+		}
+		param.Path = path
+
+		valErr = validateAllowedParameter(param)
+		if valErr != nil {
+			violations.Add(paramPath+".path", InvalidParameterViolation, paramNotAllowed, param.Path)
+		}
+
 		// validate param path and type
 		field, valErr := validateParamPath(jsonDescriptor, param)
 		if valErr != nil {
-			return valErr
+			violations.Add(paramPath+".path", InvalidParameterViolation, invalidParamPath, param.Name)
+			continue
 		}
 
 		// validate type
 		valErr = validateParamType(field, param)
 		if valErr != nil {
-			return valErr
+			violations.Add(paramPath+".type", InvalidParameterViolation, invalidParamType, param.Name)
+			continue
 		}
 		// default_value
 		param.DefaultValue = field.String()
 
-		valErr = validateAllowedParameter(param)
-		if valErr != nil {
-			return valErr
+		// constraints are checked against the default values
+		if constraintsErr == nil && param.DefaultValue != "" {
+			valErr = CheckParamConstraints(param, param.DefaultValue)
+			if valErr != nil {
+				violations.Add(paramPath+".default_value", ConstraintNotMetViolation, paramConstraintNotMet, valErr.Error())
+			}
 		}
-
 	}
-	return nil
 
+	// the references to parameters inside the fields must be defined
+	templates := referencingStrings(jsonDescriptor)
+	paths := make([]string, 0, len(templates))
+	for path := range templates {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if !interpolableMatcher.MatchString(path) {
+			violations.Add(path, InvalidParameterViolation, "param references are not allowed in this field", templates[path])
+			continue
+		}
+		for _, reference := range ParamReferences(templates[path]) {
+			if _, exists := params[reference]; !exists {
+				violations.Add(path, UnknownReferenceViolation, "unknown param referenced", reference)
+			}
+		}
+	}
 }
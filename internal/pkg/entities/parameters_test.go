@@ -36,6 +36,11 @@ func createAllowedPathParameters() []*grpc_application_go.AppParameter {
 		{Path: "rules.11.device_group_names.0"},
 		{Path: "groups.0.policy.0.environment_variables.ORGANIZATION_ID"},
 		{Path: "groups.0.specs.replicas"},
+		{Path: "rules.11.device_group_names"},
+		{Path: "groups.0.services.0.run_arguments"},
+		{Path: "groups.0.services.0.environment_variables"},
+		{Path: "environment_variables"},
+		{Path: "labels"},
 	}
 }
 
@@ -47,6 +52,14 @@ func createNotAllowedPathParameters() []*grpc_application_go.AppParameter {
 		{Path: "groups.0.services.0.credentials.username"},
 		{Path: "groups.10.services.0.exposed_ports.2.internal_port"},
 		{Path: "groups.10.services.0.deploy_after.2"},
+		{Path: "groups.10.services.0.deploy_after"},
+		{Path: "groups.10.services.0.storage"},
+		{Path: "rules.11.auth_services"},
+		{Path: "labels_extra"},
+		{Path: "groups.0.services.0.run_arguments_extra"},
+		{Path: "groups.0.specs_extra"},
+		{Path: "groups.0.labelsX.name"},
+		{Path: "groups.0.services.0.image"},
 	}
 }
 
@@ -57,8 +70,9 @@ var _ = ginkgo.Describe("Parameter tests", func() {
 
 			descriptor := utils.CreateTestDescriptor()
 
-			parametrized := newParametrizedDescriptorFromDescriptor(descriptor, nil)
+			parametrized, applied := newParametrizedDescriptorFromDescriptor(descriptor, nil)
 			gomega.Expect(parametrized).NotTo(gomega.BeNil())
+			gomega.Expect(applied).To(gomega.BeEmpty())
 
 			// update descriptor and parametrized to check they are not the same
 			descriptor.Rules[0].Name = "name modified"
@@ -130,6 +144,43 @@ var _ = ginkgo.Describe("Parameter tests", func() {
 
 	})
 
+	ginkgo.Context("List and map parameters", func() {
+		ginkgo.It("should validate list and map parameters against the descriptor", func() {
+			descriptor := utils.CreateTestAddDescriptorWithParameters()
+			descriptor.Groups[0].Services[0].RunArguments = []string{"--verbose"}
+			descriptor.Parameters = append(descriptor.Parameters,
+				&grpc_application_go.AppParameter{Name: "args", Path: "groups.0.services.0.run_arguments", Type: grpc_application_go.ParamDataType_LIST},
+				&grpc_application_go.AppParameter{Name: "envs", Path: "groups.0.services.0.environment_variables", Type: grpc_application_go.ParamDataType_MAP})
+			gomega.Expect(ValidateDescriptorParameters(descriptor)).To(gomega.Succeed())
+			gomega.Expect(descriptor.Parameters[2].DefaultValue).To(gomega.Equal(`["--verbose"]`))
+
+			// a list cannot replace a map
+			descriptor.Parameters = append(descriptor.Parameters,
+				&grpc_application_go.AppParameter{Name: "labels", Path: "groups.0.services.0.environment_variables", Type: grpc_application_go.ParamDataType_LIST})
+			gomega.Expect(ValidateDescriptorParameters(descriptor)).NotTo(gomega.Succeed())
+		})
+		ginkgo.It("should replace whole lists and maps", func() {
+			descriptor := utils.CreateTestDescriptorWithParameters()
+			descriptor.Parameters = append(descriptor.Parameters,
+				&grpc_application_go.AppParameter{Name: "envs", Path: "groups.0.services.0.environment_variables", Type: grpc_application_go.ParamDataType_MAP,
+					Constraints: &grpc_application_go.ParamConstraints{MaxLength: 2}})
+
+			parametrized, err := CreateParametrizedDescriptor(descriptor, &grpc_application_go.InstanceParameterList{
+				Parameters: []*grpc_application_go.InstanceParameter{{ParameterName: "envs", Value: `{"env3": "val3"}`}}}, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(parametrized.Groups[0].Services[0].EnvironmentVariables).To(gomega.Equal(map[string]string{"env3": "val3"}))
+			gomega.Expect(descriptor.Groups[0].Services[0].EnvironmentVariables).NotTo(gomega.HaveKey("env3"))
+
+			_, err = CreateParametrizedDescriptor(descriptor, &grpc_application_go.InstanceParameterList{
+				Parameters: []*grpc_application_go.InstanceParameter{{ParameterName: "envs", Value: `["val3"]`}}}, nil)
+			gomega.Expect(err).NotTo(gomega.Succeed())
+
+			_, err = CreateParametrizedDescriptor(descriptor, &grpc_application_go.InstanceParameterList{
+				Parameters: []*grpc_application_go.InstanceParameter{{ParameterName: "envs", Value: `{"a": "1", "b": "2", "c": "3"}`}}}, nil)
+			gomega.Expect(err).NotTo(gomega.Succeed())
+		})
+	})
+
 	ginkgo.Context("Inbound and Outbound validation", func() {
 
 		ginkgo.It("Should be able to Valid the descriptor ", func() {
@@ -0,0 +1,231 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"fmt"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-application-manager-go"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// Resources limited by the organization quotas.
+const (
+	InstancesResource    = "instances"
+	ReplicasResource     = "replicas"
+	StorageResource      = "storage"
+	ExposedPortsResource = "exposed_ports"
+)
+
+// quotaResources with the order in which the resources are checked and reported.
+var quotaResources = []string{InstancesResource, ReplicasResource, StorageResource, ExposedPortsResource}
+
+// ResourceFootprint with the resources used by one or more application instances.
+type ResourceFootprint struct {
+	// Instances of applications.
+	Instances int64
+	// Replicas with the number of services taking into account the replicas of their groups.
+	Replicas int64
+	// Storage with the bytes of storage requested by the services.
+	Storage int64
+	// ExposedPorts with the number of ports exposed by the services.
+	ExposedPorts int64
+}
+
+// groupReplicas returns the replicas of a group. A group without replicas or replicated on every cluster is
+// considered to have one.
+func groupReplicas(specs *grpc_application_go.ServiceGroupDeploymentSpecs) int64 {
+	if specs == nil || specs.Replicas < 1 {
+		return 1
+	}
+	return int64(specs.Replicas)
+}
+
+// NewDescriptorFootprint computes the resources a parametrized descriptor would use once deployed.
+func NewDescriptorFootprint(descriptor *grpc_application_go.ParametrizedDescriptor) *ResourceFootprint {
+	footprint := &ResourceFootprint{Instances: 1}
+	for _, group := range descriptor.Groups {
+		replicas := groupReplicas(group.Specs)
+		for _, service := range group.Services {
+			footprint.Replicas += replicas
+			for _, storage := range service.Storage {
+				footprint.Storage += storage.Size * replicas
+			}
+			footprint.ExposedPorts += int64(len(service.ExposedPorts))
+		}
+	}
+	return footprint
+}
+
+// NewInstancesFootprint computes the resources used by a list of instances.
+func NewInstancesFootprint(instances []*grpc_application_go.AppInstance) *ResourceFootprint {
+	footprint := &ResourceFootprint{}
+	for _, instance := range instances {
+		footprint.Instances++
+		for _, group := range instance.Groups {
+			replicas := groupReplicas(group.Specs)
+			for _, service := range group.ServiceInstances {
+				footprint.Replicas += replicas
+				for _, storage := range service.Storage {
+					footprint.Storage += storage.Size * replicas
+				}
+				footprint.ExposedPorts += int64(len(service.ExposedPorts))
+			}
+		}
+	}
+	return footprint
+}
+
+// Add returns the sum of two footprints.
+func (f *ResourceFootprint) Add(other *ResourceFootprint) *ResourceFootprint {
+	return &ResourceFootprint{
+		Instances:    f.Instances + other.Instances,
+		Replicas:     f.Replicas + other.Replicas,
+		Storage:      f.Storage + other.Storage,
+		ExposedPorts: f.ExposedPorts + other.ExposedPorts,
+	}
+}
+
+// Subtract returns the resources of a footprint that are not in the other one.
+func (f *ResourceFootprint) Subtract(other *ResourceFootprint) *ResourceFootprint {
+	return &ResourceFootprint{
+		Instances:    f.Instances - other.Instances,
+		Replicas:     f.Replicas - other.Replicas,
+		Storage:      f.Storage - other.Storage,
+		ExposedPorts: f.ExposedPorts - other.ExposedPorts,
+	}
+}
+
+// Increase returns the resources of a footprint that exceed a previous one, the resources released are not counted.
+func (f *ResourceFootprint) Increase(previous *ResourceFootprint) *ResourceFootprint {
+	increase := f.Subtract(previous)
+	for _, value := range []*int64{&increase.Instances, &increase.Replicas, &increase.Storage, &increase.ExposedPorts} {
+		if *value < 0 {
+			*value = 0
+		}
+	}
+	return increase
+}
+
+// IsEmpty checks if a footprint does not use any resource.
+func (f *ResourceFootprint) IsEmpty() bool {
+	return *f == ResourceFootprint{}
+}
+
+// get returns the amount of a resource.
+func (f *ResourceFootprint) get(resource string) int64 {
+	switch resource {
+	case InstancesResource:
+		return f.Instances
+	case ReplicasResource:
+		return f.Replicas
+	case StorageResource:
+		return f.Storage
+	case ExposedPortsResource:
+		return f.ExposedPorts
+	}
+	return 0
+}
+
+// ResourceQuotas with the limits of an organization. A limit of 0 means the resource is not limited.
+type ResourceQuotas struct {
+	Instances    int64
+	Replicas     int64
+	Storage      int64
+	ExposedPorts int64
+}
+
+// get returns the limit of a resource.
+func (q *ResourceQuotas) get(resource string) int64 {
+	return (*ResourceFootprint)(q).get(resource)
+}
+
+// NewQuotaUsageReport compares the resources used by an organization with its quotas. Reserved contains the
+// resources of the deployments admitted and not yet completed.
+func NewQuotaUsageReport(organizationID string, quotas *ResourceQuotas, used *ResourceFootprint, reserved *ResourceFootprint) *grpc_application_manager_go.QuotaUsageReport {
+	report := &grpc_application_manager_go.QuotaUsageReport{
+		OrganizationId: organizationID,
+		Usage:          make([]*grpc_application_manager_go.QuotaUsage, 0, len(quotaResources)),
+	}
+	for _, resource := range quotaResources {
+		limit := int64(0)
+		if quotas != nil {
+			limit = quotas.get(resource)
+		}
+		report.Usage = append(report.Usage, &grpc_application_manager_go.QuotaUsage{
+			Resource: resource,
+			Used:     used.get(resource),
+			Reserved: reserved.get(resource),
+			Limit:    limit,
+		})
+	}
+	return report
+}
+
+// QuotaViolation with a resource whose quota would be exceeded.
+type QuotaViolation struct {
+	Resource  string
+	Used      int64
+	Requested int64
+	Limit     int64
+}
+
+// Description of the violation.
+func (v *QuotaViolation) Description() string {
+	return fmt.Sprintf("%s quota exceeded: %d in use, %d requested, limit %d", v.Resource, v.Used, v.Requested, v.Limit)
+}
+
+// CheckQuotas returns the quotas that would be exceeded if the requested resources were added to the used ones.
+func CheckQuotas(quotas *ResourceQuotas, used *ResourceFootprint, requested *ResourceFootprint) []*QuotaViolation {
+	violations := make([]*QuotaViolation, 0)
+	if quotas == nil {
+		return violations
+	}
+	for _, resource := range quotaResources {
+		limit := quotas.get(resource)
+		if limit > 0 && used.get(resource)+requested.get(resource) > limit {
+			violations = append(violations, &QuotaViolation{Resource: resource, Used: used.get(resource),
+				Requested: requested.get(resource), Limit: limit})
+		}
+	}
+	return violations
+}
+
+// QuotaViolationsToGRPCError returns a ResourceExhausted error with a QuotaFailure detail per violation.
+func QuotaViolationsToGRPCError(organizationID string, violations []*QuotaViolation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	details := &errdetails.QuotaFailure{Violations: make([]*errdetails.QuotaFailure_Violation, 0, len(violations))}
+	descriptions := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		details.Violations = append(details.Violations, &errdetails.QuotaFailure_Violation{
+			Subject:     fmt.Sprintf("organization:%s/%s", organizationID, violation.Resource),
+			Description: violation.Description(),
+		})
+		descriptions = append(descriptions, violation.Description())
+	}
+	st := status.New(codes.ResourceExhausted, strings.Join(descriptions, "; "))
+	withDetails, err := st.WithDetails(details)
+	if err != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
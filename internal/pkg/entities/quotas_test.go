@@ -0,0 +1,91 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/nalej/application-manager/internal/pkg/utils"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = ginkgo.Describe("Organization quotas", func() {
+
+	var parametrized *grpc_application_go.ParametrizedDescriptor
+
+	ginkgo.BeforeEach(func() {
+		descriptor := utils.CreateTestDescriptorWithParameters()
+		descriptor.Groups[1].Services[0].Storage = []*grpc_application_go.Storage{{MountPath: "/data", Size: 100}}
+		descriptor.Groups[1].Services[0].ExposedPorts = []*grpc_application_go.Port{{Name: "http", InternalPort: 80, ExposedPort: 80}}
+		descriptor.Groups[1].Specs = &grpc_application_go.ServiceGroupDeploymentSpecs{Replicas: 2}
+		var err error
+		parametrized, err = CreateParametrizedDescriptor(descriptor, nil, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should compute the footprint of a descriptor", func() {
+		footprint := NewDescriptorFootprint(parametrized)
+		// g1 has two services with 3 replicas, g2 one service with 2 replicas
+		gomega.Expect(footprint).To(gomega.Equal(&ResourceFootprint{Instances: 1, Replicas: 8, Storage: 200, ExposedPorts: 1}))
+	})
+
+	ginkgo.It("should reject the deployments exceeding the quotas", func() {
+		used := &ResourceFootprint{Instances: 2, Replicas: 10, Storage: 1000, ExposedPorts: 1}
+		requested := NewDescriptorFootprint(parametrized)
+		gomega.Expect(CheckQuotas(&ResourceQuotas{Instances: 3, Replicas: 20}, used, requested)).To(gomega.BeEmpty())
+
+		violations := CheckQuotas(&ResourceQuotas{Instances: 2, Replicas: 20, ExposedPorts: 1}, used, requested)
+		gomega.Expect(violations).To(gomega.HaveLen(2))
+		gomega.Expect(violations[0].Resource).To(gomega.Equal(InstancesResource))
+		gomega.Expect(violations[1].Resource).To(gomega.Equal(ExposedPortsResource))
+
+		err := QuotaViolationsToGRPCError("org", violations)
+		st := status.Convert(err)
+		gomega.Expect(st.Code()).To(gomega.Equal(codes.ResourceExhausted))
+		gomega.Expect(st.Details()).To(gomega.HaveLen(1))
+		gomega.Expect(st.Details()[0].(*errdetails.QuotaFailure).Violations).To(gomega.HaveLen(2))
+	})
+
+	ginkgo.It("should report the usage against the quotas", func() {
+		used := &ResourceFootprint{Instances: 2, Replicas: 10}
+		reserved := &ResourceFootprint{Instances: 1, Replicas: 3}
+		report := NewQuotaUsageReport("org", &ResourceQuotas{Replicas: 20}, used, reserved)
+		gomega.Expect(report.Usage).To(gomega.HaveLen(4))
+		gomega.Expect(proto.Equal(report.Usage[1], &grpc_application_manager_go.QuotaUsage{
+			Resource: ReplicasResource, Used: 10, Reserved: 3, Limit: 20})).To(gomega.BeTrue())
+		gomega.Expect(report.Usage[0].Limit).To(gomega.Equal(int64(0)))
+	})
+
+	ginkgo.It("should add and subtract footprints", func() {
+		used := &ResourceFootprint{Instances: 2, Replicas: 10, Storage: 100}
+		requested := &ResourceFootprint{Instances: 1, Replicas: 3}
+		gomega.Expect(used.Add(requested)).To(gomega.Equal(&ResourceFootprint{Instances: 3, Replicas: 13, Storage: 100}))
+		gomega.Expect(used.Add(requested).Subtract(used).Subtract(requested).IsEmpty()).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should only count the resources added by an upgrade", func() {
+		previous := &ResourceFootprint{Instances: 1, Replicas: 4, Storage: 100, ExposedPorts: 2}
+		upgraded := &ResourceFootprint{Instances: 1, Replicas: 6, Storage: 50, ExposedPorts: 2}
+		gomega.Expect(upgraded.Increase(previous)).To(gomega.Equal(&ResourceFootprint{Replicas: 2}))
+		gomega.Expect(previous.Increase(previous).IsEmpty()).To(gomega.BeTrue())
+	})
+})
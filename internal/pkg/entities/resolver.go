@@ -0,0 +1,225 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Resolution of the references to services of a descriptor. Services are identified by the group they belong to and
+// their name, using the group.service syntax. References with only the service name are still supported.
+
+package entities
+
+import (
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-go"
+	"strconv"
+	"strings"
+)
+
+// ServiceReferenceSeparator separates the group and the service name in a qualified reference.
+const ServiceReferenceSeparator = "."
+
+const serviceNotFound = "service not found"
+const serviceGroupNotFound = "service group not found"
+const serviceOutOfScope = "service reference out of the service group"
+const ambiguousService = "ambiguous service reference, use group.service"
+
+// ServiceReference to a service of a descriptor.
+type ServiceReference struct {
+	// Group with the name of the service group. It is empty on references that only contain the service name.
+	Group string
+	// Service with the name of the service.
+	Service string
+}
+
+// ParseServiceReference parses a reference with the group.service or service syntax. As service names cannot contain
+// dots, the last separator splits the group and the service.
+func ParseServiceReference(reference string) ServiceReference {
+	pos := strings.LastIndex(reference, ServiceReferenceSeparator)
+	if pos == -1 {
+		return ServiceReference{Service: reference}
+	}
+	return ServiceReference{Group: reference[:pos], Service: reference[pos+1:]}
+}
+
+// String returns the qualified reference.
+func (r ServiceReference) String() string {
+	if r.Group == "" {
+		return r.Service
+	}
+	return fmt.Sprintf("%s%s%s", r.Group, ServiceReferenceSeparator, r.Service)
+}
+
+// ServiceResolver resolves the references to groups and services of a descriptor. Service names are unique within a
+// group, but the same name may be used in different groups.
+type ServiceResolver struct {
+	// groupNames with the names of the groups in the order they are declared.
+	groupNames []string
+	// groupIndex with the position of each group.
+	groupIndex map[string]int
+	// serviceIndex with the position of each service indexed by group name and service name.
+	serviceIndex map[string]map[string]int
+	// serviceGroups with the names of the groups that contain a service name.
+	serviceGroups map[string][]string
+}
+
+// NewServiceResolver creates a resolver for the groups of a descriptor. If a name is defined twice, the first
+// definition is used.
+func NewServiceResolver(groups []*grpc_application_go.ServiceGroup) *ServiceResolver {
+	resolver := &ServiceResolver{
+		groupNames:    make([]string, 0, len(groups)),
+		groupIndex:    make(map[string]int, len(groups)),
+		serviceIndex:  make(map[string]map[string]int, len(groups)),
+		serviceGroups: make(map[string][]string, 0),
+	}
+	for i, group := range groups {
+		resolver.groupNames = append(resolver.groupNames, group.Name)
+		if _, exists := resolver.groupIndex[group.Name]; exists {
+			continue
+		}
+		resolver.groupIndex[group.Name] = i
+		services := make(map[string]int, len(group.Services))
+		for j, service := range group.Services {
+			if _, exists := services[service.Name]; exists {
+				continue
+			}
+			services[service.Name] = j
+			resolver.serviceGroups[service.Name] = append(resolver.serviceGroups[service.Name], group.Name)
+		}
+		resolver.serviceIndex[group.Name] = services
+	}
+	return resolver
+}
+
+// HasGroup checks if a group is defined.
+func (r *ServiceResolver) HasGroup(group string) bool {
+	_, exists := r.groupIndex[group]
+	return exists
+}
+
+// groupName returns the name of a group matching a reference. Names are compared exactly and, if no group matches,
+// ignoring the case as environment variables are written in upper case.
+func (r *ServiceResolver) groupName(group string) (string, bool) {
+	if r.HasGroup(group) {
+		return group, true
+	}
+	for _, name := range r.groupNames {
+		if strings.EqualFold(name, group) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Resolve returns the qualified reference of a service. Qualified references must point to a service of the group.
+// When a scope is given the service must belong to that group; otherwise references with only the service name are
+// resolved if the name is unique in the descriptor.
+func (r *ServiceResolver) Resolve(reference string, scope string) (ServiceReference, derrors.Error) {
+	ref := ParseServiceReference(reference)
+	if ref.Group != "" {
+		group, found := r.groupName(ref.Group)
+		if !found {
+			return ref, derrors.NewNotFoundError(serviceGroupNotFound).WithParams(reference)
+		}
+		ref.Group = group
+		if scope != "" && scope != ref.Group {
+			return ref, derrors.NewFailedPreconditionError(serviceOutOfScope).WithParams(scope, reference)
+		}
+	} else if scope != "" {
+		ref.Group = scope
+	}
+
+	if ref.Group != "" {
+		if _, exists := r.serviceIndex[ref.Group][ref.Service]; !exists {
+			return ref, derrors.NewNotFoundError(serviceNotFound).WithParams(reference)
+		}
+		return ref, nil
+	}
+
+	groups := r.serviceGroups[ref.Service]
+	switch len(groups) {
+	case 0:
+		return ref, derrors.NewNotFoundError(serviceNotFound).WithParams(reference)
+	case 1:
+		ref.Group = groups[0]
+		return ref, nil
+	default:
+		return ref, derrors.NewFailedPreconditionError(ambiguousService).WithParams(reference, strings.Join(groups, ", "))
+	}
+}
+
+// IsAmbiguous checks if a reference only contains the name of a service defined in several groups.
+func (r *ServiceResolver) IsAmbiguous(reference string) bool {
+	ref := ParseServiceReference(reference)
+	return ref.Group == "" && len(r.serviceGroups[ref.Service]) > 1
+}
+
+// IndexPath translates a parameter path that identifies groups and services by name, e.g.
+// groups.g1.services.service1.specs.cpu, to the equivalent path with positions, e.g. groups.0.services.0.specs.cpu.
+// Segments that already contain a position are not modified.
+func (r *ServiceResolver) IndexPath(path string) (string, derrors.Error) {
+	parts := strings.Split(path, ".")
+	if len(parts) < 2 || parts[0] != "groups" {
+		return path, nil
+	}
+	groupIndex, err := strconv.Atoi(parts[1])
+	if err != nil {
+		index, exists := r.groupIndex[parts[1]]
+		if !exists {
+			return path, derrors.NewNotFoundError(serviceGroupNotFound).WithParams(path)
+		}
+		groupIndex = index
+		parts[1] = strconv.Itoa(index)
+	}
+	if len(parts) < 4 || parts[2] != "services" {
+		return strings.Join(parts, "."), nil
+	}
+	if _, err := strconv.Atoi(parts[3]); err != nil {
+		if groupIndex < 0 || groupIndex >= len(r.groupNames) {
+			return path, derrors.NewNotFoundError(serviceGroupNotFound).WithParams(path)
+		}
+		index, exists := r.serviceIndex[r.groupNames[groupIndex]][parts[3]]
+		if !exists {
+			return path, derrors.NewNotFoundError(serviceNotFound).WithParams(path)
+		}
+		parts[3] = strconv.Itoa(index)
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// NormalizeServiceReferences replaces the qualified references in the rules and the deploy after fields of a valid
+// descriptor with the service name, as the group is already identified by the field they are declared in.
+func NormalizeServiceReferences(descriptor *grpc_application_go.AddAppDescriptorRequest) {
+	resolver := NewServiceResolver(descriptor.Groups)
+	normalize := func(reference string, scope string) string {
+		ref, err := resolver.Resolve(reference, scope)
+		if err != nil {
+			return reference
+		}
+		return ref.Service
+	}
+	for _, rule := range descriptor.Rules {
+		rule.TargetServiceName = normalize(rule.TargetServiceName, rule.TargetServiceGroupName)
+		for i, service := range rule.AuthServices {
+			rule.AuthServices[i] = normalize(service, rule.AuthServiceGroupName)
+		}
+	}
+	for _, group := range descriptor.Groups {
+		for _, service := range group.Services {
+			for i, after := range service.DeployAfter {
+				service.DeployAfter[i] = normalize(after, group.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"github.com/nalej/application-manager/internal/pkg/utils"
+	"github.com/nalej/grpc-application-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Service references", func() {
+
+	var descriptor *grpc_application_go.AddAppDescriptorRequest
+
+	ginkgo.BeforeEach(func() {
+		descriptor = utils.CreateTestAddDescriptorWithParameters()
+		// the same service name in both groups
+		descriptor.Groups[1].Services = append(descriptor.Groups[1].Services, &grpc_application_go.Service{Name: "service2"})
+		descriptor.EnvironmentVariables["var2"] = "NALEJ_SERV_G1.SERVICE2"
+	})
+
+	ginkgo.It("should resolve qualified and legacy references", func() {
+		resolver := NewServiceResolver(descriptor.Groups)
+
+		ref, err := resolver.Resolve("g2.service2", "")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ref).To(gomega.Equal(ServiceReference{Group: "g2", Service: "service2"}))
+
+		ref, err = resolver.Resolve("service1", "")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ref.String()).To(gomega.Equal("g1.service1"))
+
+		ref, err = resolver.Resolve("service2", "g1")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ref.Group).To(gomega.Equal("g1"))
+
+		_, err = resolver.Resolve("service2", "")
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		gomega.Expect(resolver.IsAmbiguous("service2")).To(gomega.BeTrue())
+
+		_, err = resolver.Resolve("g2.service2", "g1")
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		_, err = resolver.Resolve("g3.service1", "")
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should accept the same service name in different groups", func() {
+		descriptor.Rules[0].AuthServices = []string{"g2.service3", "service2"}
+		gomega.Expect(ValidDescriptorLogic(descriptor)).To(gomega.Succeed())
+
+		NormalizeServiceReferences(descriptor)
+		gomega.Expect(descriptor.Rules[0].AuthServices).To(gomega.Equal([]string{"service3", "service2"}))
+	})
+
+	ginkgo.It("should check the environment variables references", func() {
+		descriptor.EnvironmentVariables["var3"] = "NALEJ_SERV_G2.SERVICE2:3000"
+		gomega.Expect(ValidAppDescriptorEnvironmentVariables(descriptor)).To(gomega.Succeed())
+
+		descriptor.EnvironmentVariables["var2"] = "NALEJ_SERV_SERVICE2"
+		violations := NewViolations()
+		collectEnvironmentVariablesViolations(descriptor, NewServiceResolver(descriptor.Groups), violations)
+		gomega.Expect(violations.List).To(gomega.HaveLen(1))
+		gomega.Expect(violations.List[0].Code).To(gomega.Equal(AmbiguousReferenceViolation))
+	})
+
+	ginkgo.It("should translate parameter paths with names", func() {
+		resolver := NewServiceResolver(descriptor.Groups)
+		path, err := resolver.IndexPath("groups.g2.services.service2.specs.replicas")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(path).To(gomega.Equal("groups.1.services.1.specs.replicas"))
+
+		path, err = resolver.IndexPath("groups.0.specs.replicas")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(path).To(gomega.Equal("groups.0.specs.replicas"))
+
+		_, err = resolver.IndexPath("groups.g1.services.service3.specs.replicas")
+		gomega.Expect(err).NotTo(gomega.Succeed())
+
+		descriptor.Parameters[1].Path = "groups.g1.services.service1.environment_variables.env1"
+		gomega.Expect(ValidateDescriptorParameters(descriptor)).To(gomega.Succeed())
+		gomega.Expect(descriptor.Parameters[1].Path).To(gomega.Equal("groups.0.services.0.environment_variables.env1"))
+	})
+})
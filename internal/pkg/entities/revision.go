@@ -0,0 +1,131 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"reflect"
+	"sort"
+)
+
+// flatten converts a decoded JSON document into a map of paths with the leaf values.
+func flatten(prefix string, value interface{}, result map[string]interface{}) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, child := range typed {
+			path := key
+			if prefix != "" {
+				path = fmt.Sprintf("%s.%s", prefix, key)
+			}
+			flatten(path, child, result)
+		}
+	case []interface{}:
+		for index, child := range typed {
+			flatten(fmt.Sprintf("%s.%d", prefix, index), child, result)
+		}
+	default:
+		result[prefix] = value
+	}
+}
+
+// flattenDescriptor returns the fields of a descriptor indexed by their path. The paths use the same notation as
+// the descriptor parameters.
+func flattenDescriptor(descriptor *grpc_application_go.AppDescriptor) (map[string]interface{}, derrors.Error) {
+	raw, err := json.Marshal(descriptor)
+	if err != nil {
+		return nil, conversions.ToDerror(err)
+	}
+	var decoded interface{}
+	err = json.Unmarshal(raw, &decoded)
+	if err != nil {
+		return nil, conversions.ToDerror(err)
+	}
+	result := make(map[string]interface{}, 0)
+	flatten("", decoded, result)
+	return result, nil
+}
+
+// encodeValue returns the JSON representation of a field value, or an empty string if the field does not exist.
+func encodeValue(value interface{}, exists bool) (string, derrors.Error) {
+	if !exists {
+		return "", nil
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return "", conversions.ToDerror(err)
+	}
+	return string(raw), nil
+}
+
+// DiffRevisions returns the fields that changed between two revisions of a descriptor sorted by path. The values are
+// JSON encoded; OldValue is empty for added fields and NewValue is empty for removed fields.
+func DiffRevisions(from *grpc_application_go.AppDescriptorRevision, to *grpc_application_go.AppDescriptorRevision) (*grpc_application_manager_go.AppDescriptorDiff, derrors.Error) {
+	fromFields, err := flattenDescriptor(from.Descriptor)
+	if err != nil {
+		return nil, err
+	}
+	toFields, err := flattenDescriptor(to.Descriptor)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0)
+	for path, oldValue := range fromFields {
+		newValue, exists := toFields[path]
+		if !exists || !reflect.DeepEqual(oldValue, newValue) {
+			paths = append(paths, path)
+		}
+	}
+	for path := range toFields {
+		if _, exists := fromFields[path]; !exists {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	changes := make([]*grpc_application_manager_go.DescriptorFieldChange, 0, len(paths))
+	for _, path := range paths {
+		oldValue, oldExists := fromFields[path]
+		newValue, newExists := toFields[path]
+		encodedOld, err := encodeValue(oldValue, oldExists)
+		if err != nil {
+			return nil, err
+		}
+		encodedNew, err := encodeValue(newValue, newExists)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, &grpc_application_manager_go.DescriptorFieldChange{
+			Path:     path,
+			OldValue: encodedOld,
+			NewValue: encodedNew,
+		})
+	}
+
+	return &grpc_application_manager_go.AppDescriptorDiff{
+		OrganizationId:  from.OrganizationId,
+		AppDescriptorId: from.AppDescriptorId,
+		From:            from.Revision,
+		To:              to.Revision,
+		Changes:         changes,
+	}, nil
+}
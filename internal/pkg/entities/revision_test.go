@@ -0,0 +1,63 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package entities
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/nalej/application-manager/internal/pkg/utils"
+	"github.com/nalej/grpc-application-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Descriptor revisions", func() {
+
+	var from *grpc_application_go.AppDescriptorRevision
+	var to *grpc_application_go.AppDescriptorRevision
+
+	ginkgo.BeforeEach(func() {
+		descriptor := utils.CreateTestDescriptor()
+		from = &grpc_application_go.AppDescriptorRevision{OrganizationId: descriptor.OrganizationId, AppDescriptorId: descriptor.AppDescriptorId, Revision: 1, Descriptor: descriptor}
+		to = &grpc_application_go.AppDescriptorRevision{OrganizationId: descriptor.OrganizationId, AppDescriptorId: descriptor.AppDescriptorId, Revision: 2,
+			Descriptor: proto.Clone(descriptor).(*grpc_application_go.AppDescriptor)}
+	})
+
+	ginkgo.It("should return no changes for equal revisions", func() {
+		diff, err := DiffRevisions(from, to)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(diff.From).Should(gomega.Equal(int64(1)))
+		gomega.Expect(diff.To).Should(gomega.Equal(int64(2)))
+		gomega.Expect(diff.Changes).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should report modified, added and removed fields sorted by path", func() {
+		to.Descriptor.Name = "modified"
+		to.Descriptor.Labels = map[string]string{"new": "label"}
+		from.Descriptor.ConfigurationOptions = map[string]string{"old": "option"}
+		to.Descriptor.ConfigurationOptions = nil
+
+		diff, err := DiffRevisions(from, to)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(len(diff.Changes)).Should(gomega.Equal(3))
+		gomega.Expect(diff.Changes[0].Path).Should(gomega.Equal("configuration_options.old"))
+		gomega.Expect(diff.Changes[0].OldValue).Should(gomega.Equal("\"option\""))
+		gomega.Expect(diff.Changes[0].NewValue).Should(gomega.BeEmpty())
+		gomega.Expect(diff.Changes[1].Path).Should(gomega.Equal("labels.new"))
+		gomega.Expect(diff.Changes[1].OldValue).Should(gomega.BeEmpty())
+		gomega.Expect(diff.Changes[2].Path).Should(gomega.Equal("name"))
+		gomega.Expect(diff.Changes[2].NewValue).Should(gomega.Equal("\"modified\""))
+	})
+})
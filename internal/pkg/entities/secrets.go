@@ -0,0 +1,107 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// The values of the PASSWORD parameters are kept in a secret provider and the instance parameters only hold a
+// reference to them, e.g. secret://<id>. The parametrized descriptor of the instance is persisted with the references
+// and only the one sent to the conductor holds the values. The parameters are masked in the responses and the log
+// lines.
+
+package entities
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-application-manager-go"
+	"strings"
+)
+
+// SecretReferencePrefix with the prefix of the values that reference a secret.
+const SecretReferencePrefix = "secret://"
+
+// MaskedValue replaces the secret values in the responses and the logs.
+const MaskedValue = "********"
+
+// IsSecretReference checks if a value is a reference to a secret.
+func IsSecretReference(value string) bool {
+	return strings.HasPrefix(value, SecretReferencePrefix)
+}
+
+// NewSecretReference returns the reference of a secret identifier.
+func NewSecretReference(secretID string) string {
+	return SecretReferencePrefix + secretID
+}
+
+// SecretID returns the secret identifier of a reference.
+func SecretID(reference string) string {
+	return strings.TrimPrefix(reference, SecretReferencePrefix)
+}
+
+// PasswordParameters returns the names of the PASSWORD parameters of a descriptor.
+func PasswordParameters(params []*grpc_application_go.AppParameter) map[string]bool {
+	passwords := make(map[string]bool, 0)
+	for _, param := range params {
+		if param.Type == grpc_application_go.ParamDataType_PASSWORD {
+			passwords[param.Name] = true
+		}
+	}
+	return passwords
+}
+
+// ValidPasswordParameters checks that the PASSWORD parameters of a deployment are not references to stored secrets.
+func ValidPasswordParameters(passwords map[string]bool, params *grpc_application_go.InstanceParameterList) derrors.Error {
+	if params == nil {
+		return nil
+	}
+	for _, param := range params.Parameters {
+		if passwords[param.ParameterName] && IsSecretReference(param.Value) {
+			return derrors.NewInvalidArgumentError("password parameters cannot reference a stored secret").
+				WithParams(param.ParameterName)
+		}
+	}
+	return nil
+}
+
+// MaskParameters returns a copy of a list of parameters where the values of the PASSWORD parameters and the secret
+// references are masked.
+func MaskParameters(params *grpc_application_go.InstanceParameterList, passwords map[string]bool) *grpc_application_go.InstanceParameterList {
+	if params == nil {
+		return nil
+	}
+	masked := make([]*grpc_application_go.InstanceParameter, 0, len(params.Parameters))
+	for _, param := range params.Parameters {
+		value := param.Value
+		if passwords[param.ParameterName] || IsSecretReference(value) {
+			value = MaskedValue
+		}
+		masked = append(masked, &grpc_application_go.InstanceParameter{ParameterName: param.ParameterName, Value: value})
+	}
+	return &grpc_application_go.InstanceParameterList{Parameters: masked}
+}
+
+// RedactDeployRequest returns a copy of a deployment request that can be logged.
+func RedactDeployRequest(request *grpc_application_manager_go.DeployRequest, passwords map[string]bool) *grpc_application_manager_go.DeployRequest {
+	redacted := proto.Clone(request).(*grpc_application_manager_go.DeployRequest)
+	redacted.Parameters = MaskParameters(request.Parameters, passwords)
+	return redacted
+}
+
+// RedactUpgradeRequest returns a copy of an upgrade request that can be logged.
+func RedactUpgradeRequest(request *grpc_application_manager_go.UpgradeAppInstanceRequest, passwords map[string]bool) *grpc_application_manager_go.UpgradeAppInstanceRequest {
+	redacted := proto.Clone(request).(*grpc_application_manager_go.UpgradeAppInstanceRequest)
+	redacted.Parameters = MaskParameters(request.Parameters, passwords)
+	return redacted
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"github.com/nalej/application-manager/internal/pkg/utils"
+	"github.com/nalej/grpc-application-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Password parameters", func() {
+
+	passwords := map[string]bool{"db_password": true}
+	params := &grpc_application_go.InstanceParameterList{Parameters: []*grpc_application_go.InstanceParameter{
+		{ParameterName: "db_password", Value: "s3cr3t"},
+		{ParameterName: "replicas", Value: "2"},
+		{ParameterName: "other", Value: NewSecretReference("id")},
+	}}
+
+	ginkgo.It("should mask the passwords and the secret references", func() {
+		masked := MaskParameters(params, passwords)
+		gomega.Expect(masked.Parameters[0].Value).To(gomega.Equal(MaskedValue))
+		gomega.Expect(masked.Parameters[1].Value).To(gomega.Equal("2"))
+		gomega.Expect(masked.Parameters[2].Value).To(gomega.Equal(MaskedValue))
+		gomega.Expect(params.Parameters[0].Value).To(gomega.Equal("s3cr3t"))
+	})
+
+	ginkgo.It("should reject references supplied as passwords", func() {
+		gomega.Expect(ValidPasswordParameters(passwords, params)).To(gomega.Succeed())
+		gomega.Expect(ValidPasswordParameters(map[string]bool{"other": true}, params)).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should create the referenced descriptor without the secrets", func() {
+		descriptor := utils.CreateTestDescriptorWithParameters()
+		descriptor.Parameters[1].Type = grpc_application_go.ParamDataType_PASSWORD
+		descriptor.Parameters[1].Constraints = &grpc_application_go.ParamConstraints{MinLength: 8}
+		descriptor.Groups[0].Services[0].EnvironmentVariables["url"] = "postgres://user:${env1}@db"
+
+		_, err := CreateParametrizedDescriptor(descriptor, &grpc_application_go.InstanceParameterList{
+			Parameters: []*grpc_application_go.InstanceParameter{{ParameterName: "env1", Value: "short"}}}, nil)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+
+		resolved, err := CreateParametrizedDescriptor(descriptor, &grpc_application_go.InstanceParameterList{
+			Parameters: []*grpc_application_go.InstanceParameter{{ParameterName: "env1", Value: "s3cr3t-value"}}}, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(resolved.Groups[0].Services[0].EnvironmentVariables["url"]).To(gomega.Equal("postgres://user:s3cr3t-value@db"))
+
+		reference := NewSecretReference("id")
+		referenced, _, err := CreateReferencedDescriptor(descriptor, &grpc_application_go.InstanceParameterList{
+			Parameters: []*grpc_application_go.InstanceParameter{{ParameterName: "env1", Value: reference}}}, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(referenced.Groups[0].Services[0].EnvironmentVariables["url"]).To(gomega.Equal("postgres://user:" + reference + "@db"))
+		gomega.Expect(referenced.Groups[0].Services[0].EnvironmentVariables["env1"]).To(gomega.Equal(reference))
+	})
+})
@@ -45,6 +45,15 @@ const emptyServiceGroupId = "service_group_id cannot be empty"
 const emptyServiceGroupInstanceId = "service_group_instance_id cannot be empty"
 const emptyServiceId = "service_id cannot be empty"
 const impossibleDuration = "to cannot be greater than from"
+const invalidRevision = "revision must be greater than zero"
+const invalidPageToken = "invalid page token"
+const invalidPageSize = "page_size must be between 0 and %d"
+
+// RequiredParamNotFilled is returned when a required parameter is not included in a deploy request.
+const RequiredParamNotFilled = "Required parameter not filled"
+
+// RequiredOutboundNotFilled is returned when a required outbound is not connected in a deploy request.
+const RequiredOutboundNotFilled = "Required outbound not filled"
 
 const NalejEnvironmentVariablePrefix = "NALEJ_SERV_"
 const EnvironmentVariableRegex = "[._a-zA-Z][._a-zA-Z0-9]*"
@@ -69,44 +78,61 @@ func ValidOrganizationId(organizationID *grpc_organization_go.OrganizationId) de
 }
 
 // TODO: include the endpoint options validation
+// ValidAddAppDescriptorRequest checks a descriptor returning an error with all the violations found.
 func ValidAddAppDescriptorRequest(toAdd *grpc_application_go.AddAppDescriptorRequest) derrors.Error {
+	violations := NewViolations()
+	collectAddAppDescriptorViolations(toAdd, violations)
+	return violations.AsError()
+}
+
+// CollectDescriptorViolations returns all the violations found in a descriptor, including its parameters.
+func CollectDescriptorViolations(toAdd *grpc_application_go.AddAppDescriptorRequest) *Violations {
+	violations := NewViolations()
+	collectAddAppDescriptorViolations(toAdd, violations)
+	collectDescriptorParametersViolations(toAdd, violations)
+	return violations
+}
+
+func collectAddAppDescriptorViolations(toAdd *grpc_application_go.AddAppDescriptorRequest, violations *Violations) {
+	collectAddAppDescriptorFieldsViolations(toAdd, violations)
+	collectNamesViolations(toAdd, violations)
+	collectStoragePathViolations(toAdd, violations)
+	// logic validation
+	collectDescriptorLogicViolations(toAdd, violations)
+}
+
+// ValidAddAppDescriptorRequestFields checks the required fields of a descriptor.
+func ValidAddAppDescriptorRequestFields(toAdd *grpc_application_go.AddAppDescriptorRequest) derrors.Error {
+	violations := NewViolations()
+	collectAddAppDescriptorFieldsViolations(toAdd, violations)
+	return violations.AsError()
+}
+
+func collectAddAppDescriptorFieldsViolations(toAdd *grpc_application_go.AddAppDescriptorRequest, violations *Violations) {
 	if toAdd.OrganizationId == "" {
-		return derrors.NewInvalidArgumentError(emptyOrganizationId)
+		violations.Add("organization_id", RequiredFieldViolation, emptyOrganizationId)
 	}
 
 	if toAdd.RequestId == "" {
-		return derrors.NewInvalidArgumentError(emptyRequestId)
+		violations.Add("request_id", RequiredFieldViolation, emptyRequestId)
 	}
 
 	if toAdd.Name == "" {
-		return derrors.NewInvalidArgumentError(emptyName)
+		violations.Add("name", RequiredFieldViolation, emptyName)
 	}
 
 	// At least one service group
 	if len(toAdd.Groups) == 0 {
-		return derrors.NewInvalidArgumentError("expecting at least one service group")
+		violations.Add("groups", RequiredFieldViolation, "expecting at least one service group")
 	}
 
 	// Every service group must have at least one service
-	for _, g := range toAdd.Groups {
+	for i, g := range toAdd.Groups {
 		if len(g.Services) == 0 {
-			return derrors.NewInvalidArgumentError("service group must have at least one service").WithParams(g.Name)
+			violations.Add(fmt.Sprintf("groups[%d].services", i), RequiredFieldViolation,
+				"service group must have at least one service", g.Name)
 		}
 	}
-	err := ValidateAppRequestForNames(toAdd)
-
-	if err != nil {
-		return err
-	}
-
-	err = ValidateStoragePathAppRequest(toAdd)
-	if err != nil {
-		return err
-	}
-
-	// logic validation
-	err = ValidDescriptorLogic(toAdd)
-	return err
 }
 
 // getPath returns a path equivalent to 'path' but without indirections
@@ -152,81 +178,80 @@ func GetPath(path string) string {
 
 // ValidateStoragePathAppRequest validate if the same storage path is added more than once
 func ValidateStoragePathAppRequest(toAdd *grpc_application_go.AddAppDescriptorRequest) derrors.Error {
+	violations := NewViolations()
+	collectStoragePathViolations(toAdd, violations)
+	return violations.AsError()
+}
 
-	for _, group := range toAdd.Groups {
-		for _, service := range group.Services {
+func collectStoragePathViolations(toAdd *grpc_application_go.AddAppDescriptorRequest, violations *Violations) {
+	for i, group := range toAdd.Groups {
+		for j, service := range group.Services {
 			// map to store the storage paths
 			pathMap := make(map[string]bool, 0)
-			for _, sto := range service.Storage {
+			for k, sto := range service.Storage {
 
 				path := GetPath(sto.MountPath)
 				// check if the mountPath is used before
 				_, exists := pathMap[path]
 				if exists {
-					return derrors.NewInvalidArgumentError("mounthPath defined twice").WithParams(sto.MountPath)
+					violations.Add(fmt.Sprintf("groups[%d].services[%d].storage[%d].mount_path", i, j, k),
+						DuplicatedMountPathViolation, "mounthPath defined twice", sto.MountPath)
 				}
 				pathMap[path] = true
 
 			}
 		}
 	}
-
-	return nil
 }
 
 // ValidateDescriptor checks validity of object names, ports name, port numbers  meeting Kubernetes specs.
 func ValidateAppRequestForNames(toAdd *grpc_application_go.AddAppDescriptorRequest) derrors.Error {
-	var errs []string
+	violations := NewViolations()
+	collectNamesViolations(toAdd, violations)
+	return violations.AsError()
+}
+
+func collectNamesViolations(toAdd *grpc_application_go.AddAppDescriptorRequest, violations *Violations) {
 	// for each group
-	for _, group := range toAdd.Groups {
-		for _, service := range group.Services {
+	for i, group := range toAdd.Groups {
+		for j, service := range group.Services {
+			servicePath := fmt.Sprintf("groups[%d].services[%d]", i, j)
 
 			// Validate service name
 			kerr := validation.IsDNS1123Label(service.Name)
 			if len(kerr) > 0 {
-				errs = append(errs, "serviceName", service.Name)
-				errs = append(errs, kerr...)
+				violations.Add(servicePath+".name", InvalidNameViolation, strings.Join(kerr, ", "), service.Name)
 			}
 			// validate Exposed Port Name and Number
-			for _, port := range service.ExposedPorts {
+			for k, port := range service.ExposedPorts {
+				portPath := fmt.Sprintf("%s.exposed_ports[%d]", servicePath, k)
 				kerr = validation.IsValidPortName(port.Name)
 				if len(kerr) > 0 {
-					errs = append(errs, "PortName", port.Name)
-					errs = append(errs, kerr...)
+					violations.Add(portPath+".name", InvalidNameViolation, strings.Join(kerr, ", "), port.Name)
 				}
 				kerr = validation.IsValidPortNum(int(port.ExposedPort))
 				if len(kerr) > 0 {
-					errs = append(errs, "ExposedPort")
-					errs = append(errs, kerr...)
+					violations.Add(portPath+".exposed_port", InvalidPortViolation, strings.Join(kerr, ", "))
 				}
 
 				found, _ := NalejUsedPorts[port.ExposedPort]
 				if found {
-					errs = append(errs, "ExposedPort")
-					errs = append(errs, "this is a reserved port")
+					violations.Add(portPath+".exposed_port", ReservedPortViolation, "this is a reserved port", port.ExposedPort)
 				}
 
 				kerr = validation.IsValidPortNum(int(port.InternalPort))
 				if len(kerr) > 0 {
-					errs = append(errs, "InternalPort")
-					errs = append(errs, kerr...)
+					violations.Add(portPath+".internal_port", InvalidPortViolation, strings.Join(kerr, ", "))
 				}
 
 				found, _ = NalejUsedPorts[port.InternalPort]
 				if found {
-					log.Error().Msg("internal port error")
-					errs = append(errs, "InternalPort")
-					errs = append(errs, "this is a reserved port")
+					violations.Add(portPath+".internal_port", ReservedPortViolation, "this is a reserved port", port.InternalPort)
 				}
 
 			}
 		}
 	}
-	if len(errs) > 0 {
-		err := derrors.NewFailedPreconditionError(fmt.Sprintf("%s: %v", "App descriptor validation failed", errs))
-		return err
-	}
-	return nil
 }
 
 func ValidAppDescriptorID(descriptorID *grpc_application_go.AppDescriptorId) derrors.Error {
@@ -250,6 +275,32 @@ func ValidUpdateAppDescriptorRequest(request *grpc_application_go.UpdateAppDescr
 	return nil
 }
 
+func ValidDescriptorRevisionId(revisionID *grpc_application_go.AppDescriptorRevisionId) derrors.Error {
+	if revisionID.OrganizationId == "" {
+		return derrors.NewInvalidArgumentError(emptyOrganizationId)
+	}
+	if revisionID.AppDescriptorId == "" {
+		return derrors.NewInvalidArgumentError(emptyDescriptorId)
+	}
+	if revisionID.Revision <= 0 {
+		return derrors.NewInvalidArgumentError(invalidRevision)
+	}
+	return nil
+}
+
+func ValidDiffRevisionsRequest(request *grpc_application_manager_go.DiffAppDescriptorRevisionsRequest) derrors.Error {
+	if request.OrganizationId == "" {
+		return derrors.NewInvalidArgumentError(emptyOrganizationId)
+	}
+	if request.AppDescriptorId == "" {
+		return derrors.NewInvalidArgumentError(emptyDescriptorId)
+	}
+	if request.From <= 0 || request.To <= 0 {
+		return derrors.NewInvalidArgumentError(invalidRevision)
+	}
+	return nil
+}
+
 func ValidAppInstanceID(instanceID *grpc_application_go.AppInstanceId) derrors.Error {
 	if instanceID.OrganizationId == "" {
 		return derrors.NewInvalidArgumentError(emptyOrganizationId)
@@ -270,6 +321,10 @@ func ValidDeployRequest(deployRequest *grpc_application_manager_go.DeployRequest
 		return derrors.NewInvalidArgumentError(emptyDescriptorId)
 	}
 
+	if deployRequest.DescriptorRevision < 0 {
+		return derrors.NewInvalidArgumentError(invalidRevision)
+	}
+
 	nameErr := ValidDeployRequestName(deployRequest)
 	if nameErr != nil{
 		return nameErr
@@ -292,6 +347,108 @@ func ValidDeployRequestName(deployRequest * grpc_application_manager_go.DeployRe
 	return nil
 }
 
+func ValidUpgradeAppInstanceRequest(request *grpc_application_manager_go.UpgradeAppInstanceRequest) derrors.Error {
+	if request.OrganizationId == "" {
+		return derrors.NewInvalidArgumentError(emptyOrganizationId)
+	}
+	if request.AppInstanceId == "" {
+		return derrors.NewInvalidArgumentError(emptyInstanceId)
+	}
+	if request.DescriptorRevision < 0 {
+		return derrors.NewInvalidArgumentError(invalidRevision)
+	}
+	return nil
+}
+
+func validListFilter(filter *grpc_application_manager_go.ListFilter, pageSize int32) derrors.Error {
+	if pageSize < 0 || pageSize > MaxPageSize {
+		return derrors.NewInvalidArgumentError(fmt.Sprintf(invalidPageSize, MaxPageSize))
+	}
+	if filter.GetCreatedBefore() != 0 && filter.GetCreatedBefore() < filter.GetCreatedAfter() {
+		return derrors.NewInvalidArgumentError("created_before cannot be lower than created_after")
+	}
+	return nil
+}
+
+func ValidListAppDescriptorsRequest(request *grpc_application_manager_go.ListAppDescriptorsRequest) derrors.Error {
+	if request.OrganizationId == "" {
+		return derrors.NewInvalidArgumentError(emptyOrganizationId)
+	}
+	return validListFilter(request.Filter, request.PageSize)
+}
+
+func ValidListAppInstancesRequest(request *grpc_application_manager_go.ListAppInstancesRequest) derrors.Error {
+	if request.OrganizationId == "" {
+		return derrors.NewInvalidArgumentError(emptyOrganizationId)
+	}
+	if request.Status != "" {
+		if _, exists := grpc_application_go.ApplicationStatus_value[request.Status]; !exists {
+			return derrors.NewInvalidArgumentError("invalid status").WithParams(request.Status)
+		}
+	}
+	return validListFilter(request.Filter, request.PageSize)
+}
+
+func ValidImportBundleRequest(request *grpc_application_manager_go.ImportBundleRequest) derrors.Error {
+	if request.OrganizationId == "" {
+		return derrors.NewInvalidArgumentError(emptyOrganizationId)
+	}
+	if len(request.Content) == 0 {
+		return derrors.NewInvalidArgumentError("content cannot be empty")
+	}
+	return nil
+}
+
+func ValidExportBundleRequest(request *grpc_application_manager_go.ExportBundleRequest) derrors.Error {
+	if request.OrganizationId == "" {
+		return derrors.NewInvalidArgumentError(emptyOrganizationId)
+	}
+	if request.Format != "" && request.Format != "yaml" && request.Format != "json" {
+		return derrors.NewInvalidArgumentError("format must be yaml or json").WithParams(request.Format)
+	}
+	return nil
+}
+
+// ValidRequiredParameters checks that all the parameters defined as required are filled in a deploy request.
+func ValidRequiredParameters(desc *grpc_application_go.AppDescriptor, params *grpc_application_go.InstanceParameterList) derrors.Error {
+	for _, p := range desc.Parameters {
+		if p.Required {
+			found := false
+			if params != nil {
+				for _, deployParam := range params.Parameters {
+					if deployParam.ParameterName == p.Name {
+						found = true
+						break
+					}
+				}
+			}
+			if !found {
+				return derrors.NewFailedPreconditionError(RequiredParamNotFilled).WithParams(p.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidRequiredOutbounds checks that all the outbounds defined as required are connected in a deploy request.
+func ValidRequiredOutbounds(connections []*grpc_application_manager_go.ConnectionRequest, outbounds []*grpc_application_go.OutboundNetworkInterface) derrors.Error {
+	for _, outbound := range outbounds {
+		if outbound.Required {
+			found := false
+			for _, connection := range connections {
+				if connection.SourceOutboundName == outbound.Name {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return derrors.NewFailedPreconditionError(RequiredOutboundNotFilled).WithParams(outbound.Name)
+			}
+		}
+	}
+	return nil
+}
+
 func ValidUndeployRequest(request *grpc_application_manager_go.UndeployRequest) derrors.Error {
 	if request.OrganizationId == "" {
 		return derrors.NewInvalidArgumentError(emptyOrganizationId)
@@ -329,19 +486,28 @@ func ValidRetrieveEndpointsRequest(request *grpc_application_manager_go.Retrieve
 	return nil
 }
 
-func ValidAppDescriptorEnvironmentVariables(appDescriptor *grpc_application_go.AddAppDescriptorRequest, appServices map[string]*grpc_application_go.Service) derrors.Error {
+// ValidAppDescriptorEnvironmentVariables checks the names of the environment variables and the services they refer to.
+func ValidAppDescriptorEnvironmentVariables(appDescriptor *grpc_application_go.AddAppDescriptorRequest) derrors.Error {
+	violations := NewViolations()
+	collectEnvironmentVariablesViolations(appDescriptor, NewServiceResolver(appDescriptor.Groups), violations)
+	return violations.AsError()
+}
 
-	// - Environment variables must be checked with existing service names
-	// TODO: the enviroment variables only looks the service name (servicegroup should be included)
+func collectEnvironmentVariablesViolations(appDescriptor *grpc_application_go.AddAppDescriptorRequest, resolver *ServiceResolver, violations *Violations) {
+
+	// - Environment variables must be checked with existing service names: NALEJ_SERV_<GROUP>.<SERVICE> or
+	// NALEJ_SERV_<SERVICE> if the service name is unique in the descriptor
 	re := regexp.MustCompile(EnvironmentVariableRegex)
 	for key, value := range appDescriptor.EnvironmentVariables {
+		path := fmt.Sprintf("environment_variables.%s", key)
 
 		// a valid environment variable name must consist of alphabetic characters, digits, '_', '', or '.', and must not start with a digit
 		// regex used for validation is '[._a-zA-Z][._a-zA-Z0-9]*'
 
 		match := re.FindString(key)
 		if match != key {
-			return derrors.NewFailedPreconditionError("A valid environment variable name must consist of alphabetic characters, digits, '_', '', or '.', and must not start with a digit").WithParams(key)
+			violations.Add(path, InvalidEnvironmentViolation, "A valid environment variable name must consist of alphabetic characters, digits, '_', '', or '.', and must not start with a digit", key)
+			continue
 		}
 
 		serviceValue := strings.Trim(value, " ")
@@ -351,78 +517,108 @@ func ValidAppDescriptorEnvironmentVariables(appDescriptor *grpc_application_go.A
 			if pos == -1 {
 				pos = len(serviceValue)
 			}
-			nalejService := value[len(NalejEnvironmentVariablePrefix):pos]
+			nalejService := serviceValue[len(NalejEnvironmentVariablePrefix):pos]
 
 			// find the service
-			_, exists := appServices[strings.ToLower(nalejService)]
-			if !exists {
-				return derrors.NewFailedPreconditionError("Environment variable error, service does not exist").WithParams(value)
+			reference := strings.ToLower(nalejService)
+			if resolver.IsAmbiguous(reference) {
+				violations.Add(path, AmbiguousReferenceViolation, "Environment variable error, service defined in several groups", value)
+			} else if _, err := resolver.Resolve(reference, ""); err != nil {
+				violations.Add(path, UnknownReferenceViolation, "Environment variable error, service does not exist", value)
 			}
 		}
 	}
-	return nil
 }
 
-func ValidAppDescriptorGroupSpecs(appDescriptor *grpc_application_go.AddAppDescriptorRequest, appServices map[string]*grpc_application_go.Service) derrors.Error {
+// ValidAppDescriptorGroupSpecs checks the deploy after dependencies and the deployment specs of the service groups.
+func ValidAppDescriptorGroupSpecs(appDescriptor *grpc_application_go.AddAppDescriptorRequest) derrors.Error {
+	violations := NewViolations()
+	collectGroupSpecsViolations(appDescriptor, NewServiceResolver(appDescriptor.Groups), violations)
+	return violations.AsError()
+}
 
-	// TODO: the DeployAfter only looks the service name (servicegroup should be included)
-	// - Deploy after should point to existing services
-	for _, group := range appDescriptor.Groups {
-		for _, service := range group.Services {
-			for _, after := range service.DeployAfter {
-				_, exists := appServices[after]
-				if !exists {
-					return derrors.NewFailedPreconditionError("Service indicated in deploy after field does not exist").WithParams(after)
+func collectGroupSpecsViolations(appDescriptor *grpc_application_go.AddAppDescriptorRequest, resolver *ServiceResolver, violations *Violations) {
+
+	for i, group := range appDescriptor.Groups {
+		// - Deploy after should point to existing services of the same group
+		graph := NewDependencyGraph(group)
+		for j, service := range group.Services {
+			for k, after := range service.DeployAfter {
+				_, err := resolver.Resolve(after, group.Name)
+				if err != nil {
+					violations.Add(fmt.Sprintf("groups[%d].services[%d].deploy_after[%d]", i, j, k), UnknownReferenceViolation,
+						"Service indicated in deploy after field does not exist in the service group", group.Name, after)
 				}
 			}
 		}
+		// - Deploy after dependencies cannot define a cycle
+		if cycle := graph.FindCycle(); cycle != nil {
+			violations.Add(fmt.Sprintf("groups[%d].services[%d].deploy_after", i, serviceIndex(group, cycle[0])), DependencyCycleViolation,
+				"deploy after dependency cycle found", group.Name, strings.Join(cycle, " -> "))
+		}
 		// - Multireplicate set cannot be set with number of replicas
 		if group.Specs != nil {
 			if group.Specs.MultiClusterReplica && group.Specs.Replicas > 0 {
-				return derrors.NewFailedPreconditionError("Multireplicate set cannot be set with number of replicas").WithParams(group.Name)
+				violations.Add(fmt.Sprintf("groups[%d].specs", i), InvalidSpecsViolation,
+					"Multireplicate set cannot be set with number of replicas", group.Name)
 			}
 		}
 	}
-	return nil
 }
 
-func ValidAppDescriptorRules(appDescriptor *grpc_application_go.AddAppDescriptorRequest, appServices map[string]*grpc_application_go.Service, appGroups map[string]*grpc_application_go.ServiceGroup) derrors.Error {
+// ValidAppDescriptorRules checks the security rules refer to existing groups, services and net interfaces.
+func ValidAppDescriptorRules(appDescriptor *grpc_application_go.AddAppDescriptorRequest) derrors.Error {
+	appGroups := make(map[string]*grpc_application_go.ServiceGroup, len(appDescriptor.Groups))
+	for _, group := range appDescriptor.Groups {
+		if _, exists := appGroups[group.Name]; !exists {
+			appGroups[group.Name] = group
+		}
+	}
+	violations := NewViolations()
+	collectRulesViolations(appDescriptor, NewServiceResolver(appDescriptor.Groups), appGroups, violations)
+	return violations.AsError()
+}
+
+func collectRulesViolations(appDescriptor *grpc_application_go.AddAppDescriptorRequest, resolver *ServiceResolver, appGroups map[string]*grpc_application_go.ServiceGroup, violations *Violations) {
 
 	// NP-1962 Descriptor Validation for inbound and outbound connections
 	// check the interface names are unique for each descriptor (in both inbound and outbound)
 	// interfaceNames is a map with all the names of the inbound and outbound names
 	// the value will be true if it is an inbound and false in other case
 	interfaceNames := make(map[string]bool, 0)
-	for _, inbound := range appDescriptor.InboundNetInterfaces {
+	for i, inbound := range appDescriptor.InboundNetInterfaces {
 		_, exists := interfaceNames[inbound.Name]
 		if exists {
-			return derrors.NewFailedPreconditionError("Inbound/outbound name defined twice").WithParams(inbound.Name)
+			violations.Add(fmt.Sprintf("inbound_net_interfaces[%d].name", i), DuplicatedNameViolation, "Inbound/outbound name defined twice", inbound.Name)
 		}
 		interfaceNames[inbound.Name] = true
 	}
-	for _, outbound := range appDescriptor.OutboundNetInterfaces {
+	for i, outbound := range appDescriptor.OutboundNetInterfaces {
 		_, exists := interfaceNames[outbound.Name]
 		if exists {
-			return derrors.NewFailedPreconditionError("Inbound/outbound name defined twice").WithParams(outbound.Name)
+			violations.Add(fmt.Sprintf("outbound_net_interfaces[%d].name", i), DuplicatedNameViolation, "Inbound/outbound name defined twice", outbound.Name)
 		}
 		interfaceNames[outbound.Name] = false
 	}
 
 	// - Rules refer to existing services
-	for _, rule := range appDescriptor.Rules {
+	for i, rule := range appDescriptor.Rules {
+		rulePath := fmt.Sprintf("rules[%d]", i)
 
 		// RuleId is filed by system model
 		if rule.RuleId != "" {
-			return derrors.NewFailedPreconditionError("Rule Id cannot be filled").WithParams(rule.Name)
+			violations.Add(rulePath+".rule_id", SystemFieldViolation, "Rule Id cannot be filled", rule.Name)
 		}
 
 		ruleGroup, exists := appGroups[rule.TargetServiceGroupName]
 		if !exists {
-			return derrors.NewFailedPreconditionError("Target Service Group Name in rule not found in groups definition").WithParams(rule.Name, rule.TargetServiceGroupName)
+			violations.Add(rulePath+".target_service_group_name", UnknownReferenceViolation, "Target Service Group Name in rule not found in groups definition", rule.Name, rule.TargetServiceGroupName)
 		}
-		_, exists = appServices[rule.TargetServiceName]
-		if !exists {
-			return derrors.NewFailedPreconditionError("Target Service Name in rule not found in services definition").WithParams(rule.Name, rule.TargetServiceGroupName, rule.TargetServiceName)
+		if exists {
+			_, err := resolver.Resolve(rule.TargetServiceName, rule.TargetServiceGroupName)
+			if err != nil {
+				violations.Add(rulePath+".target_service_name", UnknownReferenceViolation, "Target Service Name in rule not found in services definition", rule.Name, rule.TargetServiceGroupName, rule.TargetServiceName)
+			}
 		}
 
 		// only rules referring to PortAccess_APP_SERVICES AuthServiceGroupName and AuthServiceName should be specified or expected.
@@ -430,148 +626,151 @@ func ValidAppDescriptorRules(appDescriptor *grpc_application_go.AddAppDescriptor
 
 			_, exists = appGroups[rule.AuthServiceGroupName]
 			if !exists {
-				return derrors.NewFailedPreconditionError("Auth Service Group Name in rule not found in groups definition").WithParams(rule.Name, rule.AuthServiceGroupName)
-			}
-			for _, serviceName := range rule.AuthServices {
-				_, exists = appServices[serviceName]
-				if !exists {
-					return derrors.NewFailedPreconditionError("Auth Service Name in rule not found in services definition").WithParams(rule.Name, rule.AuthServiceGroupName, serviceName)
+				violations.Add(rulePath+".auth_service_group_name", UnknownReferenceViolation, "Auth Service Group Name in rule not found in groups definition", rule.Name, rule.AuthServiceGroupName)
+			} else {
+				for j, serviceName := range rule.AuthServices {
+					_, err := resolver.Resolve(serviceName, rule.AuthServiceGroupName)
+					if err != nil {
+						violations.Add(fmt.Sprintf("%s.auth_services[%d]", rulePath, j), UnknownReferenceViolation, "Auth Service Name in rule not found in services definition", rule.Name, rule.AuthServiceGroupName, serviceName)
+					}
 				}
 			}
 		} else {
 			if rule.AuthServiceGroupName != "" {
-				return derrors.NewFailedPreconditionError("Auth Service Group Name should no be specified for selected access rule").WithParams(rule.Name)
+				violations.Add(rulePath+".auth_service_group_name", InvalidRuleViolation, "Auth Service Group Name should no be specified for selected access rule", rule.Name)
 			}
 			if len(rule.AuthServices) > 0 {
-				return derrors.NewFailedPreconditionError("Auth Services should no be specified for selected access rule").WithParams(rule.Name)
+				violations.Add(rulePath+".auth_services", InvalidRuleViolation, "Auth Services should no be specified for selected access rule", rule.Name)
 			}
 			if rule.Access == grpc_application_go.PortAccess_DEVICE_GROUP {
 				// at least should be defined one device
 				if rule.DeviceGroupNames == nil || len(rule.DeviceGroupNames) <= 0 {
-					return derrors.NewFailedPreconditionError("Device group names in rule not defined").WithParams(rule.Name)
+					violations.Add(rulePath+".device_group_names", RequiredFieldViolation, "Device group names in rule not defined", rule.Name)
 				}
 			}
 		}
 
 		// RuleIds is filled by system-model
 		if rule.DeviceGroupIds != nil && len(rule.DeviceGroupIds) > 0 {
-			return derrors.NewFailedPreconditionError("Device Group Ids cannot be filled").WithParams(rule.Name)
+			violations.Add(rulePath+".device_group_ids", SystemFieldViolation, "Device Group Ids cannot be filled", rule.Name)
 		}
 
 		// NP-1962 Descriptor Validation for inbound and outbound connections
 		// if the rule is related to an inbound interface -> it should be defined in inboundInterfaces
 		if rule.InboundNetInterface != "" {
-			if rule.Access != grpc_application_go.PortAccess_INBOUND_APPNET {
-				return derrors.NewFailedPreconditionError("inbound_net_interface defined but the access is not INBOUND").WithParams(rule.InboundNetInterface, rule.Access)
-			}
+			path := rulePath + ".inbound_net_interface"
 			inbound, exists := interfaceNames[rule.InboundNetInterface]
-			if !exists {
-				return derrors.NewFailedPreconditionError("inbound_net_interface found in security rule is not defined").WithParams(rule.InboundNetInterface)
-			}
-			if inbound == false {
+			if rule.Access != grpc_application_go.PortAccess_INBOUND_APPNET {
+				violations.Add(path, InvalidRuleViolation, "inbound_net_interface defined but the access is not INBOUND", rule.InboundNetInterface, rule.Access)
+			} else if !exists {
+				violations.Add(path, UnknownReferenceViolation, "inbound_net_interface found in security rule is not defined", rule.InboundNetInterface)
+			} else if inbound == false {
 				// the interface named rule.InboundInterface is an outbound
-				return derrors.NewFailedPreconditionError("inbound_net_interface found in security rule is defined as Outbound").WithParams(rule.InboundNetInterface)
-			}
-			if ruleGroup.Specs != nil && (ruleGroup.Specs.Replicas > 1 || ruleGroup.Specs.MultiClusterReplica) {
-				return derrors.NewFailedPreconditionError("Inbound rule linked to a multireplica service group").WithParams(rule.InboundNetInterface)
+				violations.Add(path, InvalidRuleViolation, "inbound_net_interface found in security rule is defined as Outbound", rule.InboundNetInterface)
+			} else if ruleGroup != nil && ruleGroup.Specs != nil && (ruleGroup.Specs.Replicas > 1 || ruleGroup.Specs.MultiClusterReplica) {
+				violations.Add(path, InvalidRuleViolation, "Inbound rule linked to a multireplica service group", rule.InboundNetInterface)
 			}
 		}
 		// if the rule is related to an outbound interface -> it should be defined in outboundInterfaces
 		if rule.OutboundNetInterface != "" {
-			if rule.Access != grpc_application_go.PortAccess_OUTBOUND_APPNET {
-				return derrors.NewFailedPreconditionError("outbound_net_interface defined but the access is not OUTBOUND").WithParams(rule.OutboundNetInterface, rule.Access)
-			}
+			path := rulePath + ".outbound_net_interface"
 			outbound, exists := interfaceNames[rule.OutboundNetInterface]
-			if !exists {
-				return derrors.NewFailedPreconditionError("outbound_net_interface found in security rule is not defined").WithParams(rule.OutboundNetInterface)
-			}
-			if outbound == true {
+			if rule.Access != grpc_application_go.PortAccess_OUTBOUND_APPNET {
+				violations.Add(path, InvalidRuleViolation, "outbound_net_interface defined but the access is not OUTBOUND", rule.OutboundNetInterface, rule.Access)
+			} else if !exists {
+				violations.Add(path, UnknownReferenceViolation, "outbound_net_interface found in security rule is not defined", rule.OutboundNetInterface)
+			} else if outbound == true {
 				// the interface named rule.outboundInterface is an inbound
-				return derrors.NewFailedPreconditionError("outbound_net_interface found in security rule is defined as inbound").WithParams(rule.OutboundNetInterface)
+				violations.Add(path, InvalidRuleViolation, "outbound_net_interface found in security rule is defined as inbound", rule.OutboundNetInterface)
 			}
 		}
 	}
-
-	return nil
 }
 
 // ValidAppDescriptor checks validity related to the descriptor logic
 func ValidDescriptorLogic(appDescriptor *grpc_application_go.AddAppDescriptorRequest) derrors.Error {
+	violations := NewViolations()
+	collectDescriptorLogicViolations(appDescriptor, violations)
+	return violations.AsError()
+}
+
+func collectDescriptorLogicViolations(appDescriptor *grpc_application_go.AddAppDescriptorRequest, violations *Violations) {
 
 	/*
 		   	- Rules refer to existing services
 			- Rules specifying service to service restrictions are not supported yet ???
-			- Service and service group name are uniq
+			- Service group names are uniq and service names are uniq in their group
 			- Environment variables must be checked with existing service names
 			- Deploy after should point to existing services
 			- Multireplicate set cannot be set with number of replicas
 		    - Validate that the inbound and outbound net interfaces are always linked to specific rules
 	*/
 
-	// TODO: the service should be unique per group, not unique in the descriptor
-	appServices := make(map[string]*grpc_application_go.Service)
 	appGroups := make(map[string]*grpc_application_go.ServiceGroup)
 
 	// at least one group should be defined
 	if appDescriptor.Groups == nil || len(appDescriptor.Groups) <= 0 {
-		return derrors.NewFailedPreconditionError("at least one group should be defined")
+		violations.Add("groups", RequiredFieldViolation, "at least one group should be defined")
 	}
 
-	// - Service and service group name are uniq (and they cannot be empty)
-	for _, appGroup := range appDescriptor.Groups {
+	// - Service group names are uniq, service names are uniq in their group (and they cannot be empty)
+	for i, appGroup := range appDescriptor.Groups {
+		groupPath := fmt.Sprintf("groups[%d]", i)
 
 		if appGroup.Name == "" {
-			return derrors.NewFailedPreconditionError("Service Group Name cannot be empty")
+			violations.Add(groupPath+".name", RequiredFieldViolation, "Service Group Name cannot be empty")
 		}
 
 		// ServiceGroupId is filled by system-model
 		if appGroup.ServiceGroupId != "" {
-			return derrors.NewFailedPreconditionError("Service Group Id cannot be filled").WithParams(appGroup.Name)
+			violations.Add(groupPath+".service_group_id", SystemFieldViolation, "Service Group Id cannot be filled", appGroup.Name)
 		}
 
 		_, exists := appGroups[appGroup.Name]
 		if exists {
-			return derrors.NewFailedPreconditionError("Service Group Name defined twice").WithParams(appGroup.Name)
+			violations.Add(groupPath+".name", DuplicatedNameViolation, "Service Group Name defined twice", appGroup.Name)
 		}
 
-		for _, service := range appGroup.Services {
+		// services are unique per group
+		groupServices := make(map[string]bool, len(appGroup.Services))
+		for j, service := range appGroup.Services {
+			servicePath := fmt.Sprintf("%s.services[%d]", groupPath, j)
 			if service.Name == "" {
-				return derrors.NewFailedPreconditionError("Service Name cannot be empty")
+				violations.Add(servicePath+".name", RequiredFieldViolation, "Service Name cannot be empty")
 			}
 			// ServiceId is filled by system-model
 			if service.ServiceId != "" {
-				return derrors.NewFailedPreconditionError("Service Id cannot be filled").WithParams(service.Name)
+				violations.Add(servicePath+".service_id", SystemFieldViolation, "Service Id cannot be filled", service.Name)
 			}
 			if service.ServiceGroupId != "" {
-				return derrors.NewFailedPreconditionError("Service Group Id cannot be filled").WithParams(service.Name)
+				violations.Add(servicePath+".service_group_id", SystemFieldViolation, "Service Group Id cannot be filled", service.Name)
 			}
 
-			_, exists := appServices[service.Name]
-			if exists {
-				return derrors.NewFailedPreconditionError("Service Name defined twice").WithParams(appGroup.Name, service.Name)
+			if groupServices[service.Name] {
+				violations.Add(servicePath+".name", DuplicatedNameViolation, "Service Name defined twice in the group", appGroup.Name, service.Name)
 			}
-			appServices[service.Name] = service
+			groupServices[service.Name] = true
 
 			// ConfigFiledId is filled by system-model
-			if service.Configs != nil && len(service.Configs) > 0 {
-				for _, config := range service.Configs {
-					if config.ConfigFileId != "" {
-						return derrors.NewFailedPreconditionError("Config File Id cannot be filled").WithParams(config.Name)
-					}
+			for k, config := range service.Configs {
+				if config.ConfigFileId != "" {
+					violations.Add(fmt.Sprintf("%s.configs[%d].config_file_id", servicePath, k), SystemFieldViolation, "Config File Id cannot be filled", config.Name)
 				}
 			}
 			// validate the options in the endpoints
 			// the allowed values are: CLIENT_MAX_BODY_SIZE or HOST_HEADER_CONFIGURATION
 			// if and only if Type = WEB
-			for _, port := range service.ExposedPorts {
-				for _, endpoint := range port.Endpoints {
+			for k, port := range service.ExposedPorts {
+				for l, endpoint := range port.Endpoints {
+					endpointPath := fmt.Sprintf("%s.exposed_ports[%d].endpoints[%d]", servicePath, k, l)
 					if endpoint.Options != nil && len(endpoint.Options) > 0 {
 						if endpoint.Type != grpc_application_go.EndpointType_WEB {
-							return derrors.NewFailedPreconditionError("Endpoint options not allowed for this endpoint type").WithParams(endpoint.Type.String())
+							violations.Add(endpointPath+".options", InvalidEndpointOptionViolation, "Endpoint options not allowed for this endpoint type", endpoint.Type.String())
+							continue
 						}
 						for key := range endpoint.Options {
 							if key != grpc_application_go.EndpointOptions_CLIENT_MAX_BODY_SIZE.String() && key != grpc_application_go.EndpointOptions_HOST_HEADER_CONFIGURATION.String() {
-								return derrors.NewFailedPreconditionError("Endpoint option not allowed").WithParams(key)
+								violations.Add(fmt.Sprintf("%s.options.%s", endpointPath, key), InvalidEndpointOptionViolation, "Endpoint option not allowed", key)
 							}
 						}
 					}
@@ -579,75 +778,59 @@ func ValidDescriptorLogic(appDescriptor *grpc_application_go.AddAppDescriptorReq
 			}
 
 		}
-		appGroups[appGroup.Name] = appGroup
+		if !exists {
+			appGroups[appGroup.Name] = appGroup
+		}
 	}
+	resolver := NewServiceResolver(appDescriptor.Groups)
 
 	// - Rules refer to existing services
-	err := ValidAppDescriptorRules(appDescriptor, appServices, appGroups)
-	if err != nil {
-		return err
-	}
+	collectRulesViolations(appDescriptor, resolver, appGroups, violations)
 
 	// ValidGroupSpecs:
-	// - Deploy after should point to existing services
+	// - Deploy after should point to existing services of the group and cannot define cycles
 	// - Multireplicate set cannot be set with number of replicas
-	err = ValidAppDescriptorGroupSpecs(appDescriptor, appServices)
-	if err != nil {
-		return err
-	}
+	collectGroupSpecsViolations(appDescriptor, resolver, violations)
 
 	// - Environment variables must be checked with existing service names
-	err = ValidAppDescriptorEnvironmentVariables(appDescriptor, appServices)
-	if err != nil {
-		return err
-	}
+	collectEnvironmentVariablesViolations(appDescriptor, resolver, violations)
 
 	// - Validate the inbound and outbound net interfaces
-	err = ValidAppDescriptorNetInterfaces(appDescriptor)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	collectNetInterfacesViolations(appDescriptor, violations)
 }
 
 // ValidAppDescriptorNetInterfaces This function validates that:
 // - All inbound and outbound net interfaces are linked to a security rule. This is because rules can not be generated
 // after deployment and a net interface without rule does not make sense.
 func ValidAppDescriptorNetInterfaces(addAppDescriptorRequest *grpc_application_go.AddAppDescriptorRequest) derrors.Error {
-	var missedInterface string
-	found := true
-	for _, inboundNetInterface := range addAppDescriptorRequest.InboundNetInterfaces {
-		found = false
+	violations := NewViolations()
+	collectNetInterfacesViolations(addAppDescriptorRequest, violations)
+	return violations.AsError()
+}
+
+func collectNetInterfacesViolations(addAppDescriptorRequest *grpc_application_go.AddAppDescriptorRequest, violations *Violations) {
+	for i, inboundNetInterface := range addAppDescriptorRequest.InboundNetInterfaces {
+		found := false
 		for _, rule := range addAppDescriptorRequest.Rules {
 			if rule.InboundNetInterface == inboundNetInterface.Name {
 				found = true
 			}
 		}
 		if !found {
-			missedInterface = inboundNetInterface.Name
-			break
+			violations.Add(fmt.Sprintf("inbound_net_interfaces[%d]", i), UnlinkedInterfaceViolation, "The inbound net interface is not linked to a rule", inboundNetInterface.Name)
 		}
 	}
-	if !found {
-		return derrors.NewFailedPreconditionError("The inbound net interface is not linked to a rule").WithParams(missedInterface)
-	}
-	for _, outboundNetInterface := range addAppDescriptorRequest.OutboundNetInterfaces {
-		found = false
+	for i, outboundNetInterface := range addAppDescriptorRequest.OutboundNetInterfaces {
+		found := false
 		for _, rule := range addAppDescriptorRequest.Rules {
 			if rule.OutboundNetInterface == outboundNetInterface.Name {
 				found = true
 			}
 		}
 		if !found {
-			missedInterface = outboundNetInterface.Name
-			break
+			violations.Add(fmt.Sprintf("outbound_net_interfaces[%d]", i), UnlinkedInterfaceViolation, "The outbound net interface is not linked to a rule", outboundNetInterface.Name)
 		}
 	}
-	if !found {
-		return derrors.NewFailedPreconditionError("The outbound net interface is not linked to a rule").WithParams(missedInterface)
-	}
-	return nil
 }
 
 func ValidAddConnectionRequest(addRequest *grpc_application_network_go.AddConnectionRequest) derrors.Error {
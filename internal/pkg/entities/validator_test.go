@@ -17,6 +17,7 @@
 package entities
 
 import (
+	"github.com/nalej/grpc-application-go"
 	"github.com/nalej/grpc-application-manager-go"
 	"github.com/onsi/ginkgo"
 	"github.com/onsi/gomega"
@@ -54,4 +55,24 @@ var _ = ginkgo.Describe("Validation tests", func() {
 			}
 		})
 	})
+	ginkgo.Context("Required parameters and outbounds", func() {
+		ginkgo.It("should fail if a required parameter is not filled", func() {
+			desc := &grpc_application_go.AppDescriptor{Parameters: []*grpc_application_go.AppParameter{
+				{Name: "optional"}, {Name: "required", Required: true}}}
+			err := ValidRequiredParameters(desc, &grpc_application_go.InstanceParameterList{
+				Parameters: []*grpc_application_go.InstanceParameter{{ParameterName: "optional", Value: "1"}}})
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+			err = ValidRequiredParameters(desc, &grpc_application_go.InstanceParameterList{
+				Parameters: []*grpc_application_go.InstanceParameter{{ParameterName: "required", Value: "1"}}})
+			gomega.Expect(err).Should(gomega.Succeed())
+		})
+		ginkgo.It("should fail if a required outbound is not connected", func() {
+			outbounds := []*grpc_application_go.OutboundNetworkInterface{{Name: "out1", Required: true}, {Name: "out2"}}
+			err := ValidRequiredOutbounds(nil, outbounds)
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+			err = ValidRequiredOutbounds([]*grpc_application_manager_go.ConnectionRequest{
+				{SourceOutboundName: "out1", TargetInstanceId: "inst", TargetInboundName: "in"}}, outbounds)
+			gomega.Expect(err).Should(gomega.Succeed())
+		})
+	})
 })
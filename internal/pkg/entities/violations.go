@@ -0,0 +1,160 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// Codes of the descriptor violations.
+const (
+	RequiredFieldViolation         = "REQUIRED_FIELD"
+	SystemFieldViolation           = "SYSTEM_FIELD"
+	DuplicatedNameViolation        = "DUPLICATED_NAME"
+	InvalidNameViolation           = "INVALID_NAME"
+	InvalidPortViolation           = "INVALID_PORT"
+	ReservedPortViolation          = "RESERVED_PORT"
+	DuplicatedMountPathViolation   = "DUPLICATED_MOUNT_PATH"
+	InvalidEndpointOptionViolation = "INVALID_ENDPOINT_OPTION"
+	UnknownReferenceViolation      = "UNKNOWN_REFERENCE"
+	AmbiguousReferenceViolation    = "AMBIGUOUS_REFERENCE"
+	DependencyCycleViolation       = "DEPENDENCY_CYCLE"
+	InvalidRuleViolation           = "INVALID_RULE"
+	InvalidSpecsViolation          = "INVALID_SPECS"
+	InvalidEnvironmentViolation    = "INVALID_ENVIRONMENT_VARIABLE"
+	UnlinkedInterfaceViolation     = "UNLINKED_INTERFACE"
+	InvalidParameterViolation      = "INVALID_PARAMETER"
+	InvalidConstraintViolation     = "INVALID_CONSTRAINT"
+	ConstraintNotMetViolation      = "CONSTRAINT_NOT_MET"
+)
+
+// Violation of a validation rule found in a descriptor.
+type Violation struct {
+	// Path with the location of the violation in the descriptor, e.g. groups[1].services[0].exposed_ports[2]
+	Path string `json:"path"`
+	// Code with the machine readable type of the violation.
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (v *Violation) String() string {
+	if v.Path == "" {
+		return fmt.Sprintf("[%s] %s", v.Code, v.Message)
+	}
+	return fmt.Sprintf("%s: [%s] %s", v.Path, v.Code, v.Message)
+}
+
+// Violations with all the problems found while validating a descriptor.
+type Violations struct {
+	List []*Violation
+}
+
+// NewViolations creates an empty list of violations.
+func NewViolations() *Violations {
+	return &Violations{List: make([]*Violation, 0)}
+}
+
+// Add a new violation. The parameters are appended to the message.
+func (v *Violations) Add(path string, code string, message string, params ...interface{}) {
+	if len(params) > 0 {
+		values := make([]string, 0, len(params))
+		for _, param := range params {
+			values = append(values, fmt.Sprint(param))
+		}
+		message = fmt.Sprintf("%s: %s", message, strings.Join(values, ", "))
+	}
+	v.List = append(v.List, &Violation{Path: path, Code: code, Message: message})
+}
+
+// Empty checks if no violations have been found.
+func (v *Violations) Empty() bool {
+	return len(v.List) == 0
+}
+
+// Malformed checks if the violations make the request malformed, that is, if a required field of the request itself
+// is missing. The rest of the violations are semantic problems of the descriptor.
+func (v *Violations) Malformed() bool {
+	for _, violation := range v.List {
+		if violation.Code == RequiredFieldViolation && !strings.ContainsAny(violation.Path, ".[") {
+			return true
+		}
+	}
+	return false
+}
+
+// AsError returns an error with all the violations, or nil if there are none. Malformed requests are reported as
+// invalid arguments and the rest of the violations as failed preconditions.
+func (v *Violations) AsError() derrors.Error {
+	if v.Empty() {
+		return nil
+	}
+	params := make([]interface{}, 0, len(v.List))
+	for _, violation := range v.List {
+		params = append(params, violation.String())
+	}
+	msg := fmt.Sprintf("descriptor validation failed with %d violations, first: %s", len(v.List), v.List[0].String())
+	if v.Malformed() {
+		return derrors.NewInvalidArgumentError(msg).WithParams(params...)
+	}
+	return derrors.NewFailedPreconditionError(msg).WithParams(params...)
+}
+
+// ToGRPCError returns a gRPC error that includes the violations as error details, or nil if there are none. Malformed
+// requests return InvalidArgument with BadRequest details where the field of each violation contains its path.
+// Otherwise FailedPrecondition is returned with PreconditionFailure details where the type of each violation contains
+// its code and the subject its path.
+func (v *Violations) ToGRPCError() error {
+	if v.Empty() {
+		return nil
+	}
+	var st *status.Status
+	var err error
+	if v.Malformed() {
+		details := &errdetails.BadRequest{
+			FieldViolations: make([]*errdetails.BadRequest_FieldViolation, 0, len(v.List)),
+		}
+		for _, violation := range v.List {
+			details.FieldViolations = append(details.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       violation.Path,
+				Description: fmt.Sprintf("[%s] %s", violation.Code, violation.Message),
+			})
+		}
+		st, err = status.New(codes.InvalidArgument, v.AsError().Error()).WithDetails(details)
+	} else {
+		details := &errdetails.PreconditionFailure{
+			Violations: make([]*errdetails.PreconditionFailure_Violation, 0, len(v.List)),
+		}
+		for _, violation := range v.List {
+			details.Violations = append(details.Violations, &errdetails.PreconditionFailure_Violation{
+				Type:        violation.Code,
+				Subject:     violation.Path,
+				Description: violation.Message,
+			})
+		}
+		st, err = status.New(codes.FailedPrecondition, v.AsError().Error()).WithDetails(details)
+	}
+	if err != nil {
+		return conversions.ToGRPCError(v.AsError())
+	}
+	return st.Err()
+}
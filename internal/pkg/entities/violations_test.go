@@ -0,0 +1,104 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"github.com/nalej/application-manager/internal/pkg/utils"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = ginkgo.Describe("Descriptor violations", func() {
+
+	ginkgo.It("should not report violations on a valid descriptor", func() {
+		violations := CollectDescriptorViolations(utils.CreateTestAddDescriptorWithParameters())
+		gomega.Expect(violations.Empty()).To(gomega.BeTrue())
+		gomega.Expect(violations.AsError()).To(gomega.Succeed())
+		gomega.Expect(violations.ToGRPCError()).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should collect all the violations with their location", func() {
+		descriptor := utils.CreateTestAddDescriptorWithParameters()
+		descriptor.Name = ""
+		descriptor.Groups[1].Services[0].ExposedPorts = []*grpc_application_go.Port{
+			{Name: "http", ExposedPort: 80, InternalPort: 80},
+			{Name: "wrong_name", ExposedPort: 0, InternalPort: 8080},
+		}
+		descriptor.Groups[0].Services[1].DeployAfter = []string{"unknown"}
+		descriptor.Parameters[1].Path = "groups.0.services.0.name"
+
+		violations := CollectDescriptorViolations(descriptor)
+		gomega.Expect(violations.Empty()).To(gomega.BeFalse())
+
+		found := make(map[string]string, 0)
+		for _, v := range violations.List {
+			found[v.Path] = v.Code
+		}
+		gomega.Expect(found).To(gomega.HaveKeyWithValue("name", RequiredFieldViolation))
+		gomega.Expect(found).To(gomega.HaveKeyWithValue("groups[1].services[0].exposed_ports[1].name", InvalidNameViolation))
+		gomega.Expect(found).To(gomega.HaveKeyWithValue("groups[1].services[0].exposed_ports[1].exposed_port", InvalidPortViolation))
+		gomega.Expect(found).To(gomega.HaveKeyWithValue("groups[0].services[1].deploy_after[0]", UnknownReferenceViolation))
+		gomega.Expect(found).To(gomega.HaveKeyWithValue("parameters[1].path", InvalidParameterViolation))
+	})
+
+	ginkgo.It("should return the violations as gRPC error details", func() {
+		violations := NewViolations()
+		violations.Add("groups[0].name", RequiredFieldViolation, "Service Group Name cannot be empty")
+		violations.Add("groups[1].services[0].name", DuplicatedNameViolation, "Service Name defined twice", "g2", "s1")
+
+		st, ok := status.FromError(violations.ToGRPCError())
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(st.Code()).To(gomega.Equal(codes.FailedPrecondition))
+		gomega.Expect(st.Details()).To(gomega.HaveLen(1))
+
+		details, ok := st.Details()[0].(*errdetails.PreconditionFailure)
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(details.Violations).To(gomega.HaveLen(2))
+		gomega.Expect(details.Violations[1].Subject).To(gomega.Equal("groups[1].services[0].name"))
+		gomega.Expect(details.Violations[1].Type).To(gomega.Equal(DuplicatedNameViolation))
+		gomega.Expect(details.Violations[1].Description).To(gomega.Equal("Service Name defined twice: g2, s1"))
+	})
+
+	ginkgo.It("should report malformed requests as invalid arguments", func() {
+		violations := NewViolations()
+		violations.Add("organization_id", RequiredFieldViolation, "organization_id cannot be empty")
+		violations.Add("groups[1].services[0].name", DuplicatedNameViolation, "Service Name defined twice", "g2", "s1")
+		gomega.Expect(violations.Malformed()).To(gomega.BeTrue())
+		gomega.Expect(violations.AsError().Type()).To(gomega.Equal(derrors.InvalidArgument))
+
+		st, ok := status.FromError(violations.ToGRPCError())
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(st.Code()).To(gomega.Equal(codes.InvalidArgument))
+		gomega.Expect(st.Details()).To(gomega.HaveLen(1))
+		details, ok := st.Details()[0].(*errdetails.BadRequest)
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(details.FieldViolations).To(gomega.HaveLen(2))
+		gomega.Expect(details.FieldViolations[0].Field).To(gomega.Equal("organization_id"))
+	})
+
+	ginkgo.It("should report semantic violations as failed preconditions", func() {
+		violations := NewViolations()
+		violations.Add("groups[0].services", RequiredFieldViolation, "expecting at least one service per group")
+		gomega.Expect(violations.Malformed()).To(gomega.BeFalse())
+		gomega.Expect(violations.AsError().Type()).To(gomega.Equal(derrors.FailedPrecondition))
+	})
+})
@@ -0,0 +1,65 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = ginkgo.Describe("Interceptors", func() {
+
+	ginkgo.It("should record the code of the RPCs served", func() {
+		method := "/application_manager.ApplicationManager/Deploy"
+		info := &grpc.UnaryServerInfo{FullMethod: method}
+		interceptor := UnaryServerInterceptor()
+		before := testutil.ToFloat64(ServerHandledTotal.WithLabelValues(method, "NotFound"))
+		_, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Error(codes.NotFound, "not found")
+		})
+		gomega.Expect(status.Code(err)).To(gomega.Equal(codes.NotFound))
+		gomega.Expect(testutil.ToFloat64(ServerHandledTotal.WithLabelValues(method, "NotFound"))).To(gomega.Equal(before + 1))
+	})
+
+	ginkgo.It("should record the code of the streams served", func() {
+		method := "/application_manager.ApplicationManager/WatchAppInstance"
+		info := &grpc.StreamServerInfo{FullMethod: method, IsServerStream: true}
+		interceptor := StreamServerInterceptor()
+		before := testutil.ToFloat64(ServerHandledTotal.WithLabelValues(method, "OK"))
+		err := interceptor(nil, nil, info, func(srv interface{}, stream grpc.ServerStream) error {
+			return nil
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(testutil.ToFloat64(ServerHandledTotal.WithLabelValues(method, "OK"))).To(gomega.Equal(before + 1))
+	})
+
+	ginkgo.It("should record the calls to other components", func() {
+		method := "/application.Applications/GetAppInstance"
+		interceptor := UnaryClientInterceptor("system-model")
+		err := interceptor(context.Background(), method, nil, nil, nil,
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				return status.Error(codes.Unavailable, "unavailable")
+			})
+		gomega.Expect(status.Code(err)).To(gomega.Equal(codes.Unavailable))
+		gomega.Expect(testutil.CollectAndCount(ClientHandlingSeconds)).To(gomega.BeNumerically(">=", 1))
+	})
+})
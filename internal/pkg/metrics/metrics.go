@@ -0,0 +1,189 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// The metrics of the service are kept in their own registry and exposed in the Prometheus format by Handler.
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"time"
+)
+
+// Namespace of the metrics of the service.
+const Namespace = "application_manager"
+
+// MetricsPath with the HTTP path where the metrics are served.
+const MetricsPath = "/metrics"
+
+// Operations measured by OperationsTotal and OperationDuration.
+const (
+	DeployOperation   = "deploy"
+	UndeployOperation = "undeploy"
+)
+
+// Topics of the messages produced.
+const (
+	AppOpsTopic     = "app-ops"
+	NetworkOpsTopic = "network-ops"
+)
+
+// DeploymentServiceUpdateMessage with the name of the messages consumed from the application events.
+const DeploymentServiceUpdateMessage = "DeploymentServiceUpdateRequest"
+
+// InstancesCache with the name of the cache of the instance summaries.
+const InstancesCache = "instances"
+
+// Outcomes of the messages produced and the cache lookups.
+const (
+	SuccessOutcome = "success"
+	ErrorOutcome   = "error"
+	HitResult      = "hit"
+	MissResult     = "miss"
+)
+
+// Registry where the metrics of the service are registered.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// ServerHandledTotal with the RPCs completed by the server by method and code.
+	ServerHandledTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "grpc_server",
+		Name:      "handled_total",
+		Help:      "RPCs completed by the server.",
+	}, []string{"method", "code"})
+	// ServerHandlingSeconds with the latency of the RPCs served by method.
+	ServerHandlingSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "grpc_server",
+		Name:      "handling_seconds",
+		Help:      "Latency of the RPCs served.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+	// ClientHandlingSeconds with the latency of the calls to the other components by component, method and code.
+	ClientHandlingSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "grpc_client",
+		Name:      "handling_seconds",
+		Help:      "Latency of the calls to the other components.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service", "method", "code"})
+	// OperationsTotal with the deployments and undeployments by outcome.
+	OperationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "operations_total",
+		Help:      "Deploy and undeploy operations by outcome.",
+	}, []string{"operation", "outcome"})
+	// OperationDuration with the duration of the deployments and undeployments by outcome.
+	OperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of the deploy and undeploy operations by outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "outcome"})
+	// MessagesProducedTotal with the messages sent to the bus by topic and outcome.
+	MessagesProducedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "bus",
+		Name:      "messages_produced_total",
+		Help:      "Messages sent to the bus.",
+	}, []string{"topic", "outcome"})
+	// MessagesConsumedTotal with the messages received from the bus.
+	MessagesConsumedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "bus",
+		Name:      "messages_consumed_total",
+		Help:      "Messages received from the bus.",
+	}, []string{"message"})
+	// MessagesFailedTotal with the messages received from the bus that could not be processed.
+	MessagesFailedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "bus",
+		Name:      "messages_failed_total",
+		Help:      "Messages received from the bus that could not be processed.",
+	}, []string{"message"})
+	// CacheRequestsTotal with the lookups of the caches by result, hit or miss.
+	CacheRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "cache",
+		Name:      "requests_total",
+		Help:      "Cache lookups by result.",
+	}, []string{"cache", "result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		ServerHandledTotal, ServerHandlingSeconds, ClientHandlingSeconds,
+		OperationsTotal, OperationDuration,
+		MessagesProducedTotal, MessagesConsumedTotal, MessagesFailedTotal,
+		CacheRequestsTotal,
+	)
+}
+
+// Handler serves the metrics of the registry.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+// code returns the name of the gRPC code of an error, OK if there is no error.
+func code(err error) string {
+	return status.Code(err).String()
+}
+
+// outcome returns the outcome of an operation that may have failed.
+func outcome(err error) string {
+	if err != nil {
+		return ErrorOutcome
+	}
+	return SuccessOutcome
+}
+
+// ObserveOperation records a deploy or undeploy operation started at a given time. The outcome is the gRPC code
+// of the error.
+func ObserveOperation(operation string, start time.Time, err error) {
+	OperationsTotal.WithLabelValues(operation, code(err)).Inc()
+	OperationDuration.WithLabelValues(operation, code(err)).Observe(time.Since(start).Seconds())
+}
+
+// MessageProduced records a message sent to the bus.
+func MessageProduced(topic string, err error) {
+	MessagesProducedTotal.WithLabelValues(topic, outcome(err)).Inc()
+}
+
+// MessageConsumed records a message received from the bus and whether it could be processed.
+func MessageConsumed(message string, err error) {
+	MessagesConsumedTotal.WithLabelValues(message).Inc()
+	if err != nil {
+		MessagesFailedTotal.WithLabelValues(message).Inc()
+	}
+}
+
+// CacheLookup records a lookup of a cache.
+func CacheLookup(cache string, found bool) {
+	result := MissResult
+	if found {
+		result = HitResult
+	}
+	CacheRequestsTotal.WithLabelValues(cache, result).Inc()
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"io/ioutil"
+	"net/http/httptest"
+	"time"
+)
+
+var _ = ginkgo.Describe("Metrics", func() {
+
+	ginkgo.It("should record the operations by outcome", func() {
+		succeeded := testutil.ToFloat64(OperationsTotal.WithLabelValues(DeployOperation, "OK"))
+		failed := testutil.ToFloat64(OperationsTotal.WithLabelValues(DeployOperation, "InvalidArgument"))
+		ObserveOperation(DeployOperation, time.Now(), nil)
+		ObserveOperation(DeployOperation, time.Now(), conversions.ToGRPCError(derrors.NewInvalidArgumentError("invalid")))
+		gomega.Expect(testutil.ToFloat64(OperationsTotal.WithLabelValues(DeployOperation, "OK"))).To(gomega.Equal(succeeded + 1))
+		gomega.Expect(testutil.ToFloat64(OperationsTotal.WithLabelValues(DeployOperation, "InvalidArgument"))).To(gomega.Equal(failed + 1))
+	})
+
+	ginkgo.It("should record the consumed and the failed messages", func() {
+		consumed := testutil.ToFloat64(MessagesConsumedTotal.WithLabelValues(DeploymentServiceUpdateMessage))
+		failed := testutil.ToFloat64(MessagesFailedTotal.WithLabelValues(DeploymentServiceUpdateMessage))
+		MessageConsumed(DeploymentServiceUpdateMessage, nil)
+		MessageConsumed(DeploymentServiceUpdateMessage, derrors.NewUnavailableError("unavailable"))
+		gomega.Expect(testutil.ToFloat64(MessagesConsumedTotal.WithLabelValues(DeploymentServiceUpdateMessage))).To(gomega.Equal(consumed + 2))
+		gomega.Expect(testutil.ToFloat64(MessagesFailedTotal.WithLabelValues(DeploymentServiceUpdateMessage))).To(gomega.Equal(failed + 1))
+	})
+
+	ginkgo.It("should record the cache hits and misses", func() {
+		hits := testutil.ToFloat64(CacheRequestsTotal.WithLabelValues(InstancesCache, HitResult))
+		misses := testutil.ToFloat64(CacheRequestsTotal.WithLabelValues(InstancesCache, MissResult))
+		CacheLookup(InstancesCache, true)
+		CacheLookup(InstancesCache, false)
+		CacheLookup(InstancesCache, false)
+		gomega.Expect(testutil.ToFloat64(CacheRequestsTotal.WithLabelValues(InstancesCache, HitResult))).To(gomega.Equal(hits + 1))
+		gomega.Expect(testutil.ToFloat64(CacheRequestsTotal.WithLabelValues(InstancesCache, MissResult))).To(gomega.Equal(misses + 2))
+	})
+
+	ginkgo.It("should serve the metrics", func() {
+		MessageProduced(AppOpsTopic, nil)
+		recorder := httptest.NewRecorder()
+		Handler().ServeHTTP(recorder, httptest.NewRequest("GET", MetricsPath, nil))
+		gomega.Expect(recorder.Code).To(gomega.Equal(200))
+		body, err := ioutil.ReadAll(recorder.Body)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(string(body)).To(gomega.ContainSubstring(`application_manager_bus_messages_produced_total{outcome="success",topic="app-ops"}`))
+		gomega.Expect(string(body)).To(gomega.ContainSubstring("go_goroutines"))
+	})
+})
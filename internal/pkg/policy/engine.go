@@ -0,0 +1,165 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package policy
+
+import (
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PolicyViolation with the type of the precondition failures returned when a policy denies a request.
+const PolicyViolation = "POLICY_DENIED"
+
+// Decision taken by a policy on an element of a descriptor.
+type Decision struct {
+	// Policy that triggered.
+	Policy string
+	// Action of the policy.
+	Action string
+	// Path of the element of the descriptor.
+	Path string
+	// Message with the reason of the decision.
+	Message string
+}
+
+func (d *Decision) String() string {
+	return fmt.Sprintf("policy %s: %s: %s", d.Policy, d.Path, d.Message)
+}
+
+// Result with the decisions of the policies on an input.
+type Result struct {
+	Decisions []*Decision
+}
+
+// filter returns the decisions with a given action.
+func (r *Result) filter(action string) []*Decision {
+	result := make([]*Decision, 0)
+	for _, decision := range r.Decisions {
+		if decision.Action == action {
+			result = append(result, decision)
+		}
+	}
+	return result
+}
+
+// Denials returns the decisions that deny the request.
+func (r *Result) Denials() []*Decision {
+	return r.filter(DenyAction)
+}
+
+// Warnings returns the decisions that only warn about the request.
+func (r *Result) Warnings() []*Decision {
+	return r.filter(WarnAction)
+}
+
+// ToGRPCError returns a PermissionDenied error with the denials, or nil if the request is allowed.
+func (r *Result) ToGRPCError() error {
+	denials := r.Denials()
+	if len(denials) == 0 {
+		return nil
+	}
+	details := &errdetails.PreconditionFailure{
+		Violations: make([]*errdetails.PreconditionFailure_Violation, 0, len(denials)),
+	}
+	messages := make([]string, 0, len(denials))
+	for _, denial := range denials {
+		details.Violations = append(details.Violations, &errdetails.PreconditionFailure_Violation{
+			Type:        PolicyViolation,
+			Subject:     fmt.Sprintf("%s:%s", denial.Policy, denial.Path),
+			Description: denial.Message,
+		})
+		messages = append(messages, denial.String())
+	}
+	st := status.New(codes.PermissionDenied, "denied by policy: "+strings.Join(messages, "; "))
+	withDetails, err := st.WithDetails(details)
+	if err != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
+
+// Engine evaluates the policies on the requests.
+type Engine interface {
+	// Evaluate the policies on an input.
+	Evaluate(input *Input) *Result
+}
+
+// LocalEngine evaluates the policies read from a local directory.
+type LocalEngine struct {
+	policies []*Policy
+}
+
+// NewLocalEngine creates an engine with the policies of a directory. Files with .yaml, .yml or .json extension are
+// read in lexicographic order. An empty directory name creates an engine without policies.
+func NewLocalEngine(directory string) (*LocalEngine, derrors.Error) {
+	engine := &LocalEngine{policies: make([]*Policy, 0)}
+	if directory == "" {
+		return engine, nil
+	}
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError("cannot read the policy directory", err).WithParams(directory)
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		extension := strings.ToLower(filepath.Ext(file.Name()))
+		if !file.IsDir() && (extension == ".yaml" || extension == ".yml" || extension == ".json") {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+	loaded := make(map[string]string, 0)
+	for _, name := range names {
+		content, err := ioutil.ReadFile(filepath.Join(directory, name))
+		if err != nil {
+			return nil, derrors.NewInvalidArgumentError("cannot read policy file", err).WithParams(name)
+		}
+		policy, dErr := Parse(content)
+		if dErr != nil {
+			return nil, derrors.NewInvalidArgumentError("invalid policy file", dErr).WithParams(name)
+		}
+		if previous, exists := loaded[policy.Name]; exists {
+			return nil, derrors.NewInvalidArgumentError("duplicated policy name").WithParams(policy.Name, previous, name)
+		}
+		loaded[policy.Name] = name
+		engine.policies = append(engine.policies, policy)
+	}
+	log.Info().Int("policies", len(engine.policies)).Str("directory", directory).Msg("policies loaded")
+	return engine, nil
+}
+
+// NewEngine creates an engine with a set of policies.
+func NewEngine(policies ...*Policy) *LocalEngine {
+	return &LocalEngine{policies: policies}
+}
+
+// Evaluate the policies on an input.
+func (e *LocalEngine) Evaluate(input *Input) *Result {
+	result := &Result{Decisions: make([]*Decision, 0)}
+	for _, policy := range e.policies {
+		result.Decisions = append(result.Decisions, policy.Evaluate(input)...)
+	}
+	return result
+}
@@ -0,0 +1,247 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package policy evaluates the organization policies on the descriptors added to the system and on the descriptors
+// rendered for a deployment. A policy is a YAML or JSON document with a list of rules and the action taken when one
+// of them matches:
+//
+//	name: no-public-latest
+//	description: public ports and latest images are not allowed
+//	action: deny
+//	organizations: [org-1]
+//	phases: [deploy]
+//	rules:
+//	- forbidden_images: ["*:latest", "docker.io/*"]
+//	- forbidden_access: [PUBLIC]
+//	- required_labels: [team]
+//
+// A policy without organizations applies to all of them and a policy without phases is evaluated in every phase.
+package policy
+
+import (
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-go"
+	"gopkg.in/yaml.v2"
+	"regexp"
+	"strings"
+)
+
+// Actions taken when a policy matches.
+const (
+	DenyAction = "deny"
+	WarnAction = "warn"
+)
+
+// Phases in which the policies are evaluated.
+const (
+	// DescriptorPhase when a descriptor is added.
+	DescriptorPhase = "descriptor"
+	// DeployPhase when a descriptor is deployed, once the parameters are applied.
+	DeployPhase = "deploy"
+)
+
+var validActions = map[string]bool{DenyAction: true, WarnAction: true}
+var validPhases = map[string]bool{DescriptorPhase: true, DeployPhase: true}
+
+// Rule of a policy. A rule matches if any of its conditions match.
+type Rule struct {
+	// ForbiddenImages with the glob patterns of the images that cannot be used. * matches any sequence of characters.
+	ForbiddenImages []string `json:"forbidden_images,omitempty" yaml:"forbidden_images,omitempty"`
+	// ForbiddenAccess with the port access types that cannot be used in the security rules.
+	ForbiddenAccess []string `json:"forbidden_access,omitempty" yaml:"forbidden_access,omitempty"`
+	// RequiredLabels with the labels the descriptor must define.
+	RequiredLabels []string `json:"required_labels,omitempty" yaml:"required_labels,omitempty"`
+
+	images []*regexp.Regexp
+}
+
+// Policy with a set of rules and the action taken when they match.
+type Policy struct {
+	Name          string   `json:"name" yaml:"name"`
+	Description   string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Action        string   `json:"action,omitempty" yaml:"action,omitempty"`
+	Organizations []string `json:"organizations,omitempty" yaml:"organizations,omitempty"`
+	Phases        []string `json:"phases,omitempty" yaml:"phases,omitempty"`
+	Rules         []*Rule  `json:"rules" yaml:"rules"`
+}
+
+// Input with the elements of a descriptor the policies are evaluated on.
+type Input struct {
+	Phase          string
+	OrganizationId string
+	Labels         map[string]string
+	Groups         []*grpc_application_go.ServiceGroup
+	Rules          []*grpc_application_go.SecurityRule
+}
+
+// NewDescriptorInput creates the input of the descriptor phase.
+func NewDescriptorInput(request *grpc_application_go.AddAppDescriptorRequest) *Input {
+	return &Input{
+		Phase:          DescriptorPhase,
+		OrganizationId: request.OrganizationId,
+		Labels:         request.Labels,
+		Groups:         request.Groups,
+		Rules:          request.Rules,
+	}
+}
+
+// NewDescriptorUpdateInput creates the input of the descriptor phase from a stored descriptor with the labels of an
+// update request applied.
+func NewDescriptorUpdateInput(descriptor *grpc_application_go.AppDescriptor, request *grpc_application_go.UpdateAppDescriptorRequest) *Input {
+	labels := make(map[string]string, len(descriptor.Labels)+len(request.Labels))
+	for key, value := range descriptor.Labels {
+		labels[key] = value
+	}
+	for key, value := range request.Labels {
+		if request.AddLabels {
+			labels[key] = value
+		} else if request.RemoveLabels {
+			delete(labels, key)
+		}
+	}
+	return NewDescriptorInput(&grpc_application_go.AddAppDescriptorRequest{
+		OrganizationId: descriptor.OrganizationId,
+		Labels:         labels,
+		Groups:         descriptor.Groups,
+		Rules:          descriptor.Rules,
+	})
+}
+
+// NewDeployInput creates the input of the deploy phase from the rendered descriptor.
+func NewDeployInput(descriptor *grpc_application_go.ParametrizedDescriptor) *Input {
+	return &Input{
+		Phase:          DeployPhase,
+		OrganizationId: descriptor.OrganizationId,
+		Labels:         descriptor.Labels,
+		Groups:         descriptor.Groups,
+		Rules:          descriptor.Rules,
+	}
+}
+
+// Parse reads a policy in YAML or JSON format, JSON being a subset of YAML.
+func Parse(content []byte) (*Policy, derrors.Error) {
+	policy := &Policy{}
+	err := yaml.UnmarshalStrict(content, policy)
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError("invalid policy format", err)
+	}
+	if vErr := policy.compile(); vErr != nil {
+		return nil, vErr
+	}
+	return policy, nil
+}
+
+// globToRegexp converts a glob pattern where * matches any sequence of characters to a regular expression.
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// compile validates a policy and prepares its rules to be evaluated.
+func (p *Policy) compile() derrors.Error {
+	if p.Name == "" {
+		return derrors.NewInvalidArgumentError("policy name cannot be empty")
+	}
+	if p.Action == "" {
+		p.Action = DenyAction
+	}
+	if !validActions[p.Action] {
+		return derrors.NewInvalidArgumentError("invalid policy action").WithParams(p.Name, p.Action)
+	}
+	for _, phase := range p.Phases {
+		if !validPhases[phase] {
+			return derrors.NewInvalidArgumentError("invalid policy phase").WithParams(p.Name, phase)
+		}
+	}
+	if len(p.Rules) == 0 {
+		return derrors.NewInvalidArgumentError("policy without rules").WithParams(p.Name)
+	}
+	for _, rule := range p.Rules {
+		if len(rule.ForbiddenImages) == 0 && len(rule.ForbiddenAccess) == 0 && len(rule.RequiredLabels) == 0 {
+			return derrors.NewInvalidArgumentError("policy rule without conditions").WithParams(p.Name)
+		}
+		rule.images = make([]*regexp.Regexp, 0, len(rule.ForbiddenImages))
+		for _, image := range rule.ForbiddenImages {
+			compiled, err := globToRegexp(image)
+			if err != nil {
+				return derrors.NewInvalidArgumentError("invalid image pattern", err).WithParams(p.Name, image)
+			}
+			rule.images = append(rule.images, compiled)
+		}
+		for _, access := range rule.ForbiddenAccess {
+			if _, exists := grpc_application_go.PortAccess_value[access]; !exists {
+				return derrors.NewInvalidArgumentError("invalid port access").WithParams(p.Name, access)
+			}
+		}
+	}
+	return nil
+}
+
+// appliesTo checks if a policy must be evaluated on an input.
+func (p *Policy) appliesTo(input *Input) bool {
+	if len(p.Phases) > 0 && !contains(p.Phases, input.Phase) {
+		return false
+	}
+	return len(p.Organizations) == 0 || contains(p.Organizations, input.OrganizationId)
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate returns the decisions of a policy on an input.
+func (p *Policy) Evaluate(input *Input) []*Decision {
+	decisions := make([]*Decision, 0)
+	if !p.appliesTo(input) {
+		return decisions
+	}
+	add := func(path string, message string, params ...interface{}) {
+		decisions = append(decisions, &Decision{Policy: p.Name, Action: p.Action, Path: path,
+			Message: fmt.Sprintf(message, params...)})
+	}
+	for _, rule := range p.Rules {
+		for i, group := range input.Groups {
+			for j, service := range group.Services {
+				for _, image := range rule.images {
+					if image.MatchString(service.Image) {
+						add(fmt.Sprintf("groups[%d].services[%d].image", i, j), "image %s is forbidden", service.Image)
+						break
+					}
+				}
+			}
+		}
+		for i, securityRule := range input.Rules {
+			if contains(rule.ForbiddenAccess, securityRule.Access.String()) {
+				add(fmt.Sprintf("rules[%d].access", i), "access %s is forbidden", securityRule.Access.String())
+			}
+		}
+		for _, label := range rule.RequiredLabels {
+			if _, exists := input.Labels[label]; !exists {
+				add("labels", "label %s is required", label)
+			}
+		}
+	}
+	return decisions
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package policy
+
+import (
+	"github.com/nalej/application-manager/internal/pkg/utils"
+	"github.com/nalej/grpc-application-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const denyPolicy = `
+name: no-public-latest
+action: deny
+phases: [deploy]
+rules:
+- forbidden_images: ["*:latest"]
+- forbidden_access: [PUBLIC]
+`
+
+const warnPolicy = `{"name": "labels", "action": "warn", "rules": [{"required_labels": ["team"]}]}`
+
+var _ = ginkgo.Describe("Policies", func() {
+
+	var descriptor *grpc_application_go.AddAppDescriptorRequest
+
+	ginkgo.BeforeEach(func() {
+		descriptor = utils.CreateTestAddDescriptorWithParameters()
+		descriptor.Groups[0].Services[0].Image = "nginx:latest"
+		descriptor.Groups[0].Services[1].Image = "nginx:1.17"
+		descriptor.Rules[1].Access = grpc_application_go.PortAccess_PUBLIC
+	})
+
+	ginkgo.It("should reject invalid policies", func() {
+		_, err := Parse([]byte("name: p\nrules: []"))
+		gomega.Expect(err).NotTo(gomega.BeNil())
+		_, err = Parse([]byte("name: p\naction: block\nrules:\n- required_labels: [a]"))
+		gomega.Expect(err).NotTo(gomega.BeNil())
+		_, err = Parse([]byte("name: p\nrules:\n- forbidden_access: [ANY]"))
+		gomega.Expect(err).NotTo(gomega.BeNil())
+		_, err = Parse([]byte("name: p\nrules:\n- unknown: [a]"))
+		gomega.Expect(err).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("should evaluate the rules on the phases of the policy", func() {
+		deny, err := Parse([]byte(denyPolicy))
+		gomega.Expect(err).To(gomega.BeNil())
+		warn, err := Parse([]byte(warnPolicy))
+		gomega.Expect(err).To(gomega.BeNil())
+		engine := NewEngine(deny, warn)
+
+		result := engine.Evaluate(NewDescriptorInput(descriptor))
+		gomega.Expect(result.Denials()).To(gomega.BeEmpty())
+		gomega.Expect(result.Warnings()).To(gomega.HaveLen(1))
+		gomega.Expect(result.ToGRPCError()).To(gomega.Succeed())
+
+		input := NewDescriptorInput(descriptor)
+		input.Phase = DeployPhase
+		result = engine.Evaluate(input)
+		denials := result.Denials()
+		gomega.Expect(denials).To(gomega.HaveLen(2))
+		gomega.Expect(denials[0]).To(gomega.Equal(&Decision{Policy: "no-public-latest", Action: DenyAction,
+			Path: "groups[0].services[0].image", Message: "image nginx:latest is forbidden"}))
+		gomega.Expect(denials[1].Path).To(gomega.Equal("rules[1].access"))
+
+		st := status.Convert(result.ToGRPCError())
+		gomega.Expect(st.Code()).To(gomega.Equal(codes.PermissionDenied))
+		gomega.Expect(st.Message()).To(gomega.ContainSubstring("no-public-latest"))
+	})
+
+	ginkgo.It("should evaluate the labels of an updated descriptor", func() {
+		warn, err := Parse([]byte(warnPolicy))
+		gomega.Expect(err).To(gomega.BeNil())
+		engine := NewEngine(warn)
+		stored := &grpc_application_go.AppDescriptor{OrganizationId: descriptor.OrganizationId,
+			Labels: map[string]string{"team": "nalej"}, Groups: descriptor.Groups, Rules: descriptor.Rules}
+
+		removed := NewDescriptorUpdateInput(stored, &grpc_application_go.UpdateAppDescriptorRequest{
+			RemoveLabels: true, Labels: map[string]string{"team": ""}})
+		gomega.Expect(removed.Phase).To(gomega.Equal(DescriptorPhase))
+		gomega.Expect(engine.Evaluate(removed).Warnings()).To(gomega.HaveLen(1))
+		gomega.Expect(stored.Labels).To(gomega.HaveKey("team"))
+
+		added := NewDescriptorUpdateInput(stored, &grpc_application_go.UpdateAppDescriptorRequest{
+			AddLabels: true, Labels: map[string]string{"app": "test"}})
+		gomega.Expect(engine.Evaluate(added).Warnings()).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should only apply the policies of the organization", func() {
+		policy, err := Parse([]byte("name: p\norganizations: [other]\nrules:\n- required_labels: [team]"))
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(policy.Evaluate(NewDescriptorInput(descriptor))).To(gomega.BeEmpty())
+		policy.Organizations = append(policy.Organizations, descriptor.OrganizationId)
+		gomega.Expect(policy.Evaluate(NewDescriptorInput(descriptor))).To(gomega.HaveLen(1))
+	})
+
+	ginkgo.It("should load the policies of a directory", func() {
+		dir, tErr := ioutil.TempDir("", "policies")
+		gomega.Expect(tErr).To(gomega.Succeed())
+		defer os.RemoveAll(dir)
+		gomega.Expect(ioutil.WriteFile(filepath.Join(dir, "deny.yaml"), []byte(denyPolicy), 0644)).To(gomega.Succeed())
+		gomega.Expect(ioutil.WriteFile(filepath.Join(dir, "warn.json"), []byte(warnPolicy), 0644)).To(gomega.Succeed())
+		gomega.Expect(ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("not a policy"), 0644)).To(gomega.Succeed())
+
+		engine, err := NewLocalEngine(dir)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(engine.policies).To(gomega.HaveLen(2))
+
+		gomega.Expect(ioutil.WriteFile(filepath.Join(dir, "copy.yml"), []byte(denyPolicy), 0644)).To(gomega.Succeed())
+		_, err = NewLocalEngine(dir)
+		gomega.Expect(err).NotTo(gomega.BeNil())
+	})
+})
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/nalej/derrors"
+	"io"
+)
+
+// sealer encrypts the secrets with AES-GCM using a key derived from a passphrase.
+type sealer struct {
+	aead cipher.AEAD
+}
+
+// newSealer creates a sealer from a passphrase.
+func newSealer(key string) (*sealer, derrors.Error) {
+	if key == "" {
+		return nil, derrors.NewInvalidArgumentError("the key of the secrets must be set")
+	}
+	hash := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(hash[:])
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot create the cipher of the secrets")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot create the cipher of the secrets")
+	}
+	return &sealer{aead: aead}, nil
+}
+
+// encrypt a secret. The primary key is used as additional data so a secret cannot be moved to another entry.
+func (s *sealer) encrypt(pk string, value string) (string, derrors.Error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", derrors.AsError(err, "cannot generate the nonce of a secret")
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(value), []byte(pk))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt a secret.
+func (s *sealer) decrypt(pk string, encoded string) (string, derrors.Error) {
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return "", derrors.NewInternalError("invalid secret format")
+	}
+	nonceSize := s.aead.NonceSize()
+	value, err := s.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(pk))
+	if err != nil {
+		return "", derrors.AsError(err, "cannot decrypt the secret")
+	}
+	return string(value), nil
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/nalej/application-manager/internal/pkg/entities"
+	"github.com/nalej/derrors"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// FileProvider stores the secrets in a local file. Each secret is encrypted with AES-GCM using a key derived from
+// the configured passphrase. The file must be kept in a persistent volume, the secrets are lost otherwise. The
+// operations are local so they do not use the context.
+type FileProvider struct {
+	sync.Mutex
+	// path of the file
+	path string
+	// sealer used to encrypt the secrets
+	sealer *sealer
+	// secrets encrypted and encoded in base64, indexed by organization#secret
+	secrets map[string]string
+}
+
+// NewFileProvider creates a provider that stores the secrets in a file, loading the secrets already stored on it.
+func NewFileProvider(path string, key string) (*FileProvider, derrors.Error) {
+	if path == "" {
+		return nil, derrors.NewInvalidArgumentError("the path of the secrets file must be set")
+	}
+	sealer, dErr := newSealer(key)
+	if dErr != nil {
+		return nil, dErr
+	}
+	provider := &FileProvider{
+		path:    path,
+		sealer:  sealer,
+		secrets: make(map[string]string, 0),
+	}
+	if dErr := provider.load(); dErr != nil {
+		return nil, dErr
+	}
+	return provider, nil
+}
+
+func (f *FileProvider) composePK(organizationID string, secretID string) string {
+	return fmt.Sprintf("%s#%s", organizationID, secretID)
+}
+
+// load reads the secrets stored in the file. A missing file is considered empty.
+func (f *FileProvider) load() derrors.Error {
+	content, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return derrors.AsError(err, "cannot read the secrets file")
+	}
+	if len(content) == 0 {
+		return nil
+	}
+	err = json.Unmarshal(content, &f.secrets)
+	if err != nil {
+		return derrors.AsError(err, "cannot parse the secrets file")
+	}
+	return nil
+}
+
+// save writes the secrets to a temporal file that replaces the previous one.
+func (f *FileProvider) save() derrors.Error {
+	content, err := json.Marshal(f.secrets)
+	if err != nil {
+		return derrors.AsError(err, "cannot serialize the secrets")
+	}
+	tmpPath := f.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, content, 0600)
+	if err != nil {
+		return derrors.AsError(err, "cannot write the secrets file")
+	}
+	err = os.Rename(tmpPath, f.path)
+	if err != nil {
+		return derrors.AsError(err, "cannot write the secrets file")
+	}
+	return nil
+}
+
+// Store saves a secret of an organization and returns the reference used to retrieve it.
+func (f *FileProvider) Store(ctx context.Context, organizationID string, value string) (string, derrors.Error) {
+	f.Lock()
+	defer f.Unlock()
+	secretID := uuid.New().String()
+	pk := f.composePK(organizationID, secretID)
+	encrypted, err := f.sealer.encrypt(pk, value)
+	if err != nil {
+		return "", err
+	}
+	f.secrets[pk] = encrypted
+	if err := f.save(); err != nil {
+		delete(f.secrets, pk)
+		return "", err
+	}
+	return entities.NewSecretReference(secretID), nil
+}
+
+// Get retrieves the value of a secret from its reference.
+func (f *FileProvider) Get(ctx context.Context, organizationID string, reference string) (string, derrors.Error) {
+	f.Lock()
+	defer f.Unlock()
+	pk := f.composePK(organizationID, entities.SecretID(reference))
+	encrypted, exists := f.secrets[pk]
+	if !exists {
+		return "", derrors.NewNotFoundError("secret").WithParams(organizationID, reference)
+	}
+	return f.sealer.decrypt(pk, encrypted)
+}
+
+// Remove deletes a secret.
+func (f *FileProvider) Remove(ctx context.Context, organizationID string, reference string) derrors.Error {
+	f.Lock()
+	defer f.Unlock()
+	pk := f.composePK(organizationID, entities.SecretID(reference))
+	encrypted, exists := f.secrets[pk]
+	if !exists {
+		return derrors.NewNotFoundError("secret").WithParams(organizationID, reference)
+	}
+	delete(f.secrets, pk)
+	if err := f.save(); err != nil {
+		f.secrets[pk] = encrypted
+		return err
+	}
+	return nil
+}
+
+// Clear removes all the stored data.
+func (f *FileProvider) Clear(ctx context.Context) derrors.Error {
+	f.Lock()
+	defer f.Unlock()
+	f.secrets = make(map[string]string, 0)
+	return f.save()
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package secret
+
+import (
+	"context"
+	"github.com/nalej/application-manager/internal/pkg/entities"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var _ = ginkgo.Describe("File secret provider", func() {
+
+	var dir string
+	var path string
+	var provider *FileProvider
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "secrets")
+		gomega.Expect(err).To(gomega.Succeed())
+		path = filepath.Join(dir, "secrets.json")
+		var dErr error
+		provider, dErr = NewFileProvider(path, "passphrase")
+		gomega.Expect(dErr).To(gomega.BeNil())
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	ginkgo.It("should store and retrieve a secret", func() {
+		reference, err := provider.Store(context.Background(), "org", "s3cr3t-value")
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(entities.IsSecretReference(reference)).To(gomega.BeTrue())
+
+		value, err := provider.Get(context.Background(), "org", reference)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(value).Should(gomega.Equal("s3cr3t-value"))
+
+		// the secrets of an organization cannot be read from another one
+		_, err = provider.Get(context.Background(), "other", reference)
+		gomega.Expect(err).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("should encrypt the file", func() {
+		reference, err := provider.Store(context.Background(), "org", "s3cr3t-value")
+		gomega.Expect(err).To(gomega.BeNil())
+		content, rErr := ioutil.ReadFile(path)
+		gomega.Expect(rErr).To(gomega.Succeed())
+		gomega.Expect(strings.Contains(string(content), "s3cr3t-value")).To(gomega.BeFalse())
+
+		reloaded, err := NewFileProvider(path, "passphrase")
+		gomega.Expect(err).To(gomega.BeNil())
+		value, err := reloaded.Get(context.Background(), "org", reference)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(value).Should(gomega.Equal("s3cr3t-value"))
+
+		wrongKey, err := NewFileProvider(path, "other")
+		gomega.Expect(err).To(gomega.BeNil())
+		_, err = wrongKey.Get(context.Background(), "org", reference)
+		gomega.Expect(err).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("should remove a secret", func() {
+		reference, err := provider.Store(context.Background(), "org", "s3cr3t-value")
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(provider.Remove(context.Background(), "org", reference)).To(gomega.Succeed())
+		_, err = provider.Get(context.Background(), "org", reference)
+		gomega.Expect(err).NotTo(gomega.BeNil())
+		gomega.Expect(provider.Remove(context.Background(), "org", reference)).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should require a key", func() {
+		_, err := NewFileProvider(path, "")
+		gomega.Expect(err).NotTo(gomega.BeNil())
+	})
+})
@@ -0,0 +1,176 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/nalej/application-manager/internal/pkg/entities"
+	"github.com/nalej/derrors"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"time"
+)
+
+const (
+	// secretNamePrefix with the prefix of the name of the Kubernetes secrets.
+	secretNamePrefix = "app-secret-"
+	// componentLabel and componentValue identify the Kubernetes secrets created by the provider.
+	componentLabel = "component"
+	componentValue = "application-manager-secret"
+	// organizationLabel with the organization that owns a secret.
+	organizationLabel = "nalej-organization"
+	// valueKey with the key of the data of the Kubernetes secret that holds the value.
+	valueKey = "value"
+	// kubernetesTimeout with the maximum time of the calls to the Kubernetes API, the deadline of the caller is kept
+	// if it is shorter.
+	kubernetesTimeout = 10 * time.Second
+)
+
+// KubernetesProvider stores each secret in a Kubernetes Secret of a namespace, so the secrets survive the restarts of
+// the application manager. The values are also encrypted with AES-GCM so they cannot be read from the cluster without
+// the passphrase.
+type KubernetesProvider struct {
+	client    kubernetes.Interface
+	namespace string
+	sealer    *sealer
+}
+
+// NewKubernetesProvider creates a provider that stores the secrets in a namespace using the given client.
+func NewKubernetesProvider(client kubernetes.Interface, namespace string, key string) (*KubernetesProvider, derrors.Error) {
+	if namespace == "" {
+		return nil, derrors.NewInvalidArgumentError("the namespace of the secrets must be set")
+	}
+	sealer, dErr := newSealer(key)
+	if dErr != nil {
+		return nil, dErr
+	}
+	return &KubernetesProvider{
+		client:    client,
+		namespace: namespace,
+		sealer:    sealer,
+	}, nil
+}
+
+// NewInClusterKubernetesProvider creates a provider that uses the service account of the pod to access the secrets.
+func NewInClusterKubernetesProvider(namespace string, key string) (*KubernetesProvider, derrors.Error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot load the in-cluster configuration")
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot create the Kubernetes client")
+	}
+	return NewKubernetesProvider(client, namespace, key)
+}
+
+func (k *KubernetesProvider) composePK(organizationID string, secretID string) string {
+	return fmt.Sprintf("%s#%s", organizationID, secretID)
+}
+
+// get retrieves the Kubernetes secret of a reference checking it belongs to the organization.
+func (k *KubernetesProvider) get(ctx context.Context, organizationID string, reference string) (*v1.Secret, derrors.Error) {
+	stored, err := k.client.CoreV1().Secrets(k.namespace).Get(ctx, secretNamePrefix+entities.SecretID(reference), metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, derrors.NewNotFoundError("secret").WithParams(organizationID, reference)
+		}
+		return nil, derrors.NewUnavailableError("cannot retrieve the secret", err).WithParams(organizationID, reference)
+	}
+	if stored.Labels[organizationLabel] != organizationID {
+		return nil, derrors.NewNotFoundError("secret").WithParams(organizationID, reference)
+	}
+	return stored, nil
+}
+
+// Store saves a secret of an organization and returns the reference used to retrieve it.
+func (k *KubernetesProvider) Store(ctx context.Context, organizationID string, value string) (string, derrors.Error) {
+	secretID := uuid.New().String()
+	encrypted, dErr := k.sealer.encrypt(k.composePK(organizationID, secretID), value)
+	if dErr != nil {
+		return "", dErr
+	}
+	toCreate := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretNamePrefix + secretID,
+			Namespace: k.namespace,
+			Labels: map[string]string{
+				componentLabel:    componentValue,
+				organizationLabel: organizationID,
+			},
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: map[string][]byte{valueKey: []byte(encrypted)},
+	}
+	ctx, cancel := context.WithTimeout(ctx, kubernetesTimeout)
+	defer cancel()
+	_, err := k.client.CoreV1().Secrets(k.namespace).Create(ctx, toCreate, metav1.CreateOptions{})
+	if err != nil {
+		return "", derrors.NewUnavailableError("cannot store the secret", err).WithParams(organizationID)
+	}
+	return entities.NewSecretReference(secretID), nil
+}
+
+// Get retrieves the value of a secret from its reference.
+func (k *KubernetesProvider) Get(ctx context.Context, organizationID string, reference string) (string, derrors.Error) {
+	ctx, cancel := context.WithTimeout(ctx, kubernetesTimeout)
+	defer cancel()
+	stored, dErr := k.get(ctx, organizationID, reference)
+	if dErr != nil {
+		return "", dErr
+	}
+	return k.sealer.decrypt(k.composePK(organizationID, entities.SecretID(reference)), string(stored.Data[valueKey]))
+}
+
+// Remove deletes a secret.
+func (k *KubernetesProvider) Remove(ctx context.Context, organizationID string, reference string) derrors.Error {
+	ctx, cancel := context.WithTimeout(ctx, kubernetesTimeout)
+	defer cancel()
+	stored, dErr := k.get(ctx, organizationID, reference)
+	if dErr != nil {
+		return dErr
+	}
+	err := k.client.CoreV1().Secrets(k.namespace).Delete(ctx, stored.Name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return derrors.NewUnavailableError("cannot remove the secret", err).WithParams(organizationID, reference)
+	}
+	return nil
+}
+
+// Clear removes all the stored data.
+func (k *KubernetesProvider) Clear(ctx context.Context) derrors.Error {
+	ctx, cancel := context.WithTimeout(ctx, kubernetesTimeout)
+	defer cancel()
+	list, err := k.client.CoreV1().Secrets(k.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", componentLabel, componentValue),
+	})
+	if err != nil {
+		return derrors.NewUnavailableError("cannot list the secrets", err)
+	}
+	for _, stored := range list.Items {
+		err = k.client.CoreV1().Secrets(k.namespace).Delete(ctx, stored.Name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return derrors.NewUnavailableError("cannot remove the secret", err).WithParams(stored.Name)
+		}
+	}
+	return nil
+}
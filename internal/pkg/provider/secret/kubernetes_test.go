@@ -0,0 +1,95 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret
+
+import (
+	"context"
+	"github.com/nalej/application-manager/internal/pkg/entities"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"strings"
+)
+
+var _ = ginkgo.Describe("Kubernetes secret provider", func() {
+
+	var client *fake.Clientset
+	var provider *KubernetesProvider
+
+	ginkgo.BeforeEach(func() {
+		client = fake.NewSimpleClientset()
+		var err error
+		provider, err = NewKubernetesProvider(client, "nalej", "passphrase")
+		gomega.Expect(err).To(gomega.BeNil())
+	})
+
+	ginkgo.It("should store and retrieve a secret", func() {
+		reference, err := provider.Store(context.Background(), "org", "s3cr3t-value")
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(entities.IsSecretReference(reference)).To(gomega.BeTrue())
+
+		value, err := provider.Get(context.Background(), "org", reference)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(value).Should(gomega.Equal("s3cr3t-value"))
+
+		// the secrets of an organization cannot be read from another one
+		_, err = provider.Get(context.Background(), "other", reference)
+		gomega.Expect(err).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("should encrypt the value of the Kubernetes secret", func() {
+		reference, err := provider.Store(context.Background(), "org", "s3cr3t-value")
+		gomega.Expect(err).To(gomega.BeNil())
+		stored, kErr := client.CoreV1().Secrets("nalej").Get(context.Background(),
+			secretNamePrefix+entities.SecretID(reference), metav1.GetOptions{})
+		gomega.Expect(kErr).To(gomega.Succeed())
+		gomega.Expect(strings.Contains(string(stored.Data[valueKey]), "s3cr3t-value")).To(gomega.BeFalse())
+
+		// a new provider, e.g. after a restart, reads the stored secrets
+		restarted, err := NewKubernetesProvider(client, "nalej", "passphrase")
+		gomega.Expect(err).To(gomega.BeNil())
+		value, err := restarted.Get(context.Background(), "org", reference)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(value).Should(gomega.Equal("s3cr3t-value"))
+	})
+
+	ginkgo.It("should remove a secret", func() {
+		reference, err := provider.Store(context.Background(), "org", "s3cr3t-value")
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(provider.Remove(context.Background(), "other", reference)).NotTo(gomega.Succeed())
+		gomega.Expect(provider.Remove(context.Background(), "org", reference)).To(gomega.Succeed())
+		_, err = provider.Get(context.Background(), "org", reference)
+		gomega.Expect(err).NotTo(gomega.BeNil())
+		gomega.Expect(provider.Remove(context.Background(), "org", reference)).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should clear the stored secrets", func() {
+		reference, err := provider.Store(context.Background(), "org", "s3cr3t-value")
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(provider.Clear(context.Background())).To(gomega.Succeed())
+		_, err = provider.Get(context.Background(), "org", reference)
+		gomega.Expect(err).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("should require a namespace and a key", func() {
+		_, err := NewKubernetesProvider(client, "", "passphrase")
+		gomega.Expect(err).NotTo(gomega.BeNil())
+		_, err = NewKubernetesProvider(client, "nalej", "")
+		gomega.Expect(err).NotTo(gomega.BeNil())
+	})
+})
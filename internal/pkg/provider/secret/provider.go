@@ -0,0 +1,35 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret
+
+import (
+	"context"
+	"github.com/nalej/derrors"
+)
+
+// Provider stores the values of the PASSWORD parameters of the application instances. The operations are bound to the
+// deadline of the given context.
+type Provider interface {
+	// Store saves a secret of an organization and returns the reference used to retrieve it.
+	Store(ctx context.Context, organizationID string, value string) (string, derrors.Error)
+	// Get retrieves the value of a secret from its reference.
+	Get(ctx context.Context, organizationID string, reference string) (string, derrors.Error)
+	// Remove deletes a secret.
+	Remove(ctx context.Context, organizationID string, reference string) derrors.Error
+	// Clear removes all the stored data.
+	Clear(ctx context.Context) derrors.Error
+}
@@ -18,46 +18,95 @@ package queue
 
 import (
 	"context"
+	"github.com/nalej/application-manager/internal/pkg/metrics"
+	"github.com/nalej/application-manager/internal/pkg/server/application"
 	"github.com/nalej/application-manager/internal/pkg/server/unified-logging"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-conductor-go"
 	"github.com/nalej/nalej-bus/pkg/queue/application/events"
 	"github.com/rs/zerolog/log"
+	"sync"
 	"time"
 )
 
 const ApplicationEventsTimeout = time.Minute
 
+// eventsConsumer consumes the application events from the bus and sends them to the channels of its configuration.
+type eventsConsumer interface {
+	Consume(ctx context.Context) derrors.Error
+}
+
 type AppEventsHandler struct {
 	// unified logging manager
 	ulManager *unified_logging.Manager
+	// watcher that fans out the updates to the application instance subscribers
+	watcher *application.InstanceWatcher
 	// application events consumer
-	appEventsConsumer *events.ApplicationEventsConsumer
+	appEventsConsumer eventsConsumer
+	// updates with the channel where the consumer sends the DeploymentServiceUpdateRequest messages
+	updates chan *grpc_conductor_go.DeploymentServiceUpdateRequest
+	// ctx is cancelled to stop the loops
+	ctx    context.Context
+	cancel context.CancelFunc
+	// consumed is closed once the consumer has stopped
+	consumed chan struct{}
+	// running with the loops that have not finished yet
+	running *sync.WaitGroup
 }
 
-func NewAppEventsHandler(ulManager *unified_logging.Manager, appEventsConsumer *events.ApplicationEventsConsumer) AppEventsHandler {
-	return AppEventsHandler{ulManager: ulManager, appEventsConsumer: appEventsConsumer}
+func NewAppEventsHandler(ulManager *unified_logging.Manager, watcher *application.InstanceWatcher, appEventsConsumer *events.ApplicationEventsConsumer) AppEventsHandler {
+	return newAppEventsHandler(ulManager, watcher, appEventsConsumer, appEventsConsumer.Config.ChDeploymentServiceStatusUpdateRequest)
+}
+
+func newAppEventsHandler(ulManager *unified_logging.Manager, watcher *application.InstanceWatcher, consumer eventsConsumer,
+	updates chan *grpc_conductor_go.DeploymentServiceUpdateRequest) AppEventsHandler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return AppEventsHandler{ulManager: ulManager, watcher: watcher, appEventsConsumer: consumer, updates: updates,
+		ctx: ctx, cancel: cancel, consumed: make(chan struct{}), running: &sync.WaitGroup{}}
 }
 
 func (a AppEventsHandler) Run() {
+	a.running.Add(2)
 	go a.consumeDeploymentServiceStatusUpdateRequest()
 	go a.waitRequests()
 }
 
-// waitRequests Endless loop waiting for requests
+// Stop ends the consumption of the application events. The updates already received are processed before
+// returning unless the timeout expires first.
+func (a AppEventsHandler) Stop(timeout time.Duration) derrors.Error {
+	a.cancel()
+	finished := make(chan struct{})
+	go func() {
+		a.running.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		log.Info().Msg("application events handler stopped")
+		return nil
+	case <-time.After(timeout):
+		return derrors.NewDeadlineExceededError("application events handler did not stop in time").WithParams(timeout.String())
+	}
+}
+
+// waitRequests loop waiting for requests until the handler is stopped
 func (a AppEventsHandler) waitRequests() {
+	defer a.running.Done()
+	defer close(a.consumed)
 	log.Debug().Msg("wait for requests to be received by the application events queue")
 	for {
-		somethingReceived := false
-		ctx, cancel := context.WithTimeout(context.Background(), ApplicationEventsTimeout)
+		ctx, cancel := context.WithTimeout(a.ctx, ApplicationEventsTimeout)
 		currentTime := time.Now()
 		err := a.appEventsConsumer.Consume(ctx)
-		somethingReceived = true
 		cancel()
+		if a.ctx.Err() != nil {
+			log.Debug().Msg("stop waiting for application events")
+			return
+		}
 		select {
 		case <-ctx.Done():
 			// the timeout was reached
-			if !somethingReceived {
-				log.Debug().Str("since", currentTime.Format(time.RFC3339)).Msgf("no message received")
-			}
+			log.Debug().Str("since", currentTime.Format(time.RFC3339)).Msgf("no message received")
 		default:
 			if err != nil {
 				log.Error().Err(err).Msg("error consuming data from application events")
@@ -68,13 +117,39 @@ func (a AppEventsHandler) waitRequests() {
 
 // conductor sends DeploymentServiceStatusUpdateRequest to the bus and application-manager consumes them
 func (a AppEventsHandler) consumeDeploymentServiceStatusUpdateRequest() {
+	defer a.running.Done()
 	log.Debug().Msg("waiting for service status update requests...")
 	for {
-		received := <-a.appEventsConsumer.Config.ChDeploymentServiceStatusUpdateRequest
-		log.Debug().Interface("DeploymentServiceStatusUpdateRequest", received).Msg("<- incoming deployment service status update request")
-		err := a.ulManager.ManageCatalog(received)
-		if err != nil {
-			log.Error().Err(err).Msg("failed processing deployment service status update request")
+		select {
+		case received := <-a.updates:
+			a.processDeploymentServiceStatusUpdateRequest(received)
+		case <-a.ctx.Done():
+			a.flushDeploymentServiceStatusUpdateRequests()
+			return
+		}
+	}
+}
+
+// flushDeploymentServiceStatusUpdateRequests processes the updates received before the consumer stopped.
+func (a AppEventsHandler) flushDeploymentServiceStatusUpdateRequests() {
+	<-a.consumed
+	for {
+		select {
+		case received := <-a.updates:
+			a.processDeploymentServiceStatusUpdateRequest(received)
+		default:
+			return
 		}
 	}
 }
+
+func (a AppEventsHandler) processDeploymentServiceStatusUpdateRequest(received *grpc_conductor_go.DeploymentServiceUpdateRequest) {
+	log.Debug().Interface("DeploymentServiceStatusUpdateRequest", received).Msg("<- incoming deployment service status update request")
+	a.watcher.Publish(received)
+	// the catalog is updated even if the handler is being stopped
+	err := a.ulManager.ManageCatalog(context.Background(), received)
+	metrics.MessageConsumed(metrics.DeploymentServiceUpdateMessage, err)
+	if err != nil {
+		log.Error().Err(err).Msg("failed processing deployment service status update request")
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package queue
+
+import (
+	"context"
+	"github.com/nalej/application-manager/internal/pkg/server/application"
+	"github.com/nalej/application-manager/internal/pkg/server/unified-logging"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-conductor-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"runtime"
+	"time"
+)
+
+// fakeConsumer sends the pending updates on the first consumption and then waits until the context is done.
+type fakeConsumer struct {
+	updates chan *grpc_conductor_go.DeploymentServiceUpdateRequest
+	pending []*grpc_conductor_go.DeploymentServiceUpdateRequest
+}
+
+func (f *fakeConsumer) Consume(ctx context.Context) derrors.Error {
+	for _, update := range f.pending {
+		f.updates <- update
+	}
+	f.pending = nil
+	<-ctx.Done()
+	return nil
+}
+
+func createUpdate(serviceInstanceID string) *grpc_conductor_go.DeploymentServiceUpdateRequest {
+	return &grpc_conductor_go.DeploymentServiceUpdateRequest{
+		OrganizationId: "org",
+		List: []*grpc_conductor_go.ServiceUpdate{{
+			ApplicationInstanceId: "inst",
+			ServiceInstanceId:     serviceInstanceID,
+			Status:                grpc_application_go.ServiceStatus_SERVICE_SCHEDULED,
+		}},
+	}
+}
+
+var _ = ginkgo.Describe("Application events handler", func() {
+
+	var ulManager *unified_logging.Manager
+	var watcher *application.InstanceWatcher
+	var subscription *application.Subscription
+	var consumer *fakeConsumer
+
+	ginkgo.BeforeEach(func() {
+		var err derrors.Error
+		ulManager, err = unified_logging.NewManager(nil, nil, nil, nil)
+		gomega.Expect(err).To(gomega.BeNil())
+		watcher = application.NewInstanceWatcher(application.DefaultWatchBufferSize, application.DropOldest)
+		subscription = watcher.Subscribe("org", "inst", application.DefaultWatchBufferSize, application.DropNewest)
+		consumer = &fakeConsumer{
+			updates: make(chan *grpc_conductor_go.DeploymentServiceUpdateRequest, 10),
+			pending: []*grpc_conductor_go.DeploymentServiceUpdateRequest{createUpdate("s1"), createUpdate("s2"), createUpdate("s3")},
+		}
+	})
+
+	ginkgo.It("should process the received updates before stopping", func() {
+		handler := newAppEventsHandler(ulManager, watcher, consumer, consumer.updates)
+		handler.Run()
+		gomega.Expect(handler.Stop(time.Second)).To(gomega.Succeed())
+		gomega.Expect(consumer.updates).To(gomega.BeEmpty())
+		gomega.Expect(subscription.Events()).To(gomega.HaveLen(3))
+	})
+
+	ginkgo.It("should not leak goroutines once stopped", func() {
+		before := runtime.NumGoroutine()
+		for i := 0; i < 10; i++ {
+			consumer.pending = []*grpc_conductor_go.DeploymentServiceUpdateRequest{createUpdate("s1")}
+			handler := newAppEventsHandler(ulManager, watcher, consumer, consumer.updates)
+			handler.Run()
+			gomega.Expect(handler.Stop(time.Second)).To(gomega.Succeed())
+		}
+		gomega.Eventually(runtime.NumGoroutine).Should(gomega.BeNumerically("<=", before))
+	})
+
+	ginkgo.It("should stop without running", func() {
+		handler := newAppEventsHandler(ulManager, watcher, consumer, consumer.updates)
+		gomega.Expect(handler.Stop(time.Second)).To(gomega.Succeed())
+	})
+})
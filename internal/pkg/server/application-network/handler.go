@@ -36,29 +36,29 @@ func NewHandler(manager Manager) *Handler {
 }
 
 // AddConnection adds a new connection between one outbound and one inbound
-func (h *Handler) AddConnection(_ context.Context, addRequest *grpc_application_network_go.AddConnectionRequest) (*grpc_common_go.OpResponse, error) {
+func (h *Handler) AddConnection(ctx context.Context, addRequest *grpc_application_network_go.AddConnectionRequest) (*grpc_common_go.OpResponse, error) {
 
 	vErr := entities.ValidAddConnectionRequest(addRequest)
 	if vErr != nil {
 		return nil, conversions.ToGRPCError(vErr)
 	}
-	return h.Manager.AddConnection(addRequest)
+	return h.Manager.AddConnection(ctx, addRequest)
 }
 
 // RemoveConnection removes a connection
-func (h *Handler) RemoveConnection(_ context.Context, removeRequest *grpc_application_network_go.RemoveConnectionRequest) (*grpc_common_go.OpResponse, error) {
+func (h *Handler) RemoveConnection(ctx context.Context, removeRequest *grpc_application_network_go.RemoveConnectionRequest) (*grpc_common_go.OpResponse, error) {
 	vErr := entities.ValidRemoveConnectionRequest(removeRequest)
 	if vErr != nil {
 		return nil, conversions.ToGRPCError(vErr)
 	}
-	return h.Manager.RemoveConnection(removeRequest)
+	return h.Manager.RemoveConnection(ctx, removeRequest)
 }
 
 // ListConnections retrieves a list all the established connections of an organization
-func (h *Handler) ListConnections(_ context.Context, orgID *grpc_organization_go.OrganizationId) (*grpc_application_network_go.ConnectionInstanceList, error) {
+func (h *Handler) ListConnections(ctx context.Context, orgID *grpc_organization_go.OrganizationId) (*grpc_application_network_go.ConnectionInstanceList, error) {
 	vErr := entities.ValidOrganizationId(orgID)
 	if vErr != nil {
 		return nil, conversions.ToGRPCError(vErr)
 	}
-	return h.Manager.ListConnections(orgID)
+	return h.Manager.ListConnections(ctx, orgID)
 }
@@ -17,7 +17,9 @@
 package application_network
 
 import (
+	"context"
 	"github.com/google/uuid"
+	"github.com/nalej/application-manager/internal/pkg/metrics"
 	"github.com/nalej/application-manager/internal/pkg/server/common"
 	"github.com/nalej/derrors"
 	"github.com/nalej/grpc-application-go"
@@ -69,10 +71,10 @@ func (m *Manager) getOutboundServiceName(appInstance *grpc_application_go.AppIns
 }
 
 // AddConnection adds a new connection between one outbound and one inbound
-func (m *Manager) AddConnection(addRequest *grpc_application_network_go.AddConnectionRequest) (*grpc_common_go.OpResponse, error) {
+func (m *Manager) AddConnection(ctx context.Context, addRequest *grpc_application_network_go.AddConnectionRequest) (*grpc_common_go.OpResponse, error) {
 
 	// check it the connection already exists
-	ctxGet, cancelGet := common.GetContext()
+	ctxGet, cancelGet := common.GetContextFrom(ctx)
 	defer cancelGet()
 	exists, err := m.appNetClient.ExistsConnection(ctxGet, &grpc_application_network_go.ConnectionInstanceId{
 		OrganizationId:   addRequest.OrganizationId,
@@ -89,7 +91,7 @@ func (m *Manager) AddConnection(addRequest *grpc_application_network_go.AddConne
 			addRequest.OrganizationId, addRequest.SourceInstanceId, addRequest.OutboundName, addRequest.TargetInstanceId, addRequest.InboundName))
 	}
 
-	ctxValidOutbounds, cancelValidOutbounds := common.GetContext()
+	ctxValidOutbounds, cancelValidOutbounds := common.GetContextFrom(ctx)
 	defer cancelValidOutbounds()
 	outboundConnections, err := m.appNetClient.ListOutboundConnections(ctxValidOutbounds, &grpc_application_go.AppInstanceId{
 		OrganizationId: addRequest.OrganizationId,
@@ -111,7 +113,7 @@ func (m *Manager) AddConnection(addRequest *grpc_application_network_go.AddConne
 			WithParams(addRequest))
 	}
 
-	ctxSource, cancelSource := common.GetContext()
+	ctxSource, cancelSource := common.GetContextFrom(ctx)
 	defer cancelSource()
 
 	// Source & Outbound
@@ -138,7 +140,7 @@ func (m *Manager) AddConnection(addRequest *grpc_application_network_go.AddConne
 	}
 
 	// Target & Inbound
-	ctxTarget, cancelTarget := common.GetContext()
+	ctxTarget, cancelTarget := common.GetContextFrom(ctx)
 	defer cancelTarget()
 	targetInstance, err := m.appClient.GetAppInstance(ctxTarget, &grpc_application_go.AppInstanceId{
 		OrganizationId: addRequest.OrganizationId,
@@ -165,9 +167,10 @@ func (m *Manager) AddConnection(addRequest *grpc_application_network_go.AddConne
 	}
 
 	// send the message to the queue
-	ctxSend, cancelSend := common.GetContext()
+	ctxSend, cancelSend := common.GetContextFrom(ctx)
 	defer cancelSend()
 	err = m.netOpsProducer.Send(ctxSend, addRequest)
+	metrics.MessageProduced(metrics.NetworkOpsTopic, err)
 	if err != nil {
 		log.Error().Interface("connection", addRequest).Msg("error sending addConnection to the queue")
 		return nil, err
@@ -183,12 +186,12 @@ func (m *Manager) AddConnection(addRequest *grpc_application_network_go.AddConne
 }
 
 // RemoveConnection removes a connection
-func (m *Manager) RemoveConnection(removeRequest *grpc_application_network_go.RemoveConnectionRequest) (*grpc_common_go.OpResponse, error) {
+func (m *Manager) RemoveConnection(ctx context.Context, removeRequest *grpc_application_network_go.RemoveConnectionRequest) (*grpc_common_go.OpResponse, error) {
 
 	// check if the connection exists
-	ctx, cancel := common.GetContext()
+	ctxGet, cancel := common.GetContextFrom(ctx)
 	defer cancel()
-	conn, vErr := m.appNetClient.GetConnection(ctx, &grpc_application_network_go.ConnectionInstanceId{
+	conn, vErr := m.appNetClient.GetConnection(ctxGet, &grpc_application_network_go.ConnectionInstanceId{
 		OrganizationId:   removeRequest.OrganizationId,
 		SourceInstanceId: removeRequest.SourceInstanceId,
 		TargetInstanceId: removeRequest.TargetInstanceId,
@@ -205,9 +208,10 @@ func (m *Manager) RemoveConnection(removeRequest *grpc_application_network_go.Re
 	}
 
 	// send the message to the queue
-	ctxSend, cancelSend := common.GetContext()
+	ctxSend, cancelSend := common.GetContextFrom(ctx)
 	defer cancelSend()
 	err := m.netOpsProducer.Send(ctxSend, removeRequest)
+	metrics.MessageProduced(metrics.NetworkOpsTopic, err)
 	if err != nil {
 		log.Error().Interface("connection", removeRequest).Msg("error sending removeConnection to the queue")
 		return nil, err
@@ -222,9 +226,9 @@ func (m *Manager) RemoveConnection(removeRequest *grpc_application_network_go.Re
 }
 
 // ListConnections retrieves a list all the established connections of an organization
-func (m *Manager) ListConnections(orgID *grpc_organization_go.OrganizationId) (*grpc_application_network_go.ConnectionInstanceList, error) {
-	ctx, cancel := common.GetContext()
+func (m *Manager) ListConnections(ctx context.Context, orgID *grpc_organization_go.OrganizationId) (*grpc_application_network_go.ConnectionInstanceList, error) {
+	ctxGet, cancel := common.GetContextFrom(ctx)
 	defer cancel()
 
-	return m.appNetClient.ListConnections(ctx, orgID)
+	return m.appNetClient.ListConnections(ctxGet, orgID)
 }
@@ -0,0 +1,114 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"context"
+	"fmt"
+	"github.com/nalej/application-manager/internal/pkg/bundle"
+	"github.com/nalej/application-manager/internal/pkg/server/common"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-organization-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/rs/zerolog/log"
+)
+
+// ImportBundle adds all the descriptors of a bundle. The bundle is only imported if all its descriptors are valid,
+// and the descriptors already added are removed if one of them cannot be added.
+func (m *Manager) ImportBundle(ctx context.Context, request *grpc_application_manager_go.ImportBundleRequest) (*grpc_application_go.AppDescriptorList, error) {
+	parsed, errs := bundle.Parse(request.FileName, request.Content, request.OrganizationId)
+	if len(errs) > 0 {
+		params := make([]interface{}, 0, len(errs))
+		for _, err := range errs {
+			params = append(params, err.Error())
+		}
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("invalid bundle").WithParams(params...))
+	}
+
+	added := make([]*grpc_application_go.AppDescriptor, 0, len(parsed.Descriptors))
+	importSaga := NewSaga("import_bundle", DefaultCompensationRetries, DefaultCompensationBackoff)
+	for _, toAdd := range parsed.Descriptors {
+		descriptor := toAdd
+		var addedID *grpc_application_go.AppDescriptorId
+		importSaga.AddStep(fmt.Sprintf("add_%s", descriptor.Name), func() derrors.Error {
+			result, err := m.AddAppDescriptor(ctx, descriptor)
+			if err != nil {
+				log.Error().Err(err).Str("name", descriptor.Name).Msg("error adding descriptor of the bundle")
+				return conversions.ToDerror(err)
+			}
+			addedID = &grpc_application_go.AppDescriptorId{
+				OrganizationId:  result.OrganizationId,
+				AppDescriptorId: result.AppDescriptorId,
+			}
+			added = append(added, result)
+			return nil
+		}, func() derrors.Error {
+			// the compensation must run even if the client cancels the request
+			_, err := m.RemoveAppDescriptor(context.Background(), addedID)
+			if err != nil {
+				return conversions.ToDerror(err)
+			}
+			return nil
+		})
+	}
+
+	result := importSaga.Execute()
+	if result.Outcome != SagaCompleted {
+		return nil, conversions.ToGRPCError(result.ToError())
+	}
+	return &grpc_application_go.AppDescriptorList{Descriptors: added}, nil
+}
+
+// ExportBundle converts a set of descriptors of an organization into a bundle.
+func (m *Manager) ExportBundle(ctx context.Context, request *grpc_application_manager_go.ExportBundleRequest) (*grpc_application_manager_go.ExportedBundle, error) {
+	format := bundle.YAML
+	if request.Format != "" {
+		format = bundle.FormatFromString[request.Format]
+	}
+
+	ctx, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	descriptors := make([]*grpc_application_go.AppDescriptor, 0)
+	if len(request.AppDescriptorIds) == 0 {
+		list, err := m.appClient.ListAppDescriptors(ctx, &grpc_organization_go.OrganizationId{
+			OrganizationId: request.OrganizationId,
+		})
+		if err != nil {
+			return nil, err
+		}
+		descriptors = list.Descriptors
+	} else {
+		for _, appDescriptorID := range request.AppDescriptorIds {
+			descriptor, err := m.appClient.GetAppDescriptor(ctx, &grpc_application_go.AppDescriptorId{
+				OrganizationId:  request.OrganizationId,
+				AppDescriptorId: appDescriptorID,
+			})
+			if err != nil {
+				return nil, err
+			}
+			descriptors = append(descriptors, descriptor)
+		}
+	}
+
+	content, dErr := bundle.ExportDescriptors(descriptors, format)
+	if dErr != nil {
+		return nil, conversions.ToGRPCError(dErr)
+	}
+	return &grpc_application_manager_go.ExportedBundle{Format: bundle.FormatToString[format], Content: content}, nil
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"context"
+	"github.com/nalej/application-manager/internal/pkg/entities"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-application-network-go"
+	"github.com/nalej/grpc-organization-go"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+// DefaultExpansionWorkers with the maximum number of instances whose connections are retrieved concurrently.
+const DefaultExpansionWorkers = 8
+
+// DefaultLookupTimeout with the maximum duration of a single connection lookup. The deadline of the caller is used
+// if it is shorter.
+const DefaultLookupTimeout = 10 * time.Second
+
+// newConnectionFailure creates the failure of a connection lookup.
+func newConnectionFailure(appInstanceID string, direction string, err error) *grpc_application_manager_go.ConnectionLookupFailure {
+	return &grpc_application_manager_go.ConnectionLookupFailure{AppInstanceId: appInstanceID, Direction: direction, Error: err.Error()}
+}
+
+// getInstanceConnections returns the appInstance with the connections field filled. The inbound and outbound
+// connections are retrieved concurrently and the failed lookups are returned along with the instance.
+func (m *Manager) getInstanceConnections(ctx context.Context, instance *grpc_application_go.AppInstance) (*grpc_application_manager_go.AppInstance, []*grpc_application_manager_go.ConnectionLookupFailure) {
+
+	expandInstance := entities.ToAppInstance(instance)
+
+	appInstanceID := &grpc_application_go.AppInstanceId{
+		OrganizationId: instance.OrganizationId,
+		AppInstanceId:  instance.AppInstanceId,
+	}
+
+	var inboundConnections, outboundConnections *grpc_application_network_go.ConnectionInstanceList
+	var inboundErr, outboundErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		ctxLookup, cancel := context.WithTimeout(ctx, DefaultLookupTimeout)
+		defer cancel()
+		inboundConnections, inboundErr = m.appNetClient.ListInboundConnections(ctxLookup, appInstanceID)
+	}()
+	go func() {
+		defer wg.Done()
+		ctxLookup, cancel := context.WithTimeout(ctx, DefaultLookupTimeout)
+		defer cancel()
+		outboundConnections, outboundErr = m.appNetClient.ListOutboundConnections(ctxLookup, appInstanceID)
+	}()
+	wg.Wait()
+
+	failures := make([]*grpc_application_manager_go.ConnectionLookupFailure, 0)
+	if inboundErr != nil {
+		log.Error().Err(inboundErr).Str("instance_id", instance.AppInstanceId).Msg("error getting inbound connections")
+		failures = append(failures, newConnectionFailure(instance.AppInstanceId, entities.InboundDirection, inboundErr))
+	} else if inboundConnections != nil {
+		expandInstance.InboundConnections = inboundConnections.Connections
+	}
+	if outboundErr != nil {
+		log.Error().Err(outboundErr).Str("instance_id", instance.AppInstanceId).Msg("error getting outbound connections")
+		failures = append(failures, newConnectionFailure(instance.AppInstanceId, entities.OutboundDirection, outboundErr))
+	} else if outboundConnections != nil {
+		expandInstance.OutboundConnections = outboundConnections.Connections
+	}
+
+	return expandInstance, failures
+}
+
+// expandConnections returns the instances of an organization with their connections. The connections of the whole
+// organization are retrieved with a single request and, if it fails, the instances are expanded by a bounded pool of
+// workers. The instances whose connections could not be retrieved are returned as failures.
+func (m *Manager) expandConnections(ctx context.Context, organizationID string, instances []*grpc_application_go.AppInstance) ([]*grpc_application_manager_go.AppInstance, []*grpc_application_manager_go.ConnectionLookupFailure) {
+	if len(instances) == 0 {
+		return make([]*grpc_application_manager_go.AppInstance, 0), make([]*grpc_application_manager_go.ConnectionLookupFailure, 0)
+	}
+	if len(instances) > 1 {
+		ctxList, cancel := context.WithTimeout(ctx, DefaultLookupTimeout)
+		defer cancel()
+		connections, err := m.appNetClient.ListConnections(ctxList, &grpc_organization_go.OrganizationId{OrganizationId: organizationID})
+		if err == nil {
+			return assignConnections(instances, connections.Connections), make([]*grpc_application_manager_go.ConnectionLookupFailure, 0)
+		}
+		log.Warn().Err(err).Str("organizationId", organizationID).
+			Msg("cannot list the connections of the organization, retrieving them by instance")
+	}
+	return m.expandConnectionsByInstance(ctx, instances)
+}
+
+// assignConnections fills the connections of the instances from the connections of their organization.
+func assignConnections(instances []*grpc_application_go.AppInstance, connections []*grpc_application_network_go.ConnectionInstance) []*grpc_application_manager_go.AppInstance {
+	inbounds := make(map[string][]*grpc_application_network_go.ConnectionInstance, 0)
+	outbounds := make(map[string][]*grpc_application_network_go.ConnectionInstance, 0)
+	for _, connection := range connections {
+		inbounds[connection.TargetInstanceId] = append(inbounds[connection.TargetInstanceId], connection)
+		outbounds[connection.SourceInstanceId] = append(outbounds[connection.SourceInstanceId], connection)
+	}
+	result := make([]*grpc_application_manager_go.AppInstance, 0, len(instances))
+	for _, instance := range instances {
+		expanded := entities.ToAppInstance(instance)
+		expanded.InboundConnections = inbounds[instance.AppInstanceId]
+		expanded.OutboundConnections = outbounds[instance.AppInstanceId]
+		result = append(result, expanded)
+	}
+	return result
+}
+
+// expandConnectionsByInstance retrieves the connections of each instance with at most DefaultExpansionWorkers
+// concurrent lookups. The instances not expanded before the context is done are returned as failures.
+func (m *Manager) expandConnectionsByInstance(ctx context.Context, instances []*grpc_application_go.AppInstance) ([]*grpc_application_manager_go.AppInstance, []*grpc_application_manager_go.ConnectionLookupFailure) {
+	result := make([]*grpc_application_manager_go.AppInstance, len(instances))
+	failures := make([]*grpc_application_manager_go.ConnectionLookupFailure, 0)
+	var mutex sync.Mutex
+
+	workers := DefaultExpansionWorkers
+	if len(instances) < workers {
+		workers = len(instances)
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				expanded, failed := m.getInstanceConnections(ctx, instances[index])
+				mutex.Lock()
+				result[index] = expanded
+				failures = append(failures, failed...)
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for index, instance := range instances {
+		if ctx.Err() != nil {
+			// the remaining instances are returned without connections
+			result[index] = entities.ToAppInstance(instance)
+			mutex.Lock()
+			failures = append(failures,
+				newConnectionFailure(instance.AppInstanceId, entities.InboundDirection, ctx.Err()),
+				newConnectionFailure(instance.AppInstanceId, entities.OutboundDirection, ctx.Err()))
+			mutex.Unlock()
+			continue
+		}
+		jobs <- index
+	}
+	close(jobs)
+	wg.Wait()
+	return result, failures
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"context"
+	"fmt"
+	"github.com/nalej/application-manager/internal/pkg/entities"
+	"github.com/nalej/application-manager/internal/pkg/utils"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-application-network-go"
+	"github.com/nalej/grpc-organization-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"sync"
+	"time"
+)
+
+// fakeAppNetClient serves the connections of an organization. The methods not overridden panic if called.
+type fakeAppNetClient struct {
+	grpc_application_network_go.ApplicationNetworkClient
+	connections []*grpc_application_network_go.ConnectionInstance
+	// failList makes the listing of the organization connections fail.
+	failList bool
+	// failInstances with the instances whose connections cannot be retrieved.
+	failInstances map[string]bool
+	delay         time.Duration
+
+	mutex   sync.Mutex
+	running int
+	maxRun  int
+}
+
+func (f *fakeAppNetClient) ListConnections(ctx context.Context, in *grpc_organization_go.OrganizationId, opts ...grpc.CallOption) (*grpc_application_network_go.ConnectionInstanceList, error) {
+	if f.failList {
+		return nil, conversions.ToGRPCError(derrors.NewUnavailableError("system model unavailable"))
+	}
+	return &grpc_application_network_go.ConnectionInstanceList{Connections: f.connections}, nil
+}
+
+func (f *fakeAppNetClient) lookup(ctx context.Context, instanceID string, inbound bool) (*grpc_application_network_go.ConnectionInstanceList, error) {
+	f.mutex.Lock()
+	f.running++
+	if f.running > f.maxRun {
+		f.maxRun = f.running
+	}
+	f.mutex.Unlock()
+	defer func() {
+		f.mutex.Lock()
+		f.running--
+		f.mutex.Unlock()
+	}()
+
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if f.failInstances[instanceID] {
+		return nil, conversions.ToGRPCError(derrors.NewUnavailableError("lookup failed"))
+	}
+	result := make([]*grpc_application_network_go.ConnectionInstance, 0)
+	for _, connection := range f.connections {
+		if (inbound && connection.TargetInstanceId == instanceID) || (!inbound && connection.SourceInstanceId == instanceID) {
+			result = append(result, connection)
+		}
+	}
+	return &grpc_application_network_go.ConnectionInstanceList{Connections: result}, nil
+}
+
+func (f *fakeAppNetClient) ListInboundConnections(ctx context.Context, in *grpc_application_go.AppInstanceId, opts ...grpc.CallOption) (*grpc_application_network_go.ConnectionInstanceList, error) {
+	return f.lookup(ctx, in.AppInstanceId, true)
+}
+
+func (f *fakeAppNetClient) ListOutboundConnections(ctx context.Context, in *grpc_application_go.AppInstanceId, opts ...grpc.CallOption) (*grpc_application_network_go.ConnectionInstanceList, error) {
+	return f.lookup(ctx, in.AppInstanceId, false)
+}
+
+var _ = ginkgo.Describe("Connection expansion", func() {
+
+	var instances []*grpc_application_go.AppInstance
+	var client *fakeAppNetClient
+	var manager *Manager
+
+	ginkgo.BeforeEach(func() {
+		instances = make([]*grpc_application_go.AppInstance, 0)
+		for i := 0; i < 20; i++ {
+			instances = append(instances, utils.CreateTestAppInstance("org1", "desc1", fmt.Sprintf("inst%d", i), nil, []string{"g1"}))
+		}
+		client = &fakeAppNetClient{
+			connections: []*grpc_application_network_go.ConnectionInstance{
+				{OrganizationId: "org1", SourceInstanceId: "inst0", TargetInstanceId: "inst1", OutboundName: "out", InboundName: "in"},
+				{OrganizationId: "org1", SourceInstanceId: "inst2", TargetInstanceId: "inst1", OutboundName: "out", InboundName: "in"},
+			},
+			failInstances: make(map[string]bool, 0),
+			delay:         10 * time.Millisecond,
+		}
+		manager = &Manager{appNetClient: client}
+	})
+
+	ginkgo.It("should assign the connections of the organization", func() {
+		expanded, failures := manager.expandConnections(context.Background(), "org1", instances)
+		gomega.Expect(failures).To(gomega.BeEmpty())
+		gomega.Expect(expanded).To(gomega.HaveLen(len(instances)))
+		gomega.Expect(expanded[0].OutboundConnections).To(gomega.HaveLen(1))
+		gomega.Expect(expanded[1].InboundConnections).To(gomega.HaveLen(2))
+		gomega.Expect(expanded[3].InboundConnections).To(gomega.BeEmpty())
+		gomega.Expect(client.maxRun).To(gomega.BeZero())
+	})
+
+	ginkgo.It("should bound the lookups by instance and report the failed ones", func() {
+		client.failList = true
+		client.failInstances["inst2"] = true
+		expanded, failures := manager.expandConnections(context.Background(), "org1", instances)
+		gomega.Expect(expanded).To(gomega.HaveLen(len(instances)))
+		for index, instance := range expanded {
+			gomega.Expect(instance.AppInstanceId).To(gomega.Equal(instances[index].AppInstanceId))
+		}
+		gomega.Expect(expanded[0].OutboundConnections).To(gomega.HaveLen(1))
+		gomega.Expect(expanded[1].InboundConnections).To(gomega.HaveLen(2))
+		gomega.Expect(failures).To(gomega.HaveLen(2))
+		gomega.Expect(failures[0].AppInstanceId).To(gomega.Equal("inst2"))
+		// each worker retrieves the inbound and outbound connections concurrently
+		gomega.Expect(client.maxRun).To(gomega.BeNumerically("<=", 2*DefaultExpansionWorkers))
+		gomega.Expect(client.maxRun).To(gomega.BeNumerically(">", 2))
+	})
+
+	ginkgo.It("should return partial results when the deadline expires", func() {
+		client.failList = true
+		client.delay = time.Second
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		expanded, failures := manager.expandConnections(ctx, "org1", instances)
+		gomega.Expect(time.Since(start)).To(gomega.BeNumerically("<", 500*time.Millisecond))
+		gomega.Expect(expanded).To(gomega.HaveLen(len(instances)))
+		gomega.Expect(failures).To(gomega.HaveLen(2 * len(instances)))
+		gomega.Expect(failures[0].Direction).To(gomega.BeElementOf(entities.InboundDirection, entities.OutboundDirection))
+	})
+
+	ginkgo.It("should stop the lookups when the caller cancels the request", func() {
+		client.delay = time.Second
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		start := time.Now()
+		expanded, failures := manager.getInstanceConnections(ctx, instances[1])
+		gomega.Expect(time.Since(start)).To(gomega.BeNumerically("<", 500*time.Millisecond))
+		gomega.Expect(expanded.AppInstanceId).To(gomega.Equal("inst1"))
+		gomega.Expect(failures).To(gomega.HaveLen(2))
+	})
+})
@@ -0,0 +1,221 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"context"
+	"github.com/nalej/application-manager/internal/pkg/entities"
+	"github.com/nalej/application-manager/internal/pkg/policy"
+	"github.com/nalej/application-manager/internal/pkg/provider/secret"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-conductor-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// createDeployTestDescriptor creates a descriptor with a single service.
+func createDeployTestDescriptor() *grpc_application_go.AppDescriptor {
+	return &grpc_application_go.AppDescriptor{
+		OrganizationId:  "org",
+		AppDescriptorId: "desc",
+		Name:            "app",
+		Groups: []*grpc_application_go.ServiceGroup{{
+			OrganizationId:  "org",
+			AppDescriptorId: "desc",
+			ServiceGroupId:  "g1",
+			Name:            "group",
+			Services: []*grpc_application_go.Service{{
+				OrganizationId:  "org",
+				AppDescriptorId: "desc",
+				ServiceGroupId:  "g1",
+				ServiceId:       "s1",
+				Name:            "service",
+				Image:           "nginx:1.17",
+			}},
+		}},
+	}
+}
+
+var _ = ginkgo.Describe("Deploy saga on the manager", func() {
+
+	var dir string
+	var appClient *fakeApplicationsClient
+	var producer *fakeOpsProducer
+	var manager *Manager
+	var request *grpc_application_manager_go.DeployRequest
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "deploy")
+		gomega.Expect(err).To(gomega.Succeed())
+		secrets, dErr := secret.NewFileProvider(filepath.Join(dir, "secrets.json"), "passphrase")
+		gomega.Expect(dErr).To(gomega.BeNil())
+		appClient = newFakeApplicationsClient(createDeployTestDescriptor())
+		producer = &fakeOpsProducer{}
+		deployments, dErr := NewDeploymentStore(DefaultIdempotencyEntries, DefaultIdempotencyTTL)
+		gomega.Expect(dErr).To(gomega.BeNil())
+		manager = &Manager{
+			appClient:      appClient,
+			appOpsProducer: producer,
+			deployments:    deployments,
+			secrets:        secrets,
+		}
+		request = &grpc_application_manager_go.DeployRequest{
+			OrganizationId:  "org",
+			AppDescriptorId: "desc",
+			Name:            "test-app",
+		}
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	ginkgo.It("should deploy an instance", func() {
+		response, err := manager.Deploy(context.Background(), request)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(response.AppInstanceId).Should(gomega.Equal("instance-id"))
+		gomega.Expect(appClient.instances).Should(gomega.HaveKey("instance-id"))
+		gomega.Expect(appClient.parametrized).Should(gomega.HaveKey("instance-id"))
+		gomega.Expect(producer.sent).Should(gomega.HaveLen(1))
+		sent, ok := producer.sent[0].(*grpc_conductor_go.DeploymentRequest)
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(sent.RequestId).Should(gomega.Equal(response.RequestId))
+	})
+
+	ginkgo.It("should persist the secret references and send the passwords only to the conductor", func() {
+		appClient.descriptor.EnvironmentVariables = map[string]string{"PASSWORD": ""}
+		appClient.descriptor.Parameters = []*grpc_application_go.AppParameter{{
+			Name: "password",
+			Path: "environment_variables.PASSWORD",
+			Type: grpc_application_go.ParamDataType_PASSWORD,
+		}}
+		request.Parameters = &grpc_application_go.InstanceParameterList{Parameters: []*grpc_application_go.InstanceParameter{
+			{ParameterName: "password", Value: "s3cr3t"},
+		}}
+		response, err := manager.Deploy(context.Background(), request)
+		gomega.Expect(err).To(gomega.Succeed())
+		reference := appClient.instances[response.AppInstanceId].Parameters.Parameters[0].Value
+		gomega.Expect(entities.IsSecretReference(reference)).To(gomega.BeTrue())
+		gomega.Expect(appClient.parametrized[response.AppInstanceId].EnvironmentVariables["PASSWORD"]).Should(gomega.Equal(reference))
+		gomega.Expect(appClient.instances[response.AppInstanceId].EnvironmentVariables["PASSWORD"]).Should(gomega.Equal(reference))
+		sent, ok := producer.sent[0].(*grpc_conductor_go.DeploymentRequest)
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(sent.ParametrizedDescriptor.EnvironmentVariables["PASSWORD"]).Should(gomega.Equal("s3cr3t"))
+	})
+
+	ginkgo.It("should record the descriptor revision on the instance", func() {
+		response, err := manager.Deploy(context.Background(), request)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(appClient.revisions).Should(gomega.HaveLen(1))
+		gomega.Expect(appClient.instances[response.AppInstanceId].DescriptorRevision).Should(gomega.Equal(int64(1)))
+	})
+
+	ginkgo.It("should deploy a pinned descriptor revision", func() {
+		_, err := appClient.AddAppDescriptorRevision(context.Background(), appClient.descriptor)
+		gomega.Expect(err).To(gomega.Succeed())
+		appClient.descriptor.Groups[0].Services[0].Image = "nginx:1.19"
+		_, err = appClient.AddAppDescriptorRevision(context.Background(), appClient.descriptor)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		request.DescriptorRevision = 1
+		response, err := manager.Deploy(context.Background(), request)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(appClient.instances[response.AppInstanceId].DescriptorRevision).Should(gomega.Equal(int64(1)))
+		parametrized := appClient.parametrized[response.AppInstanceId]
+		gomega.Expect(parametrized.Groups[0].Services[0].Image).Should(gomega.Equal("nginx:1.17"))
+	})
+
+	ginkgo.It("should return the original deployment when the idempotency key is retried", func() {
+		request.IdempotencyKey = "key1"
+		response, err := manager.Deploy(context.Background(), request)
+		gomega.Expect(err).To(gomega.Succeed())
+		retried, err := manager.Deploy(context.Background(), request)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(retried).Should(gomega.Equal(response))
+		gomega.Expect(producer.sent).Should(gomega.HaveLen(1))
+	})
+
+	ginkgo.It("should not leave anything behind if the instance cannot be added", func() {
+		appClient.failOn("AddAppInstance")
+		_, err := manager.Deploy(context.Background(), request)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		gomega.Expect(appClient.called("RemoveAppInstance")).To(gomega.BeFalse())
+		gomega.Expect(appClient.called("RemoveParametrizedDescriptor")).To(gomega.BeFalse())
+		gomega.Expect(producer.sent).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should remove the instance if the parametrized descriptor cannot be added", func() {
+		appClient.failOn("AddParametrizedDescriptor")
+		_, err := manager.Deploy(context.Background(), request)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		gomega.Expect(appClient.called("RemoveAppInstance")).To(gomega.BeTrue())
+		gomega.Expect(appClient.called("RemoveParametrizedDescriptor")).To(gomega.BeFalse())
+		gomega.Expect(appClient.instances).Should(gomega.BeEmpty())
+		gomega.Expect(producer.sent).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should remove the instance and its parametrized descriptor if the instance cannot be updated", func() {
+		appClient.failOn("UpdateAppInstance")
+		_, err := manager.Deploy(context.Background(), request)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		gomega.Expect(appClient.called("RemoveParametrizedDescriptor")).To(gomega.BeTrue())
+		gomega.Expect(appClient.called("RemoveAppInstance")).To(gomega.BeTrue())
+		gomega.Expect(appClient.instances).Should(gomega.BeEmpty())
+		gomega.Expect(appClient.parametrized).Should(gomega.BeEmpty())
+		gomega.Expect(producer.sent).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should remove the instance and its parametrized descriptor if the request cannot be sent", func() {
+		producer.fail = true
+		_, err := manager.Deploy(context.Background(), request)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		gomega.Expect(appClient.called("RemoveParametrizedDescriptor")).To(gomega.BeTrue())
+		gomega.Expect(appClient.called("RemoveAppInstance")).To(gomega.BeTrue())
+		gomega.Expect(appClient.instances).Should(gomega.BeEmpty())
+		gomega.Expect(appClient.parametrized).Should(gomega.BeEmpty())
+		gomega.Expect(appClient.statusUpdates).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should flag the instance that cannot be removed", func() {
+		producer.fail = true
+		appClient.failOn("RemoveAppInstance")
+		_, err := manager.Deploy(context.Background(), request)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		gomega.Expect(appClient.called("RemoveParametrizedDescriptor")).To(gomega.BeTrue())
+		gomega.Expect(appClient.statusUpdates).Should(gomega.HaveLen(1))
+		gomega.Expect(appClient.statusUpdates[0].AppInstanceId).Should(gomega.Equal("instance-id"))
+		gomega.Expect(appClient.statusUpdates[0].Status).Should(gomega.Equal(grpc_application_go.ApplicationStatus_DEPLOYMENT_ERROR))
+		gomega.Expect(appClient.statusUpdates[0].Info).Should(gomega.ContainSubstring(SendDeploymentRequestStep))
+	})
+
+	ginkgo.It("should return the warnings of the policies in the trailer", func() {
+		warn, dErr := policy.Parse([]byte("name: pinned\naction: warn\nphases: [deploy]\nrules:\n- forbidden_images: [\"nginx:*\"]"))
+		gomega.Expect(dErr).To(gomega.BeNil())
+		manager.policies = policy.NewEngine(warn)
+		stream := &fakeTransportStream{}
+		_, err := manager.Deploy(grpc.NewContextWithServerTransportStream(context.Background(), stream), request)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(stream.trailer.Get(PolicyWarningTrailer)).Should(gomega.HaveLen(1))
+		gomega.Expect(stream.trailer.Get(PolicyWarningTrailer)[0]).Should(gomega.ContainSubstring("pinned"))
+	})
+})
@@ -20,6 +20,7 @@ import (
 	"github.com/nalej/derrors"
 	"github.com/nalej/grpc-application-go"
 	"github.com/nalej/grpc-application-manager-go"
+	"strings"
 )
 
 func compareDeviceGroupId(instance *grpc_application_go.AppInstance, filter *grpc_application_manager_go.ApplicationFilter) bool {
@@ -41,8 +42,8 @@ func compareDeviceGroupId(instance *grpc_application_go.AppInstance, filter *grp
 }
 
 func compareLabels(instance *grpc_application_go.AppInstance, filter *grpc_application_manager_go.ApplicationFilter) bool {
-	// if filter has no labels to match -> true
-	if filter.MatchLabels == nil {
+	// if filter has no labels to match or they are replaced by the label selector -> true
+	if filter.MatchLabels == nil || strings.TrimSpace(filter.LabelSelector) != "" {
 		return true
 	}
 	// if instance has no labels (and filter does) -> false
@@ -51,13 +52,7 @@ func compareLabels(instance *grpc_application_go.AppInstance, filter *grpc_appli
 	}
 
 	// match labels...
-	for key, value := range filter.MatchLabels {
-		label, ok := instance.Labels[key]
-		if !ok || label != value {
-			return false
-		}
-	}
-	return true
+	return NewSelectorFromLabels(filter.MatchLabels).Matches(instance.Labels)
 
 }
 
@@ -79,6 +74,22 @@ func ApplyFilter(appList *grpc_application_go.AppInstanceList, filter *grpc_appl
 
 }
 
+// ApplySelector filters out applications whose labels do not satisfy a label selector.
+func ApplySelector(appList *grpc_application_go.AppInstanceList, selector *Selector) *grpc_application_go.AppInstanceList {
+	if selector == nil || selector.Empty() {
+		return appList
+	}
+	appInstances := make([]*grpc_application_go.AppInstance, 0)
+	for _, instance := range appList.Instances {
+		if selector.Matches(instance.Labels) {
+			appInstances = append(appInstances, instance)
+		}
+	}
+	return &grpc_application_go.AppInstanceList{
+		Instances: appInstances,
+	}
+}
+
 // ToApplicationLabelsList transforms the result from the filter into the TargetApplications object.
 func ToApplicationLabelsList(appList *grpc_application_go.AppInstanceList) (*grpc_application_manager_go.TargetApplicationList, derrors.Error) {
 
@@ -102,6 +102,17 @@ var _ = ginkgo.Describe("Endpoints utils", func() {
 			gomega.Expect(len(result.Instances)).Should(gomega.Equal(2))
 		})
 
+		ginkgo.It("should ignore the match labels replaced by a label selector", func() {
+			var filter = &grpc_application_manager_go.ApplicationFilter{
+				OrganizationId:  "org1",
+				DeviceGroupName: "g1",
+				MatchLabels:     map[string]string{"l1": "other"},
+				LabelSelector:   "l1",
+			}
+			result := ApplyFilter(allApps, filter)
+			gomega.Expect(len(result.Instances)).Should(gomega.Equal(2))
+		})
+
 	})
 
 	ginkgo.Context("ToApplicationLabelsList", func() {
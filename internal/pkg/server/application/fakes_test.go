@@ -0,0 +1,391 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"context"
+	"github.com/golang/protobuf/proto"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-application-network-go"
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/grpc-conductor-go"
+	"github.com/nalej/grpc-organization-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeApplicationsClient is an in-memory system-model used to test the manager operations. The methods not
+// implemented panic through the embedded nil interface. The calls are recorded by method name and any of them can
+// be made to fail.
+type fakeApplicationsClient struct {
+	grpc_application_go.ApplicationsClient
+	sync.Mutex
+	descriptor    *grpc_application_go.AppDescriptor
+	instances     map[string]*grpc_application_go.AppInstance
+	parametrized  map[string]*grpc_application_go.ParametrizedDescriptor
+	revisions     []*grpc_application_go.AppDescriptorRevision
+	calls         []string
+	failing       map[string]bool
+	remaining     map[string]int
+	statusUpdates []*grpc_application_go.UpdateAppStatusRequest
+	// instanceFilter with the last filter received by ListAppInstancesWithFilter.
+	instanceFilter *grpc_application_go.AppInstanceFilter
+}
+
+func newFakeApplicationsClient(descriptor *grpc_application_go.AppDescriptor) *fakeApplicationsClient {
+	return &fakeApplicationsClient{
+		descriptor:   descriptor,
+		instances:    make(map[string]*grpc_application_go.AppInstance, 0),
+		parametrized: make(map[string]*grpc_application_go.ParametrizedDescriptor, 0),
+		revisions:    make([]*grpc_application_go.AppDescriptorRevision, 0),
+		calls:        make([]string, 0),
+		failing:      make(map[string]bool, 0),
+		remaining:    make(map[string]int, 0),
+	}
+}
+
+// failOn makes the calls to a method fail.
+func (f *fakeApplicationsClient) failOn(method string) {
+	f.Lock()
+	defer f.Unlock()
+	f.failing[method] = true
+}
+
+// failAfter makes the calls to a method fail once it has been called a given number of times.
+func (f *fakeApplicationsClient) failAfter(method string, calls int) {
+	f.Lock()
+	defer f.Unlock()
+	f.remaining[method] = calls
+}
+
+// called checks if a method has been called.
+func (f *fakeApplicationsClient) called(method string) bool {
+	f.Lock()
+	defer f.Unlock()
+	for _, call := range f.calls {
+		if call == method {
+			return true
+		}
+	}
+	return false
+}
+
+// record stores a call and returns an error if the method has been set to fail.
+func (f *fakeApplicationsClient) record(method string) error {
+	f.calls = append(f.calls, method)
+	if remaining, limited := f.remaining[method]; limited {
+		if remaining == 0 {
+			f.failing[method] = true
+		}
+		f.remaining[method] = remaining - 1
+	}
+	if f.failing[method] {
+		return conversions.ToGRPCError(derrors.NewUnavailableError("injected failure").WithParams(method))
+	}
+	return nil
+}
+
+func (f *fakeApplicationsClient) GetAppDescriptor(ctx context.Context, in *grpc_application_go.AppDescriptorId, opts ...grpc.CallOption) (*grpc_application_go.AppDescriptor, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.record("GetAppDescriptor"); err != nil {
+		return nil, err
+	}
+	return proto.Clone(f.descriptor).(*grpc_application_go.AppDescriptor), nil
+}
+
+func (f *fakeApplicationsClient) AddAppDescriptorRevision(ctx context.Context, in *grpc_application_go.AppDescriptor, opts ...grpc.CallOption) (*grpc_application_go.AppDescriptorRevision, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.record("AddAppDescriptorRevision"); err != nil {
+		return nil, err
+	}
+	revision := &grpc_application_go.AppDescriptorRevision{
+		OrganizationId:  in.OrganizationId,
+		AppDescriptorId: in.AppDescriptorId,
+		Revision:        int64(len(f.revisions) + 1),
+		Descriptor:      proto.Clone(in).(*grpc_application_go.AppDescriptor),
+	}
+	f.revisions = append(f.revisions, revision)
+	return proto.Clone(revision).(*grpc_application_go.AppDescriptorRevision), nil
+}
+
+func (f *fakeApplicationsClient) GetAppDescriptorRevision(ctx context.Context, in *grpc_application_go.AppDescriptorRevisionId, opts ...grpc.CallOption) (*grpc_application_go.AppDescriptorRevision, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.record("GetAppDescriptorRevision"); err != nil {
+		return nil, err
+	}
+	if in.Revision < 1 || in.Revision > int64(len(f.revisions)) {
+		return nil, conversions.ToGRPCError(derrors.NewNotFoundError("descriptor revision").WithParams(in.Revision))
+	}
+	return proto.Clone(f.revisions[in.Revision-1]).(*grpc_application_go.AppDescriptorRevision), nil
+}
+
+func (f *fakeApplicationsClient) GetLatestAppDescriptorRevision(ctx context.Context, in *grpc_application_go.AppDescriptorId, opts ...grpc.CallOption) (*grpc_application_go.AppDescriptorRevision, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.record("GetLatestAppDescriptorRevision"); err != nil {
+		return nil, err
+	}
+	if len(f.revisions) == 0 {
+		return nil, conversions.ToGRPCError(derrors.NewNotFoundError("descriptor revision").WithParams(in.AppDescriptorId))
+	}
+	return proto.Clone(f.revisions[len(f.revisions)-1]).(*grpc_application_go.AppDescriptorRevision), nil
+}
+
+func (f *fakeApplicationsClient) AddAppInstance(ctx context.Context, in *grpc_application_go.AddAppInstanceRequest, opts ...grpc.CallOption) (*grpc_application_go.AppInstance, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.record("AddAppInstance"); err != nil {
+		return nil, err
+	}
+	instance := &grpc_application_go.AppInstance{
+		OrganizationId:     in.OrganizationId,
+		AppDescriptorId:    in.AppDescriptorId,
+		AppInstanceId:      "instance-id",
+		Name:               in.Name,
+		Parameters:         in.Parameters,
+		Status:             grpc_application_go.ApplicationStatus_QUEUED,
+		DescriptorRevision: in.DescriptorRevision,
+		Created:            time.Now().Unix(),
+	}
+	f.instances[instance.AppInstanceId] = instance
+	return proto.Clone(instance).(*grpc_application_go.AppInstance), nil
+}
+
+func (f *fakeApplicationsClient) GetAppInstance(ctx context.Context, in *grpc_application_go.AppInstanceId, opts ...grpc.CallOption) (*grpc_application_go.AppInstance, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.record("GetAppInstance"); err != nil {
+		return nil, err
+	}
+	instance, exists := f.instances[in.AppInstanceId]
+	if !exists {
+		return nil, conversions.ToGRPCError(derrors.NewNotFoundError("instance").WithParams(in.AppInstanceId))
+	}
+	return proto.Clone(instance).(*grpc_application_go.AppInstance), nil
+}
+
+func (f *fakeApplicationsClient) ListAppInstances(ctx context.Context, in *grpc_organization_go.OrganizationId, opts ...grpc.CallOption) (*grpc_application_go.AppInstanceList, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.record("ListAppInstances"); err != nil {
+		return nil, err
+	}
+	instances := make([]*grpc_application_go.AppInstance, 0, len(f.instances))
+	for _, instance := range f.instances {
+		if instance.OrganizationId == in.OrganizationId {
+			instances = append(instances, proto.Clone(instance).(*grpc_application_go.AppInstance))
+		}
+	}
+	return &grpc_application_go.AppInstanceList{Instances: instances}, nil
+}
+
+func (f *fakeApplicationsClient) ListAppInstancesWithFilter(ctx context.Context, in *grpc_application_go.AppInstanceFilter, opts ...grpc.CallOption) (*grpc_application_go.AppInstanceList, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.record("ListAppInstancesWithFilter"); err != nil {
+		return nil, err
+	}
+	f.instanceFilter = in
+	instances := make([]*grpc_application_go.AppInstance, 0, len(f.instances))
+	for _, instance := range f.instances {
+		if instance.OrganizationId != in.OrganizationId ||
+			(in.AppDescriptorId != "" && instance.AppDescriptorId != in.AppDescriptorId) ||
+			!strings.HasPrefix(instance.Name, in.NamePrefix) ||
+			(in.CreatedAfter != 0 && instance.Created < in.CreatedAfter) ||
+			(in.CreatedBefore != 0 && instance.Created >= in.CreatedBefore) ||
+			!NewSelectorFromLabels(in.Labels).Matches(instance.Labels) {
+			continue
+		}
+		instances = append(instances, proto.Clone(instance).(*grpc_application_go.AppInstance))
+	}
+	return &grpc_application_go.AppInstanceList{Instances: instances}, nil
+}
+
+func (f *fakeApplicationsClient) UpdateAppInstance(ctx context.Context, in *grpc_application_go.AppInstance, opts ...grpc.CallOption) (*grpc_common_go.Success, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.record("UpdateAppInstance"); err != nil {
+		return nil, err
+	}
+	f.instances[in.AppInstanceId] = proto.Clone(in).(*grpc_application_go.AppInstance)
+	return &grpc_common_go.Success{}, nil
+}
+
+func (f *fakeApplicationsClient) UpdateAppStatus(ctx context.Context, in *grpc_application_go.UpdateAppStatusRequest, opts ...grpc.CallOption) (*grpc_common_go.Success, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.record("UpdateAppStatus"); err != nil {
+		return nil, err
+	}
+	if instance, exists := f.instances[in.AppInstanceId]; exists {
+		instance.Status = in.Status
+	}
+	f.statusUpdates = append(f.statusUpdates, in)
+	return &grpc_common_go.Success{}, nil
+}
+
+func (f *fakeApplicationsClient) RemoveAppInstance(ctx context.Context, in *grpc_application_go.AppInstanceId, opts ...grpc.CallOption) (*grpc_common_go.Success, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.record("RemoveAppInstance"); err != nil {
+		return nil, err
+	}
+	delete(f.instances, in.AppInstanceId)
+	return &grpc_common_go.Success{}, nil
+}
+
+func (f *fakeApplicationsClient) AddParametrizedDescriptor(ctx context.Context, in *grpc_application_go.ParametrizedDescriptor, opts ...grpc.CallOption) (*grpc_application_go.ParametrizedDescriptor, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.record("AddParametrizedDescriptor"); err != nil {
+		return nil, err
+	}
+	f.parametrized[in.AppInstanceId] = proto.Clone(in).(*grpc_application_go.ParametrizedDescriptor)
+	return proto.Clone(in).(*grpc_application_go.ParametrizedDescriptor), nil
+}
+
+func (f *fakeApplicationsClient) GetParametrizedDescriptor(ctx context.Context, in *grpc_application_go.AppInstanceId, opts ...grpc.CallOption) (*grpc_application_go.ParametrizedDescriptor, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.record("GetParametrizedDescriptor"); err != nil {
+		return nil, err
+	}
+	parametrized, exists := f.parametrized[in.AppInstanceId]
+	if !exists {
+		return nil, conversions.ToGRPCError(derrors.NewNotFoundError("parametrized descriptor").WithParams(in.AppInstanceId))
+	}
+	return proto.Clone(parametrized).(*grpc_application_go.ParametrizedDescriptor), nil
+}
+
+func (f *fakeApplicationsClient) UpdateParametrizedDescriptor(ctx context.Context, in *grpc_application_go.ParametrizedDescriptor, opts ...grpc.CallOption) (*grpc_common_go.Success, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.record("UpdateParametrizedDescriptor"); err != nil {
+		return nil, err
+	}
+	if _, exists := f.parametrized[in.AppInstanceId]; !exists {
+		return nil, conversions.ToGRPCError(derrors.NewNotFoundError("parametrized descriptor").WithParams(in.AppInstanceId))
+	}
+	f.parametrized[in.AppInstanceId] = proto.Clone(in).(*grpc_application_go.ParametrizedDescriptor)
+	return &grpc_common_go.Success{}, nil
+}
+
+func (f *fakeApplicationsClient) GetInstanceParameters(ctx context.Context, in *grpc_application_go.AppInstanceId, opts ...grpc.CallOption) (*grpc_application_go.InstanceParameterList, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.record("GetInstanceParameters"); err != nil {
+		return nil, err
+	}
+	instance, exists := f.instances[in.AppInstanceId]
+	if !exists {
+		return nil, conversions.ToGRPCError(derrors.NewNotFoundError("instance").WithParams(in.AppInstanceId))
+	}
+	if instance.Parameters == nil {
+		return &grpc_application_go.InstanceParameterList{}, nil
+	}
+	return proto.Clone(instance.Parameters).(*grpc_application_go.InstanceParameterList), nil
+}
+
+func (f *fakeApplicationsClient) RemoveParametrizedDescriptor(ctx context.Context, in *grpc_application_go.AppInstanceId, opts ...grpc.CallOption) (*grpc_common_go.Success, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.record("RemoveParametrizedDescriptor"); err != nil {
+		return nil, err
+	}
+	delete(f.parametrized, in.AppInstanceId)
+	return &grpc_common_go.Success{}, nil
+}
+
+// fakeApplicationNetworkClient returns instances without connections.
+type fakeApplicationNetworkClient struct {
+	grpc_application_network_go.ApplicationNetworkClient
+}
+
+func (f *fakeApplicationNetworkClient) ListInboundConnections(ctx context.Context, in *grpc_application_go.AppInstanceId, opts ...grpc.CallOption) (*grpc_application_network_go.ConnectionInstanceList, error) {
+	return &grpc_application_network_go.ConnectionInstanceList{}, nil
+}
+
+func (f *fakeApplicationNetworkClient) ListOutboundConnections(ctx context.Context, in *grpc_application_go.AppInstanceId, opts ...grpc.CallOption) (*grpc_application_network_go.ConnectionInstanceList, error) {
+	return &grpc_application_network_go.ConnectionInstanceList{}, nil
+}
+
+// fakeOpsProducer records the operations sent to the conductor.
+type fakeOpsProducer struct {
+	sync.Mutex
+	sent []proto.Message
+	fail bool
+}
+
+func (f *fakeOpsProducer) Send(ctx context.Context, msg proto.Message) derrors.Error {
+	f.Lock()
+	defer f.Unlock()
+	if f.fail {
+		return derrors.NewUnavailableError("injected failure")
+	}
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+// fakeWatchStream records the updates sent to a watcher.
+type fakeWatchStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent chan *grpc_conductor_go.DeploymentServiceUpdateRequest
+}
+
+func newFakeWatchStream(ctx context.Context) *fakeWatchStream {
+	return &fakeWatchStream{ctx: ctx, sent: make(chan *grpc_conductor_go.DeploymentServiceUpdateRequest, DefaultWatchBufferSize)}
+}
+
+func (f *fakeWatchStream) Send(update *grpc_conductor_go.DeploymentServiceUpdateRequest) error {
+	f.sent <- update
+	return nil
+}
+
+func (f *fakeWatchStream) Context() context.Context {
+	return f.ctx
+}
+
+// fakeTransportStream records the trailer set by a unary call.
+type fakeTransportStream struct {
+	trailer metadata.MD
+}
+
+func (f *fakeTransportStream) Method() string {
+	return "fake"
+}
+
+func (f *fakeTransportStream) SetHeader(md metadata.MD) error {
+	return nil
+}
+
+func (f *fakeTransportStream) SendHeader(md metadata.MD) error {
+	return nil
+}
+
+func (f *fakeTransportStream) SetTrailer(md metadata.MD) error {
+	f.trailer = metadata.Join(f.trailer, md)
+	return nil
+}
@@ -19,12 +19,14 @@ package application
 import (
 	"context"
 	"github.com/nalej/application-manager/internal/pkg/entities"
+	"github.com/nalej/application-manager/internal/pkg/metrics"
 	"github.com/nalej/grpc-application-go"
 	"github.com/nalej/grpc-application-manager-go"
 	"github.com/nalej/grpc-common-go"
 	"github.com/nalej/grpc-organization-go"
 	"github.com/nalej/grpc-utils/pkg/conversions"
 	"github.com/rs/zerolog/log"
+	"time"
 )
 
 // Handler structure for the user requests.
@@ -41,11 +43,12 @@ func NewHandler(manager Manager) *Handler {
 func (h *Handler) AddAppDescriptor(ctx context.Context, addDescriptorRequest *grpc_application_go.AddAppDescriptorRequest) (*grpc_application_go.AppDescriptor, error) {
 	log.Debug().Str("organizationID", addDescriptorRequest.OrganizationId).
 		Str("name", addDescriptorRequest.Name).Msg("add application descriptor")
-	vErr := entities.ValidAddAppDescriptorRequest(addDescriptorRequest)
-	if vErr != nil {
-		return nil, conversions.ToGRPCError(vErr)
+	// all the violations are returned to the client as error details
+	violations := entities.CollectDescriptorViolations(addDescriptorRequest)
+	if !violations.Empty() {
+		return nil, violations.ToGRPCError()
 	}
-	return h.Manager.AddAppDescriptor(addDescriptorRequest)
+	return h.Manager.AddAppDescriptor(ctx, addDescriptorRequest)
 }
 
 // ListAppDescriptors retrieves a list of application descriptors.
@@ -54,7 +57,16 @@ func (h *Handler) ListAppDescriptors(ctx context.Context, organizationID *grpc_o
 	if vErr != nil {
 		return nil, conversions.ToGRPCError(vErr)
 	}
-	return h.Manager.ListAppDescriptors(organizationID)
+	return h.Manager.ListAppDescriptors(ctx, organizationID)
+}
+
+// ListAppDescriptorsPage retrieves a page of the descriptors of an organization that match a filter.
+func (h *Handler) ListAppDescriptorsPage(ctx context.Context, request *grpc_application_manager_go.ListAppDescriptorsRequest) (*grpc_application_manager_go.AppDescriptorPage, error) {
+	vErr := entities.ValidListAppDescriptorsRequest(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	return h.Manager.ListAppDescriptorsPage(ctx, request)
 }
 
 // GetAppDescriptor retrieves a given application descriptor.
@@ -63,7 +75,7 @@ func (h *Handler) GetAppDescriptor(ctx context.Context, appDescriptorID *grpc_ap
 	if vErr != nil {
 		return nil, conversions.ToGRPCError(vErr)
 	}
-	return h.Manager.GetAppDescriptor(appDescriptorID)
+	return h.Manager.GetAppDescriptor(ctx, appDescriptorID)
 }
 
 // UpdateAppDescriptor allows the user to update the information of a registered descriptor.
@@ -72,7 +84,7 @@ func (h *Handler) UpdateAppDescriptor(ctx context.Context, request *grpc_applica
 	if vErr != nil {
 		return nil, conversions.ToGRPCError(vErr)
 	}
-	return h.Manager.UpdateAppDescriptor(request)
+	return h.Manager.UpdateAppDescriptor(ctx, request)
 }
 
 // RemoveAppDescriptor removes an application descriptor from the system.
@@ -81,10 +93,66 @@ func (h *Handler) RemoveAppDescriptor(ctx context.Context, appDescriptorID *grpc
 	if vErr != nil {
 		return nil, conversions.ToGRPCError(vErr)
 	}
-	return h.Manager.RemoveAppDescriptor(appDescriptorID)
+	return h.Manager.RemoveAppDescriptor(ctx, appDescriptorID)
+}
+
+// ListAppDescriptorRevisions retrieves the revisions of an application descriptor.
+func (h *Handler) ListAppDescriptorRevisions(ctx context.Context, appDescriptorID *grpc_application_go.AppDescriptorId) (*grpc_application_go.AppDescriptorRevisionList, error) {
+	vErr := entities.ValidAppDescriptorID(appDescriptorID)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	return h.Manager.ListAppDescriptorRevisions(ctx, appDescriptorID)
+}
+
+// GetAppDescriptorRevision retrieves a given revision of an application descriptor.
+func (h *Handler) GetAppDescriptorRevision(ctx context.Context, revisionID *grpc_application_go.AppDescriptorRevisionId) (*grpc_application_go.AppDescriptorRevision, error) {
+	vErr := entities.ValidDescriptorRevisionId(revisionID)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	return h.Manager.GetAppDescriptorRevision(ctx, revisionID)
+}
+
+// DiffAppDescriptorRevisions returns the changes between two revisions of an application descriptor.
+func (h *Handler) DiffAppDescriptorRevisions(ctx context.Context, request *grpc_application_manager_go.DiffAppDescriptorRevisionsRequest) (*grpc_application_manager_go.AppDescriptorDiff, error) {
+	vErr := entities.ValidDiffRevisionsRequest(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	return h.Manager.DiffAppDescriptorRevisions(ctx, request)
+}
+
+// ListInstanceRevisions retrieves the descriptor revision of the running instances of a descriptor.
+func (h *Handler) ListInstanceRevisions(ctx context.Context, appDescriptorID *grpc_application_go.AppDescriptorId) (*grpc_application_manager_go.InstanceRevisionList, error) {
+	vErr := entities.ValidAppDescriptorID(appDescriptorID)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	return h.Manager.ListInstanceRevisions(ctx, appDescriptorID)
 }
 
-// Deploy an application descriptor.
+// ImportBundle adds the descriptors of a bundle document.
+func (h *Handler) ImportBundle(ctx context.Context, request *grpc_application_manager_go.ImportBundleRequest) (*grpc_application_go.AppDescriptorList, error) {
+	log.Debug().Str("organizationID", request.OrganizationId).Str("fileName", request.FileName).Msg("import bundle")
+	vErr := entities.ValidImportBundleRequest(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	return h.Manager.ImportBundle(ctx, request)
+}
+
+// ExportBundle converts descriptors of an organization into a bundle document.
+func (h *Handler) ExportBundle(ctx context.Context, request *grpc_application_manager_go.ExportBundleRequest) (*grpc_application_manager_go.ExportedBundle, error) {
+	vErr := entities.ValidExportBundleRequest(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	return h.Manager.ExportBundle(ctx, request)
+}
+
+// Deploy an application descriptor. Clients may include an idempotency key in the request so retried requests return
+// the original deployment, and a descriptor revision to deploy a previous definition.
 func (h *Handler) Deploy(ctx context.Context, deployRequest *grpc_application_manager_go.DeployRequest) (*grpc_application_manager_go.DeploymentResponse, error) {
 	log.Debug().Str("organizationID", deployRequest.OrganizationId).
 		Str("appDescriptorId", deployRequest.AppDescriptorId).Msg("deploy application")
@@ -92,7 +160,36 @@ func (h *Handler) Deploy(ctx context.Context, deployRequest *grpc_application_ma
 	if vErr != nil {
 		return nil, conversions.ToGRPCError(vErr)
 	}
-	return h.Manager.Deploy(deployRequest)
+	start := time.Now()
+	response, err := h.Manager.Deploy(ctx, deployRequest)
+	metrics.ObserveOperation(metrics.DeployOperation, start, err)
+	return response, err
+}
+
+// DryRunDeploy validates a deploy request and returns what the deployment would create without deploying it.
+func (h *Handler) DryRunDeploy(ctx context.Context, deployRequest *grpc_application_manager_go.DeployRequest) (*grpc_application_manager_go.DeploymentPlan, error) {
+	log.Debug().Str("organizationID", deployRequest.OrganizationId).
+		Str("appDescriptorId", deployRequest.AppDescriptorId).Msg("dry run deploy application")
+	vErr := entities.ValidDeployRequest(deployRequest)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	plan, err := h.Manager.DryRunDeploy(ctx, deployRequest)
+	if err != nil {
+		return nil, err
+	}
+	return plan.ToGRPC(deployRequest), nil
+}
+
+// UpgradeAppInstance updates a running instance to a new descriptor revision or parameter set keeping its connections.
+func (h *Handler) UpgradeAppInstance(ctx context.Context, request *grpc_application_manager_go.UpgradeAppInstanceRequest) (*grpc_application_manager_go.DeploymentResponse, error) {
+	log.Debug().Str("organizationID", request.OrganizationId).
+		Str("appInstanceId", request.AppInstanceId).Msg("upgrade application instance")
+	vErr := entities.ValidUpgradeAppInstanceRequest(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	return h.Manager.UpgradeAppInstance(ctx, request)
 }
 
 // Undeploy a running application instance.
@@ -103,7 +200,10 @@ func (h *Handler) Undeploy(ctx context.Context, undeployRequest *grpc_applicatio
 	if vErr != nil {
 		return nil, conversions.ToGRPCError(vErr)
 	}
-	return h.Manager.Undeploy(undeployRequest)
+	start := time.Now()
+	response, err := h.Manager.Undeploy(ctx, undeployRequest)
+	metrics.ObserveOperation(metrics.UndeployOperation, start, err)
+	return response, err
 }
 
 // ListAppInstances retrieves a list of application descriptors.
@@ -112,7 +212,16 @@ func (h *Handler) ListAppInstances(ctx context.Context, organizationID *grpc_org
 	if vErr != nil {
 		return nil, conversions.ToGRPCError(vErr)
 	}
-	return h.Manager.ListAppInstances(organizationID)
+	return h.Manager.ListAppInstances(ctx, organizationID)
+}
+
+// ListAppInstancesPage retrieves a page of the instances of an organization that match a filter.
+func (h *Handler) ListAppInstancesPage(ctx context.Context, request *grpc_application_manager_go.ListAppInstancesRequest) (*grpc_application_manager_go.AppInstancePage, error) {
+	vErr := entities.ValidListAppInstancesRequest(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	return h.Manager.ListAppInstancesPage(ctx, request)
 }
 
 // GetAppDescriptor retrieves a given application descriptor.
@@ -121,7 +230,25 @@ func (h *Handler) GetAppInstance(ctx context.Context, appInstanceID *grpc_applic
 	if vErr != nil {
 		return nil, conversions.ToGRPCError(vErr)
 	}
-	return h.Manager.GetAppInstance(appInstanceID)
+	return h.Manager.GetAppInstance(ctx, appInstanceID)
+}
+
+// WatchAppInstance streams the service updates of an application instance until it reaches a terminal state.
+func (h *Handler) WatchAppInstance(appInstanceID *grpc_application_go.AppInstanceId, stream grpc_application_manager_go.ApplicationManager_WatchAppInstanceServer) error {
+	vErr := entities.ValidAppInstanceID(appInstanceID)
+	if vErr != nil {
+		return conversions.ToGRPCError(vErr)
+	}
+	return h.Manager.WatchAppInstance(appInstanceID, stream)
+}
+
+// GetQuotaUsage retrieves the resources used by an organization against its quotas.
+func (h *Handler) GetQuotaUsage(ctx context.Context, organizationID *grpc_organization_go.OrganizationId) (*grpc_application_manager_go.QuotaUsageReport, error) {
+	vErr := entities.ValidOrganizationId(organizationID)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	return h.Manager.GetQuotaUsage(ctx, organizationID)
 }
 
 // ListInstanceParameters retrieves a list of instance parameters
@@ -130,7 +257,7 @@ func (h *Handler) ListInstanceParameters(ctx context.Context, appInstanceID *grp
 	if vErr != nil {
 		return nil, conversions.ToGRPCError(vErr)
 	}
-	return h.Manager.ListInstanceParameters(appInstanceID)
+	return h.Manager.ListInstanceParameters(ctx, appInstanceID)
 }
 
 func (h *Handler) ListDescriptorAppParameters(ctx context.Context, descriptorID *grpc_application_go.AppDescriptorId) (*grpc_application_go.AppParameterList, error) {
@@ -138,7 +265,7 @@ func (h *Handler) ListDescriptorAppParameters(ctx context.Context, descriptorID
 	if vErr != nil {
 		return nil, conversions.ToGRPCError(vErr)
 	}
-	return h.Manager.ListDescriptorAppParameters(descriptorID)
+	return h.Manager.ListDescriptorAppParameters(ctx, descriptorID)
 }
 
 func (h *Handler) RetrieveTargetApplications(ctx context.Context, filter *grpc_application_manager_go.ApplicationFilter) (*grpc_application_manager_go.TargetApplicationList, error) {
@@ -146,7 +273,11 @@ func (h *Handler) RetrieveTargetApplications(ctx context.Context, filter *grpc_a
 	if vErr != nil {
 		return nil, conversions.ToGRPCError(vErr)
 	}
-	return h.Manager.RetrieveTargetApplications(filter)
+	selector, vErr := NewFilterSelector(filter)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	return h.Manager.RetrieveTargetApplications(ctx, filter, selector)
 }
 
 func (h *Handler) RetrieveEndpoints(ctx context.Context, filter *grpc_application_manager_go.RetrieveEndpointsRequest) (*grpc_application_manager_go.ApplicationEndpoints, error) {
@@ -154,7 +285,7 @@ func (h *Handler) RetrieveEndpoints(ctx context.Context, filter *grpc_applicatio
 	if vErr != nil {
 		return nil, conversions.ToGRPCError(vErr)
 	}
-	return h.Manager.RetrieveEndpoints(filter)
+	return h.Manager.RetrieveEndpoints(ctx, filter)
 }
 
 // ListAvailableInstanceInbounds retrieves a list of available inbounds of an organization
@@ -163,7 +294,7 @@ func (h *Handler) ListAvailableInstanceInbounds(ctx context.Context, organizatio
 	if vErr != nil {
 		return nil, conversions.ToGRPCError(vErr)
 	}
-	return h.Manager.ListAvailableInstanceInbounds(organizationID)
+	return h.Manager.ListAvailableInstanceInbounds(ctx, organizationID)
 }
 
 // ListAvailableInstanceOutbounds retrieves a list of available outbounds of an organization
@@ -172,5 +303,5 @@ func (h *Handler) ListAvailableInstanceOutbounds(ctx context.Context, organizati
 	if vErr != nil {
 		return nil, conversions.ToGRPCError(vErr)
 	}
-	return h.Manager.ListAvailableInstanceOutbounds(organizationID)
+	return h.Manager.ListAvailableInstanceOutbounds(ctx, organizationID)
 }
@@ -27,6 +27,8 @@ import (
 	"context"
 	"fmt"
 	"github.com/google/uuid"
+	"github.com/nalej/application-manager/internal/pkg/policy"
+	"github.com/nalej/application-manager/internal/pkg/provider/secret"
 	"github.com/nalej/application-manager/internal/pkg/server/application-network"
 	"github.com/nalej/application-manager/internal/pkg/utils"
 	"github.com/nalej/grpc-application-go"
@@ -49,6 +51,7 @@ import (
 	"google.golang.org/grpc/test/bufconn"
 	"math/rand"
 	"os"
+	"path/filepath"
 )
 
 func GetAddAppDescriptorRequest(name string, organizationID string) *grpc_application_go.AddAppDescriptorRequest {
@@ -191,6 +194,7 @@ var _ = ginkgo.Describe("Application Manager service", func() {
 	var conductorConn *grpc.ClientConn
 
 	var client grpc_application_manager_go.ApplicationManagerClient
+	var handler *Handler
 
 	// Target organization.
 	var targetOrganization *grpc_organization_manager_go.Organization
@@ -224,8 +228,11 @@ var _ = ginkgo.Describe("Application Manager service", func() {
 		// Register the service
 		appNetManager := application_network.NewManager(apNetClient, appClient, netOpsProducer)
 
-		manager := NewManager(appClient, orgClient, conductorClient, clusterClient, deviceClient, apNetClient, appOpsProducer, appNetManager)
-		handler := NewHandler(manager)
+		secrets, sErr := secret.NewFileProvider(filepath.Join(os.TempDir(), "application-manager-it-secrets.json"), "it-key")
+		gomega.Expect(sErr).To(gomega.BeNil())
+
+		manager := NewManager(appClient, orgClient, conductorClient, clusterClient, deviceClient, apNetClient, appOpsProducer, appNetManager, NewInstanceWatcher(DefaultWatchBufferSize, DropOldest), secrets, policy.NewEngine())
+		handler = NewHandler(manager)
 		grpc_application_manager_go.RegisterApplicationManagerServer(server, handler)
 
 		conn, err := test.GetConn(*listener)
@@ -297,6 +304,45 @@ var _ = ginkgo.Describe("Application Manager service", func() {
 			gomega.Expect(response.AppInstanceId).ShouldNot(gomega.BeEmpty())
 		})
 
+		ginkgo.It("should be able to preview a deployment without deploying it", func() {
+			deployRequest := &grpc_application_manager_go.DeployRequest{
+				OrganizationId:  targetAppDescriptor.OrganizationId,
+				AppDescriptorId: targetAppDescriptor.AppDescriptorId,
+				Name:            "testDryRunAppManager",
+			}
+			plan, err := client.DryRunDeploy(context.Background(), deployRequest)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(plan.Valid).To(gomega.BeTrue())
+			gomega.Expect(plan.ParametrizedDescriptor).NotTo(gomega.BeNil())
+			gomega.Expect(plan.ParametrizedDescriptor.AppInstanceId).Should(gomega.BeEmpty())
+
+			organizationID := &grpc_organization_go.OrganizationId{
+				OrganizationId: targetOrganization.OrganizationId,
+			}
+			instances, err := client.ListAppInstances(context.Background(), organizationID)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(instances.Instances).Should(gomega.BeEmpty())
+		})
+
+		ginkgo.It("should report all the validation errors of a deployment preview", func() {
+			added, err := client.AddAppDescriptor(context.Background(),
+				GetAddAppDescriptorWithParametersRequest("Descriptor with parameter", targetOrganization.OrganizationId))
+			gomega.Expect(err).To(gomega.Succeed())
+
+			deployRequest := &grpc_application_manager_go.DeployRequest{
+				OrganizationId:  targetAppDescriptor.OrganizationId,
+				AppDescriptorId: added.AppDescriptorId,
+				Name:            "testDryRunAppManager",
+				Parameters: &grpc_application_go.InstanceParameterList{
+					Parameters: []*grpc_application_go.InstanceParameter{{ParameterName: "policy", Value: "invalid"}},
+				},
+			}
+			plan, err := client.DryRunDeploy(context.Background(), deployRequest)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(plan.Valid).To(gomega.BeFalse())
+			gomega.Expect(len(plan.Errors)).Should(gomega.Equal(2))
+		})
+
 		ginkgo.It("should not be able to delete a descriptor with instances", func() {
 			deployRequest := &grpc_application_manager_go.DeployRequest{
 				OrganizationId:  targetAppDescriptor.OrganizationId,
@@ -0,0 +1,139 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/golang-lru"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-manager-go"
+	"sync"
+	"time"
+)
+
+// DefaultIdempotencyEntries with the maximum number of deployments remembered.
+const DefaultIdempotencyEntries = 1024
+
+// DefaultIdempotencyTTL with the time a deployment response is remembered.
+const DefaultIdempotencyTTL = 10 * time.Minute
+
+// deploymentEntry with the response of a deployment and the request that originated it.
+type deploymentEntry struct {
+	appDescriptorId string
+	name            string
+	response        *grpc_application_manager_go.DeploymentResponse
+	created         time.Time
+}
+
+// DeploymentStore remembers the responses of recent deployments by their idempotency key so retries of the same
+// request return the original response instead of deploying a new instance. The store is kept in the memory of each
+// replica: the deduplication only covers the retries that reach the same replica before the entry expires or the
+// process restarts, so clients must not rely on it across replicas.
+type DeploymentStore struct {
+	sync.Mutex
+	cache *lru.Cache
+	ttl   time.Duration
+	// inFlight contains the keys of the deployments being processed. The channel is closed when the deployment finishes.
+	inFlight map[string]chan struct{}
+}
+
+// NewDeploymentStore creates a store that remembers up to numEntries deployments during ttl.
+func NewDeploymentStore(numEntries int, ttl time.Duration) (*DeploymentStore, derrors.Error) {
+	cache, err := lru.New(numEntries)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot create deployment store")
+	}
+	return &DeploymentStore{
+		cache:    cache,
+		ttl:      ttl,
+		inFlight: make(map[string]chan struct{}, 0),
+	}, nil
+}
+
+func (ds *DeploymentStore) composePK(organizationId string, key string) string {
+	return fmt.Sprintf("%s#%s", organizationId, key)
+}
+
+// getValid returns the entry stored for a key if it has not expired.
+func (ds *DeploymentStore) getValid(pk string) (*deploymentEntry, bool) {
+	value, found := ds.cache.Get(pk)
+	if !found {
+		return nil, false
+	}
+	entry := value.(*deploymentEntry)
+	if time.Since(entry.created) > ds.ttl {
+		ds.cache.Remove(pk)
+		return nil, false
+	}
+	return entry, true
+}
+
+// Begin checks if a deployment with the same key has already been processed. If so, the original response is
+// returned. If not, the key is marked as in flight and a nil response is returned; the caller must call Complete
+// once the deployment finishes. Concurrent requests with the same key wait for the first one to finish or for their
+// context to be done.
+func (ds *DeploymentStore) Begin(ctx context.Context, key string, request *grpc_application_manager_go.DeployRequest) (*grpc_application_manager_go.DeploymentResponse, derrors.Error) {
+	pk := ds.composePK(request.OrganizationId, key)
+	for {
+		ds.Lock()
+		entry, found := ds.getValid(pk)
+		if found {
+			ds.Unlock()
+			if entry.appDescriptorId != request.AppDescriptorId || entry.name != request.Name {
+				return nil, derrors.NewFailedPreconditionError("idempotency key already used with a different request").WithParams(key)
+			}
+			return entry.response, nil
+		}
+		wait, processing := ds.inFlight[pk]
+		if !processing {
+			ds.inFlight[pk] = make(chan struct{})
+			ds.Unlock()
+			return nil, nil
+		}
+		ds.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, derrors.NewDeadlineExceededError("deadline exceeded waiting for a deployment with the same idempotency key", ctx.Err()).WithParams(key)
+			}
+			return nil, derrors.NewAbortedError("request cancelled waiting for a deployment with the same idempotency key", ctx.Err()).WithParams(key)
+		}
+	}
+}
+
+// Complete finishes a deployment started with Begin. The response is only remembered if the deployment succeeded so
+// failed requests can be retried. It must be deferred so the key is released even if the deployment panics.
+func (ds *DeploymentStore) Complete(key string, request *grpc_application_manager_go.DeployRequest, response *grpc_application_manager_go.DeploymentResponse) {
+	pk := ds.composePK(request.OrganizationId, key)
+	ds.Lock()
+	defer ds.Unlock()
+	if response != nil {
+		ds.cache.Add(pk, &deploymentEntry{
+			appDescriptorId: request.AppDescriptorId,
+			name:            request.Name,
+			response:        response,
+			created:         time.Now(),
+		})
+	}
+	wait, processing := ds.inFlight[pk]
+	if processing {
+		close(wait)
+		delete(ds.inFlight, pk)
+	}
+}
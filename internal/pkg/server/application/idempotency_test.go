@@ -0,0 +1,135 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"context"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"time"
+)
+
+var _ = ginkgo.Describe("Idempotent deployments", func() {
+
+	var store *DeploymentStore
+	var request *grpc_application_manager_go.DeployRequest
+	var response *grpc_application_manager_go.DeploymentResponse
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		store, err = NewDeploymentStore(10, time.Minute)
+		gomega.Expect(err).To(gomega.BeNil())
+		request = &grpc_application_manager_go.DeployRequest{
+			OrganizationId:  "org",
+			AppDescriptorId: "desc",
+			Name:            "app",
+		}
+		response = &grpc_application_manager_go.DeploymentResponse{
+			RequestId:     "app-mngr-1",
+			AppInstanceId: "inst",
+			Status:        grpc_application_go.ApplicationStatus_QUEUED,
+		}
+	})
+
+	ginkgo.It("should return the original response on a retry", func() {
+		previous, err := store.Begin(context.Background(), "key1", request)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(previous).To(gomega.BeNil())
+		store.Complete("key1", request, response)
+
+		previous, err = store.Begin(context.Background(), "key1", request)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(previous).Should(gomega.Equal(response))
+	})
+
+	ginkgo.It("should scope the keys by organization", func() {
+		_, err := store.Begin(context.Background(), "key1", request)
+		gomega.Expect(err).To(gomega.BeNil())
+		store.Complete("key1", request, response)
+
+		other := &grpc_application_manager_go.DeployRequest{OrganizationId: "org2", AppDescriptorId: "desc", Name: "app"}
+		previous, err := store.Begin(context.Background(), "key1", other)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(previous).To(gomega.BeNil())
+	})
+
+	ginkgo.It("should reject a key reused with a different request", func() {
+		_, err := store.Begin(context.Background(), "key1", request)
+		gomega.Expect(err).To(gomega.BeNil())
+		store.Complete("key1", request, response)
+
+		other := &grpc_application_manager_go.DeployRequest{OrganizationId: "org", AppDescriptorId: "desc", Name: "other"}
+		_, err = store.Begin(context.Background(), "key1", other)
+		gomega.Expect(err).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("should allow retrying a failed deployment", func() {
+		_, err := store.Begin(context.Background(), "key1", request)
+		gomega.Expect(err).To(gomega.BeNil())
+		store.Complete("key1", request, nil)
+
+		previous, err := store.Begin(context.Background(), "key1", request)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(previous).To(gomega.BeNil())
+	})
+
+	ginkgo.It("should forget expired deployments", func() {
+		expiring, err := NewDeploymentStore(10, time.Millisecond)
+		gomega.Expect(err).To(gomega.BeNil())
+		_, err = expiring.Begin(context.Background(), "key1", request)
+		gomega.Expect(err).To(gomega.BeNil())
+		expiring.Complete("key1", request, response)
+		time.Sleep(5 * time.Millisecond)
+
+		previous, err := expiring.Begin(context.Background(), "key1", request)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(previous).To(gomega.BeNil())
+	})
+
+	ginkgo.It("should make concurrent retries wait for the first deployment", func() {
+		_, err := store.Begin(context.Background(), "key1", request)
+		gomega.Expect(err).To(gomega.BeNil())
+
+		retried := make(chan *grpc_application_manager_go.DeploymentResponse, 1)
+		go func() {
+			previous, _ := store.Begin(context.Background(), "key1", request)
+			retried <- previous
+		}()
+		gomega.Consistently(retried, 50*time.Millisecond).ShouldNot(gomega.Receive())
+		store.Complete("key1", request, response)
+		gomega.Eventually(retried).Should(gomega.Receive(gomega.Equal(response)))
+	})
+
+	ginkgo.It("should stop waiting when the context of a concurrent retry is done", func() {
+		_, err := store.Begin(context.Background(), "key1", request)
+		gomega.Expect(err).To(gomega.BeNil())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		previous, err := store.Begin(ctx, "key1", request)
+		gomega.Expect(err).NotTo(gomega.BeNil())
+		gomega.Expect(previous).To(gomega.BeNil())
+
+		// the first deployment is not affected
+		store.Complete("key1", request, response)
+		previous, err = store.Begin(context.Background(), "key1", request)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(previous).Should(gomega.Equal(response))
+	})
+})
@@ -0,0 +1,209 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"context"
+	"github.com/nalej/application-manager/internal/pkg/entities"
+	"github.com/nalej/application-manager/internal/pkg/server/common"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"sort"
+	"strings"
+)
+
+// listFilter with the parsed conditions of a listing request.
+type listFilter struct {
+	*grpc_application_manager_go.ListFilter
+	selector *Selector
+}
+
+func newListFilter(filter *grpc_application_manager_go.ListFilter) (*listFilter, derrors.Error) {
+	selector, err := ParseSelector(filter.GetLabelSelector())
+	if err != nil {
+		return nil, err
+	}
+	return &listFilter{ListFilter: filter, selector: selector}, nil
+}
+
+// matches checks the name, the labels and the creation timestamp of an element.
+func (f *listFilter) matches(name string, labels map[string]string, created int64) bool {
+	if !strings.HasPrefix(name, f.GetNamePrefix()) {
+		return false
+	}
+	if f.GetCreatedAfter() != 0 && created < f.GetCreatedAfter() {
+		return false
+	}
+	if f.GetCreatedBefore() != 0 && created >= f.GetCreatedBefore() {
+		return false
+	}
+	return f.selector.Matches(labels)
+}
+
+// instanceFilter returns the conditions of a listing the system model can evaluate, the rest of the selector
+// requirements are checked once the instances are retrieved.
+func (f *listFilter) instanceFilter(organizationID string, appDescriptorID string) *grpc_application_go.AppInstanceFilter {
+	return &grpc_application_go.AppInstanceFilter{
+		OrganizationId:  organizationID,
+		AppDescriptorId: appDescriptorID,
+		NamePrefix:      f.GetNamePrefix(),
+		Labels:          f.selector.EqualityLabels(),
+		CreatedAfter:    f.GetCreatedAfter(),
+		CreatedBefore:   f.GetCreatedBefore(),
+	}
+}
+
+// descriptorFilter returns the conditions of a listing the system model can evaluate.
+func (f *listFilter) descriptorFilter(organizationID string) *grpc_application_go.AppDescriptorFilter {
+	return &grpc_application_go.AppDescriptorFilter{
+		OrganizationId: organizationID,
+		NamePrefix:     f.GetNamePrefix(),
+		Labels:         f.selector.EqualityLabels(),
+		CreatedAfter:   f.GetCreatedAfter(),
+		CreatedBefore:  f.GetCreatedBefore(),
+	}
+}
+
+// instanceKey returns the position of an instance in a listing.
+func instanceKey(instance *grpc_application_go.AppInstance) entities.PageKey {
+	return entities.PageKey{Created: instance.Created, Id: instance.AppInstanceId}
+}
+
+// descriptorKey returns the position of a descriptor in a listing.
+func descriptorKey(descriptor *grpc_application_go.AppDescriptor) entities.PageKey {
+	return entities.PageKey{Created: descriptor.Created, Id: descriptor.AppDescriptorId}
+}
+
+// FilterAppInstances returns the instances that match a listing request sorted by creation timestamp.
+func FilterAppInstances(instances []*grpc_application_go.AppInstance, request *grpc_application_manager_go.ListAppInstancesRequest) ([]*grpc_application_go.AppInstance, derrors.Error) {
+	filter, err := newListFilter(request.Filter)
+	if err != nil {
+		return nil, err
+	}
+	filtered := make([]*grpc_application_go.AppInstance, 0)
+	for _, instance := range instances {
+		if request.AppDescriptorId != "" && instance.AppDescriptorId != request.AppDescriptorId {
+			continue
+		}
+		if request.Status != "" && instance.Status.String() != request.Status {
+			continue
+		}
+		if filter.matches(instance.Name, instance.Labels, instance.Created) {
+			filtered = append(filtered, instance)
+		}
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		return instanceKey(filtered[i]).Before(instanceKey(filtered[j]))
+	})
+	return filtered, nil
+}
+
+// FilterAppDescriptors returns the descriptors that match a listing request sorted by creation timestamp.
+func FilterAppDescriptors(descriptors []*grpc_application_go.AppDescriptor, request *grpc_application_manager_go.ListAppDescriptorsRequest) ([]*grpc_application_go.AppDescriptor, derrors.Error) {
+	filter, err := newListFilter(request.Filter)
+	if err != nil {
+		return nil, err
+	}
+	filtered := make([]*grpc_application_go.AppDescriptor, 0)
+	for _, descriptor := range descriptors {
+		if filter.matches(descriptor.Name, descriptor.Labels, descriptor.Created) {
+			filtered = append(filtered, descriptor)
+		}
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		return descriptorKey(filtered[i]).Before(descriptorKey(filtered[j]))
+	})
+	return filtered, nil
+}
+
+// ListAppDescriptorsPage retrieves a page of the descriptors of an organization that match a filter.
+func (m *Manager) ListAppDescriptorsPage(ctx context.Context, request *grpc_application_manager_go.ListAppDescriptorsRequest) (*grpc_application_manager_go.AppDescriptorPage, error) {
+	fingerprint := entities.ListAppDescriptorsFingerprint(request)
+	last, dErr := entities.DecodePageToken(request.PageToken, fingerprint)
+	if dErr != nil {
+		return nil, conversions.ToGRPCError(dErr)
+	}
+	filter, dErr := newListFilter(request.Filter)
+	if dErr != nil {
+		return nil, conversions.ToGRPCError(dErr)
+	}
+	ctxList, cancelList := common.GetContextFrom(ctx)
+	defer cancelList()
+	list, err := m.appClient.ListAppDescriptorsWithFilter(ctxList, filter.descriptorFilter(request.OrganizationId))
+	if err != nil {
+		return nil, err
+	}
+	filtered, dErr := FilterAppDescriptors(list.Descriptors, request)
+	if dErr != nil {
+		return nil, conversions.ToGRPCError(dErr)
+	}
+	keys := make([]entities.PageKey, 0, len(filtered))
+	for _, descriptor := range filtered {
+		keys = append(keys, descriptorKey(descriptor))
+	}
+	start, end, next := entities.PageBounds(keys, request.PageSize, last, fingerprint)
+	return &grpc_application_manager_go.AppDescriptorPage{
+		Descriptors:   filtered[start:end],
+		NextPageToken: next,
+		TotalSize:     int32(len(filtered)),
+	}, nil
+}
+
+// ListAppInstancesPage retrieves a page of the instances of an organization that match a filter. The connections are
+// only retrieved for the instances of the page and if the request asks for them.
+func (m *Manager) ListAppInstancesPage(ctx context.Context, request *grpc_application_manager_go.ListAppInstancesRequest) (*grpc_application_manager_go.AppInstancePage, error) {
+	fingerprint := entities.ListAppInstancesFingerprint(request)
+	last, dErr := entities.DecodePageToken(request.PageToken, fingerprint)
+	if dErr != nil {
+		return nil, conversions.ToGRPCError(dErr)
+	}
+	filter, dErr := newListFilter(request.Filter)
+	if dErr != nil {
+		return nil, conversions.ToGRPCError(dErr)
+	}
+	ctxList, cancelList := common.GetContextFrom(ctx)
+	defer cancelList()
+	list, err := m.appClient.ListAppInstancesWithFilter(ctxList, filter.instanceFilter(request.OrganizationId, request.AppDescriptorId))
+	if err != nil {
+		return nil, err
+	}
+	filtered, dErr := FilterAppInstances(list.Instances, request)
+	if dErr != nil {
+		return nil, conversions.ToGRPCError(dErr)
+	}
+	keys := make([]entities.PageKey, 0, len(filtered))
+	for _, instance := range filtered {
+		keys = append(keys, instanceKey(instance))
+	}
+	start, end, next := entities.PageBounds(keys, request.PageSize, last, fingerprint)
+	page := &grpc_application_manager_go.AppInstancePage{
+		NextPageToken:      next,
+		TotalSize:          int32(len(filtered)),
+		ConnectionFailures: make([]*grpc_application_manager_go.ConnectionLookupFailure, 0),
+	}
+	if request.ExpandConnections {
+		page.Instances, page.ConnectionFailures = m.expandConnections(ctx, request.OrganizationId, filtered[start:end])
+	} else {
+		page.Instances = make([]*grpc_application_manager_go.AppInstance, 0, end-start)
+		for _, instance := range filtered[start:end] {
+			page.Instances = append(page.Instances, entities.ToAppInstance(instance))
+		}
+	}
+	return page, nil
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"context"
+	"github.com/nalej/application-manager/internal/pkg/utils"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Listing filters", func() {
+
+	newInstance := func(id string, name string, created int64, labels map[string]string, status grpc_application_go.ApplicationStatus) *grpc_application_go.AppInstance {
+		instance := utils.CreateTestAppInstance("org1", "desc1", id, labels, []string{"g1"})
+		instance.Name = name
+		instance.Status = status
+		instance.Created = created
+		return instance
+	}
+
+	var instances []*grpc_application_go.AppInstance
+
+	ginkgo.BeforeEach(func() {
+		instances = []*grpc_application_go.AppInstance{
+			newInstance("inst3", "web-3", 300, map[string]string{"tier": "frontend"}, grpc_application_go.ApplicationStatus_RUNNING),
+			newInstance("inst1", "web-1", 100, map[string]string{"tier": "frontend", "env": "prod"}, grpc_application_go.ApplicationStatus_RUNNING),
+			newInstance("inst2", "db-1", 200, map[string]string{"tier": "backend"}, grpc_application_go.ApplicationStatus_ERROR),
+		}
+		instances[2].AppDescriptorId = "desc2"
+	})
+
+	ids := func(filtered []*grpc_application_go.AppInstance) []string {
+		result := make([]string, 0, len(filtered))
+		for _, instance := range filtered {
+			result = append(result, instance.AppInstanceId)
+		}
+		return result
+	}
+
+	ginkgo.It("should sort the instances by creation timestamp", func() {
+		filtered, err := FilterAppInstances(instances, &grpc_application_manager_go.ListAppInstancesRequest{OrganizationId: "org1"})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ids(filtered)).To(gomega.Equal([]string{"inst1", "inst2", "inst3"}))
+	})
+
+	ginkgo.It("should filter the instances", func() {
+		filtered, err := FilterAppInstances(instances, &grpc_application_manager_go.ListAppInstancesRequest{OrganizationId: "org1",
+			Filter: &grpc_application_manager_go.ListFilter{NamePrefix: "web-", LabelSelector: "tier=frontend"}})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ids(filtered)).To(gomega.Equal([]string{"inst1", "inst3"}))
+
+		filtered, err = FilterAppInstances(instances, &grpc_application_manager_go.ListAppInstancesRequest{OrganizationId: "org1",
+			Filter: &grpc_application_manager_go.ListFilter{CreatedAfter: 100, CreatedBefore: 300}})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ids(filtered)).To(gomega.Equal([]string{"inst1", "inst2"}))
+
+		filtered, err = FilterAppInstances(instances, &grpc_application_manager_go.ListAppInstancesRequest{OrganizationId: "org1",
+			Status: "RUNNING", AppDescriptorId: "desc1"})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ids(filtered)).To(gomega.Equal([]string{"inst1", "inst3"}))
+
+		_, err = FilterAppInstances(instances, &grpc_application_manager_go.ListAppInstancesRequest{OrganizationId: "org1",
+			Filter: &grpc_application_manager_go.ListFilter{LabelSelector: "tier in (web"}})
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should filter the descriptors", func() {
+		descriptors := []*grpc_application_go.AppDescriptor{
+			{AppDescriptorId: "desc2", Name: "web", Labels: map[string]string{"tier": "frontend"}, Created: 200},
+			{AppDescriptorId: "desc1", Name: "web-old", Labels: map[string]string{"tier": "frontend"}, Created: 100},
+			{AppDescriptorId: "desc3", Name: "db"},
+		}
+		filtered, err := FilterAppDescriptors(descriptors, &grpc_application_manager_go.ListAppDescriptorsRequest{OrganizationId: "org1",
+			Filter: &grpc_application_manager_go.ListFilter{NamePrefix: "web", LabelSelector: "tier==frontend"}})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(filtered).To(gomega.HaveLen(2))
+		gomega.Expect(filtered[0].AppDescriptorId).To(gomega.Equal("desc1"))
+		gomega.Expect(filtered[1].AppDescriptorId).To(gomega.Equal("desc2"))
+	})
+
+	ginkgo.It("should push the filter down to the system model", func() {
+		appClient := newFakeApplicationsClient(createDeployTestDescriptor())
+		for _, instance := range instances {
+			appClient.instances[instance.AppInstanceId] = instance
+		}
+		manager := &Manager{appClient: appClient}
+		page, err := manager.ListAppInstancesPage(context.Background(), &grpc_application_manager_go.ListAppInstancesRequest{
+			OrganizationId: "org1",
+			Filter:         &grpc_application_manager_go.ListFilter{NamePrefix: "web-", LabelSelector: "tier=frontend, env!=dev"},
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(page.TotalSize).To(gomega.Equal(int32(2)))
+		gomega.Expect(appClient.called("ListAppInstances")).To(gomega.BeFalse())
+		gomega.Expect(appClient.instanceFilter.NamePrefix).To(gomega.Equal("web-"))
+		gomega.Expect(appClient.instanceFilter.Labels).To(gomega.Equal(map[string]string{"tier": "frontend"}))
+	})
+})
@@ -19,8 +19,14 @@ package application
 import (
 	"context"
 	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/uuid"
 	"github.com/nalej/application-manager/internal/pkg/entities"
+	"github.com/nalej/application-manager/internal/pkg/metrics"
+	"github.com/nalej/application-manager/internal/pkg/policy"
+	"github.com/nalej/application-manager/internal/pkg/provider/secret"
 	appnet "github.com/nalej/application-manager/internal/pkg/server/application-network"
+	"github.com/nalej/application-manager/internal/pkg/server/common"
 	"github.com/nalej/derrors"
 	"github.com/nalej/grpc-application-go"
 	"github.com/nalej/grpc-application-manager-go"
@@ -32,18 +38,27 @@ import (
 	"github.com/nalej/grpc-organization-go"
 	"github.com/nalej/grpc-organization-manager-go"
 	"github.com/nalej/grpc-utils/pkg/conversions"
-	"github.com/nalej/nalej-bus/pkg/queue/application/ops"
 	"github.com/rs/zerolog/log"
-	"math/rand"
 	"sync"
-	"time"
 )
 
-const DefaultTimeout = time.Minute
-const RequiredParamNotFilled = "Required parameter not filled"
-const RequiredOutboundNotFilled = "Required outbound not filled"
+const RequiredParamNotFilled = entities.RequiredParamNotFilled
+const RequiredOutboundNotFilled = entities.RequiredOutboundNotFilled
 const OutboundNotDefined = "Deploy outbound connection not defined"
 
+// Names of the steps of the deploy saga.
+const (
+	AddAppInstanceStep            = "add_app_instance"
+	AddParametrizedDescriptorStep = "add_parametrized_descriptor"
+	UpdateAppInstanceStep         = "update_app_instance"
+	SendDeploymentRequestStep     = "send_deployment_request"
+)
+
+// AppOpsProducer sends the application operations to the conductor through the bus.
+type AppOpsProducer interface {
+	Send(ctx context.Context, msg proto.Message) derrors.Error
+}
+
 // Manager structure with the required clients for roles operations.
 type Manager struct {
 	appClient       grpc_application_go.ApplicationsClient
@@ -52,8 +67,14 @@ type Manager struct {
 	clusterClient   grpc_infrastructure_go.ClustersClient
 	deviceClient    grpc_device_go.DevicesClient
 	appNetClient    grpc_application_network_go.ApplicationNetworkClient
-	appOpsProducer  *ops.ApplicationOpsProducer
+	appOpsProducer  AppOpsProducer
 	appNetManager   appnet.Manager
+	deployments     *DeploymentStore
+	watcher         *InstanceWatcher
+	secrets         secret.Provider
+	settings        *entities.OrganizationSettingsCache
+	policies        policy.Engine
+	reservations    *QuotaReservations
 }
 
 // NewManager creates a Manager using a set of clients.
@@ -64,45 +85,100 @@ func NewManager(
 	clusterClient grpc_infrastructure_go.ClustersClient,
 	deviceClient grpc_device_go.DevicesClient,
 	appNetClient grpc_application_network_go.ApplicationNetworkClient,
-	appOpsProducer *ops.ApplicationOpsProducer,
-	appNetManager appnet.Manager) Manager {
-	return Manager{appClient, orgClient, conductorClient, clusterClient, deviceClient, appNetClient, appOpsProducer, appNetManager}
+	appOpsProducer AppOpsProducer,
+	appNetManager appnet.Manager,
+	watcher *InstanceWatcher,
+	secrets secret.Provider,
+	policies policy.Engine) Manager {
+	deployments, err := NewDeploymentStore(DefaultIdempotencyEntries, DefaultIdempotencyTTL)
+	if err != nil {
+		log.Fatal().Str("err", err.DebugReport()).Msg("cannot create deployment store")
+	}
+	settings, err := entities.NewOrganizationSettingsCache(orgClient, entities.DefaultSettingsEntries, entities.DefaultSettingsTTL)
+	if err != nil {
+		log.Fatal().Str("err", err.DebugReport()).Msg("cannot create organization settings cache")
+	}
+	return Manager{appClient, orgClient, conductorClient, clusterClient, deviceClient, appNetClient, appOpsProducer, appNetManager, deployments, watcher, secrets, settings, policies, NewQuotaReservations()}
 }
 
 // AddAppDescriptor adds a new application descriptor to a given organization.
-func (m *Manager) AddAppDescriptor(addDescriptorRequest *grpc_application_go.AddAppDescriptorRequest) (*grpc_application_go.AppDescriptor, error) {
+func (m *Manager) AddAppDescriptor(ctx context.Context, addDescriptorRequest *grpc_application_go.AddAppDescriptorRequest) (*grpc_application_go.AppDescriptor, error) {
 
 	// before add appDescriptor, validate parameters
-	err := entities.ValidateDescriptorParameters(addDescriptorRequest)
+	vErr := entities.ValidateDescriptorParameters(addDescriptorRequest)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	// rules and deploy after fields are stored with the service name as the rest of the components expect
+	entities.NormalizeServiceReferences(addDescriptorRequest)
+
+	warnings, err := m.evaluatePolicies(policy.NewDescriptorInput(addDescriptorRequest))
+	setPolicyWarnings(ctx, warnings)
 	if err != nil {
-		return nil, conversions.ToGRPCError(err)
+		return nil, err
 	}
 
-	return m.appClient.AddAppDescriptor(context.Background(), addDescriptorRequest)
+	ctxAdd, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	added, err := m.appClient.AddAppDescriptor(ctxAdd, addDescriptorRequest)
+	if err != nil {
+		return nil, err
+	}
+	m.recordRevision(ctx, added)
+	return added, nil
 }
 
 // ListAppDescriptors retrieves a list of application descriptors.
-func (m *Manager) ListAppDescriptors(organizationID *grpc_organization_go.OrganizationId) (*grpc_application_go.AppDescriptorList, error) {
-	return m.appClient.ListAppDescriptors(context.Background(), organizationID)
+func (m *Manager) ListAppDescriptors(ctx context.Context, organizationID *grpc_organization_go.OrganizationId) (*grpc_application_go.AppDescriptorList, error) {
+	ctxList, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	return m.appClient.ListAppDescriptors(ctxList, organizationID)
 }
 
 // GetAppDescriptor retrieves a given application descriptor.
-func (m *Manager) GetAppDescriptor(appDescriptorID *grpc_application_go.AppDescriptorId) (*grpc_application_go.AppDescriptor, error) {
-	return m.appClient.GetAppDescriptor(context.Background(), appDescriptorID)
+func (m *Manager) GetAppDescriptor(ctx context.Context, appDescriptorID *grpc_application_go.AppDescriptorId) (*grpc_application_go.AppDescriptor, error) {
+	ctxGet, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	return m.appClient.GetAppDescriptor(ctxGet, appDescriptorID)
 }
 
 // UpdateAppDescriptor allows the user to update the information of a registered descriptor.
-func (m *Manager) UpdateAppDescriptor(request *grpc_application_go.UpdateAppDescriptorRequest) (*grpc_application_go.AppDescriptor, error) {
-	return m.appClient.UpdateAppDescriptor(context.Background(), request)
+func (m *Manager) UpdateAppDescriptor(ctx context.Context, request *grpc_application_go.UpdateAppDescriptorRequest) (*grpc_application_go.AppDescriptor, error) {
+	// the policies are evaluated on the descriptor as it will be once updated
+	ctxGet, cancelGet := common.GetContextFrom(ctx)
+	defer cancelGet()
+	current, err := m.appClient.GetAppDescriptor(ctxGet, &grpc_application_go.AppDescriptorId{
+		OrganizationId:  request.OrganizationId,
+		AppDescriptorId: request.AppDescriptorId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	warnings, err := m.evaluatePolicies(policy.NewDescriptorUpdateInput(current, request))
+	setPolicyWarnings(ctx, warnings)
+	if err != nil {
+		return nil, err
+	}
+
+	ctxUpdate, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	updated, err := m.appClient.UpdateAppDescriptor(ctxUpdate, request)
+	if err != nil {
+		return nil, err
+	}
+	m.recordRevision(ctx, updated)
+	return updated, nil
 }
 
 // RemoveAppDescriptor removes an application descriptor from the system.
-func (m *Manager) RemoveAppDescriptor(appDescriptorID *grpc_application_go.AppDescriptorId) (*grpc_common_go.Success, error) {
+func (m *Manager) RemoveAppDescriptor(ctx context.Context, appDescriptorID *grpc_application_go.AppDescriptorId) (*grpc_common_go.Success, error) {
 	// Check if there are instances running with that descriptor
 	orgID := &grpc_organization_go.OrganizationId{
 		OrganizationId: appDescriptorID.OrganizationId,
 	}
-	instances, err := m.appClient.ListAppInstances(context.Background(), orgID)
+	ctxList, cancelList := common.GetContextFrom(ctx)
+	defer cancelList()
+	instances, err := m.appClient.ListAppInstances(ctxList, orgID)
 	if err != nil {
 		return nil, err
 	}
@@ -111,32 +187,27 @@ func (m *Manager) RemoveAppDescriptor(appDescriptorID *grpc_application_go.AppDe
 			return nil, derrors.NewFailedPreconditionError("application instances must be removed before deleting the descriptor")
 		}
 	}
-	return m.appClient.RemoveAppDescriptor(context.Background(), appDescriptorID)
+	ctxRemove, cancelRemove := common.GetContextFrom(ctx)
+	defer cancelRemove()
+	success, err := m.appClient.RemoveAppDescriptor(ctxRemove, appDescriptorID)
+	if err != nil {
+		return nil, err
+	}
+	ctxRevisions, cancelRevisions := common.GetContextFrom(ctx)
+	defer cancelRevisions()
+	_, err = m.appClient.RemoveAppDescriptorRevisions(ctxRevisions, appDescriptorID)
+	if err != nil {
+		log.Error().Err(err).Str("appDescriptorId", appDescriptorID.AppDescriptorId).Msg("cannot remove descriptor revisions")
+	}
+	return success, nil
 }
 
 // checkAllRequiredParametersAreFilled checks all the params defined as required are filled in deploy request
 func (m *Manager) checkAllRequiredParametersAreFilled(desc *grpc_application_go.AppDescriptor, params *grpc_application_go.InstanceParameterList) error {
-	// get all the required parameters
-	for _, p := range desc.Parameters {
-		if p.Required == true {
-			find := false
-			if params != nil {
-				// look for it in deploy params
-				for _, deployParam := range params.Parameters {
-
-					if deployParam.ParameterName == p.Name {
-						find = true
-						break
-					}
-
-				}
-			}
-			if !find {
-				return derrors.NewFailedPreconditionError(RequiredParamNotFilled)
-			}
-		}
+	err := entities.ValidRequiredParameters(desc, params)
+	if err != nil {
+		return err
 	}
-
 	return nil
 }
 
@@ -151,12 +222,14 @@ type CheckInboundResponse struct {
 }
 
 // checkInbounds checks if the instanceID has defined all the inbounds in the inboundNames array
-func (m *Manager) checkInbounds(respond chan<- CheckInboundResponse, wg *sync.WaitGroup, organizationID string, instanceID string, inboundNames []string) {
+func (m *Manager) checkInbounds(ctx context.Context, respond chan<- CheckInboundResponse, wg *sync.WaitGroup, organizationID string, instanceID string, inboundNames []string) {
 	defer wg.Done()
 
 	log.Debug().Str("TargetInstanceId", instanceID).Interface("TargetInboundNames", inboundNames).Msg("check inbounds Interface")
 
-	targetInstance, err := m.appClient.GetAppInstance(context.Background(),
+	ctxGet, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	targetInstance, err := m.appClient.GetAppInstance(ctxGet,
 		&grpc_application_go.AppInstanceId{
 			OrganizationId: organizationID,
 			AppInstanceId:  instanceID,
@@ -201,23 +274,13 @@ func (m *Manager) checkInbounds(respond chan<- CheckInboundResponse, wg *sync.Wa
 
 // checkConnections: Checks all the connection fields are consistent (the target_instance_id has an inbound named TargetInboundName)
 // and checks the required outbounds are informed
-func (m *Manager) checkConnections(organizationID string, connections []*grpc_application_manager_go.ConnectionRequest,
+func (m *Manager) checkConnections(ctx context.Context, organizationID string, connections []*grpc_application_manager_go.ConnectionRequest,
 	outboundInterfaces []*grpc_application_go.OutboundNetworkInterface) derrors.Error {
 
 	// 1.- Check required outbounds
-	for _, outbound := range outboundInterfaces {
-		if outbound.Required {
-			log.Debug().Interface("outboundName", outbound.Name).Msg("check required outbound")
-			found := false
-			for _, connection := range connections {
-				if connection.SourceOutboundName == outbound.Name {
-					found = true
-				}
-			}
-			if !found {
-				return derrors.NewFailedPreconditionError(RequiredOutboundNotFilled).WithParams(outbound.Name)
-			}
-		}
+	err := entities.ValidRequiredOutbounds(connections, outboundInterfaces)
+	if err != nil {
+		return err
 	}
 
 	// create a map with all the inbounds per instance_id
@@ -239,7 +302,7 @@ func (m *Manager) checkConnections(organizationID string, connections []*grpc_ap
 	for instanceId, inboundList := range instanceList {
 		log.Debug().Str("instanceID", instanceId).Interface("inboundList", inboundList).Msg("check inbound names")
 
-		go m.checkInbounds(respond, &wg, organizationID, instanceId, inboundList)
+		go m.checkInbounds(ctx, respond, &wg, organizationID, instanceId, inboundList)
 
 	}
 
@@ -260,22 +323,208 @@ func (m *Manager) checkConnections(organizationID string, connections []*grpc_ap
 
 }
 
-// Deploy an application descriptor.
-func (m *Manager) Deploy(deployRequest *grpc_application_manager_go.DeployRequest) (*grpc_application_manager_go.DeploymentResponse, error) {
+// buildConnections creates the connection instances requested in a deployment. The source of each connection is the
+// service linked to the outbound interface through the security rules.
+func (m *Manager) buildConnections(organizationID string, rules []*grpc_application_go.SecurityRule,
+	connectionRequests []*grpc_application_manager_go.ConnectionRequest) []*grpc_application_network_go.ConnectionInstance {
 
-	log.Debug().Interface("request", deployRequest).Msg("received deployment request")
+	connections := make([]*grpc_application_network_go.ConnectionInstance, 0)
+	for _, connectionRequest := range connectionRequests {
+		sourceInstanceName := ""
+		// TODO Too cumbersome. Consider a refactor of the descriptor to link outbound interfaces to services explicitly
+		for _, rule := range rules {
+			if rule.OutboundNetInterface == connectionRequest.SourceOutboundName {
+				sourceInstanceName = rule.TargetServiceName
+			}
+		}
+		if sourceInstanceName == "" {
+			log.Error().Interface("connectionRequest", connectionRequest).Msg("the connection request refers to an outbound interface name not linked to a service. Skipping.")
+			continue
+		}
+		connections = append(connections, &grpc_application_network_go.ConnectionInstance{
+			OrganizationId:     organizationID,
+			SourceInstanceName: sourceInstanceName,
+			TargetInstanceId:   connectionRequest.TargetInstanceId,
+			InboundName:        connectionRequest.TargetInboundName,
+			OutboundName:       connectionRequest.SourceOutboundName,
+		})
+	}
+	return connections
+}
 
-	// Retrieve descriptor by descriptorID
-	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
-	defer cancel()
-	desc, err := m.appClient.GetAppDescriptor(ctx, &grpc_application_go.AppDescriptorId{
-		OrganizationId:  deployRequest.OrganizationId,
-		AppDescriptorId: deployRequest.AppDescriptorId,
-	})
+// DeploymentPlan with the result of a dry run deployment.
+type DeploymentPlan struct {
+	// DescriptorRevision with the revision of the descriptor that would be deployed.
+	DescriptorRevision int64
+	// ParametrizedDescriptor with the descriptor that would be stored once the parameters are applied.
+	ParametrizedDescriptor *grpc_application_go.ParametrizedDescriptor
+	// OutboundConnections with the connections that would be created.
+	OutboundConnections []*grpc_application_network_go.ConnectionInstance
+	// DeploymentOrder with the order in which the services of each group would be deployed, indexed by group name.
+	DeploymentOrder map[string][]string
+	// Errors with all the validation errors found.
+	Errors []derrors.Error
+	// Warnings with the decisions of the policies that warn about the deployment.
+	Warnings []*policy.Decision
+}
+
+// Valid returns true if the plan does not contain validation errors.
+func (p *DeploymentPlan) Valid() bool {
+	return len(p.Errors) == 0
+}
+
+// ToGRPC converts the plan into the message returned by the DryRunDeploy operation. The deployment order follows the
+// order of the groups in the descriptor.
+func (p *DeploymentPlan) ToGRPC(deployRequest *grpc_application_manager_go.DeployRequest) *grpc_application_manager_go.DeploymentPlan {
+	order := make([]*grpc_application_manager_go.ServiceGroupDeploymentOrder, 0, len(p.DeploymentOrder))
+	if p.ParametrizedDescriptor != nil {
+		for _, group := range p.ParametrizedDescriptor.Groups {
+			if services, exists := p.DeploymentOrder[group.Name]; exists {
+				order = append(order, &grpc_application_manager_go.ServiceGroupDeploymentOrder{
+					GroupName:    group.Name,
+					ServiceNames: services,
+				})
+			}
+		}
+	}
+	errors := make([]string, 0, len(p.Errors))
+	for _, err := range p.Errors {
+		errors = append(errors, err.Error())
+	}
+	warnings := make([]*grpc_application_manager_go.PolicyWarning, 0, len(p.Warnings))
+	for _, warning := range p.Warnings {
+		warnings = append(warnings, &grpc_application_manager_go.PolicyWarning{
+			Policy:  warning.Policy,
+			Action:  warning.Action,
+			Path:    warning.Path,
+			Message: warning.Message,
+		})
+	}
+	return &grpc_application_manager_go.DeploymentPlan{
+		OrganizationId:         deployRequest.OrganizationId,
+		AppDescriptorId:        deployRequest.AppDescriptorId,
+		DescriptorRevision:     p.DescriptorRevision,
+		ParametrizedDescriptor: p.ParametrizedDescriptor,
+		OutboundConnections:    p.OutboundConnections,
+		DeploymentOrder:        order,
+		Errors:                 errors,
+		Warnings:               warnings,
+		Valid:                  p.Valid(),
+	}
+}
+
+// DryRunDeploy validates a deployment request and returns the plan of the deployment without creating the instance,
+// the parametrized descriptor or sending any operation to the conductor. A descriptor revision different from 0 in
+// the request pins the revision to be deployed.
+func (m *Manager) DryRunDeploy(ctx context.Context, deployRequest *grpc_application_manager_go.DeployRequest) (*DeploymentPlan, error) {
+
+	// each call derives its own bounded context from the request one
+	desc, revisionNumber, err := m.resolveDescriptor(ctx, deployRequest.OrganizationId, deployRequest.AppDescriptorId, deployRequest.DescriptorRevision)
 	if err != nil {
 		log.Error().Err(err).Msgf("error getting application descriptor %s", deployRequest.AppDescriptorId)
 		return nil, err
 	}
+	passwords := entities.PasswordParameters(desc.Parameters)
+	log.Debug().Interface("request", entities.RedactDeployRequest(deployRequest, passwords)).
+		Msg("received dry run deployment request")
+
+	plan := &DeploymentPlan{
+		DescriptorRevision:  revisionNumber,
+		OutboundConnections: make([]*grpc_application_network_go.ConnectionInstance, 0),
+		Errors:              make([]derrors.Error, 0),
+		Warnings:            make([]*policy.Decision, 0),
+	}
+
+	err = m.checkAllRequiredParametersAreFilled(desc, deployRequest.Parameters)
+	if err != nil {
+		plan.Errors = append(plan.Errors, conversions.ToDerror(err))
+	}
+
+	dErr := entities.ValidPasswordParameters(passwords, deployRequest.Parameters)
+	if dErr != nil {
+		plan.Errors = append(plan.Errors, dErr)
+	}
+
+	dErr = m.checkConnections(ctx, deployRequest.OrganizationId, deployRequest.OutboundConnections, desc.OutboundNetInterfaces)
+	if dErr != nil {
+		plan.Errors = append(plan.Errors, dErr)
+	}
+
+	orgSettings := m.settings.Get(ctx, deployRequest.OrganizationId)
+
+	parametrizedDesc, dErr := entities.CreateParametrizedDescriptor(desc, deployRequest.Parameters, orgSettings)
+	if dErr != nil {
+		plan.Errors = append(plan.Errors, dErr)
+	} else {
+		// the plan is returned to the user so it is rendered with the passwords masked
+		redacted, _, dErr := entities.CreateReferencedDescriptor(desc, entities.MaskParameters(deployRequest.Parameters, passwords), orgSettings)
+		if dErr != nil {
+			plan.Errors = append(plan.Errors, dErr)
+		}
+		plan.ParametrizedDescriptor = redacted
+		plan.OutboundConnections = m.buildConnections(desc.OrganizationId, parametrizedDesc.Rules, deployRequest.OutboundConnections)
+		warnings, err := m.evaluatePolicies(policy.NewDeployInput(parametrizedDesc))
+		plan.Warnings = warnings
+		if err != nil {
+			plan.Errors = append(plan.Errors, conversions.ToDerror(err))
+		}
+		if hasQuotas(orgSettings) {
+			// the dry run only checks the quotas, nothing is reserved as nothing is deployed
+			err := m.checkQuotas(ctx, deployRequest.OrganizationId, entities.NewDescriptorFootprint(parametrizedDesc), orgSettings)
+			if err != nil {
+				plan.Errors = append(plan.Errors, conversions.ToDerror(err))
+			}
+		}
+		order, dErr := entities.DeploymentOrder(parametrizedDesc.Groups)
+		if dErr != nil {
+			plan.Errors = append(plan.Errors, dErr)
+		} else {
+			plan.DeploymentOrder = order
+		}
+	}
+
+	return plan, nil
+}
+
+// Deploy an application descriptor. If the request contains an idempotency key and a deployment with the same key
+// has been processed recently, the original response is returned instead of deploying a new instance. A descriptor
+// revision different from 0 in the request pins the revision to be deployed.
+func (m *Manager) Deploy(ctx context.Context, deployRequest *grpc_application_manager_go.DeployRequest) (*grpc_application_manager_go.DeploymentResponse, error) {
+	idempotencyKey := deployRequest.IdempotencyKey
+	if idempotencyKey == "" {
+		return m.deploy(ctx, deployRequest)
+	}
+
+	previous, dErr := m.deployments.Begin(ctx, idempotencyKey, deployRequest)
+	if dErr != nil {
+		return nil, conversions.ToGRPCError(dErr)
+	}
+	if previous != nil {
+		log.Debug().Str("idempotencyKey", idempotencyKey).Str("appInstanceId", previous.AppInstanceId).
+			Msg("deployment already processed, returning the original response")
+		return previous, nil
+	}
+
+	var response *grpc_application_manager_go.DeploymentResponse
+	var err error
+	defer func() {
+		m.deployments.Complete(idempotencyKey, deployRequest, response)
+	}()
+	response, err = m.deploy(ctx, deployRequest)
+	return response, err
+}
+
+// deploy creates the application instance and sends the deployment request to the conductor.
+func (m *Manager) deploy(ctx context.Context, deployRequest *grpc_application_manager_go.DeployRequest) (*grpc_application_manager_go.DeploymentResponse, error) {
+
+	// Retrieve descriptor by descriptorID, each call derives its own bounded context from the request one
+	desc, revisionNumber, err := m.resolveDescriptor(ctx, deployRequest.OrganizationId, deployRequest.AppDescriptorId, deployRequest.DescriptorRevision)
+	if err != nil {
+		log.Error().Err(err).Msgf("error getting application descriptor %s", deployRequest.AppDescriptorId)
+		return nil, err
+	}
+	passwords := entities.PasswordParameters(desc.Parameters)
+	log.Debug().Interface("request", entities.RedactDeployRequest(deployRequest, passwords)).Msg("received deployment request")
 
 	// check if all required params are filled
 	err = m.checkAllRequiredParametersAreFilled(desc, deployRequest.Parameters)
@@ -283,125 +532,192 @@ func (m *Manager) Deploy(deployRequest *grpc_application_manager_go.DeployReques
 		return nil, err
 	}
 
+	dErr := entities.ValidPasswordParameters(passwords, deployRequest.Parameters)
+	if dErr != nil {
+		return nil, conversions.ToGRPCError(dErr)
+	}
+
 	// NP-1963. Check connections
 	// 1.- TargetInstanceId has an inbound named TargetInboundName
 	// 2.- The descriptor has an outbound named SourceOutboundName
 	// 3.- All required outbound are informed
-	dErr := m.checkConnections(deployRequest.OrganizationId, deployRequest.OutboundConnections, desc.OutboundNetInterfaces)
+	dErr = m.checkConnections(ctx, deployRequest.OrganizationId, deployRequest.OutboundConnections, desc.OutboundNetInterfaces)
 	if dErr != nil {
 		return nil, conversions.ToGRPCError(dErr)
 	}
 
-	orgSettings := entities.NewOrganizationSettings(deployRequest.OrganizationId, m.orgClient)
+	orgSettings := m.settings.Get(ctx, deployRequest.OrganizationId)
 
-	// Create it parametrized descriptor
+	// Create it parametrized descriptor, it holds the values of the PASSWORD parameters so it is only sent to the conductor
 	parametrizedDesc, err := entities.CreateParametrizedDescriptor(desc, deployRequest.Parameters, orgSettings)
 	if err != nil {
 		log.Error().Err(err).Msgf("error creating  parametrized descriptor %s.", deployRequest.AppDescriptorId)
 		return nil, err
 	}
 
-	// Create new application instance
-	addReq := &grpc_application_go.AddAppInstanceRequest{
-		OrganizationId:  deployRequest.OrganizationId,
-		AppDescriptorId: deployRequest.AppDescriptorId,
-		Name:            deployRequest.Name,
-		Parameters:      deployRequest.Parameters,
+	// the policies are evaluated on the rendered descriptor as the parameters may change the images or the labels
+	warnings, err := m.evaluatePolicies(policy.NewDeployInput(parametrizedDesc))
+	setPolicyWarnings(ctx, warnings)
+	if err != nil {
+		return nil, err
 	}
 
-	// Add instance, by default this is created with bus status
-	ctxInstance, cancelInstance := context.WithTimeout(context.Background(), DefaultTimeout)
-	defer cancelInstance()
-	instance, err := m.appClient.AddAppInstance(ctxInstance, addReq)
+	// admission control, the deployment is rejected before creating the instance if it exceeds the quotas. Its
+	// resources remain reserved until the saga ends so concurrent deployments cannot exceed the quotas either
+	release, err := m.admit(ctx, deployRequest.OrganizationId, entities.NewDescriptorFootprint(parametrizedDesc), orgSettings)
 	if err != nil {
-		log.Error().Err(err).Msg("error adding application instance")
 		return nil, err
 	}
+	defer release()
 
-	connections := make([]*grpc_application_network_go.ConnectionInstance, len(deployRequest.OutboundConnections))
-	for connectionIndex, connectionRequest := range deployRequest.OutboundConnections {
-		sourceInstanceName := ""
-		// TODO Too cumbersome. Consider a refactor of the descriptor to link outbound interfaces to services explicitly
-		for _, rule := range instance.Rules {
-			if rule.OutboundNetInterface == connectionRequest.SourceOutboundName {
-				sourceInstanceName = rule.TargetServiceName
-			}
-		}
-		if sourceInstanceName == "" {
-			log.Error().Interface("connectionRequest", connectionRequest).Msg("the connection request refers to an outbound interface name not linked to a service. Skipping.")
-			continue
+	// Create new application instance, the parameters are filled with the secret references once they are stored
+	addReq := &grpc_application_go.AddAppInstanceRequest{
+		OrganizationId:     deployRequest.OrganizationId,
+		AppDescriptorId:    deployRequest.AppDescriptorId,
+		Name:               deployRequest.Name,
+		DescriptorRevision: revisionNumber,
+	}
+	var secretRefs []string
+	// parametrized descriptor persisted with the references to the secrets
+	var storedDesc *grpc_application_go.ParametrizedDescriptor
+	// organization defaults applied to the descriptor and recorded in the instance
+	var appliedDefaults []*grpc_application_go.AppliedDefault
+
+	var instance *grpc_application_go.AppInstance
+	var appInstanceID *grpc_application_go.AppInstanceId
+	var newDesc *grpc_application_go.ParametrizedDescriptor
+	// the same request identifier is sent to the conductor and returned to the caller so both can be correlated
+	requestID := fmt.Sprintf("app-mngr-%s", uuid.New().String())
+
+	deploySaga := NewSaga("deploy", DefaultCompensationRetries, DefaultCompensationBackoff)
+
+	// Store the PASSWORD parameters so the instance and its parametrized descriptor only keep their references
+	deploySaga.AddStep(StoreSecretsStep, func() derrors.Error {
+		stored, references, err := m.storeSecrets(ctx, deployRequest.OrganizationId, passwords, deployRequest.Parameters)
+		if err != nil {
+			log.Error().Str("err", err.DebugReport()).Msg("error storing the password parameters")
+			return err
 		}
-		connections[connectionIndex] = &grpc_application_network_go.ConnectionInstance{
-			OrganizationId:     desc.OrganizationId,
-			SourceInstanceName: sourceInstanceName,
-			TargetInstanceId:   connectionRequest.TargetInstanceId,
-			InboundName:        connectionRequest.TargetInboundName,
-			OutboundName:       connectionRequest.SourceOutboundName,
+		storedDesc, appliedDefaults, err = entities.CreateReferencedDescriptor(desc, stored, orgSettings)
+		if err != nil {
+			log.Error().Str("err", err.DebugReport()).Msg("error creating the referenced parametrized descriptor")
+			// a failed step is not compensated
+			ctxRemove, cancelRemove := common.GetContext()
+			defer cancelRemove()
+			m.removeSecrets(ctxRemove, deployRequest.OrganizationId, references)
+			return err
 		}
-	}
-
-	// fill the instance_id in the parametrized descriptor
-	parametrizedDesc.AppInstanceId = instance.AppInstanceId
+		addReq.Parameters = stored
+		secretRefs = references
+		return nil
+	}, func() derrors.Error {
+		// compensations are not bound to the request, they must run even if the client cancels it
+		ctxRemove, cancelRemove := common.GetContext()
+		defer cancelRemove()
+		return m.removeSecrets(ctxRemove, deployRequest.OrganizationId, secretRefs)
+	})
 
-	appInstanceID := &grpc_application_go.AppInstanceId{
-		OrganizationId: deployRequest.OrganizationId,
-		AppInstanceId:  instance.AppInstanceId,
-	}
+	// Add instance, by default this is created with bus status
+	deploySaga.AddStep(AddAppInstanceStep, func() derrors.Error {
+		ctxInstance, cancelInstance := common.GetContextFrom(ctx)
+		defer cancelInstance()
+		added, err := m.appClient.AddAppInstance(ctxInstance, addReq)
+		if err != nil {
+			log.Error().Err(err).Msg("error adding application instance")
+			return conversions.ToDerror(err)
+		}
+		instance = added
+		appInstanceID = &grpc_application_go.AppInstanceId{
+			OrganizationId: deployRequest.OrganizationId,
+			AppInstanceId:  instance.AppInstanceId,
+		}
+		return nil
+	}, func() derrors.Error {
+		// compensations are not bound to the request, they must run even if the client cancels it
+		ctxRemove, cancelRemove := common.GetContext()
+		defer cancelRemove()
+		_, err := m.appClient.RemoveAppInstance(ctxRemove, appInstanceID)
+		if err != nil {
+			return conversions.ToDerror(err)
+		}
+		return nil
+	})
 
 	// Add parametrizedDescriptor in the system
-	ctxParametrized, cancelParametrized := context.WithTimeout(context.Background(), DefaultTimeout)
-	defer cancelParametrized()
-	newDesc, err := m.appClient.AddParametrizedDescriptor(ctxParametrized, parametrizedDesc)
-	if err != nil {
-		log.Error().Err(err).Msgf("error adding  parametrized descriptor %s. Delete instance", instance.AppInstanceId)
-		_, rollbackErr := m.appClient.RemoveAppInstance(context.Background(), appInstanceID)
-		if rollbackErr != nil {
-			log.Error().Err(err).Msgf("error in rollback deleting the instance %s", instance.AppInstanceId)
+	deploySaga.AddStep(AddParametrizedDescriptorStep, func() derrors.Error {
+		// fill the instance_id in the parametrized descriptors
+		parametrizedDesc.AppInstanceId = instance.AppInstanceId
+		storedDesc.AppInstanceId = instance.AppInstanceId
+		ctxParametrized, cancelParametrized := common.GetContextFrom(ctx)
+		defer cancelParametrized()
+		added, err := m.appClient.AddParametrizedDescriptor(ctxParametrized, storedDesc)
+		if err != nil {
+			log.Error().Err(err).Msgf("error adding parametrized descriptor %s", instance.AppInstanceId)
+			return conversions.ToDerror(err)
 		}
-		return nil, err
-	}
+		newDesc = added
+		return nil
+	}, func() derrors.Error {
+		// compensations are not bound to the request, they must run even if the client cancels it
+		ctxRemove, cancelRemove := common.GetContext()
+		defer cancelRemove()
+		_, err := m.appClient.RemoveParametrizedDescriptor(ctxRemove, appInstanceID)
+		if err != nil {
+			return conversions.ToDerror(err)
+		}
+		return nil
+	})
 
-	// update the instance with the rules parametrized
-	if len(parametrizedDesc.Rules) > 0 {
-		ctxUpdateInstance, cancelUpdate := context.WithTimeout(context.Background(), DefaultTimeout)
+	// update the instance with the rules parametrized, the organization defaults applied and the creation timestamp.
+	// The instance is removed by the compensation of the first step so there is nothing to undo here.
+	deploySaga.AddStep(UpdateAppInstanceStep, func() derrors.Error {
+		ctxUpdateInstance, cancelUpdate := common.GetContextFrom(ctx)
 		defer cancelUpdate()
-		// update the instance
 		instance.Rules = newDesc.Rules
 		instance.ConfigurationOptions = newDesc.ConfigurationOptions
 		instance.EnvironmentVariables = newDesc.EnvironmentVariables
 		instance.Labels = newDesc.Labels
+		instance.AppliedDefaults = appliedDefaults
 		_, err := m.appClient.UpdateAppInstance(ctxUpdateInstance, instance)
-
 		if err != nil {
-			log.Error().Err(err).Msgf("error updating instance %s. Delete instance", instance.AppInstanceId)
-			_, rollbackErr := m.appClient.RemoveAppInstance(context.Background(), appInstanceID)
-			if rollbackErr != nil {
-				log.Error().Err(err).Msgf("error in rollback deleting the instance %s", instance.AppInstanceId)
-			}
-			return nil, err
+			log.Error().Err(err).Msgf("error updating instance %s", instance.AppInstanceId)
+			return conversions.ToDerror(err)
 		}
-
-	}
-
-	// send deploy command to conductor
-	request := &grpc_conductor_go.DeploymentRequest{
-		RequestId:           fmt.Sprintf("app-mngr-%d", rand.Int()),
-		AppInstanceId:       appInstanceID,
-		Name:                deployRequest.Name,
-		OutboundConnections: connections,
-	}
-
-	ctx, cancel = context.WithTimeout(context.Background(), DefaultTimeout)
-	defer cancel()
-	err = m.appOpsProducer.Send(ctx, request)
-	if err != nil {
-		log.Error().Err(err).Str("appInstanceId", instance.AppInstanceId).
-			Msg("error when sending deployment request to the queue")
-		return nil, err
+		return nil
+	}, nil)
+
+	// send deploy command to conductor with the parametrized descriptor that holds the secrets
+	deploySaga.AddStep(SendDeploymentRequestStep, func() derrors.Error {
+		request := &grpc_conductor_go.DeploymentRequest{
+			RequestId:              requestID,
+			AppInstanceId:          appInstanceID,
+			Name:                   deployRequest.Name,
+			OutboundConnections:    m.buildConnections(desc.OrganizationId, instance.Rules, deployRequest.OutboundConnections),
+			ParametrizedDescriptor: parametrizedDesc,
+		}
+		ctxSend, cancelSend := common.GetContextFrom(ctx)
+		defer cancelSend()
+		err := m.appOpsProducer.Send(ctxSend, request)
+		metrics.MessageProduced(metrics.AppOpsTopic, err)
+		if err != nil {
+			log.Error().Err(err).Str("appInstanceId", instance.AppInstanceId).
+				Msg("error when sending deployment request to the queue")
+			return conversions.ToDerror(err)
+		}
+		return nil
+	}, nil)
+
+	result := deploySaga.Execute()
+	if result.Outcome != SagaCompleted {
+		// an instance that could not be removed is left flagged so it is not taken for a running deployment
+		if result.HasNotCompensated(AddAppInstanceStep) {
+			m.recordSagaFailure(appInstanceID, result)
+		}
+		return nil, conversions.ToGRPCError(result.ToError())
 	}
 
 	toReturn := grpc_application_manager_go.DeploymentResponse{
-		RequestId:     fmt.Sprintf("app-mngr-%d", rand.Int()),
+		RequestId:     requestID,
 		AppInstanceId: instance.AppInstanceId,
 		Status:        grpc_application_go.ApplicationStatus_QUEUED}
 
@@ -411,11 +727,32 @@ func (m *Manager) Deploy(deployRequest *grpc_application_manager_go.DeployReques
 
 }
 
+// recordSagaFailure sets the status of an instance left behind by a failed saga to DEPLOYMENT_ERROR with the outcome
+// of the saga as its info. Failures are logged as the saga error is returned to the caller anyway.
+func (m *Manager) recordSagaFailure(appInstanceID *grpc_application_go.AppInstanceId, result *SagaResult) {
+	if appInstanceID == nil {
+		return
+	}
+	ctx, cancel := common.GetContext()
+	defer cancel()
+	_, err := m.appClient.UpdateAppStatus(ctx, &grpc_application_go.UpdateAppStatusRequest{
+		OrganizationId: appInstanceID.OrganizationId,
+		AppInstanceId:  appInstanceID.AppInstanceId,
+		Status:         grpc_application_go.ApplicationStatus_DEPLOYMENT_ERROR,
+		Info:           result.ToError().Error(),
+	})
+	if err != nil {
+		log.Error().Err(err).Str("appInstanceId", appInstanceID.AppInstanceId).Str("saga", result.Name).
+			Str("outcome", result.Outcome.String()).Msg("cannot record the saga outcome on the instance")
+	}
+}
+
 // Undeploy a running application instance.
-func (m *Manager) Undeploy(undeployRequest *grpc_application_manager_go.UndeployRequest) (*grpc_common_go.Success, error) {
+func (m *Manager) Undeploy(ctx context.Context, undeployRequest *grpc_application_manager_go.UndeployRequest) (*grpc_common_go.Success, error) {
 
-	// GetAppInstance returns expanded instance (with its connections)
-	instance, iErr := m.GetAppInstance(&grpc_application_go.AppInstanceId{
+	ctxGet, cancelGet := common.GetContextFrom(ctx)
+	defer cancelGet()
+	current, iErr := m.appClient.GetAppInstance(ctxGet, &grpc_application_go.AppInstanceId{
 		OrganizationId: undeployRequest.OrganizationId,
 		AppInstanceId:  undeployRequest.AppInstanceId,
 	})
@@ -425,6 +762,12 @@ func (m *Manager) Undeploy(undeployRequest *grpc_application_manager_go.Undeploy
 			Msg("error when sending the undeploy request to the queue")
 		return nil, iErr
 	}
+	// the inbound connections must be known to ask for the user confirmation
+	instance, failures := m.getInstanceConnections(ctx, current)
+	if len(failures) > 0 {
+		return nil, conversions.ToGRPCError(derrors.NewUnavailableError("cannot retrieve the connections of the instance").
+			WithParams(undeployRequest.AppInstanceId))
+	}
 
 	if len(instance.InboundConnections) > 0 && !undeployRequest.UserConfirmation {
 		return nil, conversions.ToGRPCError(derrors.NewFailedPreconditionError("can not undeploy the instance, it has inbound connections. User confirmation required"))
@@ -432,7 +775,7 @@ func (m *Manager) Undeploy(undeployRequest *grpc_application_manager_go.Undeploy
 
 	// Remove Inbound connections
 	for _, conn := range instance.InboundConnections {
-		_, rErr := m.appNetManager.RemoveConnection(&grpc_application_network_go.RemoveConnectionRequest{
+		_, rErr := m.appNetManager.RemoveConnection(ctx, &grpc_application_network_go.RemoveConnectionRequest{
 			OrganizationId:   conn.OrganizationId,
 			SourceInstanceId: conn.SourceInstanceId,
 			TargetInstanceId: conn.TargetInstanceId,
@@ -447,7 +790,7 @@ func (m *Manager) Undeploy(undeployRequest *grpc_application_manager_go.Undeploy
 		}
 	}
 	for _, conn := range instance.OutboundConnections {
-		_, rErr := m.appNetManager.RemoveConnection(&grpc_application_network_go.RemoveConnectionRequest{
+		_, rErr := m.appNetManager.RemoveConnection(ctx, &grpc_application_network_go.RemoveConnectionRequest{
 			OrganizationId:   conn.OrganizationId,
 			SourceInstanceId: conn.SourceInstanceId,
 			TargetInstanceId: conn.TargetInstanceId,
@@ -466,95 +809,182 @@ func (m *Manager) Undeploy(undeployRequest *grpc_application_manager_go.Undeploy
 		OrganizationId: undeployRequest.OrganizationId,
 		AppInstanceId:  undeployRequest.AppInstanceId,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
-	defer cancel()
-	err := m.appOpsProducer.Send(ctx, appInstanceID)
+	ctxSend, cancelSend := common.GetContextFrom(ctx)
+	defer cancelSend()
+	err := m.appOpsProducer.Send(ctxSend, appInstanceID)
+	metrics.MessageProduced(metrics.AppOpsTopic, err)
 	if err != nil {
 		log.Error().Err(err).Str("appInstanceId", undeployRequest.AppInstanceId).
 			Msg("error when sending the undeploy request to the queue")
 		return nil, err
 	}
 
-	return &grpc_common_go.Success{}, nil
-
-}
-
-// getInstanceConnections returns the appInstance with the connections field filled
-func (m *Manager) getInstanceConnections(instance *grpc_application_go.AppInstance) *grpc_application_manager_go.AppInstance {
-
-	expandInstance := entities.ToAppInstance(instance)
-
-	appInstanceID := &grpc_application_go.AppInstanceId{
-		OrganizationId: instance.OrganizationId,
-		AppInstanceId:  instance.AppInstanceId,
-	}
-
-	// InboundConnections
-	inboundConnections, err := m.appNetClient.ListInboundConnections(context.Background(), appInstanceID)
-	if err != nil {
-		log.Error().Str("instance_id", instance.AppInstanceId).Msg("error getting inbound connections")
-	} else {
-		if inboundConnections != nil {
-			expandInstance.InboundConnections = inboundConnections.Connections
-		}
-	}
-
-	// OutboundConnections
-	outboundConnections, err := m.appNetClient.ListOutboundConnections(context.Background(), appInstanceID)
-	if err != nil {
-		log.Error().Str("instance_id", instance.AppInstanceId).Msg("error getting outbound connections")
+	// the conductor works with the parametrized descriptor so the secrets of the instance are no longer needed
+	ctxParams, cancelParams := common.GetContextFrom(ctx)
+	defer cancelParams()
+	params, pErr := m.appClient.GetInstanceParameters(ctxParams, &grpc_application_go.AppInstanceId{
+		OrganizationId: undeployRequest.OrganizationId,
+		AppInstanceId:  undeployRequest.AppInstanceId,
+	})
+	if pErr != nil {
+		log.Error().Err(pErr).Str("appInstanceId", undeployRequest.AppInstanceId).
+			Msg("cannot retrieve the instance parameters to remove its secrets")
 	} else {
-		if outboundConnections != nil {
-			expandInstance.OutboundConnections = outboundConnections.Connections
+		ctxRemove, cancelRemove := common.GetContextFrom(ctx)
+		defer cancelRemove()
+		rErr := m.removeSecrets(ctxRemove, undeployRequest.OrganizationId, secretReferences(params))
+		if rErr != nil {
+			log.Error().Str("appInstanceId", undeployRequest.AppInstanceId).Str("err", rErr.DebugReport()).
+				Msg("cannot remove the secrets of the undeployed instance")
 		}
 	}
 
-	return expandInstance
+	return &grpc_common_go.Success{}, nil
 
 }
 
-// ListAppInstances retrieves a list of application descriptors.
-func (m *Manager) ListAppInstances(organizationID *grpc_organization_go.OrganizationId) (*grpc_application_manager_go.AppInstanceList, error) {
+// ListAppInstances retrieves a list of application instances. The instances whose connections cannot be retrieved
+// are returned without them.
+func (m *Manager) ListAppInstances(ctx context.Context, organizationID *grpc_organization_go.OrganizationId) (*grpc_application_manager_go.AppInstanceList, error) {
 
-	list, err := m.appClient.ListAppInstances(context.Background(), organizationID)
+	ctxList, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	list, err := m.appClient.ListAppInstances(ctxList, organizationID)
 	if err != nil {
 		return nil, err
 	}
-	expandList := make([]*grpc_application_manager_go.AppInstance, 0)
-	for _, instance := range list.Instances {
-		expandList = append(expandList, m.getInstanceConnections(instance))
+	expandList, failures := m.expandConnections(ctx, organizationID.OrganizationId, list.Instances)
+	if len(failures) > 0 {
+		log.Warn().Str("organizationId", organizationID.OrganizationId).Int("failures", len(failures)).
+			Msg("instances listed with incomplete connections")
 	}
 	return &grpc_application_manager_go.AppInstanceList{
 		Instances: expandList,
 	}, nil
 }
 
-// GetAppDescriptor retrieves a given application descriptor.
-func (m *Manager) GetAppInstance(appInstanceID *grpc_application_go.AppInstanceId) (*grpc_application_manager_go.AppInstance, error) {
+// GetAppInstance retrieves a given application instance with its connections.
+func (m *Manager) GetAppInstance(ctx context.Context, appInstanceID *grpc_application_go.AppInstanceId) (*grpc_application_manager_go.AppInstance, error) {
 
-	appInstance, err := m.appClient.GetAppInstance(context.Background(), appInstanceID)
+	ctxGet, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	appInstance, err := m.appClient.GetAppInstance(ctxGet, appInstanceID)
 
 	if err != nil {
 		return nil, err
 	}
 
 	// get inbound and outbound connections for the instance
-	expandInstance := m.getInstanceConnections(appInstance)
+	expandInstance, _ := m.getInstanceConnections(ctx, appInstance)
 	return expandInstance, nil
 }
 
-func (m *Manager) ListInstanceParameters(appInstanceID *grpc_application_go.AppInstanceId) (*grpc_application_go.InstanceParameterList, error) {
-	return m.appClient.GetInstanceParameters(context.Background(), appInstanceID)
+// WatchAppInstance sends the current state of the services of an application instance followed by their updates to
+// the stream until the instance reaches a terminal state or the client cancels the stream.
+func (m *Manager) WatchAppInstance(appInstanceID *grpc_application_go.AppInstanceId, stream grpc_application_manager_go.ApplicationManager_WatchAppInstanceServer) error {
+	// the subscription is created before retrieving the instance so no update is lost in between
+	subscription := m.watcher.Watch(appInstanceID.OrganizationId, appInstanceID.AppInstanceId)
+	defer m.watcher.Unsubscribe(subscription)
+
+	current, terminal, err := m.seedWatch(stream.Context(), appInstanceID, subscription)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(current); err != nil {
+		return err
+	}
+	if terminal {
+		log.Debug().Str("appInstanceId", appInstanceID.AppInstanceId).Msg("instance already in a terminal state")
+		return m.drainWatch(subscription, stream)
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			log.Debug().Str("appInstanceId", appInstanceID.AppInstanceId).Msg("watch cancelled by the client")
+			return nil
+		case update, ok := <-subscription.Events():
+			if !ok {
+				log.Debug().Str("appInstanceId", appInstanceID.AppInstanceId).Msg("watch finished")
+				return nil
+			}
+			if err := stream.Send(update); err != nil {
+				return err
+			}
+			if !m.watcher.NeedsRefresh(subscription) {
+				continue
+			}
+			// the services reported so far are settled, the instance tells if they are all of them
+			_, terminal, err := m.seedWatch(stream.Context(), appInstanceID, subscription)
+			if err != nil {
+				return err
+			}
+			if terminal {
+				return m.drainWatch(subscription, stream)
+			}
+		}
+	}
 }
 
-func (m *Manager) ListDescriptorAppParameters(descriptorID *grpc_application_go.AppDescriptorId) (*grpc_application_go.AppParameterList, error) {
-	return m.appClient.GetDescriptorAppParameters(context.Background(), descriptorID)
+// seedWatch retrieves an application instance and records its current state in a subscription.
+func (m *Manager) seedWatch(ctx context.Context, appInstanceID *grpc_application_go.AppInstanceId, subscription *Subscription) (*grpc_conductor_go.DeploymentServiceUpdateRequest, bool, error) {
+	ctx, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	instance, err := m.appClient.GetAppInstance(ctx, appInstanceID)
+	if err != nil {
+		return nil, false, err
+	}
+	current, terminal := m.watcher.Seed(subscription, instance)
+	return current, terminal, nil
 }
 
-func (m *Manager) RetrieveTargetApplications(filter *grpc_application_manager_go.ApplicationFilter) (*grpc_application_manager_go.TargetApplicationList, error) {
+// drainWatch closes a subscription and sends the updates already buffered.
+func (m *Manager) drainWatch(subscription *Subscription, stream grpc_application_manager_go.ApplicationManager_WatchAppInstanceServer) error {
+	m.watcher.Unsubscribe(subscription)
+	for update := range subscription.Events() {
+		if err := stream.Send(update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListInstanceParameters retrieves the parameters of an instance with the PASSWORD parameters masked.
+func (m *Manager) ListInstanceParameters(ctx context.Context, appInstanceID *grpc_application_go.AppInstanceId) (*grpc_application_go.InstanceParameterList, error) {
+	ctx, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	params, err := m.appClient.GetInstanceParameters(ctx, appInstanceID)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := m.appClient.GetAppInstance(ctx, appInstanceID)
+	if err != nil {
+		return nil, err
+	}
+	descParams, err := m.appClient.GetDescriptorAppParameters(ctx, &grpc_application_go.AppDescriptorId{
+		OrganizationId:  instance.OrganizationId,
+		AppDescriptorId: instance.AppDescriptorId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entities.MaskParameters(params, entities.PasswordParameters(descParams.Parameters)), nil
+}
+
+func (m *Manager) ListDescriptorAppParameters(ctx context.Context, descriptorID *grpc_application_go.AppDescriptorId) (*grpc_application_go.AppParameterList, error) {
+	ctxGet, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	return m.appClient.GetDescriptorAppParameters(ctxGet, descriptorID)
+}
+
+// RetrieveTargetApplications retrieves the applications a device group can access whose labels match the selector of
+// the filter.
+func (m *Manager) RetrieveTargetApplications(ctx context.Context, filter *grpc_application_manager_go.ApplicationFilter, selector *Selector) (*grpc_application_manager_go.TargetApplicationList, error) {
 
 	// check if the device_group_id and device_group_name are correct
-	group, err := m.deviceClient.GetDeviceGroup(context.Background(), &grpc_device_go.DeviceGroupId{
+	ctxGet, cancelGet := common.GetContextFrom(ctx)
+	defer cancelGet()
+	group, err := m.deviceClient.GetDeviceGroup(ctxGet, &grpc_device_go.DeviceGroupId{
 		OrganizationId: filter.OrganizationId,
 		DeviceGroupId:  filter.DeviceGroupId,
 	})
@@ -565,16 +995,19 @@ func (m *Manager) RetrieveTargetApplications(filter *grpc_application_manager_go
 		return nil, conversions.ToGRPCError(derrors.NewPermissionDeniedError("cannot access device_group_name"))
 	}
 
-	orgID := &grpc_organization_go.OrganizationId{
+	// the labels that must be equal are filtered by the system model, the device group and the rest of the selector
+	// requirements are checked on the instances retrieved
+	ctxList, cancelList := common.GetContextFrom(ctx)
+	defer cancelList()
+	candidates, err := m.appClient.ListAppInstancesWithFilter(ctxList, &grpc_application_go.AppInstanceFilter{
 		OrganizationId: filter.OrganizationId,
-	}
-	// TODO allow filtering on the list request
-	allApps, err := m.appClient.ListAppInstances(context.Background(), orgID)
+		Labels:         selector.EqualityLabels(),
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	filtered := ApplyFilter(allApps, filter)
+	filtered := ApplySelector(ApplyFilter(candidates, filter), selector)
 
 	result, fErr := ToApplicationLabelsList(filtered)
 	if fErr != nil {
@@ -583,20 +1016,29 @@ func (m *Manager) RetrieveTargetApplications(filter *grpc_application_manager_go
 	return result, nil
 }
 
+// getCluster retrieves a cluster with its own deadline budget as it is called once per service.
+func (m *Manager) getCluster(ctx context.Context, clusterID *grpc_infrastructure_go.ClusterId) (*grpc_infrastructure_go.Cluster, error) {
+	ctxGet, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	return m.clusterClient.GetCluster(ctxGet, clusterID)
+}
+
 func (m *Manager) fillEndpoints(endpoints []*grpc_application_go.EndpointInstance) {
 	for i := 0; i < len(endpoints); i++ {
 		endpoints[i].Fqdn = fmt.Sprintf("%s:%d", endpoints[i].Fqdn, endpoints[i].Port)
 	}
 }
 
-func (m *Manager) RetrieveEndpoints(request *grpc_application_manager_go.RetrieveEndpointsRequest) (*grpc_application_manager_go.ApplicationEndpoints, error) {
+func (m *Manager) RetrieveEndpoints(ctx context.Context, request *grpc_application_manager_go.RetrieveEndpointsRequest) (*grpc_application_manager_go.ApplicationEndpoints, error) {
 
 	instanceID := &grpc_application_go.AppInstanceId{
 		OrganizationId: request.OrganizationId,
 		AppInstanceId:  request.AppInstanceId,
 	}
 	// get the instance requested
-	instance, err := m.appClient.GetAppInstance(context.Background(), instanceID)
+	ctxGet, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	instance, err := m.appClient.GetAppInstance(ctxGet, instanceID)
 	if err != nil {
 		return nil, err
 	}
@@ -615,7 +1057,7 @@ func (m *Manager) RetrieveEndpoints(request *grpc_application_manager_go.Retriev
 					OrganizationId: request.OrganizationId,
 					ClusterId:      service.DeployedOnClusterId,
 				}
-				cluster, err := m.clusterClient.GetCluster(context.Background(), clusterId)
+				cluster, err := m.getCluster(ctx, clusterId)
 				if err != nil {
 					return nil, err
 				}
@@ -638,8 +1080,10 @@ func (m *Manager) RetrieveEndpoints(request *grpc_application_manager_go.Retriev
 }
 
 // ListAvailableInstanceInbounds List all the pluggable inbounds
-func (m *Manager) ListAvailableInstanceInbounds(organizationId *grpc_organization_go.OrganizationId) (*grpc_application_manager_go.AvailableInstanceInboundList, error) {
-	appInstances, err := m.appClient.ListAppInstances(context.Background(), organizationId)
+func (m *Manager) ListAvailableInstanceInbounds(ctx context.Context, organizationId *grpc_organization_go.OrganizationId) (*grpc_application_manager_go.AvailableInstanceInboundList, error) {
+	ctxList, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	appInstances, err := m.appClient.ListAppInstances(ctxList, organizationId)
 	if err != nil {
 		return nil, err
 	}
@@ -658,14 +1102,29 @@ func (m *Manager) ListAvailableInstanceInbounds(organizationId *grpc_organizatio
 }
 
 // ListAvailableInstanceOutbounds List all the outbounds that are not connected
-func (m *Manager) ListAvailableInstanceOutbounds(organizationId *grpc_organization_go.OrganizationId) (*grpc_application_manager_go.AvailableInstanceOutboundList, error) {
-	appInstances, err := m.appClient.ListAppInstances(context.Background(), organizationId)
+func (m *Manager) ListAvailableInstanceOutbounds(ctx context.Context, organizationId *grpc_organization_go.OrganizationId) (*grpc_application_manager_go.AvailableInstanceOutboundList, error) {
+	ctxList, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	appInstances, err := m.appClient.ListAppInstances(ctxList, organizationId)
 	if err != nil {
 		return nil, err
 	}
+	expandedAppInstances, failures := m.expandConnections(ctx, organizationId.OrganizationId, appInstances.Instances)
+	// the outbounds of the instances whose connections are unknown cannot be reported as available
+	unknown := make(map[string]bool, 0)
+	for _, failure := range failures {
+		if failure.Direction == entities.OutboundDirection {
+			unknown[failure.AppInstanceId] = true
+		}
+	}
 	instanceOutbounds := make([]*grpc_application_manager_go.AvailableInstanceOutbound, 0)
-	for _, appInstance := range appInstances.Instances {
-		expandedAppInstance := m.getInstanceConnections(appInstance)
+	for index, appInstance := range appInstances.Instances {
+		if unknown[appInstance.AppInstanceId] {
+			log.Warn().Str("appInstanceId", appInstance.AppInstanceId).
+				Msg("outbounds not listed, the connections of the instance cannot be retrieved")
+			continue
+		}
+		expandedAppInstance := expandedAppInstances[index]
 		for _, outbound := range appInstance.OutboundNetInterfaces {
 			connected := false
 			for _, connection := range expandedAppInstance.OutboundConnections {
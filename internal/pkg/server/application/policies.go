@@ -0,0 +1,64 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"context"
+	"github.com/nalej/application-manager/internal/pkg/policy"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// PolicyWarningTrailer with the trailer of the responses that holds the warnings of the policies, one value per
+// warning.
+const PolicyWarningTrailer = "nalej-policy-warning"
+
+// evaluatePolicies evaluates the policies on an input. The warnings are logged and returned, and a PermissionDenied
+// error is returned if any policy denies the request.
+func (m *Manager) evaluatePolicies(input *policy.Input) ([]*policy.Decision, error) {
+	if m.policies == nil {
+		return make([]*policy.Decision, 0), nil
+	}
+	result := m.policies.Evaluate(input)
+	warnings := result.Warnings()
+	for _, warning := range warnings {
+		log.Warn().Str("organizationId", input.OrganizationId).Str("phase", input.Phase).
+			Str("policy", warning.Policy).Str("path", warning.Path).Msg(warning.Message)
+	}
+	if err := result.ToGRPCError(); err != nil {
+		log.Info().Str("organizationId", input.OrganizationId).Str("phase", input.Phase).
+			Int("denials", len(result.Denials())).Msg("request denied by policy")
+		return warnings, err
+	}
+	return warnings, nil
+}
+
+// setPolicyWarnings returns the warnings of the policies to the caller in the trailer of the response. The trailer
+// cannot be set outside of a gRPC call, so the failures are only logged.
+func setPolicyWarnings(ctx context.Context, warnings []*policy.Decision) {
+	if len(warnings) == 0 {
+		return
+	}
+	values := make([]string, 0, len(warnings)*2)
+	for _, warning := range warnings {
+		values = append(values, PolicyWarningTrailer, warning.String())
+	}
+	if err := grpc.SetTrailer(ctx, metadata.Pairs(values...)); err != nil {
+		log.Debug().Err(err).Msg("cannot return the policy warnings in the trailer")
+	}
+}
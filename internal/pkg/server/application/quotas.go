@@ -0,0 +1,170 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"context"
+	"github.com/nalej/application-manager/internal/pkg/entities"
+	"github.com/nalej/application-manager/internal/pkg/server/common"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-organization-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/rs/zerolog/log"
+	"sync"
+)
+
+// QuotaReservations keeps the resources of the deployments that have been admitted and are not yet completed. The
+// admissions of an organization are serialized and checked against the instances and the reservations, so concurrent
+// deployments cannot exceed the quotas.
+type QuotaReservations struct {
+	sync.Mutex
+	// locks with the lock of each organization, held while its admissions are checked.
+	locks map[string]*sync.Mutex
+	// reserved with the resources reserved by each organization.
+	reserved map[string]*entities.ResourceFootprint
+}
+
+// NewQuotaReservations creates an empty set of reservations.
+func NewQuotaReservations() *QuotaReservations {
+	return &QuotaReservations{
+		locks:    make(map[string]*sync.Mutex, 0),
+		reserved: make(map[string]*entities.ResourceFootprint, 0),
+	}
+}
+
+// lock acquires the admission lock of an organization.
+func (r *QuotaReservations) lock(organizationID string) *sync.Mutex {
+	r.Lock()
+	orgLock, exists := r.locks[organizationID]
+	if !exists {
+		orgLock = &sync.Mutex{}
+		r.locks[organizationID] = orgLock
+	}
+	r.Unlock()
+	orgLock.Lock()
+	return orgLock
+}
+
+// Reserved returns the resources reserved by an organization.
+func (r *QuotaReservations) Reserved(organizationID string) *entities.ResourceFootprint {
+	r.Lock()
+	defer r.Unlock()
+	if reserved, exists := r.reserved[organizationID]; exists {
+		return reserved
+	}
+	return &entities.ResourceFootprint{}
+}
+
+// reserve adds the requested resources to the reservations of an organization and returns the function that
+// releases them.
+func (r *QuotaReservations) reserve(organizationID string, requested *entities.ResourceFootprint) func() {
+	r.Lock()
+	defer r.Unlock()
+	r.reserved[organizationID] = r.reservedLocked(organizationID).Add(requested)
+	return func() {
+		r.Lock()
+		defer r.Unlock()
+		remaining := r.reservedLocked(organizationID).Subtract(requested)
+		if remaining.IsEmpty() {
+			delete(r.reserved, organizationID)
+		} else {
+			r.reserved[organizationID] = remaining
+		}
+	}
+}
+
+func (r *QuotaReservations) reservedLocked(organizationID string) *entities.ResourceFootprint {
+	if reserved, exists := r.reserved[organizationID]; exists {
+		return reserved
+	}
+	return &entities.ResourceFootprint{}
+}
+
+// currentUsage computes the resources used by the instances of an organization.
+func (m *Manager) currentUsage(ctx context.Context, organizationID string) (*entities.ResourceFootprint, error) {
+	ctx, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	instances, err := m.appClient.ListAppInstances(ctx, &grpc_organization_go.OrganizationId{OrganizationId: organizationID})
+	if err != nil {
+		log.Error().Err(err).Str("organizationId", organizationID).Msg("error listing the instances to compute the usage")
+		return nil, err
+	}
+	return entities.NewInstancesFootprint(instances.Instances), nil
+}
+
+// checkQuotas checks that deploying a parametrized descriptor does not exceed the quotas of the organization taking
+// into account the instances and the reservations of the deployments in progress.
+func (m *Manager) checkQuotas(ctx context.Context, organizationID string, requested *entities.ResourceFootprint, settings *entities.OrganizationSettings) error {
+	if settings.QuotasUnavailable {
+		log.Warn().Str("organizationId", organizationID).Msg("deployment rejected as the quotas are unknown")
+		return conversions.ToGRPCError(derrors.NewUnavailableError("cannot retrieve the quotas of the organization").
+			WithParams(organizationID))
+	}
+	used, err := m.currentUsage(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+	used = used.Add(m.reservations.Reserved(organizationID))
+	violations := entities.CheckQuotas(&settings.Quotas, used, requested)
+	if len(violations) > 0 {
+		log.Info().Str("organizationId", organizationID).Interface("violations", violations).
+			Msg("deployment rejected by the organization quotas")
+		return entities.QuotaViolationsToGRPCError(organizationID, violations)
+	}
+	return nil
+}
+
+// hasQuotas checks if the organization has any quota. The quotas that could not be retrieved are considered to
+// exist so the checks fail instead of admitting everything.
+func hasQuotas(settings *entities.OrganizationSettings) bool {
+	return settings != nil && (settings.QuotasUnavailable || settings.Quotas != (entities.ResourceQuotas{}))
+}
+
+// admit checks that the requested resources of a deployment or an upgrade do not exceed the quotas of the
+// organization and reserves them. The admissions of an organization are serialized, and the reservation must be
+// released once the operation has been completed or compensated. The resources are counted twice until then, so the
+// check is conservative but never lets the quotas be exceeded.
+func (m *Manager) admit(ctx context.Context, organizationID string, requested *entities.ResourceFootprint, settings *entities.OrganizationSettings) (func(), error) {
+	if !hasQuotas(settings) || requested.IsEmpty() {
+		return func() {}, nil
+	}
+	orgLock := m.reservations.lock(organizationID)
+	defer orgLock.Unlock()
+	err := m.checkQuotas(ctx, organizationID, requested, settings)
+	if err != nil {
+		return nil, err
+	}
+	return m.reservations.reserve(organizationID, requested), nil
+}
+
+// GetQuotaUsage retrieves the resources used by an organization against its quotas.
+func (m *Manager) GetQuotaUsage(ctx context.Context, organizationID *grpc_organization_go.OrganizationId) (*grpc_application_manager_go.QuotaUsageReport, error) {
+	used, err := m.currentUsage(ctx, organizationID.OrganizationId)
+	if err != nil {
+		return nil, err
+	}
+	var quotas *entities.ResourceQuotas
+	if settings := m.settings.Get(ctx, organizationID.OrganizationId); settings != nil {
+		if settings.QuotasUnavailable {
+			return nil, conversions.ToGRPCError(derrors.NewUnavailableError("cannot retrieve the quotas of the organization").
+				WithParams(organizationID.OrganizationId))
+		}
+		quotas = &settings.Quotas
+	}
+	return entities.NewQuotaUsageReport(organizationID.OrganizationId, quotas, used, m.reservations.Reserved(organizationID.OrganizationId)), nil
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"context"
+	"github.com/nalej/application-manager/internal/pkg/entities"
+	"github.com/nalej/grpc-application-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = ginkgo.Describe("Quota admission on the manager", func() {
+
+	var appClient *fakeApplicationsClient
+	var manager *Manager
+	var parametrized *grpc_application_go.ParametrizedDescriptor
+	var settings *entities.OrganizationSettings
+
+	ginkgo.BeforeEach(func() {
+		appClient = newFakeApplicationsClient(createDeployTestDescriptor())
+		manager = &Manager{
+			appClient:    appClient,
+			reservations: NewQuotaReservations(),
+		}
+		parametrized = &grpc_application_go.ParametrizedDescriptor{
+			OrganizationId: "org",
+			Groups: []*grpc_application_go.ServiceGroup{{
+				Services: []*grpc_application_go.Service{{Name: "service"}},
+			}},
+		}
+		settings = &entities.OrganizationSettings{Quotas: entities.ResourceQuotas{Instances: 1}}
+	})
+
+	ginkgo.It("should reject a deployment while another one holds the reservation", func() {
+		release, err := manager.admit(context.Background(), "org", entities.NewDescriptorFootprint(parametrized), settings)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(manager.reservations.Reserved("org").Instances).Should(gomega.Equal(int64(1)))
+
+		_, err = manager.admit(context.Background(), "org", entities.NewDescriptorFootprint(parametrized), settings)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+
+		release()
+		gomega.Expect(manager.reservations.Reserved("org").IsEmpty()).To(gomega.BeTrue())
+		release, err = manager.admit(context.Background(), "org", entities.NewDescriptorFootprint(parametrized), settings)
+		gomega.Expect(err).To(gomega.Succeed())
+		release()
+	})
+
+	ginkgo.It("should not reserve anything for the organizations without quotas", func() {
+		release, err := manager.admit(context.Background(), "org", entities.NewDescriptorFootprint(parametrized), &entities.OrganizationSettings{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(manager.reservations.Reserved("org").IsEmpty()).To(gomega.BeTrue())
+		release()
+	})
+
+	ginkgo.It("should count the deployed instances", func() {
+		_, err := appClient.AddAppInstance(context.Background(), &grpc_application_go.AddAppInstanceRequest{OrganizationId: "org"})
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = manager.admit(context.Background(), "org", entities.NewDescriptorFootprint(parametrized), settings)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		gomega.Expect(manager.reservations.Reserved("org").IsEmpty()).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should reject the deployments if the quotas cannot be retrieved", func() {
+		unavailable := &entities.OrganizationSettings{QuotasUnavailable: true}
+		_, err := manager.admit(context.Background(), "org", entities.NewDescriptorFootprint(parametrized), unavailable)
+		gomega.Expect(status.Code(err)).To(gomega.Equal(codes.Unavailable))
+		gomega.Expect(manager.reservations.Reserved("org").IsEmpty()).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should admit the upgrades that do not add resources", func() {
+		_, err := appClient.AddAppInstance(context.Background(), &grpc_application_go.AddAppInstanceRequest{OrganizationId: "org"})
+		gomega.Expect(err).To(gomega.Succeed())
+		footprint := entities.NewDescriptorFootprint(parametrized)
+		release, err := manager.admit(context.Background(), "org", footprint.Increase(footprint), settings)
+		gomega.Expect(err).To(gomega.Succeed())
+		release()
+	})
+})
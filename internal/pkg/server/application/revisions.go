@@ -0,0 +1,158 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"context"
+	"github.com/nalej/application-manager/internal/pkg/entities"
+	"github.com/nalej/application-manager/internal/pkg/server/common"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-organization-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// resolveDescriptor retrieves the descriptor to be deployed. If revision is 0 the current descriptor is used, otherwise
+// the pinned revision is returned. Descriptors added before revisions were recorded get their first revision here.
+func (m *Manager) resolveDescriptor(ctx context.Context, organizationID string, appDescriptorID string, revision int64) (*grpc_application_go.AppDescriptor, int64, error) {
+	if revision > 0 {
+		ctxGet, cancel := common.GetContextFrom(ctx)
+		defer cancel()
+		pinned, err := m.appClient.GetAppDescriptorRevision(ctxGet, &grpc_application_go.AppDescriptorRevisionId{
+			OrganizationId:  organizationID,
+			AppDescriptorId: appDescriptorID,
+			Revision:        revision,
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		return pinned.Descriptor, pinned.Revision, nil
+	}
+	descriptorID := &grpc_application_go.AppDescriptorId{
+		OrganizationId:  organizationID,
+		AppDescriptorId: appDescriptorID,
+	}
+	ctxGet, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	desc, err := m.appClient.GetAppDescriptor(ctxGet, descriptorID)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctxLatest, cancelLatest := common.GetContextFrom(ctx)
+	defer cancelLatest()
+	latest, err := m.appClient.GetLatestAppDescriptorRevision(ctxLatest, descriptorID)
+	if status.Code(err) == codes.NotFound {
+		ctxAdd, cancelAdd := common.GetContextFrom(ctx)
+		defer cancelAdd()
+		latest, err = m.appClient.AddAppDescriptorRevision(ctxAdd, desc)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return desc, latest.Revision, nil
+}
+
+// recordRevision stores a new revision of a descriptor in system-model, which assigns the revision number. Failures
+// are logged as the descriptor has already been stored.
+func (m *Manager) recordRevision(ctx context.Context, descriptor *grpc_application_go.AppDescriptor) {
+	ctxAdd, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	revision, err := m.appClient.AddAppDescriptorRevision(ctxAdd, descriptor)
+	if err != nil {
+		log.Error().Err(err).Str("appDescriptorId", descriptor.AppDescriptorId).Msg("cannot store descriptor revision")
+		return
+	}
+	log.Debug().Str("appDescriptorId", descriptor.AppDescriptorId).Int64("revision", revision.Revision).
+		Msg("descriptor revision stored")
+}
+
+// ListAppDescriptorRevisions retrieves the revisions of an application descriptor.
+func (m *Manager) ListAppDescriptorRevisions(ctx context.Context, appDescriptorID *grpc_application_go.AppDescriptorId) (*grpc_application_go.AppDescriptorRevisionList, error) {
+	ctxList, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	return m.appClient.ListAppDescriptorRevisions(ctxList, appDescriptorID)
+}
+
+// GetAppDescriptorRevision retrieves a given revision of an application descriptor.
+func (m *Manager) GetAppDescriptorRevision(ctx context.Context, revisionID *grpc_application_go.AppDescriptorRevisionId) (*grpc_application_go.AppDescriptorRevision, error) {
+	ctxGet, cancel := common.GetContextFrom(ctx)
+	defer cancel()
+	return m.appClient.GetAppDescriptorRevision(ctxGet, revisionID)
+}
+
+// DiffAppDescriptorRevisions compares two revisions of an application descriptor.
+func (m *Manager) DiffAppDescriptorRevisions(ctx context.Context, request *grpc_application_manager_go.DiffAppDescriptorRevisionsRequest) (*grpc_application_manager_go.AppDescriptorDiff, error) {
+	from, err := m.GetAppDescriptorRevision(ctx, &grpc_application_go.AppDescriptorRevisionId{
+		OrganizationId:  request.OrganizationId,
+		AppDescriptorId: request.AppDescriptorId,
+		Revision:        request.From,
+	})
+	if err != nil {
+		return nil, err
+	}
+	to, err := m.GetAppDescriptorRevision(ctx, &grpc_application_go.AppDescriptorRevisionId{
+		OrganizationId:  request.OrganizationId,
+		AppDescriptorId: request.AppDescriptorId,
+		Revision:        request.To,
+	})
+	if err != nil {
+		return nil, err
+	}
+	diff, dErr := entities.DiffRevisions(from, to)
+	if dErr != nil {
+		return nil, conversions.ToGRPCError(dErr)
+	}
+	return diff, nil
+}
+
+// ListInstanceRevisions retrieves the revision each running instance of a descriptor was deployed from. The revision
+// is recorded on the instance when it is deployed or upgraded.
+func (m *Manager) ListInstanceRevisions(ctx context.Context, appDescriptorID *grpc_application_go.AppDescriptorId) (*grpc_application_manager_go.InstanceRevisionList, error) {
+	ctxList, cancelList := common.GetContextFrom(ctx)
+	defer cancelList()
+	instances, err := m.appClient.ListAppInstances(ctxList, &grpc_organization_go.OrganizationId{
+		OrganizationId: appDescriptorID.OrganizationId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctxLatest, cancelLatest := common.GetContextFrom(ctx)
+	defer cancelLatest()
+	latest, err := m.appClient.GetLatestAppDescriptorRevision(ctxLatest, appDescriptorID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*grpc_application_manager_go.InstanceRevision, 0)
+	for _, inst := range instances.Instances {
+		if inst.AppDescriptorId != appDescriptorID.AppDescriptorId {
+			continue
+		}
+		result = append(result, &grpc_application_manager_go.InstanceRevision{
+			OrganizationId:  inst.OrganizationId,
+			AppDescriptorId: inst.AppDescriptorId,
+			AppInstanceId:   inst.AppInstanceId,
+			Revision:        inst.DescriptorRevision,
+			LatestRevision:  latest.Revision,
+			Outdated:        inst.DescriptorRevision < latest.Revision,
+		})
+	}
+	return &grpc_application_manager_go.InstanceRevisionList{Instances: result}, nil
+}
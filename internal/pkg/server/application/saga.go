@@ -0,0 +1,196 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// DefaultCompensationRetries with the number of times a compensation is attempted before giving up.
+const DefaultCompensationRetries = 3
+
+// DefaultCompensationBackoff with the time to wait between compensation attempts. The wait grows linearly with
+// the number of attempts.
+const DefaultCompensationBackoff = time.Second
+
+// SagaOutcome with the final result of a saga execution.
+type SagaOutcome int
+
+const (
+	// SagaCompleted when all the steps have been executed.
+	SagaCompleted SagaOutcome = iota
+	// SagaCompensated when a step failed and all the previous steps have been undone.
+	SagaCompensated
+	// SagaCompensationFailed when a step failed and at least one of the previous steps could not be undone.
+	SagaCompensationFailed
+)
+
+var SagaOutcomeToString = map[SagaOutcome]string{
+	SagaCompleted:          "COMPLETED",
+	SagaCompensated:        "COMPENSATED",
+	SagaCompensationFailed: "COMPENSATION_FAILED",
+}
+
+func (o SagaOutcome) String() string {
+	return SagaOutcomeToString[o]
+}
+
+// SagaStep with an action of a distributed operation and the compensation that undoes it.
+type SagaStep struct {
+	// Name of the step.
+	Name string
+	// Action to be executed.
+	Action func() derrors.Error
+	// Compensation that undoes the action. Nil if the step does not require to be undone.
+	Compensation func() derrors.Error
+}
+
+// Saga executes a list of steps in order. If one of them fails, the compensations of the steps already executed are
+// run in reverse order.
+type Saga struct {
+	name    string
+	steps   []SagaStep
+	retries int
+	backoff time.Duration
+}
+
+// NewSaga creates an empty saga.
+func NewSaga(name string, retries int, backoff time.Duration) *Saga {
+	if retries < 1 {
+		retries = 1
+	}
+	return &Saga{
+		name:    name,
+		steps:   make([]SagaStep, 0),
+		retries: retries,
+		backoff: backoff,
+	}
+}
+
+// AddStep appends a new step to the saga.
+func (s *Saga) AddStep(name string, action func() derrors.Error, compensation func() derrors.Error) *Saga {
+	s.steps = append(s.steps, SagaStep{Name: name, Action: action, Compensation: compensation})
+	return s
+}
+
+// SagaResult with the outcome of a saga execution.
+type SagaResult struct {
+	// Name of the saga.
+	Name string
+	// Outcome of the execution.
+	Outcome SagaOutcome
+	// FailedStep with the name of the step that failed.
+	FailedStep string
+	// Cause with the error returned by the failed step.
+	Cause derrors.Error
+	// Completed with the names of the steps executed successfully.
+	Completed []string
+	// Compensated with the names of the steps that have been undone.
+	Compensated []string
+	// NotCompensated with the names of the steps whose compensation failed.
+	NotCompensated []string
+}
+
+// HasNotCompensated checks if the compensation of a given step failed.
+func (r *SagaResult) HasNotCompensated(step string) bool {
+	for _, name := range r.NotCompensated {
+		if name == step {
+			return true
+		}
+	}
+	return false
+}
+
+// ToError returns the error describing a failed saga, or nil if the saga has been completed.
+func (r *SagaResult) ToError() derrors.Error {
+	if r.Outcome == SagaCompleted {
+		return nil
+	}
+	msg := fmt.Sprintf("%s failed on step %s", r.Name, r.FailedStep)
+	var err derrors.Error
+	if r.Outcome == SagaCompensated {
+		err = derrors.NewAbortedError(msg, r.Cause)
+	} else {
+		err = derrors.NewInternalError(fmt.Sprintf("%s and could not be undone", msg), r.Cause)
+	}
+	return err.WithParams("outcome", r.Outcome.String(), "failed_step", r.FailedStep,
+		"compensated", r.Compensated, "not_compensated", r.NotCompensated)
+}
+
+// compensate executes the compensation of a step retrying it if required.
+func (s *Saga) compensate(step SagaStep) derrors.Error {
+	var err derrors.Error
+	for attempt := 1; attempt <= s.retries; attempt++ {
+		err = step.Compensation()
+		if err == nil {
+			return nil
+		}
+		log.Warn().Str("saga", s.name).Str("step", step.Name).Int("attempt", attempt).
+			Str("err", err.DebugReport()).Msg("error compensating step")
+		if attempt < s.retries {
+			time.Sleep(s.backoff * time.Duration(attempt))
+		}
+	}
+	return err
+}
+
+// Execute runs the steps of the saga and compensates them if one fails.
+func (s *Saga) Execute() *SagaResult {
+	result := &SagaResult{
+		Name:           s.name,
+		Outcome:        SagaCompleted,
+		Completed:      make([]string, 0),
+		Compensated:    make([]string, 0),
+		NotCompensated: make([]string, 0),
+	}
+
+	failedIndex := -1
+	for index, step := range s.steps {
+		err := step.Action()
+		if err != nil {
+			log.Error().Str("saga", s.name).Str("step", step.Name).Str("err", err.DebugReport()).Msg("saga step failed")
+			result.FailedStep = step.Name
+			result.Cause = err
+			failedIndex = index
+			break
+		}
+		result.Completed = append(result.Completed, step.Name)
+	}
+
+	if failedIndex != -1 {
+		result.Outcome = SagaCompensated
+		for index := failedIndex - 1; index >= 0; index-- {
+			step := s.steps[index]
+			if step.Compensation == nil {
+				continue
+			}
+			if err := s.compensate(step); err != nil {
+				result.NotCompensated = append(result.NotCompensated, step.Name)
+				result.Outcome = SagaCompensationFailed
+			} else {
+				result.Compensated = append(result.Compensated, step.Name)
+			}
+		}
+	}
+
+	log.Info().Str("saga", s.name).Str("outcome", result.Outcome.String()).Str("failedStep", result.FailedStep).
+		Strs("compensated", result.Compensated).Strs("notCompensated", result.NotCompensated).Msg("saga finished")
+	return result
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var deploySteps = []string{AddAppInstanceStep, AddParametrizedDescriptorStep, UpdateAppInstanceStep, SendDeploymentRequestStep}
+
+// createTestSaga creates a saga with the steps of a deployment. The step in failStep position fails and the
+// compensations fail compensationFailures times before succeeding. The executed actions and compensations are
+// recorded in the returned slices.
+func createTestSaga(failStep int, compensationFailures int) (*Saga, *[]string, *[]string) {
+	executed := make([]string, 0)
+	compensated := make([]string, 0)
+	saga := NewSaga("test", DefaultCompensationRetries, 0)
+	for index, name := range deploySteps {
+		stepIndex := index
+		stepName := name
+		failures := 0
+		saga.AddStep(stepName, func() derrors.Error {
+			if stepIndex == failStep {
+				return derrors.NewInternalError("injected failure").WithParams(stepName)
+			}
+			executed = append(executed, stepName)
+			return nil
+		}, func() derrors.Error {
+			if failures < compensationFailures {
+				failures++
+				return derrors.NewUnavailableError("injected compensation failure").WithParams(stepName)
+			}
+			compensated = append(compensated, stepName)
+			return nil
+		})
+	}
+	return saga, &executed, &compensated
+}
+
+var _ = ginkgo.Describe("Deploy saga", func() {
+
+	ginkgo.It("should execute all the steps", func() {
+		saga, executed, compensated := createTestSaga(-1, 0)
+		result := saga.Execute()
+		gomega.Expect(result.Outcome).Should(gomega.Equal(SagaCompleted))
+		gomega.Expect(result.ToError()).To(gomega.BeNil())
+		gomega.Expect(*executed).Should(gomega.Equal(deploySteps))
+		gomega.Expect(*compensated).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should compensate the previous steps when any step fails", func() {
+		for failStep := range deploySteps {
+			saga, executed, compensated := createTestSaga(failStep, 0)
+			result := saga.Execute()
+			gomega.Expect(result.Outcome).Should(gomega.Equal(SagaCompensated))
+			gomega.Expect(result.FailedStep).Should(gomega.Equal(deploySteps[failStep]))
+			gomega.Expect(*executed).Should(gomega.Equal(deploySteps[:failStep]))
+			// compensations are executed in reverse order
+			expected := make([]string, 0)
+			for index := failStep - 1; index >= 0; index-- {
+				expected = append(expected, deploySteps[index])
+			}
+			gomega.Expect(*compensated).Should(gomega.Equal(expected))
+			gomega.Expect(result.Compensated).Should(gomega.Equal(expected))
+			gomega.Expect(result.NotCompensated).Should(gomega.BeEmpty())
+			gomega.Expect(result.ToError()).NotTo(gomega.BeNil())
+		}
+	})
+
+	ginkgo.It("should retry the compensations", func() {
+		saga, _, compensated := createTestSaga(len(deploySteps)-1, DefaultCompensationRetries-1)
+		result := saga.Execute()
+		gomega.Expect(result.Outcome).Should(gomega.Equal(SagaCompensated))
+		gomega.Expect(len(*compensated)).Should(gomega.Equal(len(deploySteps) - 1))
+	})
+
+	ginkgo.It("should report the steps that could not be undone", func() {
+		saga, _, compensated := createTestSaga(len(deploySteps)-1, DefaultCompensationRetries)
+		result := saga.Execute()
+		gomega.Expect(result.Outcome).Should(gomega.Equal(SagaCompensationFailed))
+		gomega.Expect(*compensated).Should(gomega.BeEmpty())
+		gomega.Expect(len(result.NotCompensated)).Should(gomega.Equal(len(deploySteps) - 1))
+		gomega.Expect(result.ToError()).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("should skip the steps without compensation", func() {
+		executed := make([]string, 0)
+		saga := NewSaga("test", DefaultCompensationRetries, 0)
+		saga.AddStep(AddAppInstanceStep, func() derrors.Error { return nil }, func() derrors.Error {
+			executed = append(executed, AddAppInstanceStep)
+			return nil
+		})
+		saga.AddStep(UpdateAppInstanceStep, func() derrors.Error { return nil }, nil)
+		saga.AddStep(SendDeploymentRequestStep, func() derrors.Error {
+			return derrors.NewInternalError("injected failure")
+		}, nil)
+		result := saga.Execute()
+		gomega.Expect(result.Outcome).Should(gomega.Equal(SagaCompensated))
+		gomega.Expect(result.Compensated).Should(gomega.Equal([]string{AddAppInstanceStep}))
+		gomega.Expect(executed).Should(gomega.Equal([]string{AddAppInstanceStep}))
+	})
+})
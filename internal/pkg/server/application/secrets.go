@@ -0,0 +1,104 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"context"
+	"github.com/nalej/application-manager/internal/pkg/entities"
+	"github.com/nalej/application-manager/internal/pkg/server/common"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-go"
+	"github.com/rs/zerolog/log"
+)
+
+// StoreSecretsStep with the name of the deploy saga step that stores the PASSWORD parameters.
+const StoreSecretsStep = "store_secrets"
+
+// storeSecrets stores the values of the PASSWORD parameters in the secret provider. It returns the parameters to be
+// persisted with the instance, where those values are replaced by their references, and the references created.
+func (m *Manager) storeSecrets(ctx context.Context, organizationID string, passwords map[string]bool, params *grpc_application_go.InstanceParameterList) (*grpc_application_go.InstanceParameterList, []string, derrors.Error) {
+	references := make([]string, 0)
+	if params == nil {
+		return nil, references, nil
+	}
+	stored := make([]*grpc_application_go.InstanceParameter, 0, len(params.Parameters))
+	for _, param := range params.Parameters {
+		value := param.Value
+		if passwords[param.ParameterName] && value != "" {
+			reference, err := m.secrets.Store(ctx, organizationID, value)
+			if err != nil {
+				// the secrets already stored are removed even if the request has been cancelled
+				ctxRemove, cancelRemove := common.GetContext()
+				m.removeSecrets(ctxRemove, organizationID, references)
+				cancelRemove()
+				return nil, nil, err
+			}
+			references = append(references, reference)
+			value = reference
+		}
+		stored = append(stored, &grpc_application_go.InstanceParameter{ParameterName: param.ParameterName, Value: value})
+	}
+	return &grpc_application_go.InstanceParameterList{Parameters: stored}, references, nil
+}
+
+// removeSecrets removes a list of secrets. All the secrets are removed even if one of them fails.
+func (m *Manager) removeSecrets(ctx context.Context, organizationID string, references []string) derrors.Error {
+	var result derrors.Error
+	for _, reference := range references {
+		err := m.secrets.Remove(ctx, organizationID, reference)
+		if err != nil {
+			log.Error().Str("organizationId", organizationID).Str("err", err.DebugReport()).Msg("cannot remove secret")
+			result = err
+		}
+	}
+	return result
+}
+
+// resolveSecrets returns a copy of the parameters where the secret references are replaced by their values. Only
+// the parametrized descriptor consumed by the conductor must be built from the resolved parameters.
+func (m *Manager) resolveSecrets(ctx context.Context, organizationID string, params *grpc_application_go.InstanceParameterList) (*grpc_application_go.InstanceParameterList, derrors.Error) {
+	if params == nil {
+		return nil, nil
+	}
+	resolved := make([]*grpc_application_go.InstanceParameter, 0, len(params.Parameters))
+	for _, param := range params.Parameters {
+		value := param.Value
+		if entities.IsSecretReference(value) {
+			secret, err := m.secrets.Get(ctx, organizationID, value)
+			if err != nil {
+				return nil, err
+			}
+			value = secret
+		}
+		resolved = append(resolved, &grpc_application_go.InstanceParameter{ParameterName: param.ParameterName, Value: value})
+	}
+	return &grpc_application_go.InstanceParameterList{Parameters: resolved}, nil
+}
+
+// secretReferences returns the secret references of a list of parameters.
+func secretReferences(params *grpc_application_go.InstanceParameterList) []string {
+	references := make([]string, 0)
+	if params == nil {
+		return references
+	}
+	for _, param := range params.Parameters {
+		if entities.IsSecretReference(param.Value) {
+			references = append(references, param.Value)
+		}
+	}
+	return references
+}
@@ -0,0 +1,233 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-manager-go"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Operators of the selector requirements.
+const (
+	EqualsOperator       = "="
+	NotEqualsOperator    = "!="
+	InOperator           = "in"
+	NotInOperator        = "notin"
+	ExistsOperator       = "exists"
+	DoesNotExistOperator = "!"
+)
+
+// setRequirementMatcher matches the requirements with the in and notin operators.
+var setRequirementMatcher = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+
+// requirement of a selector on a label.
+type requirement struct {
+	key      string
+	operator string
+	values   []string
+}
+
+// matches checks if a set of labels satisfies the requirement. As in Kubernetes, the != and notin operators match
+// the labels without the key.
+func (r *requirement) matches(labels map[string]string) bool {
+	value, exists := labels[r.key]
+	switch r.operator {
+	case EqualsOperator:
+		return exists && value == r.values[0]
+	case NotEqualsOperator:
+		return !exists || value != r.values[0]
+	case InOperator:
+		return exists && contains(r.values, value)
+	case NotInOperator:
+		return !exists || !contains(r.values, value)
+	case ExistsOperator:
+		return exists
+	case DoesNotExistOperator:
+		return !exists
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Selector matches a set of labels against a list of requirements. Label selectors follow the Kubernetes syntax, a
+// comma separated list of requirements that must all be satisfied:
+//
+//	tier=frontend, env!=dev, region in (eu-west, eu-north), track notin (canary), team, !deprecated
+type Selector struct {
+	requirements []*requirement
+}
+
+// NewSelectorFromLabels creates a selector that requires the labels to have the given values, as the MatchLabels of
+// the application filters.
+func NewSelectorFromLabels(labels map[string]string) *Selector {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	selector := &Selector{requirements: make([]*requirement, 0, len(keys))}
+	for _, key := range keys {
+		selector.requirements = append(selector.requirements,
+			&requirement{key: key, operator: EqualsOperator, values: []string{labels[key]}})
+	}
+	return selector
+}
+
+// splitRequirements splits a selector by the commas that are not inside a set of values.
+func splitRequirements(selector string) ([]string, derrors.Error) {
+	result := make([]string, 0)
+	depth := 0
+	start := 0
+	for i, char := range selector {
+		switch char {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, derrors.NewInvalidArgumentError("unbalanced parentheses in label selector").WithParams(selector)
+			}
+		case ',':
+			if depth == 0 {
+				result = append(result, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, derrors.NewInvalidArgumentError("unbalanced parentheses in label selector").WithParams(selector)
+	}
+	return append(result, selector[start:]), nil
+}
+
+// parseRequirement parses a single requirement of a selector.
+func parseRequirement(expression string) (*requirement, derrors.Error) {
+	var result *requirement
+	if match := setRequirementMatcher.FindStringSubmatch(expression); match != nil {
+		values := make([]string, 0)
+		for _, value := range strings.Split(match[3], ",") {
+			values = append(values, strings.TrimSpace(value))
+		}
+		result = &requirement{key: match[1], operator: match[2], values: values}
+	} else if parts := strings.SplitN(expression, "!=", 2); len(parts) == 2 {
+		result = &requirement{key: strings.TrimSpace(parts[0]), operator: NotEqualsOperator,
+			values: []string{strings.TrimSpace(parts[1])}}
+	} else if parts := strings.SplitN(expression, "=", 2); len(parts) == 2 {
+		// == is accepted as an alias of =
+		result = &requirement{key: strings.TrimSpace(parts[0]), operator: EqualsOperator,
+			values: []string{strings.TrimSpace(strings.TrimPrefix(parts[1], "="))}}
+	} else if strings.HasPrefix(expression, "!") {
+		result = &requirement{key: strings.TrimSpace(expression[1:]), operator: DoesNotExistOperator}
+	} else {
+		result = &requirement{key: expression, operator: ExistsOperator}
+	}
+
+	if errs := validation.IsQualifiedName(result.key); len(errs) > 0 {
+		return nil, derrors.NewInvalidArgumentError("invalid label selector key").WithParams(expression, strings.Join(errs, "; "))
+	}
+	for _, value := range result.values {
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return nil, derrors.NewInvalidArgumentError("invalid label selector value").WithParams(expression, strings.Join(errs, "; "))
+		}
+	}
+	return result, nil
+}
+
+// ParseSelector parses a label selector. An empty selector matches everything.
+func ParseSelector(selector string) (*Selector, derrors.Error) {
+	result := &Selector{requirements: make([]*requirement, 0)}
+	if strings.TrimSpace(selector) == "" {
+		return result, nil
+	}
+	expressions, err := splitRequirements(selector)
+	if err != nil {
+		return nil, err
+	}
+	for _, expression := range expressions {
+		expression = strings.TrimSpace(expression)
+		if expression == "" {
+			return nil, derrors.NewInvalidArgumentError("empty label selector requirement").WithParams(selector)
+		}
+		parsed, err := parseRequirement(expression)
+		if err != nil {
+			return nil, err
+		}
+		result.requirements = append(result.requirements, parsed)
+	}
+	return result, nil
+}
+
+// And returns a selector with the requirements of both selectors.
+func (s *Selector) And(other *Selector) *Selector {
+	if other == nil {
+		return s
+	}
+	requirements := make([]*requirement, 0, len(s.requirements)+len(other.requirements))
+	requirements = append(requirements, s.requirements...)
+	return &Selector{requirements: append(requirements, other.requirements...)}
+}
+
+// Empty checks if the selector has no requirements.
+func (s *Selector) Empty() bool {
+	return len(s.requirements) == 0
+}
+
+// Matches checks if a set of labels satisfies all the requirements of the selector.
+func (s *Selector) Matches(labels map[string]string) bool {
+	for _, requirement := range s.requirements {
+		if !requirement.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// EqualityLabels returns the labels the selector requires to have a given value, so the filter can be evaluated by
+// the system model. The rest of the requirements must still be checked with Matches.
+func (s *Selector) EqualityLabels() map[string]string {
+	labels := make(map[string]string, 0)
+	if s == nil {
+		return labels
+	}
+	for _, requirement := range s.requirements {
+		if requirement.operator == EqualsOperator || (requirement.operator == InOperator && len(requirement.values) == 1) {
+			labels[requirement.key] = requirement.values[0]
+		}
+	}
+	return labels
+}
+
+// NewFilterSelector returns the selector of an application filter. The MatchLabels are only used if the filter does
+// not have a label selector.
+func NewFilterSelector(filter *grpc_application_manager_go.ApplicationFilter) (*Selector, derrors.Error) {
+	if strings.TrimSpace(filter.LabelSelector) == "" {
+		return NewSelectorFromLabels(filter.MatchLabels), nil
+	}
+	return ParseSelector(filter.LabelSelector)
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"github.com/nalej/application-manager/internal/pkg/utils"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Label selectors", func() {
+
+	var labels = map[string]string{"tier": "frontend", "env": "prod", "nalej.io/team": "devices"}
+
+	ginkgo.It("should match the requirements", func() {
+		expected := map[string]bool{
+			"":                       true,
+			"tier=frontend":          true,
+			"tier==backend":          false,
+			"env!=dev":               true,
+			"region!=eu":             true,
+			"env in (prod, staging)": true,
+			"region in (eu)":         false,
+			"env notin (prod)":       false,
+			"region notin (eu)":      true,
+			"nalej.io/team":          true,
+			"!deprecated":            true,
+			"!tier":                  false,
+			"tier=frontend, env in (prod,staging), !deprecated": true,
+			"tier=frontend,env notin (prod,staging)":            false,
+		}
+		for selector, matches := range expected {
+			parsed, err := ParseSelector(selector)
+			gomega.Expect(err).To(gomega.Succeed(), selector)
+			gomega.Expect(parsed.Matches(labels)).To(gomega.Equal(matches), selector)
+		}
+	})
+
+	ginkgo.It("should reject invalid selectors", func() {
+		invalid := []string{"tier=frontend,,env=prod", "env in (prod", "-tier=frontend", "tier=front end"}
+		for _, selector := range invalid {
+			_, err := ParseSelector(selector)
+			gomega.Expect(err).NotTo(gomega.Succeed(), selector)
+		}
+	})
+
+	ginkgo.It("should keep the behaviour of the match labels", func() {
+		selector := NewSelectorFromLabels(map[string]string{"tier": "frontend", "env": "prod"})
+		gomega.Expect(selector.Matches(labels)).To(gomega.BeTrue())
+		gomega.Expect(selector.Matches(map[string]string{"tier": "frontend"})).To(gomega.BeFalse())
+		gomega.Expect(NewSelectorFromLabels(nil).Matches(labels)).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should return the labels required to have a value", func() {
+		selector, err := ParseSelector("tier=frontend, env in (prod), region in (eu, us), !deprecated, team!=ops")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(selector.EqualityLabels()).To(gomega.Equal(map[string]string{"tier": "frontend", "env": "prod"}))
+		var empty *Selector
+		gomega.Expect(empty.EqualityLabels()).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should read the selector from the filter", func() {
+		filter := &grpc_application_manager_go.ApplicationFilter{MatchLabels: map[string]string{"l2": "v2"}}
+		selector, err := NewFilterSelector(filter)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(selector.Matches(map[string]string{"l2": "v2"})).To(gomega.BeTrue())
+
+		filter.LabelSelector = "l1 in (v1, v2),!l3"
+		selector, err = NewFilterSelector(filter)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		app1 := utils.CreateTestAppInstance("org1", "desc1", "inst1", map[string]string{"l1": "v1"}, []string{"g1"})
+		app2 := utils.CreateTestAppInstance("org1", "desc1", "inst2", map[string]string{"l1": "v2", "l3": "v3"}, []string{"g1"})
+		app3 := utils.CreateTestAppInstance("org1", "desc1", "inst3", map[string]string{"l2": "v2"}, []string{"g1"})
+		filtered := ApplySelector(&grpc_application_go.AppInstanceList{
+			Instances: []*grpc_application_go.AppInstance{app1, app2, app3}}, selector)
+		gomega.Expect(filtered.Instances).To(gomega.HaveLen(1))
+		gomega.Expect(filtered.Instances[0].AppInstanceId).To(gomega.Equal("inst1"))
+	})
+})
@@ -0,0 +1,320 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/uuid"
+	"github.com/nalej/application-manager/internal/pkg/entities"
+	"github.com/nalej/application-manager/internal/pkg/metrics"
+	"github.com/nalej/application-manager/internal/pkg/policy"
+	"github.com/nalej/application-manager/internal/pkg/server/common"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-conductor-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/rs/zerolog/log"
+)
+
+// Names of the steps of the upgrade saga.
+const (
+	UpdateParametrizedDescriptorStep = "update_parametrized_descriptor"
+	SendUpgradeRequestStep           = "send_upgrade_request"
+)
+
+// checkUpgradeConnections checks that the connections of an instance can be kept with a new descriptor: the inbound
+// and outbound interfaces used by the connections must exist, the required outbounds must be connected and the
+// targets of the outbound connections must still expose their inbounds.
+func (m *Manager) checkUpgradeConnections(ctx context.Context, instance *grpc_application_manager_go.AppInstance, desc *grpc_application_go.AppDescriptor) derrors.Error {
+	inbounds := make(map[string]bool, 0)
+	for _, inbound := range desc.InboundNetInterfaces {
+		inbounds[inbound.Name] = true
+	}
+	for _, conn := range instance.InboundConnections {
+		if !inbounds[conn.InboundName] {
+			return derrors.NewFailedPreconditionError("inbound interface used by a connection not defined in the new descriptor").
+				WithParams(conn.SourceInstanceId, conn.InboundName)
+		}
+	}
+
+	outbounds := make(map[string]bool, 0)
+	for _, outbound := range desc.OutboundNetInterfaces {
+		outbounds[outbound.Name] = true
+	}
+	requests := make([]*grpc_application_manager_go.ConnectionRequest, 0)
+	for _, conn := range instance.OutboundConnections {
+		if !outbounds[conn.OutboundName] {
+			return derrors.NewFailedPreconditionError(OutboundNotDefined).WithParams(conn.OutboundName)
+		}
+		requests = append(requests, &grpc_application_manager_go.ConnectionRequest{
+			SourceOutboundName: conn.OutboundName,
+			TargetInstanceId:   conn.TargetInstanceId,
+			TargetInboundName:  conn.InboundName,
+		})
+	}
+	return m.checkConnections(ctx, instance.OrganizationId, requests, desc.OutboundNetInterfaces)
+}
+
+// UpgradeAppInstance updates a running instance with a new descriptor revision or a new set of parameters. The
+// instance identifier and its connections are kept. New PASSWORD parameters are stored as secrets and the secrets they
+// replace are removed once the upgrade has been sent to the conductor.
+func (m *Manager) UpgradeAppInstance(ctx context.Context, request *grpc_application_manager_go.UpgradeAppInstanceRequest) (*grpc_application_manager_go.DeploymentResponse, error) {
+
+	appInstanceID := &grpc_application_go.AppInstanceId{
+		OrganizationId: request.OrganizationId,
+		AppInstanceId:  request.AppInstanceId,
+	}
+	// each call derives its own bounded context from the request one
+	ctxGet, cancelGet := common.GetContextFrom(ctx)
+	defer cancelGet()
+	current, err := m.appClient.GetAppInstance(ctxGet, appInstanceID)
+	if err != nil {
+		log.Error().Err(err).Str("appInstanceId", request.AppInstanceId).Msg("error getting application instance")
+		return nil, err
+	}
+	// expanded instance with its connections, they must be known to check that the new descriptor keeps them
+	expanded, failures := m.getInstanceConnections(ctx, current)
+	if len(failures) > 0 {
+		return nil, conversions.ToGRPCError(derrors.NewUnavailableError("cannot retrieve the connections of the instance").
+			WithParams(request.AppInstanceId))
+	}
+
+	appDescriptorID := request.AppDescriptorId
+	if appDescriptorID == "" {
+		appDescriptorID = current.AppDescriptorId
+	}
+	desc, revisionNumber, err := m.resolveDescriptor(ctx, request.OrganizationId, appDescriptorID, request.DescriptorRevision)
+	if err != nil {
+		log.Error().Err(err).Msgf("error getting application descriptor %s", appDescriptorID)
+		return nil, err
+	}
+	passwords := entities.PasswordParameters(desc.Parameters)
+	log.Debug().Interface("request", entities.RedactUpgradeRequest(request, passwords)).Msg("received upgrade request")
+
+	// the stored parameters only hold the references to the secrets of the instance
+	ctxParams, cancelParams := common.GetContextFrom(ctx)
+	defer cancelParams()
+	currentParams, err := m.appClient.GetInstanceParameters(ctxParams, appInstanceID)
+	if err != nil {
+		log.Error().Err(err).Str("appInstanceId", request.AppInstanceId).Msg("error getting instance parameters")
+		return nil, err
+	}
+	replaceParams := request.Parameters != nil
+	params := request.Parameters
+	if replaceParams {
+		dErr := entities.ValidPasswordParameters(passwords, params)
+		if dErr != nil {
+			return nil, conversions.ToGRPCError(dErr)
+		}
+	} else {
+		resolved, dErr := m.resolveSecrets(ctx, request.OrganizationId, currentParams)
+		if dErr != nil {
+			log.Error().Str("appInstanceId", request.AppInstanceId).Str("err", dErr.DebugReport()).
+				Msg("error resolving the secrets of the instance")
+			return nil, conversions.ToGRPCError(dErr)
+		}
+		params = resolved
+	}
+
+	err = m.checkAllRequiredParametersAreFilled(desc, params)
+	if err != nil {
+		return nil, err
+	}
+
+	dErr := m.checkUpgradeConnections(ctx, expanded, desc)
+	if dErr != nil {
+		return nil, conversions.ToGRPCError(dErr)
+	}
+
+	orgSettings := m.settings.Get(ctx, request.OrganizationId)
+	// the parametrized descriptor with the values of the PASSWORD parameters is only sent to the conductor
+	parametrizedDesc, err := entities.CreateParametrizedDescriptor(desc, params, orgSettings)
+	if err != nil {
+		log.Error().Err(err).Msgf("error creating parametrized descriptor %s.", appDescriptorID)
+		return nil, err
+	}
+	parametrizedDesc.AppInstanceId = current.AppInstanceId
+
+	// the upgraded descriptor is rendered again so the deploy policies are evaluated on it as in a deployment
+	warnings, err := m.evaluatePolicies(policy.NewDeployInput(parametrizedDesc))
+	setPolicyWarnings(ctx, warnings)
+	if err != nil {
+		return nil, err
+	}
+
+	ctxPrevious, cancelPrevious := common.GetContextFrom(ctx)
+	defer cancelPrevious()
+	previousDesc, err := m.appClient.GetParametrizedDescriptor(ctxPrevious, appInstanceID)
+	if err != nil {
+		log.Error().Err(err).Str("appInstanceId", request.AppInstanceId).Msg("error getting parametrized descriptor")
+		return nil, err
+	}
+
+	// admission control, only the resources the upgrade adds to the instance are checked and they remain reserved
+	// until the saga ends
+	requested := entities.NewDescriptorFootprint(parametrizedDesc).Increase(entities.NewDescriptorFootprint(previousDesc))
+	release, err := m.admit(ctx, request.OrganizationId, requested, orgSettings)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	storedParams := currentParams
+	var secretRefs []string
+	// parametrized descriptor persisted with the references to the secrets
+	var storedDesc *grpc_application_go.ParametrizedDescriptor
+	var appliedDefaults []*grpc_application_go.AppliedDefault
+	requestID := fmt.Sprintf("app-mngr-%s", uuid.New().String())
+	upgradeSaga := NewSaga("upgrade", DefaultCompensationRetries, DefaultCompensationBackoff)
+
+	// Store the new PASSWORD parameters, the current secrets are kept until the upgrade is sent
+	if replaceParams {
+		upgradeSaga.AddStep(StoreSecretsStep, func() derrors.Error {
+			stored, references, err := m.storeSecrets(ctx, request.OrganizationId, passwords, request.Parameters)
+			if err != nil {
+				log.Error().Str("err", err.DebugReport()).Msg("error storing the password parameters")
+				return err
+			}
+			storedParams = stored
+			secretRefs = references
+			return nil
+		}, func() derrors.Error {
+			// compensations are not bound to the request, they must run even if the client cancels it
+			ctxRemove, cancelRemove := common.GetContext()
+			defer cancelRemove()
+			return m.removeSecrets(ctxRemove, request.OrganizationId, secretRefs)
+		})
+	}
+
+	// the parametrized descriptor is replaced in a single update so the instance always has one
+	upgradeSaga.AddStep(UpdateParametrizedDescriptorStep, func() derrors.Error {
+		referenced, applied, dErr := entities.CreateReferencedDescriptor(desc, storedParams, orgSettings)
+		if dErr != nil {
+			log.Error().Str("err", dErr.DebugReport()).Msg("error creating the referenced parametrized descriptor")
+			return dErr
+		}
+		referenced.AppInstanceId = current.AppInstanceId
+		ctxUpdate, cancelUpdate := common.GetContextFrom(ctx)
+		defer cancelUpdate()
+		_, err := m.appClient.UpdateParametrizedDescriptor(ctxUpdate, referenced)
+		if err != nil {
+			log.Error().Err(err).Msgf("error updating parametrized descriptor %s", current.AppInstanceId)
+			return conversions.ToDerror(err)
+		}
+		storedDesc = referenced
+		appliedDefaults = applied
+		return nil
+	}, func() derrors.Error {
+		// compensations are not bound to the request, they must run even if the client cancels it
+		ctxUpdate, cancelUpdate := common.GetContext()
+		defer cancelUpdate()
+		_, err := m.appClient.UpdateParametrizedDescriptor(ctxUpdate, previousDesc)
+		if err != nil {
+			return conversions.ToDerror(err)
+		}
+		return nil
+	})
+
+	upgradeSaga.AddStep(UpdateAppInstanceStep, func() derrors.Error {
+		ctxUpdate, cancelUpdate := common.GetContextFrom(ctx)
+		defer cancelUpdate()
+		upgraded := proto.Clone(current).(*grpc_application_go.AppInstance)
+		upgraded.AppDescriptorId = desc.AppDescriptorId
+		upgraded.DescriptorRevision = revisionNumber
+		upgraded.Parameters = storedParams
+		upgraded.Rules = storedDesc.Rules
+		upgraded.ConfigurationOptions = storedDesc.ConfigurationOptions
+		upgraded.EnvironmentVariables = storedDesc.EnvironmentVariables
+		upgraded.Labels = storedDesc.Labels
+		upgraded.AppliedDefaults = appliedDefaults
+		upgraded.InboundNetInterfaces = desc.InboundNetInterfaces
+		upgraded.OutboundNetInterfaces = desc.OutboundNetInterfaces
+		_, err := m.appClient.UpdateAppInstance(ctxUpdate, upgraded)
+		if err != nil {
+			log.Error().Err(err).Msgf("error updating instance %s", current.AppInstanceId)
+			return conversions.ToDerror(err)
+		}
+		return nil
+	}, func() derrors.Error {
+		// compensations are not bound to the request, they must run even if the client cancels it
+		ctxUpdate, cancelUpdate := common.GetContext()
+		defer cancelUpdate()
+		_, err := m.appClient.UpdateAppInstance(ctxUpdate, current)
+		if err != nil {
+			return conversions.ToDerror(err)
+		}
+		return nil
+	})
+
+	upgradeSaga.AddStep(SendUpgradeRequestStep, func() derrors.Error {
+		connections := make([]*grpc_application_manager_go.ConnectionRequest, 0)
+		for _, conn := range expanded.OutboundConnections {
+			connections = append(connections, &grpc_application_manager_go.ConnectionRequest{
+				SourceOutboundName: conn.OutboundName,
+				TargetInstanceId:   conn.TargetInstanceId,
+				TargetInboundName:  conn.InboundName,
+			})
+		}
+		upgradeRequest := &grpc_conductor_go.UpgradeRequest{
+			RequestId:              requestID,
+			AppInstanceId:          appInstanceID,
+			Name:                   current.Name,
+			OutboundConnections:    m.buildConnections(request.OrganizationId, parametrizedDesc.Rules, connections),
+			ParametrizedDescriptor: parametrizedDesc,
+		}
+		ctxSend, cancelSend := common.GetContextFrom(ctx)
+		defer cancelSend()
+		err := m.appOpsProducer.Send(ctxSend, upgradeRequest)
+		metrics.MessageProduced(metrics.AppOpsTopic, err)
+		if err != nil {
+			log.Error().Err(err).Str("appInstanceId", current.AppInstanceId).
+				Msg("error when sending upgrade request to the queue")
+			return conversions.ToDerror(err)
+		}
+		return nil
+	}, nil)
+
+	result := upgradeSaga.Execute()
+	if result.Outcome != SagaCompleted {
+		// an instance that could not be restored is left flagged so it is not taken for a running deployment
+		if result.HasNotCompensated(UpdateParametrizedDescriptorStep) || result.HasNotCompensated(UpdateAppInstanceStep) {
+			m.recordSagaFailure(appInstanceID, result)
+		}
+		return nil, conversions.ToGRPCError(result.ToError())
+	}
+
+	// the secrets of the previous parameters are no longer referenced by the instance
+	if replaceParams {
+		// the upgrade has been sent, so the secrets are removed even if the client cancels the request
+		ctxRemove, cancelRemove := common.GetContext()
+		defer cancelRemove()
+		dErr = m.removeSecrets(ctxRemove, request.OrganizationId, secretReferences(currentParams))
+		if dErr != nil {
+			log.Error().Str("appInstanceId", current.AppInstanceId).Str("err", dErr.DebugReport()).
+				Msg("cannot remove the secrets replaced by the upgrade")
+		}
+	}
+
+	return &grpc_application_manager_go.DeploymentResponse{
+		RequestId:     requestID,
+		AppInstanceId: current.AppInstanceId,
+		Status:        grpc_application_go.ApplicationStatus_QUEUED,
+	}, nil
+}
@@ -0,0 +1,240 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"context"
+	"github.com/golang/protobuf/proto"
+	"github.com/nalej/application-manager/internal/pkg/entities"
+	"github.com/nalej/application-manager/internal/pkg/policy"
+	"github.com/nalej/application-manager/internal/pkg/provider/secret"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-application-network-go"
+	"github.com/nalej/grpc-conductor-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var _ = ginkgo.Describe("Upgrade connections", func() {
+
+	var manager *Manager
+	var instance *grpc_application_manager_go.AppInstance
+	var desc *grpc_application_go.AppDescriptor
+
+	ginkgo.BeforeEach(func() {
+		manager = &Manager{}
+		instance = &grpc_application_manager_go.AppInstance{
+			OrganizationId: "org",
+			AppInstanceId:  "inst",
+			InboundConnections: []*grpc_application_network_go.ConnectionInstance{
+				{OrganizationId: "org", SourceInstanceId: "other", TargetInstanceId: "inst", InboundName: "in1", OutboundName: "out"},
+			},
+		}
+		desc = &grpc_application_go.AppDescriptor{
+			OrganizationId:       "org",
+			AppDescriptorId:      "desc",
+			InboundNetInterfaces: []*grpc_application_go.InboundNetworkInterface{{Name: "in1"}},
+		}
+	})
+
+	ginkgo.It("should keep the connections if the interfaces still exist", func() {
+		gomega.Expect(manager.checkUpgradeConnections(context.Background(), instance, desc)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should fail if an inbound interface in use is removed", func() {
+		desc.InboundNetInterfaces = []*grpc_application_go.InboundNetworkInterface{{Name: "in2"}}
+		gomega.Expect(manager.checkUpgradeConnections(context.Background(), instance, desc)).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should fail if an outbound interface in use is removed", func() {
+		instance.OutboundConnections = []*grpc_application_network_go.ConnectionInstance{
+			{OrganizationId: "org", SourceInstanceId: "inst", TargetInstanceId: "other", InboundName: "in", OutboundName: "out1"},
+		}
+		gomega.Expect(manager.checkUpgradeConnections(context.Background(), instance, desc)).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should fail if a new required outbound is not connected", func() {
+		desc.OutboundNetInterfaces = []*grpc_application_go.OutboundNetworkInterface{{Name: "out1", Required: true}}
+		gomega.Expect(manager.checkUpgradeConnections(context.Background(), instance, desc)).NotTo(gomega.Succeed())
+	})
+})
+
+var _ = ginkgo.Describe("Upgrade saga on the manager", func() {
+
+	var dir string
+	var appClient *fakeApplicationsClient
+	var producer *fakeOpsProducer
+	var secrets *secret.FileProvider
+	var manager *Manager
+	var instanceID string
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "upgrade")
+		gomega.Expect(err).To(gomega.Succeed())
+		var dErr derrors.Error
+		secrets, dErr = secret.NewFileProvider(filepath.Join(dir, "secrets.json"), "passphrase")
+		gomega.Expect(dErr).To(gomega.BeNil())
+		desc := createDeployTestDescriptor()
+		desc.EnvironmentVariables = map[string]string{"PASSWORD": ""}
+		desc.Parameters = []*grpc_application_go.AppParameter{{
+			Name: "password",
+			Path: "environment_variables.PASSWORD",
+			Type: grpc_application_go.ParamDataType_PASSWORD,
+		}}
+		appClient = newFakeApplicationsClient(desc)
+		producer = &fakeOpsProducer{}
+		deployments, dErr := NewDeploymentStore(DefaultIdempotencyEntries, DefaultIdempotencyTTL)
+		gomega.Expect(dErr).To(gomega.BeNil())
+		manager = &Manager{
+			appClient:      appClient,
+			appNetClient:   &fakeApplicationNetworkClient{},
+			appOpsProducer: producer,
+			deployments:    deployments,
+			secrets:        secrets,
+		}
+		response, err := manager.Deploy(context.Background(), &grpc_application_manager_go.DeployRequest{
+			OrganizationId:  "org",
+			AppDescriptorId: "desc",
+			Name:            "test-app",
+			Parameters: &grpc_application_go.InstanceParameterList{Parameters: []*grpc_application_go.InstanceParameter{
+				{ParameterName: "password", Value: "first"},
+			}},
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		instanceID = response.AppInstanceId
+		// a new revision of the descriptor with a different image
+		appClient.descriptor.Groups[0].Services[0].Image = "nginx:1.19"
+		_, err = appClient.AddAppDescriptorRevision(context.Background(), appClient.descriptor)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	// storedPassword returns the reference stored as the password parameter of the instance.
+	storedPassword := func() string {
+		params := appClient.instances[instanceID].Parameters
+		gomega.Expect(params).NotTo(gomega.BeNil())
+		gomega.Expect(params.Parameters).Should(gomega.HaveLen(1))
+		return params.Parameters[0].Value
+	}
+
+	ginkgo.It("should upgrade the instance to the latest revision", func() {
+		previousPassword := storedPassword()
+		response, err := manager.UpgradeAppInstance(context.Background(), &grpc_application_manager_go.UpgradeAppInstanceRequest{
+			OrganizationId: "org",
+			AppInstanceId:  instanceID,
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(response.AppInstanceId).Should(gomega.Equal(instanceID))
+		gomega.Expect(appClient.called("RemoveParametrizedDescriptor")).To(gomega.BeFalse())
+		gomega.Expect(appClient.parametrized[instanceID].Groups[0].Services[0].Image).Should(gomega.Equal("nginx:1.19"))
+		gomega.Expect(appClient.instances[instanceID].DescriptorRevision).Should(gomega.Equal(int64(2)))
+		// the parameters and their secrets are kept
+		gomega.Expect(storedPassword()).Should(gomega.Equal(previousPassword))
+		value, dErr := secrets.Get(context.Background(), "org", previousPassword)
+		gomega.Expect(dErr).To(gomega.BeNil())
+		gomega.Expect(value).Should(gomega.Equal("first"))
+
+		gomega.Expect(producer.sent).Should(gomega.HaveLen(2))
+		sent, ok := producer.sent[1].(*grpc_conductor_go.UpgradeRequest)
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(sent.RequestId).Should(gomega.Equal(response.RequestId))
+		// only the conductor receives the value of the password
+		gomega.Expect(appClient.parametrized[instanceID].EnvironmentVariables["PASSWORD"]).Should(gomega.Equal(previousPassword))
+		gomega.Expect(appClient.instances[instanceID].EnvironmentVariables["PASSWORD"]).Should(gomega.Equal(previousPassword))
+		gomega.Expect(sent.ParametrizedDescriptor.EnvironmentVariables["PASSWORD"]).Should(gomega.Equal("first"))
+	})
+
+	ginkgo.It("should evaluate the deploy policies on the upgraded descriptor", func() {
+		deny, dErr := policy.Parse([]byte("name: no-119\naction: deny\nphases: [deploy]\nrules:\n- forbidden_images: [\"nginx:1.19\"]"))
+		gomega.Expect(dErr).To(gomega.BeNil())
+		manager.policies = policy.NewEngine(deny)
+		revision := appClient.instances[instanceID].DescriptorRevision
+		_, err := manager.UpgradeAppInstance(context.Background(), &grpc_application_manager_go.UpgradeAppInstanceRequest{
+			OrganizationId: "org",
+			AppInstanceId:  instanceID,
+		})
+		gomega.Expect(status.Code(err)).Should(gomega.Equal(codes.PermissionDenied))
+		gomega.Expect(appClient.instances[instanceID].DescriptorRevision).Should(gomega.Equal(revision))
+		gomega.Expect(producer.sent).Should(gomega.HaveLen(1))
+	})
+
+	ginkgo.It("should store the new parameters and remove the replaced secrets", func() {
+		previousPassword := storedPassword()
+		_, err := manager.UpgradeAppInstance(context.Background(), &grpc_application_manager_go.UpgradeAppInstanceRequest{
+			OrganizationId: "org",
+			AppInstanceId:  instanceID,
+			Parameters: &grpc_application_go.InstanceParameterList{Parameters: []*grpc_application_go.InstanceParameter{
+				{ParameterName: "password", Value: "second"},
+			}},
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		newPassword := storedPassword()
+		gomega.Expect(entities.IsSecretReference(newPassword)).To(gomega.BeTrue())
+		gomega.Expect(newPassword).ShouldNot(gomega.Equal(previousPassword))
+		value, dErr := secrets.Get(context.Background(), "org", newPassword)
+		gomega.Expect(dErr).To(gomega.BeNil())
+		gomega.Expect(value).Should(gomega.Equal("second"))
+		_, dErr = secrets.Get(context.Background(), "org", previousPassword)
+		gomega.Expect(dErr).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("should restore the instance if the upgrade cannot be sent", func() {
+		previousPassword := storedPassword()
+		previousDesc := appClient.parametrized[instanceID]
+		producer.fail = true
+		_, err := manager.UpgradeAppInstance(context.Background(), &grpc_application_manager_go.UpgradeAppInstanceRequest{
+			OrganizationId: "org",
+			AppInstanceId:  instanceID,
+			Parameters: &grpc_application_go.InstanceParameterList{Parameters: []*grpc_application_go.InstanceParameter{
+				{ParameterName: "password", Value: "second"},
+			}},
+		})
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		gomega.Expect(proto.Equal(appClient.parametrized[instanceID], previousDesc)).To(gomega.BeTrue())
+		gomega.Expect(appClient.instances[instanceID].DescriptorRevision).Should(gomega.Equal(revision))
+		gomega.Expect(storedPassword()).Should(gomega.Equal(previousPassword))
+		// the secret of the instance is kept
+		_, dErr := secrets.Get(context.Background(), "org", previousPassword)
+		gomega.Expect(dErr).To(gomega.BeNil())
+		gomega.Expect(appClient.statusUpdates).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should flag the instance that cannot be restored", func() {
+		producer.fail = true
+		appClient.failAfter("UpdateAppInstance", 1)
+		_, err := manager.UpgradeAppInstance(context.Background(), &grpc_application_manager_go.UpgradeAppInstanceRequest{
+			OrganizationId: "org",
+			AppInstanceId:  instanceID,
+		})
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		gomega.Expect(appClient.statusUpdates).Should(gomega.HaveLen(1))
+		gomega.Expect(appClient.statusUpdates[0].AppInstanceId).Should(gomega.Equal(instanceID))
+		gomega.Expect(appClient.statusUpdates[0].Status).Should(gomega.Equal(grpc_application_go.ApplicationStatus_DEPLOYMENT_ERROR))
+		gomega.Expect(appClient.statusUpdates[0].Info).Should(gomega.ContainSubstring(SendUpgradeRequestStep))
+	})
+})
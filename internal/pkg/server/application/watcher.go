@@ -0,0 +1,327 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-conductor-go"
+	"github.com/rs/zerolog/log"
+	"sync"
+)
+
+// DefaultWatchBufferSize with the number of updates buffered for each subscriber.
+const DefaultWatchBufferSize = 32
+
+// DropPolicy determines what happens when the buffer of a subscriber is full.
+type DropPolicy int
+
+const (
+	// DropOldest discards the oldest buffered update to make room for the new one.
+	DropOldest DropPolicy = iota
+	// DropNewest discards the incoming update.
+	DropNewest
+	// CloseOnOverflow closes the subscription so the client can reconnect and retrieve the current state.
+	CloseOnOverflow
+)
+
+var DropPolicyToString = map[DropPolicy]string{
+	DropOldest:      "drop_oldest",
+	DropNewest:      "drop_newest",
+	CloseOnOverflow: "close_on_overflow",
+}
+
+var DropPolicyFromString = map[string]DropPolicy{
+	"drop_oldest":       DropOldest,
+	"drop_newest":       DropNewest,
+	"close_on_overflow": CloseOnOverflow,
+}
+
+func (p DropPolicy) String() string {
+	return DropPolicyToString[p]
+}
+
+// ParseDropPolicy returns the drop policy with a given name.
+func ParseDropPolicy(name string) (DropPolicy, derrors.Error) {
+	policy, exists := DropPolicyFromString[name]
+	if !exists {
+		return DropOldest, derrors.NewInvalidArgumentError("unknown drop policy").WithParams(name)
+	}
+	return policy, nil
+}
+
+// terminalInstanceStatus contains the aggregated statuses of an application instance after which no more deployment
+// updates are expected.
+var terminalInstanceStatus = map[grpc_application_go.ApplicationStatus]bool{
+	grpc_application_go.ApplicationStatus_RUNNING:          true,
+	grpc_application_go.ApplicationStatus_PLANNING_ERROR:   true,
+	grpc_application_go.ApplicationStatus_DEPLOYMENT_ERROR: true,
+	grpc_application_go.ApplicationStatus_ERROR:            true,
+}
+
+// Subscription to the updates of an application instance.
+type Subscription struct {
+	id             uint64
+	organizationID string
+	appInstanceID  string
+	policy         DropPolicy
+	events         chan *grpc_conductor_go.DeploymentServiceUpdateRequest
+	// statuses contains the last status received for each service instance.
+	statuses map[string]grpc_application_go.ServiceStatus
+	// expected with the number of service instances of the application instance, 0 while it is unknown.
+	expected int
+	// Dropped with the number of updates discarded because the buffer was full.
+	Dropped int
+}
+
+// Events returns the channel where the updates are received. The channel is closed when the instance reaches
+// a terminal state or the subscription is cancelled.
+func (s *Subscription) Events() <-chan *grpc_conductor_go.DeploymentServiceUpdateRequest {
+	return s.events
+}
+
+// countStatuses returns the number of services that are running, being terminated or failed.
+func (s *Subscription) countStatuses() (int, int, int) {
+	running := 0
+	terminating := 0
+	failed := 0
+	for _, status := range s.statuses {
+		switch status {
+		case grpc_application_go.ServiceStatus_SERVICE_ERROR:
+			failed++
+		case grpc_application_go.ServiceStatus_SERVICE_RUNNING:
+			running++
+		case grpc_application_go.ServiceStatus_SERVICE_TERMINATING:
+			terminating++
+		}
+	}
+	return running, terminating, failed
+}
+
+// settled checks if all the services reported so far are running or all of them are being terminated.
+func (s *Subscription) settled() bool {
+	running, terminating, _ := s.countStatuses()
+	return len(s.statuses) > 0 && (running == len(s.statuses) || terminating == len(s.statuses))
+}
+
+// isTerminal checks if the instance has reached a state where no more deployment updates are expected: a service
+// failed, or all the service instances of the application instance are running or all of them are being terminated.
+// The subscription is not terminal while the number of service instances is unknown.
+func (s *Subscription) isTerminal() bool {
+	_, _, failed := s.countStatuses()
+	if failed > 0 {
+		return true
+	}
+	if s.expected == 0 || len(s.statuses) < s.expected {
+		return false
+	}
+	return s.settled()
+}
+
+// InstanceWatcher fans out the service updates received from the application events bus to the subscribers of
+// each application instance.
+type InstanceWatcher struct {
+	sync.Mutex
+	nextID        uint64
+	subscriptions map[uint64]*Subscription
+	// bufferSize and policy applied to the subscriptions created with Watch.
+	bufferSize int
+	policy     DropPolicy
+	// closed is set once the watcher is closed, the subscriptions created afterwards are already closed.
+	closed bool
+}
+
+// NewInstanceWatcher creates a watcher without subscribers. The subscriptions created with Watch buffer bufferSize
+// updates and apply the given drop policy when the buffer is full.
+func NewInstanceWatcher(bufferSize int, policy DropPolicy) *InstanceWatcher {
+	return &InstanceWatcher{
+		subscriptions: make(map[uint64]*Subscription, 0),
+		bufferSize:    bufferSize,
+		policy:        policy,
+	}
+}
+
+// Watch registers a new subscriber with the buffer size and drop policy of the watcher.
+func (w *InstanceWatcher) Watch(organizationID string, appInstanceID string) *Subscription {
+	return w.Subscribe(organizationID, appInstanceID, w.bufferSize, w.policy)
+}
+
+// Subscribe registers a new subscriber for the updates of an application instance.
+func (w *InstanceWatcher) Subscribe(organizationID string, appInstanceID string, bufferSize int, policy DropPolicy) *Subscription {
+	w.Lock()
+	defer w.Unlock()
+	w.nextID++
+	subscription := &Subscription{
+		id:             w.nextID,
+		organizationID: organizationID,
+		appInstanceID:  appInstanceID,
+		policy:         policy,
+		events:         make(chan *grpc_conductor_go.DeploymentServiceUpdateRequest, bufferSize),
+		statuses:       make(map[string]grpc_application_go.ServiceStatus, 0),
+	}
+	if w.closed {
+		close(subscription.events)
+		return subscription
+	}
+	w.subscriptions[subscription.id] = subscription
+	return subscription
+}
+
+// Unsubscribe cancels a subscription. It is safe to call it on an already closed subscription.
+func (w *InstanceWatcher) Unsubscribe(subscription *Subscription) {
+	w.Lock()
+	defer w.Unlock()
+	w.close(subscription)
+}
+
+// Close cancels all the subscriptions so their watchers finish once they have sent the updates already buffered. It
+// is called when the service stops so the watch streams do not hold the shutdown of the gRPC server.
+func (w *InstanceWatcher) Close() {
+	w.Lock()
+	defer w.Unlock()
+	w.closed = true
+	for _, subscription := range w.subscriptions {
+		w.close(subscription)
+	}
+}
+
+// close removes the subscription and closes its channel. The lock must be held by the caller.
+func (w *InstanceWatcher) close(subscription *Subscription) {
+	if _, exists := w.subscriptions[subscription.id]; exists {
+		delete(w.subscriptions, subscription.id)
+		close(subscription.events)
+	}
+}
+
+// Seed records the current state of an application instance in a subscription created before the instance was
+// retrieved, so no update is lost in between. The statuses received since the subscription was created are newer and
+// are kept. Returns an update with the current state of the services and whether the instance is already in a
+// terminal state.
+func (w *InstanceWatcher) Seed(subscription *Subscription, instance *grpc_application_go.AppInstance) (*grpc_conductor_go.DeploymentServiceUpdateRequest, bool) {
+	w.Lock()
+	defer w.Unlock()
+	services := make([]*grpc_conductor_go.ServiceUpdate, 0)
+	for _, group := range instance.Groups {
+		for _, service := range group.ServiceInstances {
+			status, received := subscription.statuses[service.ServiceInstanceId]
+			if !received {
+				status = service.Status
+				subscription.statuses[service.ServiceInstanceId] = status
+			}
+			services = append(services, &grpc_conductor_go.ServiceUpdate{
+				OrganizationId:         instance.OrganizationId,
+				ApplicationId:          instance.AppDescriptorId,
+				ApplicationInstanceId:  instance.AppInstanceId,
+				ServiceGroupId:         service.ServiceGroupId,
+				ServiceGroupInstanceId: service.ServiceGroupInstanceId,
+				ServiceId:              service.ServiceId,
+				ServiceInstanceId:      service.ServiceInstanceId,
+				ClusterId:              service.DeployedOnClusterId,
+				Status:                 status,
+				Endpoints:              service.Endpoints,
+				Info:                   service.Info,
+			})
+		}
+	}
+	if len(services) > subscription.expected {
+		subscription.expected = len(services)
+	}
+	current := &grpc_conductor_go.DeploymentServiceUpdateRequest{
+		OrganizationId: instance.OrganizationId,
+		List:           services,
+	}
+	return current, terminalInstanceStatus[instance.Status] || subscription.isTerminal()
+}
+
+// NeedsRefresh checks if all the services reported to a subscription are running or being terminated but the
+// subscription cannot tell whether they are all the services of the instance, so the instance must be retrieved
+// again with Seed.
+func (w *InstanceWatcher) NeedsRefresh(subscription *Subscription) bool {
+	w.Lock()
+	defer w.Unlock()
+	if _, exists := w.subscriptions[subscription.id]; !exists {
+		return false
+	}
+	return subscription.settled() && !subscription.isTerminal()
+}
+
+// NumSubscribers returns the number of active subscriptions.
+func (w *InstanceWatcher) NumSubscribers() int {
+	w.Lock()
+	defer w.Unlock()
+	return len(w.subscriptions)
+}
+
+// deliver sends an update to a subscriber applying its drop policy if the buffer is full. Returns false if the
+// subscription has been closed.
+func (w *InstanceWatcher) deliver(subscription *Subscription, update *grpc_conductor_go.DeploymentServiceUpdateRequest) bool {
+	select {
+	case subscription.events <- update:
+		return true
+	default:
+	}
+	subscription.Dropped++
+	switch subscription.policy {
+	case DropOldest:
+		select {
+		case <-subscription.events:
+		default:
+		}
+		select {
+		case subscription.events <- update:
+		default:
+		}
+	case CloseOnOverflow:
+		log.Warn().Str("appInstanceId", subscription.appInstanceID).Msg("subscriber is too slow, closing the subscription")
+		w.close(subscription)
+		return false
+	}
+	return true
+}
+
+// Publish sends the services of an update to the subscribers of their application instances. Subscriptions are
+// closed once their instance reaches a terminal state.
+func (w *InstanceWatcher) Publish(update *grpc_conductor_go.DeploymentServiceUpdateRequest) {
+	if update == nil {
+		return
+	}
+	w.Lock()
+	defer w.Unlock()
+	for _, subscription := range w.subscriptions {
+		if subscription.organizationID != update.OrganizationId {
+			continue
+		}
+		services := make([]*grpc_conductor_go.ServiceUpdate, 0)
+		for _, service := range update.List {
+			if service.ApplicationInstanceId == subscription.appInstanceID {
+				services = append(services, service)
+				subscription.statuses[service.ServiceInstanceId] = service.Status
+			}
+		}
+		if len(services) == 0 {
+			continue
+		}
+		filtered := &grpc_conductor_go.DeploymentServiceUpdateRequest{
+			OrganizationId: update.OrganizationId,
+			List:           services,
+		}
+		if w.deliver(subscription, filtered) && subscription.isTerminal() {
+			log.Debug().Str("appInstanceId", subscription.appInstanceID).Msg("instance reached a terminal state, closing the subscription")
+			w.close(subscription)
+		}
+	}
+}
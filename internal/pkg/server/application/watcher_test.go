@@ -0,0 +1,258 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package application
+
+import (
+	"context"
+	"github.com/nalej/grpc-application-go"
+	"github.com/nalej/grpc-conductor-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"time"
+)
+
+func createServiceUpdate(organizationID string, appInstanceID string, serviceInstanceID string, status grpc_application_go.ServiceStatus) *grpc_conductor_go.DeploymentServiceUpdateRequest {
+	return &grpc_conductor_go.DeploymentServiceUpdateRequest{
+		OrganizationId: organizationID,
+		List: []*grpc_conductor_go.ServiceUpdate{{
+			ApplicationInstanceId: appInstanceID,
+			ServiceInstanceId:     serviceInstanceID,
+			Status:                status,
+		}},
+	}
+}
+
+func createWatchedInstance(organizationID string, appInstanceID string, status grpc_application_go.ApplicationStatus, services ...string) *grpc_application_go.AppInstance {
+	instances := make([]*grpc_application_go.ServiceInstance, 0, len(services))
+	for _, service := range services {
+		instances = append(instances, &grpc_application_go.ServiceInstance{
+			OrganizationId:    organizationID,
+			AppInstanceId:     appInstanceID,
+			ServiceInstanceId: service,
+			Status:            grpc_application_go.ServiceStatus_SERVICE_SCHEDULED,
+		})
+	}
+	return &grpc_application_go.AppInstance{
+		OrganizationId: organizationID,
+		AppInstanceId:  appInstanceID,
+		Status:         status,
+		Groups:         []*grpc_application_go.ServiceGroupInstance{{ServiceInstances: instances}},
+	}
+}
+
+var _ = ginkgo.Describe("Instance watcher", func() {
+
+	var watcher *InstanceWatcher
+
+	ginkgo.BeforeEach(func() {
+		watcher = NewInstanceWatcher(DefaultWatchBufferSize, DropOldest)
+	})
+
+	ginkgo.It("should only send the updates of the watched instance", func() {
+		subscription := watcher.Subscribe("org1", "inst1", DefaultWatchBufferSize, DropOldest)
+		watcher.Publish(createServiceUpdate("org1", "inst2", "s1", grpc_application_go.ServiceStatus_SERVICE_DEPLOYING))
+		watcher.Publish(createServiceUpdate("org2", "inst1", "s1", grpc_application_go.ServiceStatus_SERVICE_DEPLOYING))
+		gomega.Expect(subscription.Events()).ShouldNot(gomega.Receive())
+
+		watcher.Publish(createServiceUpdate("org1", "inst1", "s1", grpc_application_go.ServiceStatus_SERVICE_DEPLOYING))
+		var received *grpc_conductor_go.DeploymentServiceUpdateRequest
+		gomega.Expect(subscription.Events()).Should(gomega.Receive(&received))
+		gomega.Expect(len(received.List)).Should(gomega.Equal(1))
+		gomega.Expect(received.List[0].ApplicationInstanceId).Should(gomega.Equal("inst1"))
+	})
+
+	ginkgo.It("should fan out the updates to all the subscribers", func() {
+		first := watcher.Subscribe("org1", "inst1", DefaultWatchBufferSize, DropOldest)
+		second := watcher.Subscribe("org1", "inst1", DefaultWatchBufferSize, DropOldest)
+		watcher.Publish(createServiceUpdate("org1", "inst1", "s1", grpc_application_go.ServiceStatus_SERVICE_DEPLOYING))
+		gomega.Expect(first.Events()).Should(gomega.Receive())
+		gomega.Expect(second.Events()).Should(gomega.Receive())
+	})
+
+	ginkgo.It("should close the subscription once the instance reaches a terminal state", func() {
+		subscription := watcher.Subscribe("org1", "inst1", DefaultWatchBufferSize, DropOldest)
+		_, terminal := watcher.Seed(subscription, createWatchedInstance("org1", "inst1", grpc_application_go.ApplicationStatus_DEPLOYING, "s1", "s2"))
+		gomega.Expect(terminal).To(gomega.BeFalse())
+		watcher.Publish(createServiceUpdate("org1", "inst1", "s1", grpc_application_go.ServiceStatus_SERVICE_DEPLOYING))
+		watcher.Publish(createServiceUpdate("org1", "inst1", "s2", grpc_application_go.ServiceStatus_SERVICE_RUNNING))
+		gomega.Expect(watcher.NumSubscribers()).Should(gomega.Equal(1))
+		watcher.Publish(createServiceUpdate("org1", "inst1", "s1", grpc_application_go.ServiceStatus_SERVICE_RUNNING))
+		gomega.Expect(watcher.NumSubscribers()).Should(gomega.Equal(0))
+
+		// buffered updates are delivered before the channel is closed
+		received := 0
+		for range subscription.Events() {
+			received++
+		}
+		gomega.Expect(received).Should(gomega.Equal(3))
+	})
+
+	ginkgo.It("should wait for all the services of the instance", func() {
+		subscription := watcher.Subscribe("org1", "inst1", DefaultWatchBufferSize, DropOldest)
+		watcher.Seed(subscription, createWatchedInstance("org1", "inst1", grpc_application_go.ApplicationStatus_DEPLOYING, "s1", "s2"))
+		watcher.Publish(createServiceUpdate("org1", "inst1", "s1", grpc_application_go.ServiceStatus_SERVICE_RUNNING))
+		gomega.Expect(watcher.NumSubscribers()).Should(gomega.Equal(1))
+		gomega.Expect(watcher.NeedsRefresh(subscription)).To(gomega.BeFalse())
+		watcher.Publish(createServiceUpdate("org1", "inst1", "s2", grpc_application_go.ServiceStatus_SERVICE_RUNNING))
+		gomega.Expect(watcher.NumSubscribers()).Should(gomega.Equal(0))
+	})
+
+	ginkgo.It("should ask for a refresh when the number of services is unknown", func() {
+		subscription := watcher.Subscribe("org1", "inst1", DefaultWatchBufferSize, DropOldest)
+		watcher.Seed(subscription, createWatchedInstance("org1", "inst1", grpc_application_go.ApplicationStatus_QUEUED))
+		watcher.Publish(createServiceUpdate("org1", "inst1", "s1", grpc_application_go.ServiceStatus_SERVICE_RUNNING))
+		gomega.Expect(watcher.NumSubscribers()).Should(gomega.Equal(1))
+		gomega.Expect(watcher.NeedsRefresh(subscription)).To(gomega.BeTrue())
+
+		_, terminal := watcher.Seed(subscription, createWatchedInstance("org1", "inst1", grpc_application_go.ApplicationStatus_DEPLOYING, "s1", "s2"))
+		gomega.Expect(terminal).To(gomega.BeFalse())
+		gomega.Expect(watcher.NeedsRefresh(subscription)).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should keep the statuses received before seeding the subscription", func() {
+		subscription := watcher.Subscribe("org1", "inst1", DefaultWatchBufferSize, DropOldest)
+		watcher.Publish(createServiceUpdate("org1", "inst1", "s1", grpc_application_go.ServiceStatus_SERVICE_RUNNING))
+		current, terminal := watcher.Seed(subscription, createWatchedInstance("org1", "inst1", grpc_application_go.ApplicationStatus_DEPLOYING, "s1"))
+		gomega.Expect(current.List).Should(gomega.HaveLen(1))
+		gomega.Expect(current.List[0].Status).Should(gomega.Equal(grpc_application_go.ServiceStatus_SERVICE_RUNNING))
+		gomega.Expect(terminal).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should report an instance already in a terminal state", func() {
+		subscription := watcher.Subscribe("org1", "inst1", DefaultWatchBufferSize, DropOldest)
+		_, terminal := watcher.Seed(subscription, createWatchedInstance("org1", "inst1", grpc_application_go.ApplicationStatus_RUNNING, "s1"))
+		gomega.Expect(terminal).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should create the subscriptions with the configured drop policy", func() {
+		configured := NewInstanceWatcher(1, DropNewest)
+		subscription := configured.Watch("org1", "inst1")
+		configured.Publish(createServiceUpdate("org1", "inst1", "s1", grpc_application_go.ServiceStatus_SERVICE_SCHEDULED))
+		configured.Publish(createServiceUpdate("org1", "inst1", "s1", grpc_application_go.ServiceStatus_SERVICE_DEPLOYING))
+		var received *grpc_conductor_go.DeploymentServiceUpdateRequest
+		gomega.Expect(subscription.Events()).Should(gomega.Receive(&received))
+		gomega.Expect(received.List[0].Status).Should(gomega.Equal(grpc_application_go.ServiceStatus_SERVICE_SCHEDULED))
+
+		policy, err := ParseDropPolicy(CloseOnOverflow.String())
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(policy).Should(gomega.Equal(CloseOnOverflow))
+		_, err = ParseDropPolicy("unknown")
+		gomega.Expect(err).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("should close the subscription when a service fails", func() {
+		subscription := watcher.Subscribe("org1", "inst1", DefaultWatchBufferSize, DropOldest)
+		watcher.Publish(createServiceUpdate("org1", "inst1", "s1", grpc_application_go.ServiceStatus_SERVICE_ERROR))
+		gomega.Expect(subscription.Events()).Should(gomega.Receive())
+		gomega.Eventually(subscription.Events()).Should(gomega.BeClosed())
+	})
+
+	ginkgo.It("should drop the oldest updates when the buffer is full", func() {
+		subscription := watcher.Subscribe("org1", "inst1", 1, DropOldest)
+		watcher.Publish(createServiceUpdate("org1", "inst1", "s1", grpc_application_go.ServiceStatus_SERVICE_SCHEDULED))
+		watcher.Publish(createServiceUpdate("org1", "inst1", "s1", grpc_application_go.ServiceStatus_SERVICE_DEPLOYING))
+		var received *grpc_conductor_go.DeploymentServiceUpdateRequest
+		gomega.Expect(subscription.Events()).Should(gomega.Receive(&received))
+		gomega.Expect(received.List[0].Status).Should(gomega.Equal(grpc_application_go.ServiceStatus_SERVICE_DEPLOYING))
+		gomega.Expect(subscription.Dropped).Should(gomega.Equal(1))
+	})
+
+	ginkgo.It("should drop the newest updates when the buffer is full", func() {
+		subscription := watcher.Subscribe("org1", "inst1", 1, DropNewest)
+		watcher.Publish(createServiceUpdate("org1", "inst1", "s1", grpc_application_go.ServiceStatus_SERVICE_SCHEDULED))
+		watcher.Publish(createServiceUpdate("org1", "inst1", "s1", grpc_application_go.ServiceStatus_SERVICE_DEPLOYING))
+		var received *grpc_conductor_go.DeploymentServiceUpdateRequest
+		gomega.Expect(subscription.Events()).Should(gomega.Receive(&received))
+		gomega.Expect(received.List[0].Status).Should(gomega.Equal(grpc_application_go.ServiceStatus_SERVICE_SCHEDULED))
+		gomega.Expect(subscription.Dropped).Should(gomega.Equal(1))
+	})
+
+	ginkgo.It("should close slow subscribers when required", func() {
+		subscription := watcher.Subscribe("org1", "inst1", 1, CloseOnOverflow)
+		watcher.Publish(createServiceUpdate("org1", "inst1", "s1", grpc_application_go.ServiceStatus_SERVICE_SCHEDULED))
+		watcher.Publish(createServiceUpdate("org1", "inst1", "s1", grpc_application_go.ServiceStatus_SERVICE_DEPLOYING))
+		gomega.Expect(watcher.NumSubscribers()).Should(gomega.Equal(0))
+		watcher.Unsubscribe(subscription)
+	})
+
+	ginkgo.It("should close all the subscriptions when the watcher is closed", func() {
+		subscription := watcher.Subscribe("org1", "inst1", DefaultWatchBufferSize, DropOldest)
+		watcher.Publish(createServiceUpdate("org1", "inst1", "s1", grpc_application_go.ServiceStatus_SERVICE_DEPLOYING))
+		watcher.Close()
+		gomega.Expect(watcher.NumSubscribers()).Should(gomega.Equal(0))
+		gomega.Expect(subscription.Events()).Should(gomega.Receive())
+		gomega.Expect(subscription.Events()).Should(gomega.BeClosed())
+
+		late := watcher.Watch("org1", "inst1")
+		gomega.Expect(late.Events()).Should(gomega.BeClosed())
+		gomega.Expect(watcher.NumSubscribers()).Should(gomega.Equal(0))
+		watcher.Unsubscribe(late)
+	})
+})
+
+var _ = ginkgo.Describe("Watch application instances", func() {
+
+	var appClient *fakeApplicationsClient
+	var manager *Manager
+	var instanceID *grpc_application_go.AppInstanceId
+
+	ginkgo.BeforeEach(func() {
+		appClient = newFakeApplicationsClient(nil)
+		manager = &Manager{appClient: appClient, watcher: NewInstanceWatcher(DefaultWatchBufferSize, DropOldest)}
+		instanceID = &grpc_application_go.AppInstanceId{OrganizationId: "org1", AppInstanceId: "inst1"}
+	})
+
+	ginkgo.It("should send the current state and finish if the instance is already in a terminal state", func() {
+		appClient.instances["inst1"] = createWatchedInstance("org1", "inst1", grpc_application_go.ApplicationStatus_RUNNING, "s1")
+		stream := newFakeWatchStream(context.Background())
+		gomega.Expect(manager.WatchAppInstance(instanceID, stream)).To(gomega.Succeed())
+		var received *grpc_conductor_go.DeploymentServiceUpdateRequest
+		gomega.Expect(stream.sent).Should(gomega.Receive(&received))
+		gomega.Expect(received.List).Should(gomega.HaveLen(1))
+		gomega.Expect(manager.watcher.NumSubscribers()).Should(gomega.Equal(0))
+	})
+
+	ginkgo.It("should stream the updates until all the services are running", func() {
+		appClient.instances["inst1"] = createWatchedInstance("org1", "inst1", grpc_application_go.ApplicationStatus_DEPLOYING, "s1", "s2")
+		stream := newFakeWatchStream(context.Background())
+		finished := make(chan error, 1)
+		go func() {
+			finished <- manager.WatchAppInstance(instanceID, stream)
+		}()
+		gomega.Eventually(stream.sent).Should(gomega.Receive())
+		manager.watcher.Publish(createServiceUpdate("org1", "inst1", "s1", grpc_application_go.ServiceStatus_SERVICE_RUNNING))
+		gomega.Eventually(stream.sent).Should(gomega.Receive())
+		gomega.Consistently(finished, 50*time.Millisecond).ShouldNot(gomega.Receive())
+		manager.watcher.Publish(createServiceUpdate("org1", "inst1", "s2", grpc_application_go.ServiceStatus_SERVICE_RUNNING))
+		gomega.Eventually(stream.sent).Should(gomega.Receive())
+		gomega.Eventually(finished).Should(gomega.Receive(gomega.BeNil()))
+	})
+
+	ginkgo.It("should stop when the client cancels the stream", func() {
+		appClient.instances["inst1"] = createWatchedInstance("org1", "inst1", grpc_application_go.ApplicationStatus_DEPLOYING, "s1")
+		ctx, cancel := context.WithCancel(context.Background())
+		stream := newFakeWatchStream(ctx)
+		finished := make(chan error, 1)
+		go func() {
+			finished <- manager.WatchAppInstance(instanceID, stream)
+		}()
+		gomega.Eventually(stream.sent).Should(gomega.Receive())
+		cancel()
+		gomega.Eventually(finished).Should(gomega.Receive(gomega.BeNil()))
+		gomega.Expect(manager.watcher.NumSubscribers()).Should(gomega.Equal(0))
+	})
+})
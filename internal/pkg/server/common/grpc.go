@@ -23,8 +23,29 @@ import (
 
 const DefaultTimeout = time.Minute
 
+// DeadlineMargin with the time reserved from the deadline of an incoming request so the errors of the calls to other
+// components can still be returned to the client.
+const DeadlineMargin = 200 * time.Millisecond
+
 // GetContext returns a context with a default timeout for internal communications. Notice that the context does not
 // have any security related information attached to it.
 func GetContext() (context.Context, context.CancelFunc) {
 	return context.WithTimeout(context.Background(), DefaultTimeout)
 }
+
+// GetContextFrom returns a context for a call to another component made while serving a request. The context is
+// cancelled with the parent and its timeout is the time left until the deadline of the parent minus DeadlineMargin,
+// capped to DefaultTimeout.
+func GetContextFrom(parent context.Context) (context.Context, context.CancelFunc) {
+	timeout := DefaultTimeout
+	if deadline, ok := parent.Deadline(); ok {
+		remaining := time.Until(deadline)
+		if remaining > 2*DeadlineMargin {
+			remaining -= DeadlineMargin
+		}
+		if remaining < timeout {
+			timeout = remaining
+		}
+	}
+	return context.WithTimeout(parent, timeout)
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"context"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"time"
+)
+
+var _ = ginkgo.Describe("Context propagation", func() {
+
+	ginkgo.It("should use the default timeout without a parent deadline", func() {
+		ctx, cancel := GetContextFrom(context.Background())
+		defer cancel()
+		deadline, ok := ctx.Deadline()
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(time.Until(deadline)).To(gomega.BeNumerically("~", DefaultTimeout, time.Second))
+	})
+
+	ginkgo.It("should keep a margin from the parent deadline", func() {
+		parent, cancelParent := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelParent()
+		ctx, cancel := GetContextFrom(parent)
+		defer cancel()
+		parentDeadline, _ := parent.Deadline()
+		deadline, ok := ctx.Deadline()
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(parentDeadline.Sub(deadline)).To(gomega.BeNumerically("~", DeadlineMargin, 50*time.Millisecond))
+	})
+
+	ginkgo.It("should not extend a parent deadline shorter than the margin", func() {
+		parent, cancelParent := context.WithTimeout(context.Background(), DeadlineMargin)
+		defer cancelParent()
+		ctx, cancel := GetContextFrom(parent)
+		defer cancel()
+		parentDeadline, _ := parent.Deadline()
+		deadline, _ := ctx.Deadline()
+		gomega.Expect(deadline.After(parentDeadline)).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should be cancelled with the parent", func() {
+		parent, cancelParent := context.WithCancel(context.Background())
+		ctx, cancel := GetContextFrom(parent)
+		defer cancel()
+		cancelParent()
+		gomega.Eventually(ctx.Done()).Should(gomega.BeClosed())
+		gomega.Expect(ctx.Err()).To(gomega.Equal(context.Canceled))
+	})
+})
@@ -17,13 +17,29 @@
 package server
 
 import (
+	"github.com/nalej/application-manager/internal/pkg/server/application"
 	"github.com/nalej/derrors"
 	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
 )
 
+// Backends where the PASSWORD parameters of the instances are stored.
+const (
+	KubernetesSecretsBackend = "kubernetes"
+	FileSecretsBackend       = "file"
+)
+
+// SecretsKeyEnv with the environment variable that holds the passphrase of the secrets if no file is configured.
+const SecretsKeyEnv = "SECRETS_KEY"
+
 type Config struct {
 	// Port where the gRPC API service will listen requests.
 	Port int
+	// MetricsPort where the Prometheus metrics are served.
+	MetricsPort int
 	// ConductorAddress with the host:port to connect to Conductor.
 	ConductorAddress string
 	// SystemModelAddress with the host:port to connect to System Model
@@ -34,10 +50,32 @@ type Config struct {
 	QueueAddress string
 	// UnifiedLoggingAddress with the host:port to connect to the Unified Logging Coordinator component.
 	UnifiedLoggingAddress string
+	// SecretsBackend with the backend where the PASSWORD parameters of the instances are stored: kubernetes or file.
+	SecretsBackend string
+	// SecretsNamespace with the namespace where the kubernetes backend creates the secrets.
+	SecretsNamespace string
+	// SecretsPath with the file where the file backend stores the secrets, it must be in a persistent volume.
+	SecretsPath string
+	// SecretsKeyPath with the file, usually a mounted secret, with the passphrase used to encrypt the secrets. The
+	// passphrase is read from the SECRETS_KEY environment variable if empty.
+	SecretsKeyPath string
+	// PolicyDirectory with the directory where the policies are loaded from. No policies are evaluated if empty.
+	PolicyDirectory string
+	// WatchBufferSize with the number of updates buffered for each watcher of an application instance.
+	WatchBufferSize int
+	// WatchDropPolicy with the policy applied when the buffer of a watcher is full: drop_oldest, drop_newest or
+	// close_on_overflow.
+	WatchDropPolicy string
+	// ShutdownTimeout with the time given to the in-flight requests and bus updates to finish when the service stops.
+	ShutdownTimeout time.Duration
 }
 
 func (conf *Config) Validate() derrors.Error {
 
+	if conf.MetricsPort <= 0 || conf.MetricsPort == conf.Port {
+		return derrors.NewInvalidArgumentError("metricsPort must be set and differ from port")
+	}
+
 	if conf.ConductorAddress == "" {
 		return derrors.NewInvalidArgumentError("conductorAddress must be set")
 	}
@@ -58,15 +96,72 @@ func (conf *Config) Validate() derrors.Error {
 		return derrors.NewInvalidArgumentError("unifiedLoggingAddress must be set")
 	}
 
+	switch conf.SecretsBackend {
+	case KubernetesSecretsBackend:
+		if conf.SecretsNamespace == "" {
+			return derrors.NewInvalidArgumentError("secretsNamespace must be set")
+		}
+	case FileSecretsBackend:
+		if conf.SecretsPath == "" {
+			return derrors.NewInvalidArgumentError("secretsPath must be set")
+		}
+	default:
+		return derrors.NewInvalidArgumentError("secretsBackend must be kubernetes or file").WithParams(conf.SecretsBackend)
+	}
+
+	if _, err := conf.LoadSecretsKey(); err != nil {
+		return err
+	}
+
+	if conf.WatchBufferSize <= 0 {
+		return derrors.NewInvalidArgumentError("watchBufferSize must be positive")
+	}
+
+	if _, err := application.ParseDropPolicy(conf.WatchDropPolicy); err != nil {
+		return err
+	}
+
+	if conf.ShutdownTimeout <= 0 {
+		return derrors.NewInvalidArgumentError("shutdownTimeout must be positive")
+	}
+
 	return nil
 }
 
 func (conf *Config) Print() {
 	log.Info().Int("port", conf.Port).Msg("gRPC port")
+	log.Info().Int("port", conf.MetricsPort).Msg("Metrics port")
 	log.Info().Str("URL", conf.ConductorAddress).Msg("Conductor")
 	log.Info().Str("URL", conf.SystemModelAddress).Msg("System Model")
 	log.Info().Str("URL", conf.OrgManagerAddress).Msg("Organization Manager")
 	log.Info().Str("URL", conf.QueueAddress).Msg("Queue address")
 	log.Info().Str("URL", conf.UnifiedLoggingAddress).Msg("Unified Logging Coordinator Service")
+	log.Info().Str("backend", conf.SecretsBackend).Str("namespace", conf.SecretsNamespace).
+		Str("path", conf.SecretsPath).Str("keyPath", conf.SecretsKeyPath).Msg("Secrets")
+	log.Info().Str("path", conf.PolicyDirectory).Msg("Policy directory")
+	log.Info().Int("size", conf.WatchBufferSize).Str("dropPolicy", conf.WatchDropPolicy).Msg("Watch buffer")
+	log.Info().Str("timeout", conf.ShutdownTimeout.String()).Msg("Shutdown timeout")
 
 }
+
+// LoadSecretsKey reads the passphrase used to encrypt the secrets from the configured file or, if no file is set,
+// from the SECRETS_KEY environment variable. The passphrase is not passed as an argument so it does not show up in
+// the process list.
+func (conf *Config) LoadSecretsKey() (string, derrors.Error) {
+	if conf.SecretsKeyPath == "" {
+		key := os.Getenv(SecretsKeyEnv)
+		if key == "" {
+			return "", derrors.NewInvalidArgumentError("secretsKeyPath or the SECRETS_KEY environment variable must be set")
+		}
+		return key, nil
+	}
+	content, err := ioutil.ReadFile(conf.SecretsKeyPath)
+	if err != nil {
+		return "", derrors.NewInvalidArgumentError("cannot read the secrets key", err).WithParams(conf.SecretsKeyPath)
+	}
+	key := strings.TrimSpace(string(content))
+	if key == "" {
+		return "", derrors.NewInvalidArgumentError("the secrets key file is empty").WithParams(conf.SecretsKeyPath)
+	}
+	return key, nil
+}
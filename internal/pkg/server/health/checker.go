@@ -0,0 +1,205 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// The checker tracks the components the service depends on and reports their state through the grpc.health.v1
+// service. Each component is reported with its own name, the overall readiness with the empty service name and the
+// liveness of the process with the liveness service name.
+
+package health
+
+import (
+	"context"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	grpcHealth "google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultCheckInterval with the time between two checks of the dependencies.
+const DefaultCheckInterval = 10 * time.Second
+
+// DefaultProbeTimeout with the maximum duration of a probe.
+const DefaultProbeTimeout = 2 * time.Second
+
+// OverallService with the service name that reports if all the dependencies are reachable.
+const OverallService = ""
+
+// LivenessService with the service name that reports if the process is serving requests, regardless of the
+// dependencies, so an unreachable dependency does not get the service restarted.
+const LivenessService = "liveness"
+
+// Probe checks if a dependency is reachable.
+type Probe func(ctx context.Context) bool
+
+// ConnectionsProbe checks the state of the gRPC connections with a dependency. The connections that are idle are
+// considered reachable as they connect on the next call.
+func ConnectionsProbe(conns ...*grpc.ClientConn) Probe {
+	return func(ctx context.Context) bool {
+		for _, conn := range conns {
+			switch conn.GetState() {
+			case connectivity.Ready, connectivity.Idle:
+			default:
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// AddressProbe checks that a TCP connection can be established with a dependency, for the clients that do not
+// expose their connection state.
+func AddressProbe(address string) Probe {
+	return func(ctx context.Context) bool {
+		dialer := net.Dialer{}
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return false
+		}
+		_ = conn.Close()
+		return true
+	}
+}
+
+// Checker probes the dependencies of the service periodically and updates the health server.
+type Checker struct {
+	server   *grpcHealth.Server
+	probes   map[string]Probe
+	names    []string
+	interval time.Duration
+	timeout  time.Duration
+
+	mutex sync.Mutex
+	ready map[string]bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewChecker creates a checker of a set of dependencies. The service is reported as not serving until the first
+// check.
+func NewChecker(probes map[string]Probe, interval time.Duration, timeout time.Duration) *Checker {
+	names := make([]string, 0, len(probes))
+	for name := range probes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	server := grpcHealth.NewServer()
+	server.SetServingStatus(OverallService, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	server.SetServingStatus(LivenessService, grpc_health_v1.HealthCheckResponse_SERVING)
+	for _, name := range names {
+		server.SetServingStatus(name, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	}
+	return &Checker{
+		server:   server,
+		probes:   probes,
+		names:    names,
+		interval: interval,
+		timeout:  timeout,
+		ready:    make(map[string]bool, len(probes)),
+	}
+}
+
+// Register the grpc.health.v1 service on a gRPC server.
+func (c *Checker) Register(server *grpc.Server) {
+	grpc_health_v1.RegisterHealthServer(server, c.server)
+}
+
+// Check probes all the dependencies concurrently and updates their status.
+func (c *Checker) Check(ctx context.Context) {
+	results := make([]bool, len(c.names))
+	var wg sync.WaitGroup
+	for i, name := range c.names {
+		wg.Add(1)
+		go func(i int, probe Probe) {
+			defer wg.Done()
+			ctxProbe, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+			results[i] = probe(ctxProbe)
+		}(i, c.probes[name])
+	}
+	wg.Wait()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	overall := true
+	for i, name := range c.names {
+		ready := results[i]
+		overall = overall && ready
+		if previous, checked := c.ready[name]; !checked || previous != ready {
+			if ready {
+				log.Info().Str("dependency", name).Msg("dependency reachable")
+			} else {
+				log.Warn().Str("dependency", name).Msg("dependency unreachable")
+			}
+		}
+		c.ready[name] = ready
+		c.server.SetServingStatus(name, toServingStatus(ready))
+	}
+	c.server.SetServingStatus(OverallService, toServingStatus(overall))
+}
+
+// Ready returns the last known state of each dependency.
+func (c *Checker) Ready() map[string]bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	result := make(map[string]bool, len(c.ready))
+	for name, ready := range c.ready {
+		result[name] = ready
+	}
+	return result
+}
+
+// Start checks the dependencies in the background until the checker is stopped.
+func (c *Checker) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			c.Check(ctx)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop the background checks and report every service as not serving, so no new requests are routed to the
+// service while it shuts down.
+func (c *Checker) Stop() {
+	if c.cancel != nil {
+		c.cancel()
+		<-c.done
+	}
+	c.server.Shutdown()
+}
+
+func toServingStatus(ready bool) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	if ready {
+		return grpc_health_v1.HealthCheckResponse_SERVING
+	}
+	return grpc_health_v1.HealthCheckResponse_NOT_SERVING
+}
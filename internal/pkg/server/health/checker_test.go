@@ -0,0 +1,110 @@
+/*
+ * Copyright 2020 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package health
+
+import (
+	"context"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"sync"
+	"time"
+)
+
+// switchProbe reports the state set by the test.
+type switchProbe struct {
+	sync.Mutex
+	ready bool
+}
+
+func (s *switchProbe) set(ready bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.ready = ready
+}
+
+func (s *switchProbe) probe(ctx context.Context) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.ready
+}
+
+var _ = ginkgo.Describe("Health checker", func() {
+
+	var systemModel *switchProbe
+	var queue *switchProbe
+	var checker *Checker
+
+	status := func(service string) grpc_health_v1.HealthCheckResponse_ServingStatus {
+		response, err := checker.server.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: service})
+		gomega.Expect(err).To(gomega.Succeed())
+		return response.Status
+	}
+
+	ginkgo.BeforeEach(func() {
+		systemModel = &switchProbe{ready: true}
+		queue = &switchProbe{ready: true}
+		checker = NewChecker(map[string]Probe{"system-model": systemModel.probe, "queue": queue.probe},
+			10*time.Millisecond, time.Second)
+	})
+
+	ginkgo.It("should not be serving before the first check", func() {
+		gomega.Expect(status(OverallService)).To(gomega.Equal(grpc_health_v1.HealthCheckResponse_NOT_SERVING))
+		gomega.Expect(status("queue")).To(gomega.Equal(grpc_health_v1.HealthCheckResponse_NOT_SERVING))
+		gomega.Expect(status(LivenessService)).To(gomega.Equal(grpc_health_v1.HealthCheckResponse_SERVING))
+	})
+
+	ginkgo.It("should report each dependency and the overall readiness", func() {
+		checker.Check(context.Background())
+		gomega.Expect(status(OverallService)).To(gomega.Equal(grpc_health_v1.HealthCheckResponse_SERVING))
+		gomega.Expect(status("system-model")).To(gomega.Equal(grpc_health_v1.HealthCheckResponse_SERVING))
+
+		queue.set(false)
+		checker.Check(context.Background())
+		gomega.Expect(status(OverallService)).To(gomega.Equal(grpc_health_v1.HealthCheckResponse_NOT_SERVING))
+		gomega.Expect(status("system-model")).To(gomega.Equal(grpc_health_v1.HealthCheckResponse_SERVING))
+		gomega.Expect(status("queue")).To(gomega.Equal(grpc_health_v1.HealthCheckResponse_NOT_SERVING))
+		gomega.Expect(status(LivenessService)).To(gomega.Equal(grpc_health_v1.HealthCheckResponse_SERVING))
+		gomega.Expect(checker.Ready()).To(gomega.Equal(map[string]bool{"system-model": true, "queue": false}))
+	})
+
+	ginkgo.It("should track the dependencies in the background", func() {
+		checker.Start()
+		gomega.Eventually(func() grpc_health_v1.HealthCheckResponse_ServingStatus {
+			return status(OverallService)
+		}).Should(gomega.Equal(grpc_health_v1.HealthCheckResponse_SERVING))
+		systemModel.set(false)
+		gomega.Eventually(func() grpc_health_v1.HealthCheckResponse_ServingStatus {
+			return status("system-model")
+		}).Should(gomega.Equal(grpc_health_v1.HealthCheckResponse_NOT_SERVING))
+		checker.Stop()
+		systemModel.set(true)
+		checker.Check(context.Background())
+		// once stopped the service keeps reporting that it is not serving
+		gomega.Expect(status(OverallService)).To(gomega.Equal(grpc_health_v1.HealthCheckResponse_NOT_SERVING))
+	})
+
+	ginkgo.It("should check that an address is reachable", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		gomega.Expect(err).To(gomega.Succeed())
+		address := listener.Addr().String()
+		gomega.Expect(AddressProbe(address)(context.Background())).To(gomega.BeTrue())
+		gomega.Expect(listener.Close()).To(gomega.Succeed())
+		gomega.Expect(AddressProbe(address)(context.Background())).To(gomega.BeFalse())
+	})
+})
@@ -17,10 +17,15 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"github.com/nalej/application-manager/internal/pkg/metrics"
+	"github.com/nalej/application-manager/internal/pkg/policy"
+	"github.com/nalej/application-manager/internal/pkg/provider/secret"
 	"github.com/nalej/application-manager/internal/pkg/queue"
 	"github.com/nalej/application-manager/internal/pkg/server/application"
 	"github.com/nalej/application-manager/internal/pkg/server/application-network"
+	"github.com/nalej/application-manager/internal/pkg/server/health"
 	"github.com/nalej/application-manager/internal/pkg/server/unified-logging"
 	"github.com/nalej/derrors"
 	"github.com/nalej/grpc-application-go"
@@ -32,6 +37,7 @@ import (
 	"github.com/nalej/grpc-infrastructure-go"
 	"github.com/nalej/grpc-organization-manager-go"
 	"github.com/nalej/grpc-unified-logging-go"
+	"github.com/nalej/nalej-bus/pkg/bus"
 	"github.com/nalej/nalej-bus/pkg/bus/pulsar-comcast"
 	"github.com/nalej/nalej-bus/pkg/queue/application/events"
 	"github.com/nalej/nalej-bus/pkg/queue/application/ops"
@@ -40,6 +46,20 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Names of the components the service depends on, as reported by the health service.
+const (
+	ConductorDependency      = "conductor"
+	SystemModelDependency    = "system-model"
+	UnifiedLoggingDependency = "unified-logging"
+	OrgManagerDependency     = "organization-manager"
+	QueueDependency          = "queue"
 )
 
 // Service structure with the configuration and the gRPC server.
@@ -65,9 +85,13 @@ type Clients struct {
 	CoordinatorClient    grpc_unified_logging_go.CoordinatorClient
 	UnifiedLoggingClient grpc_application_manager_go.UnifiedLoggingClient
 	AppHistoryLogsClient grpc_application_history_logs_go.ApplicationHistoryLogsClient
+	// Connections with the components the clients connect to, by component name.
+	Connections map[string][]*grpc.ClientConn
 }
 
 type BusClients struct {
+	// QueueClient with the connection to the queue shared by the producers and the consumer.
+	QueueClient       bus.NalejClient
 	AppOpsProducer    *ops.ApplicationOpsProducer
 	NetOpsProducer    *networkOps.NetworkOpsProducer
 	AppEventsConsumer *events.ApplicationEventsConsumer
@@ -91,8 +115,12 @@ func (s *Service) GetBusClients() (*BusClients, derrors.Error) {
 		DeploymentServiceUpdateRequest: true,
 	})
 	appEventsConsumer, err := events.NewApplicationEventsConsumer(queueClient, "application-manager-application-events", true, appEventsConfig)
+	if err != nil {
+		return nil, err
+	}
 
 	return &BusClients{
+		QueueClient:       queueClient,
 		AppOpsProducer:    appOpsProducer,
 		NetOpsProducer:    netOpsProducer,
 		AppEventsConsumer: appEventsConsumer,
@@ -101,32 +129,38 @@ func (s *Service) GetBusClients() (*BusClients, derrors.Error) {
 
 // GetClients creates the required connections with the remote clients.
 func (s *Service) GetClients() (*Clients, derrors.Error) {
-	conductorConn, err := grpc.Dial(s.Configuration.ConductorAddress, grpc.WithInsecure())
+	conductorConn, err := grpc.Dial(s.Configuration.ConductorAddress, grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(metrics.UnaryClientInterceptor(ConductorDependency)))
 	if err != nil {
 		return nil, derrors.AsError(err, "cannot create connection with the conductor component")
 	}
 
-	smConn, err := grpc.Dial(s.Configuration.SystemModelAddress, grpc.WithInsecure())
+	smConn, err := grpc.Dial(s.Configuration.SystemModelAddress, grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(metrics.UnaryClientInterceptor(SystemModelDependency)))
 	if err != nil {
 		return nil, derrors.AsError(err, "cannot create connection with the system model component")
 	}
 
-	coordConn, err := grpc.Dial(s.Configuration.UnifiedLoggingAddress, grpc.WithInsecure())
+	coordConn, err := grpc.Dial(s.Configuration.UnifiedLoggingAddress, grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(metrics.UnaryClientInterceptor(UnifiedLoggingDependency)))
 	if err != nil {
 		return nil, derrors.AsError(err, "cannot create connection with unified logging coordinator")
 	}
 
-	ulConn, err := grpc.Dial(s.Configuration.UnifiedLoggingAddress, grpc.WithInsecure())
+	ulConn, err := grpc.Dial(s.Configuration.UnifiedLoggingAddress, grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(metrics.UnaryClientInterceptor(UnifiedLoggingDependency)))
 	if err != nil {
 		return nil, derrors.AsError(err, "cannot create connection with unified logging")
 	}
 
-	ahlConn, err := grpc.Dial(s.Configuration.SystemModelAddress, grpc.WithInsecure())
+	ahlConn, err := grpc.Dial(s.Configuration.SystemModelAddress, grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(metrics.UnaryClientInterceptor(SystemModelDependency)))
 	if err != nil {
 		return nil, derrors.AsError(err, "cannot create connection with the system model component")
 	}
 
-	orgConn, err := grpc.Dial(s.Configuration.OrgManagerAddress, grpc.WithInsecure())
+	orgConn, err := grpc.Dial(s.Configuration.OrgManagerAddress, grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(metrics.UnaryClientInterceptor(OrgManagerDependency)))
 	if err != nil {
 		return nil, derrors.AsError(err, "cannot create connection with the organization-manager component")
 	}
@@ -141,8 +175,46 @@ func (s *Service) GetClients() (*Clients, derrors.Error) {
 	ahlClient := grpc_application_history_logs_go.NewApplicationHistoryLogsClient(ahlConn)
 	orgClient := grpc_organization_manager_go.NewOrganizationsClient(orgConn)
 
-	return &Clients{aClient, orgClient,cClient, clClient,
-		dvClient, appNetClient, coordClient, ulClient, ahlClient}, nil
+	connections := map[string][]*grpc.ClientConn{
+		ConductorDependency:      {conductorConn},
+		SystemModelDependency:    {smConn, ahlConn},
+		UnifiedLoggingDependency: {coordConn, ulConn},
+		OrgManagerDependency:     {orgConn},
+	}
+
+	return &Clients{aClient, orgClient, cClient, clClient,
+		dvClient, appNetClient, coordClient, ulClient, ahlClient, connections}, nil
+}
+
+// newSecretProvider creates the provider of the configured backend where the PASSWORD parameters are stored.
+func (s *Service) newSecretProvider() (secret.Provider, derrors.Error) {
+	key, err := s.Configuration.LoadSecretsKey()
+	if err != nil {
+		return nil, err
+	}
+	if s.Configuration.SecretsBackend == FileSecretsBackend {
+		provider, err := secret.NewFileProvider(s.Configuration.SecretsPath, key)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	}
+	provider, err := secret.NewInClusterKubernetesProvider(s.Configuration.SecretsNamespace, key)
+	if err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// healthProbes returns the probes of the components the service depends on.
+func (s *Service) healthProbes(clients *Clients) map[string]health.Probe {
+	probes := make(map[string]health.Probe, len(clients.Connections)+1)
+	for name, conns := range clients.Connections {
+		probes[name] = health.ConnectionsProbe(conns...)
+	}
+	// the bus clients do not expose the state of their connection
+	probes[QueueDependency] = health.AddressProbe(s.Configuration.QueueAddress)
+	return probes
 }
 
 // Run the service, launch the REST service handler.
@@ -167,7 +239,7 @@ func (s *Service) Run() error {
 
 	// BusClients
 	busClients, bErr := s.GetBusClients()
-	if err != nil {
+	if bErr != nil {
 		log.Fatal().Str("err", bErr.DebugReport()).Msg("Cannot create bus clients")
 	}
 
@@ -181,22 +253,124 @@ func (s *Service) Run() error {
 	}
 	unifiedLogHandler := unified_logging.NewHandler(*unifiedLoggingManager)
 
-	manager := application.NewManager(clients.AppClient, clients.OrgClient, clients.ConductorClient, clients.ClusterClient, clients.DeviceClient, clients.AppNetClient, busClients.AppOpsProducer, appNetManager)
+	secrets, sErr := s.newSecretProvider()
+	if sErr != nil {
+		log.Fatal().Str("err", sErr.DebugReport()).Msg("Cannot create secret provider")
+	}
+
+	policies, pErr := policy.NewLocalEngine(s.Configuration.PolicyDirectory)
+	if pErr != nil {
+		log.Fatal().Str("err", pErr.DebugReport()).Msg("Cannot load policies")
+	}
+
+	// the drop policy has already been checked when validating the configuration
+	dropPolicy, _ := application.ParseDropPolicy(s.Configuration.WatchDropPolicy)
+	instanceWatcher := application.NewInstanceWatcher(s.Configuration.WatchBufferSize, dropPolicy)
+	manager := application.NewManager(clients.AppClient, clients.OrgClient, clients.ConductorClient, clients.ClusterClient, clients.DeviceClient, clients.AppNetClient, busClients.AppOpsProducer, appNetManager, instanceWatcher, secrets, policies)
 	handler := application.NewHandler(manager)
 
-	appEventsHandler := queue.NewAppEventsHandler(unifiedLoggingManager, busClients.AppEventsConsumer)
+	appEventsHandler := queue.NewAppEventsHandler(unifiedLoggingManager, instanceWatcher, busClients.AppEventsConsumer)
 	appEventsHandler.Run()
 
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(metrics.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(metrics.StreamServerInterceptor()))
 	grpc_application_manager_go.RegisterApplicationManagerServer(grpcServer, handler)
 	grpc_application_manager_go.RegisterApplicationNetworkServer(grpcServer, appNetHandler)
 	grpc_application_manager_go.RegisterUnifiedLoggingServer(grpcServer, unifiedLogHandler)
 
+	checker := health.NewChecker(s.healthProbes(clients), health.DefaultCheckInterval, health.DefaultProbeTimeout)
+	checker.Register(grpcServer)
+	checker.Start()
+
 	// Register reflection service on gRPC server.
 	reflection.Register(grpcServer)
-	log.Info().Int("port", s.Configuration.Port).Msg("Launching gRPC server")
-	if err := grpcServer.Serve(lis); err != nil {
-		log.Fatal().Errs("failed to serve: %v", []error{err})
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	metricsServer := &http.Server{Addr: fmt.Sprintf(":%d", s.Configuration.MetricsPort), Handler: metrics.Handler()}
+	go func() {
+		log.Info().Int("port", s.Configuration.MetricsPort).Msg("Launching metrics server")
+		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error().Err(err).Msg("failed to serve the metrics")
+		}
+	}()
+
+	served := make(chan error, 1)
+	go func() {
+		log.Info().Int("port", s.Configuration.Port).Msg("Launching gRPC server")
+		served <- grpcServer.Serve(lis)
+	}()
+
+	select {
+	case sig := <-signals:
+		log.Info().Str("signal", sig.String()).Msg("shutting down the service")
+	case err := <-served:
+		if err != nil {
+			log.Fatal().Errs("failed to serve: %v", []error{err})
+		}
+	}
+	s.shutdown(grpcServer, checker, instanceWatcher, appEventsHandler, busClients)
+	// the metrics are served until the end so the shutdown can be observed
+	ctx, cancel := context.WithTimeout(context.Background(), s.Configuration.ShutdownTimeout)
+	defer cancel()
+	if err := metricsServer.Shutdown(ctx); err != nil {
+		log.Warn().Err(err).Msg("error stopping the metrics server")
 	}
 	return nil
 }
+
+// shutdown stops the service in order: the health service reports it as not serving, the watch streams are
+// finished, the gRPC server stops accepting requests and waits for the in-flight ones, the application events already
+// received are processed and finally the bus clients are closed.
+func (s *Service) shutdown(grpcServer *grpc.Server, checker *health.Checker, instanceWatcher *application.InstanceWatcher,
+	appEventsHandler queue.AppEventsHandler, busClients *BusClients) {
+	checker.Stop()
+	// the watch streams only finish when their instances settle, they would hold the graceful stop until the timeout
+	instanceWatcher.Close()
+	if !gracefulStop(grpcServer, s.Configuration.ShutdownTimeout) {
+		log.Warn().Msg("the in-flight requests did not finish in time, their connections have been closed")
+	}
+	if err := appEventsHandler.Stop(s.Configuration.ShutdownTimeout); err != nil {
+		log.Warn().Str("err", err.DebugReport()).Msg("the application events have not been drained")
+	}
+	closeBusClients(busClients)
+	log.Info().Msg("service stopped")
+}
+
+// gracefulStop stops the server once the in-flight requests finish. If the timeout expires first, the server is
+// stopped closing their connections and false is returned.
+func gracefulStop(grpcServer *grpc.Server, timeout time.Duration) bool {
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return true
+	case <-time.After(timeout):
+		grpcServer.Stop()
+		<-stopped
+		return false
+	}
+}
+
+// closeBusClients closes the producers and the consumer of the bus, and then the connection to the queue they share.
+func closeBusClients(busClients *BusClients) {
+	logBusClientClosed("app-ops producer", busClients.AppOpsProducer.Close())
+	logBusClientClosed("network-ops producer", busClients.NetOpsProducer.Close())
+	logBusClientClosed("application events consumer", busClients.AppEventsConsumer.Close())
+	logBusClientClosed("queue client", busClients.QueueClient.Close())
+}
+
+// logBusClientClosed logs the result of closing a bus client.
+func logBusClientClosed(name string, err derrors.Error) {
+	if err != nil {
+		log.Warn().Str("err", err.DebugReport()).Str("client", name).Msg("error closing bus client")
+		return
+	}
+	log.Debug().Str("client", name).Msg("bus client closed")
+}
@@ -33,12 +33,12 @@ func NewHandler(manager Manager) *Handler {
 	return &Handler{manager}
 }
 
-func (h *Handler) Search(_ context.Context, in *grpc_application_manager_go.SearchRequest) (*grpc_application_manager_go.LogResponse, error) {
+func (h *Handler) Search(ctx context.Context, in *grpc_application_manager_go.SearchRequest) (*grpc_application_manager_go.LogResponse, error) {
 	vErr := entities.ValidSearchRequest(in)
 	if vErr != nil {
 		return nil, conversions.ToGRPCError(vErr)
 	}
-	return h.Manager.Search(in)
+	return h.Manager.Search(ctx, in)
 }
 
 func (h *Handler) Catalog(ctx context.Context, availableLogsRequest *grpc_application_manager_go.AvailableLogRequest) (*grpc_application_manager_go.AvailableLogResponse, error) {
@@ -46,5 +46,5 @@ func (h *Handler) Catalog(ctx context.Context, availableLogsRequest *grpc_applic
 	if vErr != nil {
 		return nil, conversions.ToGRPCError(vErr)
 	}
-	return h.Manager.Catalog(availableLogsRequest)
+	return h.Manager.Catalog(ctx, availableLogsRequest)
 }
@@ -83,11 +83,11 @@ func (m *Manager) isDead(response *grpc_unified_logging_go.LogResponse, catalog
 // Search returns a list of log entries that follow the conditions of the request and
 // returns two lists of services that have been active in the time period of the logs returned
 // one group by application descriptors and another one group application instances
-func (m *Manager) Search(request *grpc_application_manager_go.SearchRequest) (*grpc_application_manager_go.LogResponse, error) {
+func (m *Manager) Search(ctx context.Context, request *grpc_application_manager_go.SearchRequest) (*grpc_application_manager_go.LogResponse, error) {
 
 	log.Debug().Interface("request", request).Msg("search request")
 
-	ctx, cancel := common.GetContext()
+	ctx, cancel := common.GetContextFrom(ctx)
 	defer cancel()
 	// 1.- call to unified logging to retrieve log entries
 	searchResponse, err := m.coordinatorClient.Search(ctx, &grpc_unified_logging_go.SearchRequest{
@@ -125,7 +125,7 @@ func (m *Manager) Search(request *grpc_application_manager_go.SearchRequest) (*g
 		// TODO: ask what to do here
 	}else{
 		// 3.- Fill the list returned by system-model with the names and labels
-		availableList := m.Organize(logHistoryResponse)
+		availableList := m.Organize(ctx, logHistoryResponse)
 
 		descriptors = availableList.AppDescriptorLogSummary
 		instances = availableList.AppInstanceLogSummary
@@ -171,9 +171,9 @@ func (m *Manager) Search(request *grpc_application_manager_go.SearchRequest) (*g
 	}, nil
 }
 
-func (m *Manager) Catalog(request *grpc_application_manager_go.AvailableLogRequest) (*grpc_application_manager_go.AvailableLogResponse, error) {
+func (m *Manager) Catalog(ctx context.Context, request *grpc_application_manager_go.AvailableLogRequest) (*grpc_application_manager_go.AvailableLogResponse, error) {
 	log.Debug().Interface("request", request).Msg("available log request")
-	ctx, cancel := common.GetContext()
+	ctx, cancel := common.GetContextFrom(ctx)
 	defer cancel()
 
 	searchRequest := &grpc_application_history_logs_go.SearchLogRequest{
@@ -187,20 +187,20 @@ func (m *Manager) Catalog(request *grpc_application_manager_go.AvailableLogReque
 		return nil, cErr
 	}
 
-	availableLogResponse := m.Organize(logResponse)
+	availableLogResponse := m.Organize(ctx, logResponse)
 
 	return availableLogResponse, nil
 }
 
 // ManageCatalog receives DeploymentServiceUpdateRequest messages from the bus and manages the catalog entries to be sent to system-model
-func (m *Manager) ManageCatalog(request *grpc_conductor_go.DeploymentServiceUpdateRequest) error {
-	addCtx, addCancel := context.WithTimeout(context.Background(), ApplicationManagerTimeout)
+func (m *Manager) ManageCatalog(ctx context.Context, request *grpc_conductor_go.DeploymentServiceUpdateRequest) error {
+	addCtx, addCancel := context.WithTimeout(ctx, ApplicationManagerTimeout)
 	defer addCancel()
 	for _, service := range request.List {
 		log.Debug().Str("app instance id", service.ApplicationInstanceId).Msg("incoming service update request")
 		if service.Status == grpc_application_go.ServiceStatus_SERVICE_DEPLOYING {
 			log.Debug().Str("service instance id", service.ServiceInstanceId).Msg("adding service to service history logs")
-			appInstanceReducedSummary, sumErr := m.instHelper.RetrieveInstanceSummary(request.OrganizationId, service.ApplicationInstanceId)
+			appInstanceReducedSummary, sumErr := m.instHelper.RetrieveInstanceSummary(addCtx, request.OrganizationId, service.ApplicationInstanceId)
 			if sumErr != nil {
 				log.Debug().Msg("error retrieving service instance id")
 				return conversions.ToGRPCError(sumErr)
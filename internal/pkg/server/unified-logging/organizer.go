@@ -17,25 +17,26 @@
 package unified_logging
 
 import (
+	"context"
 	"github.com/nalej/grpc-application-history-logs-go"
 	"github.com/nalej/grpc-application-manager-go"
 )
 
-func (m *Manager) createDescriptorLogSummary(event *grpc_application_history_logs_go.ServiceInstanceLog) *grpc_application_manager_go.AppDescriptorLogSummary {
-	instanceNames := m.instHelper.GetNames(event.OrganizationId, event.AppInstanceId, event.ServiceGroupId, event.ServiceId)
-	labels := m.instHelper.GetLabels(event.OrganizationId, event.AppDescriptorId)
+func (m *Manager) createDescriptorLogSummary(ctx context.Context, event *grpc_application_history_logs_go.ServiceInstanceLog) *grpc_application_manager_go.AppDescriptorLogSummary {
+	instanceNames := m.instHelper.GetNames(ctx, event.OrganizationId, event.AppInstanceId, event.ServiceGroupId, event.ServiceId)
+	labels := m.instHelper.GetLabels(ctx, event.OrganizationId, event.AppDescriptorId)
 	return &grpc_application_manager_go.AppDescriptorLogSummary{
 		OrganizationId:    event.OrganizationId,
 		AppDescriptorId:   event.AppDescriptorId,
 		AppDescriptorName: instanceNames.AppDescriptorName,
 		CurrentLabels:     labels,
-		Instances:         []*grpc_application_manager_go.AppInstanceLogSummary{m.createInstanceLogSummary(event)},
+		Instances:         []*grpc_application_manager_go.AppInstanceLogSummary{m.createInstanceLogSummary(ctx, event)},
 	}
 }
 
-func (m *Manager) createInstanceLogSummary(event *grpc_application_history_logs_go.ServiceInstanceLog) *grpc_application_manager_go.AppInstanceLogSummary {
-	instanceNames := m.instHelper.GetNames(event.OrganizationId, event.AppInstanceId, event.ServiceGroupId, event.ServiceId)
-	labels := m.instHelper.GetLabels(event.OrganizationId, event.AppDescriptorId)
+func (m *Manager) createInstanceLogSummary(ctx context.Context, event *grpc_application_history_logs_go.ServiceInstanceLog) *grpc_application_manager_go.AppInstanceLogSummary {
+	instanceNames := m.instHelper.GetNames(ctx, event.OrganizationId, event.AppInstanceId, event.ServiceGroupId, event.ServiceId)
+	labels := m.instHelper.GetLabels(ctx, event.OrganizationId, event.AppDescriptorId)
 	return &grpc_application_manager_go.AppInstanceLogSummary{
 		OrganizationId:    event.OrganizationId,
 		AppInstanceId:     event.AppInstanceId,
@@ -43,22 +44,22 @@ func (m *Manager) createInstanceLogSummary(event *grpc_application_history_logs_
 		AppDescriptorId:   event.AppDescriptorId,
 		AppDescriptorName: instanceNames.AppDescriptorName,
 		CurrentLabels:     labels,
-		Groups:            []*grpc_application_manager_go.ServiceGroupInstanceLogSummary{m.createServiceGroupLogSummary(event)},
+		Groups:            []*grpc_application_manager_go.ServiceGroupInstanceLogSummary{m.createServiceGroupLogSummary(ctx, event)},
 	}
 }
 
-func (m *Manager) createServiceGroupLogSummary(event *grpc_application_history_logs_go.ServiceInstanceLog) *grpc_application_manager_go.ServiceGroupInstanceLogSummary {
-	instanceNames := m.instHelper.GetNames(event.OrganizationId, event.AppInstanceId, event.ServiceGroupId, event.ServiceId)
+func (m *Manager) createServiceGroupLogSummary(ctx context.Context, event *grpc_application_history_logs_go.ServiceInstanceLog) *grpc_application_manager_go.ServiceGroupInstanceLogSummary {
+	instanceNames := m.instHelper.GetNames(ctx, event.OrganizationId, event.AppInstanceId, event.ServiceGroupId, event.ServiceId)
 	return &grpc_application_manager_go.ServiceGroupInstanceLogSummary{
 		ServiceGroupId:         event.ServiceGroupId,
 		ServiceGroupInstanceId: event.ServiceGroupInstanceId,
 		Name:                   instanceNames.ServiceGroupName,
-		ServiceInstances:       []*grpc_application_manager_go.ServiceInstanceLogSummary{m.createServiceInstanceLogSummary(event)},
+		ServiceInstances:       []*grpc_application_manager_go.ServiceInstanceLogSummary{m.createServiceInstanceLogSummary(ctx, event)},
 	}
 }
 
-func (m *Manager) createServiceInstanceLogSummary(event *grpc_application_history_logs_go.ServiceInstanceLog) *grpc_application_manager_go.ServiceInstanceLogSummary {
-	instanceNames := m.instHelper.GetNames(event.OrganizationId, event.AppInstanceId, event.ServiceGroupId, event.ServiceId)
+func (m *Manager) createServiceInstanceLogSummary(ctx context.Context, event *grpc_application_history_logs_go.ServiceInstanceLog) *grpc_application_manager_go.ServiceInstanceLogSummary {
+	instanceNames := m.instHelper.GetNames(ctx, event.OrganizationId, event.AppInstanceId, event.ServiceGroupId, event.ServiceId)
 	return &grpc_application_manager_go.ServiceInstanceLogSummary{
 		ServiceId:         event.ServiceId,
 		ServiceInstanceId: event.ServiceInstanceId,
@@ -66,7 +67,7 @@ func (m *Manager) createServiceInstanceLogSummary(event *grpc_application_histor
 	}
 }
 
-func (m *Manager) Organize(logResponse *grpc_application_history_logs_go.LogResponse) *grpc_application_manager_go.AvailableLogResponse {
+func (m *Manager) Organize(ctx context.Context, logResponse *grpc_application_history_logs_go.LogResponse) *grpc_application_manager_go.AvailableLogResponse {
 	// LogResponse entries organized according to the structure needed
 	//var appDescriptorLogSummary *grpc_application_manager_go.AppDescriptorLogSummary
 	//var appInstanceLogSummary *grpc_application_manager_go.AppInstanceLogSummary
@@ -90,7 +91,7 @@ func (m *Manager) Organize(logResponse *grpc_application_history_logs_go.LogResp
 			}
 		}
 		if !found {
-			appDescriptorLogSummaries = append(appDescriptorLogSummaries, m.createDescriptorLogSummary(event))
+			appDescriptorLogSummaries = append(appDescriptorLogSummaries, m.createDescriptorLogSummary(ctx, event))
 			continue
 		}
 
@@ -103,7 +104,7 @@ func (m *Manager) Organize(logResponse *grpc_application_history_logs_go.LogResp
 			}
 		}
 		if !found {
-			appDescriptorLogSummary.Instances = append(appDescriptorLogSummary.Instances, m.createInstanceLogSummary(event))
+			appDescriptorLogSummary.Instances = append(appDescriptorLogSummary.Instances, m.createInstanceLogSummary(ctx, event))
 			continue
 		}
 
@@ -116,7 +117,7 @@ func (m *Manager) Organize(logResponse *grpc_application_history_logs_go.LogResp
 			}
 		}
 		if !found {
-			appInstanceLogSummary.Groups = append(appInstanceLogSummary.Groups, m.createServiceGroupLogSummary(event))
+			appInstanceLogSummary.Groups = append(appInstanceLogSummary.Groups, m.createServiceGroupLogSummary(ctx, event))
 			continue
 		}
 
@@ -128,7 +129,7 @@ func (m *Manager) Organize(logResponse *grpc_application_history_logs_go.LogResp
 			}
 		}
 		if !found {
-			serviceGroupInstanceLogSummary.ServiceInstances = append(serviceGroupInstanceLogSummary.ServiceInstances, m.createServiceInstanceLogSummary(event))
+			serviceGroupInstanceLogSummary.ServiceInstances = append(serviceGroupInstanceLogSummary.ServiceInstances, m.createServiceInstanceLogSummary(ctx, event))
 			continue
 		}
 	}
@@ -17,6 +17,8 @@
 package unified_logging
 
 import (
+	"context"
+	"github.com/nalej/application-manager/internal/pkg/utils"
 	grpc_application_go "github.com/nalej/grpc-application-go"
 	"github.com/nalej/grpc-application-history-logs-go"
 	"github.com/nalej/grpc-utils/pkg/test"
@@ -102,8 +104,22 @@ var _ = ginkgo.Describe("Test", func() {
 
 	ginkgo.Context("U-L", func() {
 		ginkgo.It("-----", func() {
-			availableLogResponse := manager.Organize(createLogResponse())
+			availableLogResponse := manager.Organize(context.Background(), createLogResponse())
 			gomega.Expect(availableLogResponse).NotTo(gomega.BeNil())
 		})
+		ginkgo.It("should not retrieve the names once the context is cancelled", func() {
+			logResponse := createLogResponse()
+			for _, event := range logResponse.Events {
+				event.OrganizationId = logResponse.OrganizationId
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			availableLogResponse := manager.Organize(ctx, logResponse)
+			gomega.Expect(availableLogResponse.AppDescriptorLogSummary).To(gomega.HaveLen(3))
+			for _, summary := range availableLogResponse.AppDescriptorLogSummary {
+				gomega.Expect(summary.AppDescriptorName).To(gomega.Equal(utils.UnknownName))
+				gomega.Expect(summary.CurrentLabels).To(gomega.BeNil())
+			}
+		})
 	})
 })
@@ -17,8 +17,10 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"github.com/hashicorp/golang-lru"
+	"github.com/nalej/application-manager/internal/pkg/metrics"
 	"github.com/nalej/application-manager/internal/pkg/server/common"
 	"github.com/nalej/derrors"
 	"github.com/nalej/grpc-application-go"
@@ -61,17 +63,19 @@ func (i *InstancesHelper) composePK(organizationId string, instanceId string) st
 }
 
 // RetrieveInstanceSummary looks for a Instance Summary in the cache. If it does not exists, retrieves it from the database
-func (i *InstancesHelper) RetrieveInstanceSummary(organizationId string, appInstanceId string) (*grpc_application_go.AppInstanceReducedSummary, derrors.Error) {
+// within the deadline of the context
+func (i *InstancesHelper) RetrieveInstanceSummary(ctx context.Context, organizationId string, appInstanceId string) (*grpc_application_go.AppInstanceReducedSummary, derrors.Error) {
 
 	pk := i.composePK(organizationId, appInstanceId)
 
 	summary, found := i.cache.Get(pk)
+	metrics.CacheLookup(metrics.InstancesCache, found)
 	if found {
 		return summary.(*grpc_application_go.AppInstanceReducedSummary), nil
 	}
 
 	// else -> ask to system-model
-	ctx, cancel := common.GetContext()
+	ctx, cancel := common.GetContextFrom(ctx)
 	defer cancel()
 	retrievedSummary, err := i.appClient.GetAppInstanceReducedSummary(ctx, &grpc_application_go.AppInstanceId{
 		OrganizationId: organizationId,
@@ -85,7 +89,7 @@ func (i *InstancesHelper) RetrieveInstanceSummary(organizationId string, appInst
 	return retrievedSummary, nil
 }
 
-func (i *InstancesHelper) GetNames(organizationId string, appInstanceId string, serviceGroupId string, serviceId string) *InstanceNames {
+func (i *InstancesHelper) GetNames(ctx context.Context, organizationId string, appInstanceId string, serviceGroupId string, serviceId string) *InstanceNames {
 	if organizationId == "" || appInstanceId == "" {
 		return &InstanceNames{
 			AppInstanceName:   UnknownName,
@@ -94,7 +98,7 @@ func (i *InstancesHelper) GetNames(organizationId string, appInstanceId string,
 			ServiceName:       UnknownName,
 		}
 	}
-	appInstanceReducedSummary, _ := i.RetrieveInstanceSummary(organizationId, appInstanceId)
+	appInstanceReducedSummary, _ := i.RetrieveInstanceSummary(ctx, organizationId, appInstanceId)
 
 	if appInstanceReducedSummary != nil {
 		instanceNames := &InstanceNames{
@@ -125,13 +129,13 @@ func (i *InstancesHelper) GetNames(organizationId string, appInstanceId string,
 
 }
 
-func (i InstancesHelper) GetLabels(organizationId string, appDescriptorId string) map[string]string {
+func (i InstancesHelper) GetLabels(ctx context.Context, organizationId string, appDescriptorId string) map[string]string {
 	if organizationId == "" || appDescriptorId == "" {
 		log.Error().Msg("organization id or app descriptor id is empty")
 		return nil
 	}
 
-	ctx, cancel := common.GetContext()
+	ctx, cancel := common.GetContextFrom(ctx)
 	defer cancel()
 	appDescriptor, err := i.appClient.GetAppDescriptor(ctx, &grpc_application_go.AppDescriptorId{
 		OrganizationId:  organizationId,